@@ -1,11 +1,13 @@
 package cmd
 
 import (
+	"fmt"
 	"path/filepath"
 	"strings"
 
 	"github.com/spf13/cobra"
 
+	"github.com/xhanio/errors"
 	"github.com/xhanio/framingo/pkg/types/info"
 
 	"github.com/xhanio/gopro/pkg/types"
@@ -18,17 +20,25 @@ var (
 	buildType      string
 	buildDate      string
 	binaryOutput   string
+	buildJobs      int
 
 	pushImage  bool
 	pushLatest bool
+	skipBases  bool
+	noCache    bool
+	engineName string
 )
 
 func NewBuildCmd() *cobra.Command {
 	cmd := &cobra.Command{
 		Use: "build",
 	}
+	addPlanFlags(cmd)
+	cmd.PersistentFlags().StringVarP(&manifestPath, "manifest", "", "", "build manifest to record the artifacts in (default build_manifest.path, then dist/build-manifest.json)")
+	cmd.PersistentFlags().StringVarP(&manifestFormat, "manifest-format", "", "", "build manifest format: gopro or slsa (default build_manifest.format, then gopro)")
 	cmd.AddCommand(NewBuildBinaryCmd())
 	cmd.AddCommand(NewBuildImageCmd())
+	cmd.AddCommand(NewBuildPackageCmd())
 	return cmd
 }
 
@@ -43,6 +53,9 @@ func NewBuildBinaryCmd() *cobra.Command {
 	cmd.Flags().StringVarP(&buildType, "build-type", "", "", "overwrite build type")
 	cmd.Flags().StringVarP(&buildDate, "build-date", "", "", "overwrite build date")
 	cmd.Flags().StringVarP(&binaryOutput, "output", "o", "", "build binary output dir")
+	cmd.Flags().IntVarP(&buildJobs, "jobs", "j", 1, "number of builds to run concurrently, 0 for one per CPU")
+	cmd.Flags().BoolVarP(&forceBuild, "force", "", false, "rebuild binaries whose inputs are unchanged")
+	cmd.Flags().BoolVarP(&signArtifacts, "sign", "", false, "sign each binary with the private key named by signing.key_env")
 	return cmd
 }
 
@@ -76,11 +89,10 @@ func applyApplicationInfo(binary types.BinarySpec) {
 	}
 }
 
-func runBuildBinary(cmd *cobra.Command, args []string) error {
-	overwriteBuildInfo()
-	if binaryOutput == "" {
-		binaryOutput = env.BinaryTgt
-	}
+// selectedBinaries returns the build.binaries entries enabled in the current
+// environment and matched by the filter, in the environment's order.
+func selectedBinaries() []types.BinarySpec {
+	var result []types.BinarySpec
 	for _, name := range env.Binaries {
 		if !filterRegex.MatchString(name) {
 			continue
@@ -89,27 +101,92 @@ func runBuildBinary(cmd *cobra.Command, args []string) error {
 			if name != binary.Name {
 				continue
 			}
-			binarySrc := binary.Src
-			if binarySrc == "" {
-				binarySrc = filepath.Join(env.BinarySrc, binary.Name)
-			}
-			applyApplicationInfo(binary)
-			// build default platform
-			titlef("Build Binary %s from %s", name, binarySrc)
-			if err := executeBuildBinary(binary, types.PlatformSpec{}, binarySrc, binaryOutput); err != nil {
+			result = append(result, binary)
+		}
+	}
+	return result
+}
+
+func binarySource(binary types.BinarySpec) string {
+	if binary.Src != "" {
+		return binary.Src
+	}
+	return filepath.Join(env.BinarySrc, binary.Name)
+}
+
+func runBuildBinary(cmd *cobra.Command, args []string) error {
+	overwriteBuildInfo()
+	if binaryOutput == "" {
+		binaryOutput = env.BinaryTgt
+	}
+	s, err := openSigner()
+	if err != nil {
+		return err
+	}
+	signer = s
+	m, err := openManifest()
+	if err != nil {
+		return err
+	}
+	artifacts = m
+	builds = loadFingerprints(binaryOutput)
+	err = buildBinaries()
+	builds.report()
+	// whatever was built before a failure stays recorded
+	return errors.Combine(err, builds.save(), artifacts.save())
+}
+
+// buildBinaries builds every selected binary for the host and each of its
+// platforms.
+func buildBinaries() error {
+	if buildJobs != 1 {
+		return runBuildBinaryJobs(buildJobs)
+	}
+	for _, binary := range selectedBinaries() {
+		binarySrc := binarySource(binary)
+		applyApplicationInfo(binary)
+		// build default platform
+		titlef("Build Binary %s from %s", binary.Name, binarySrc)
+		if err := executeBuildBinary(binary, types.PlatformSpec{}, binarySrc, binaryOutput); err != nil {
+			return err
+		}
+		for _, platform := range binary.GetPlatforms() {
+			linef("build for platform %s", platform.Name)
+			if err := executeBuildBinary(binary, platform, binarySrc, binaryOutput); err != nil {
 				return err
 			}
-			for _, platform := range binary.GetPlatforms() {
-				linef("build for platform %s", platform.Name)
-				if err := executeBuildBinary(binary, platform, binarySrc, binaryOutput); err != nil {
-					return err
-				}
-			}
 		}
 	}
 	return nil
 }
 
+// runBuildBinaryJobs runs every build of every selected binary on n workers.
+// The injected application info is package state, so each build is resolved
+// up front, one binary at a time, and only the go build invocations run
+// concurrently.
+func runBuildBinaryJobs(n int) error {
+	var (
+		jobs []job
+		errs []error
+	)
+	for _, binary := range selectedBinaries() {
+		binarySrc := binarySource(binary)
+		applyApplicationInfo(binary)
+		platforms := append([]types.PlatformSpec{{}}, binary.GetPlatforms()...)
+		for _, platform := range platforms {
+			b, err := newBinaryBuild(binary, platform, binarySrc, binaryOutput)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s: %w", binary.Name, err))
+				continue
+			}
+			jobs = append(jobs, job{name: b.String(), run: b.run})
+		}
+	}
+	titlef("Build %d targets concurrently", len(jobs))
+	errs = append(errs, runJobs(n, jobs))
+	return errors.Combine(errs...)
+}
+
 func NewBuildImageCmd() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:  "image",
@@ -117,6 +194,9 @@ func NewBuildImageCmd() *cobra.Command {
 	}
 	cmd.Flags().BoolVarP(&pushImage, "push", "p", false, "push image")
 	cmd.Flags().BoolVarP(&pushLatest, "latest", "l", false, "also tag and push :latest (requires --push)")
+	cmd.Flags().BoolVarP(&skipBases, "skip-bases", "", false, "build only the filtered images, without the $image bases they need")
+	cmd.Flags().BoolVarP(&noCache, "no-cache", "", false, "build without any cache, whatever is configured")
+	cmd.Flags().StringVarP(&engineName, "engine", "", "", "container engine: docker, podman, nerdctl or dry-run (default image_engine, then docker)")
 	return cmd
 }
 
@@ -124,63 +204,182 @@ func runBuildImage(cmd *cobra.Command, args []string) error {
 	if pushLatest && !pushImage {
 		warnf("--latest has no effect without --push; ignoring")
 	}
-	for _, name := range env.Images {
-		if !filterRegex.MatchString(name) {
-			continue
+	e, err := selectEngine(engineName, env)
+	if err != nil {
+		return err
+	}
+	engine = e
+	images, err := selectedImages(!skipBases)
+	if err != nil {
+		return err
+	}
+	// an engine that only plans its commands produces nothing to record
+	artifacts = nil
+	if !enginePlans(engineName, env) {
+		m, err := openManifest()
+		if err != nil {
+			return err
 		}
-		for _, image := range project.Build.Images {
-			if name != image.Name {
-				continue
-			}
-			buildTarget := image.GetImageName(env)
-			if image.BuildFrom != "" {
-				// build from thrid party image
-				buildSource := image.BuildFrom
-				titlef("Build Image %s from %s as %s", name, buildSource, buildTarget)
-				err := executePullImage(buildSource)
-				if err != nil {
-					return err
-				}
-				err = executeTagImage(buildSource, buildTarget)
-				if err != nil {
-					return err
-				}
-			} else {
-				// build from dockerfile
-				buildSource := image.BuildSrc
-				if buildSource == "" {
-					buildSource = filepath.Join(env.ImageBuildSrc, image.Name)
-				}
-				titlef("Build Image %s from %s as %s", name, buildSource, buildTarget)
-				buildBase := image.Base
-				if baseName, ok := strings.CutPrefix(buildBase, "$"); ok {
-					buildBase = GetImageName(baseName)
-				}
-				err := executeBuildImage(name, buildSource, buildTarget, buildBase)
-				if err != nil {
-					return err
-				}
+		artifacts = m
+	}
+	for _, image := range images {
+		if err = buildImage(image); err != nil {
+			break
+		}
+	}
+	// whatever was built before a failure stays recorded
+	return errors.Combine(err, artifacts.save())
+}
+
+func imageSource(image types.ImageSpec) string {
+	if image.BuildSrc != "" {
+		return image.BuildSrc
+	}
+	return filepath.Join(env.ImageBuildSrc, image.Name)
+}
+
+func imageBase(image types.ImageSpec) string {
+	if baseName, ok := image.BaseImage(); ok {
+		return GetImageName(baseName)
+	}
+	return image.Base
+}
+
+func buildImage(image types.ImageSpec) error {
+	name := image.Name
+	buildTarget := image.GetImageName(env)
+	if image.BuildMode == types.ImageBuildModeOCI {
+		return buildOCIImage(image, buildTarget)
+	}
+	if image.BuildFrom != "" {
+		// build from thrid party image
+		buildSource := image.BuildFrom
+		titlef("Build Image %s from %s as %s", name, buildSource, buildTarget)
+		if len(image.Platforms) > 0 {
+			warnf("platforms apply to Dockerfile builds only; pulling %s for this host", buildSource)
+		}
+		err := engine.Pull(buildSource)
+		if err != nil {
+			return err
+		}
+		err = engine.Tag(buildSource, buildTarget)
+		if err != nil {
+			return err
+		}
+	} else if len(image.Platforms) > 0 {
+		// Not every engine can keep a manifest list locally, so a
+		// multi-platform build pushes as part of the build instead of after.
+		return buildMultiPlatformImage(image, buildTarget)
+	} else {
+		// build from dockerfile
+		buildSource := imageSource(image)
+		titlef("Build Image %s from %s as %s", name, buildSource, buildTarget)
+		err := engine.Build(imageBuild{
+			image:   image,
+			src:     buildSource,
+			base:    imageBase(image),
+			targets: []string{buildTarget},
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if !pushImage || image.NoPush {
+		return imageBuilt(image, []string{buildTarget}, false)
+	}
+	titlef("Push Image %s", buildTarget)
+	err := engine.Push(buildTarget)
+	if err != nil {
+		return err
+	}
+	refs := []string{buildTarget}
+	if pushLatest {
+		latestTarget := image.GetImageNameWithTag(env, "latest")
+		if latestTarget != buildTarget {
+			titlef("Tag+Push Latest %s", latestTarget)
+			if err := engine.Tag(buildTarget, latestTarget); err != nil {
+				return err
 			}
-			if pushImage && !image.NoPush {
-				titlef("Push Image %s", buildTarget)
-				err := executePushImage(buildTarget)
-				if err != nil {
-					return err
-				}
-				if pushLatest {
-					latestTarget := image.GetImageNameWithTag(env, "latest")
-					if latestTarget != buildTarget {
-						titlef("Tag+Push Latest %s", latestTarget)
-						if err := executeTagImage(buildTarget, latestTarget); err != nil {
-							return err
-						}
-						if err := executePushImage(latestTarget); err != nil {
-							return err
-						}
-					}
-				}
+			if err := engine.Push(latestTarget); err != nil {
+				return err
 			}
+			refs = append(refs, latestTarget)
 		}
 	}
+	return imageBuilt(image, refs, true)
+}
+
+// imageBuilt writes the SBOMs of an image built as refs, to sbom_tgt, and
+// records it in the build manifest. A pushed image is known by the digest the
+// engine reports for it; not knowing it is only worth a warning, as the image
+// itself was built and pushed fine. An engine that only plans its commands
+// built nothing to describe.
+func imageBuilt(image types.ImageSpec, refs []string, pushed bool) error {
+	if enginePlans(engineName, env) {
+		return nil
+	}
+	a := imageArtifact{Name: image.Name, Refs: refs, Platforms: image.Platforms, Pushed: pushed}
+	if pushed && !dryRun && (artifacts != nil || len(image.SBOM) > 0) {
+		digest, err := engine.Digest(refs[0])
+		if err != nil {
+			warnf("digest of %s is unknown, leaving it out of the build manifest: %s", refs[0], err)
+		}
+		a.Digest = digest
+	}
+	sboms, err := writeImageSBOMs(image, a, filepath.Join(env.GetSBOMTgt(), image.Name))
+	if err != nil {
+		return err
+	}
+	a.SBOMs = sboms
+	artifacts.addImage(a)
 	return nil
 }
+
+// buildMultiPlatformImage builds one image for all its platforms, producing a
+// manifest list. With --push the list is pushed by the build itself, under
+// :latest too when asked.
+func buildMultiPlatformImage(image types.ImageSpec, buildTarget string) error {
+	buildSource := imageSource(image)
+	titlef("Build Image %s from %s as %s for %s", image.Name, buildSource, buildTarget, strings.Join(image.Platforms, ", "))
+	warnMissingBinaryPlatforms(image)
+	targets := []string{buildTarget}
+	push := pushImage && !image.NoPush
+	if push && pushLatest {
+		if latestTarget := image.GetImageNameWithTag(env, "latest"); latestTarget != buildTarget {
+			targets = append(targets, latestTarget)
+		}
+	}
+	err := engine.Build(imageBuild{
+		image:   image,
+		src:     buildSource,
+		base:    imageBase(image),
+		targets: targets,
+		push:    push,
+	})
+	if err != nil {
+		return err
+	}
+	return imageBuilt(image, targets, push)
+}
+
+// warnMissingBinaryPlatforms points out image platforms the same-named binary
+// is not cross-compiled for, since a Dockerfile copying that binary's
+// per-platform output would fail on them mid-build.
+func warnMissingBinaryPlatforms(image types.ImageSpec) {
+	for _, binary := range project.Build.Binaries {
+		if binary.Name != image.Name {
+			continue
+		}
+		built := make(map[string]bool)
+		for _, platform := range binary.GetPlatforms() {
+			built[platform.Name] = true
+		}
+		for _, platform := range image.Platforms {
+			// a variant such as linux/arm/v7 builds from the linux/arm binary
+			parts := strings.SplitN(platform, "/", 3)
+			if len(parts) >= 2 && !built[parts[0]+"/"+parts[1]] {
+				warnf("binary %s is not built for %s", binary.Name, platform)
+			}
+		}
+	}
+}
@@ -0,0 +1,324 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/spf13/cobra"
+
+	"github.com/xhanio/errors"
+
+	"github.com/xhanio/gopro/pkg/utils/diffutil"
+)
+
+var (
+	againstEnv   string
+	diffExitCode bool
+	diffContext  int
+)
+
+func NewDiffCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use: "diff",
+	}
+	cmd.PersistentFlags().StringVarP(&prefix, "prefix", "x", "template.", "render files with given prefix")
+	cmd.PersistentFlags().BoolVarP(&strictRender, "strict", "", true, "fail on a missing map key instead of rendering <no value>")
+	cmd.PersistentFlags().StringVarP(&againstEnv, "against-env", "", "", "diff against the rendered output of this environment instead of the target")
+	cmd.PersistentFlags().BoolVarP(&diffExitCode, "exit-code", "", false, "fail when there are differences")
+	cmd.PersistentFlags().IntVarP(&diffContext, "unified", "U", 3, "lines of context around each change")
+
+	config := &cobra.Command{
+		Use: "config",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runDiff(cmd, configUnits)
+		},
+	}
+	config.Flags().StringVarP(&configOutput, "output", "o", "", "render config output dir")
+	kubernetes := &cobra.Command{
+		Use: "kubernetes",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runDiff(cmd, kubernetesUnits)
+		},
+	}
+	kubernetes.Flags().StringVarP(&kubernetesOutput, "output", "t", "", "kubernetes output folder to store rendered templates")
+	dockerCompose := &cobra.Command{
+		Use: "docker-compose",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runDiff(cmd, func() []renderUnit { return []renderUnit{dockerComposeUnit()} })
+		},
+	}
+	cmd.AddCommand(config, kubernetes, dockerCompose)
+	return cmd
+}
+
+// runDiff renders the components units returns into memory and prints how
+// they differ from their targets on disk, or with --against-env from the
+// same components rendered for another environment. Nothing is written.
+func runDiff(cmd *cobra.Command, units func() []renderUnit) error {
+	// the diff owns stdout, so it can be piped into patch or a file
+	printOut = os.Stderr
+	var (
+		changed int
+		err     error
+	)
+	if againstEnv == "" {
+		changed, err = diffTargets(os.Stdout, units())
+	} else {
+		changed, err = diffEnvs(os.Stdout, units, againstEnv)
+	}
+	if err != nil {
+		return err
+	}
+	if changed == 0 {
+		linef("no differences")
+		return nil
+	}
+	linef("%d files differ", changed)
+	if diffExitCode {
+		// differences are an outcome, not a misuse worth the usage text
+		cmd.SilenceUsage = true
+		return errors.Newf("%d files differ", changed)
+	}
+	return nil
+}
+
+// diffTargets diffs each component's target, as it is on disk, against what
+// generate would render into it now. The diff applies with patch -p1 to bring
+// the target up to date.
+func diffTargets(w io.Writer, units []renderUnit) (int, error) {
+	var changed int
+	for _, unit := range units {
+		titlef("Diff %s against %s", unit.title, unit.dst)
+		rendered, err := renderTree(unit)
+		if err != nil {
+			return changed, err
+		}
+		// A target that is cleared first loses every file not rendered
+		// again. One that is not keeps them, so only the rendered files
+		// can change.
+		whole := unit.clear
+		if whole {
+			in, err := inPlace(unit.dst, unit.srcs...)
+			if err != nil {
+				return changed, err
+			}
+			whole = !in
+		}
+		current, err := readTarget(unit.dst, rendered, whole)
+		if err != nil {
+			return changed, err
+		}
+		// the directories the unit owns lose what it does not render again
+		for _, dir := range unit.owned {
+			owned, err := readTarget(filepath.Join(unit.dst, filepath.FromSlash(dir)), nil, true)
+			if err != nil {
+				return changed, err
+			}
+			for rel, b := range owned {
+				current[dir+"/"+rel] = b
+			}
+		}
+		label := func(side string) func(string) string {
+			return func(rel string) string {
+				return side + "/" + filepath.ToSlash(filepath.Join(unit.dst, rel))
+			}
+		}
+		changed += diffTrees(w, current, rendered, label("a"), label("b"))
+	}
+	return changed, nil
+}
+
+// diffEnvs diffs each component as rendered for the selected environment
+// against the same component rendered for other. A component generated in
+// only one of them shows as added or removed in full.
+func diffEnvs(w io.Writer, units func() []renderUnit, other string) (int, error) {
+	if _, ok := project.Env[other]; !ok {
+		return 0, errors.Newf("environment %q is not defined", other)
+	}
+	name := envName
+	if name == "" {
+		name = "default"
+	}
+	ours, err := renderUnits(units())
+	if err != nil {
+		return 0, err
+	}
+	var theirs map[string]map[string][]byte
+	err = inEnv(other, func() error {
+		var err error
+		theirs, err = renderUnits(units())
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	var changed int
+	for _, component := range sortedKeys(ours, theirs) {
+		titlef("Diff %s of %s against %s", component, name, other)
+		label := func(env string) func(string) string {
+			return func(rel string) string {
+				return env + "/" + component + "/" + rel
+			}
+		}
+		changed += diffTrees(w, ours[component], theirs[component], label(name), label(other))
+	}
+	return changed, nil
+}
+
+// inEnv runs fn with the command state switched to another environment, for
+// the templates and their helpers to render it.
+func inEnv(name string, fn func() error) error {
+	oldName, oldEnv := envName, env
+	defer func() { envName, env = oldName, oldEnv }()
+	envName, env = name, project.GetEnv(name)
+	return fn()
+}
+
+// renderUnits renders each component into memory, by name.
+func renderUnits(units []renderUnit) (map[string]map[string][]byte, error) {
+	trees := make(map[string]map[string][]byte)
+	for _, unit := range units {
+		tree, err := renderTree(unit)
+		if err != nil {
+			return nil, err
+		}
+		trees[unit.name] = tree
+	}
+	return trees, nil
+}
+
+// renderTree renders a component as generate does, into memory: its output
+// files by slash-separated path relative to the target.
+func renderTree(unit renderUnit) (map[string][]byte, error) {
+	if unit.pack != nil {
+		return unit.pack(unit)
+	}
+	return unit.layers()
+}
+
+// renderLayers renders srcs into memory one over the other, as generate does
+// the layers of a component.
+func renderLayers(name string, srcs, patterns []string) (map[string][]byte, error) {
+	rendered, err := renderFiles(name, srcs, patterns)
+	if err != nil {
+		return nil, err
+	}
+	return contents(rendered), nil
+}
+
+// renderFiles renders srcs into memory one over the other, the files of a
+// later layer replacing those of an earlier one, and keeps where each came
+// from. Template failures of every layer are reported together.
+func renderFiles(name string, srcs, patterns []string) (map[string]renderedFile, error) {
+	rendered := make(map[string]renderedFile)
+	failed := componentErrors{name: name}
+	for _, src := range srcs {
+		if !isDir(src) {
+			continue
+		}
+		err := renderTo(name, src, prefix, patterns, func(rel string, b []byte, action, from string) error {
+			rel = filepath.ToSlash(rel)
+			seq := len(rendered)
+			if was, ok := rendered[rel]; ok {
+				seq = was.seq
+			}
+			rendered[rel] = renderedFile{content: b, action: action, from: from, seq: seq}
+			return nil
+		})
+		if err := failed.add(err); err != nil {
+			return nil, err
+		}
+	}
+	if err := failed.err(); err != nil {
+		return nil, err
+	}
+	return rendered, nil
+}
+
+// contents are the rendered files by slash path, without where they came
+// from.
+func contents(rendered map[string]renderedFile) map[string][]byte {
+	tree := make(map[string][]byte, len(rendered))
+	for rel, f := range rendered {
+		tree[rel] = f.content
+	}
+	return tree
+}
+
+// readTarget reads what is in a target now: every file under it when whole
+// is set, otherwise only the files rendered into it.
+func readTarget(dst string, rendered map[string][]byte, whole bool) (map[string][]byte, error) {
+	tree := make(map[string][]byte)
+	if !whole {
+		for rel := range rendered {
+			b, err := os.ReadFile(filepath.Join(dst, filepath.FromSlash(rel)))
+			if os.IsNotExist(err) {
+				continue
+			}
+			if err != nil {
+				return nil, err
+			}
+			tree[rel] = b
+		}
+		return tree, nil
+	}
+	err := filepath.WalkDir(dst, func(path string, d fs.DirEntry, err error) error {
+		if os.IsNotExist(err) && path == dst {
+			return filepath.SkipDir
+		}
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dst, path)
+		if err != nil {
+			return err
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		tree[filepath.ToSlash(rel)] = b
+		return nil
+	})
+	return tree, err
+}
+
+// diffTrees writes the unified diff turning tree a into tree b, file by file
+// in path order, and returns how many files differ. label names the file of
+// either side for a path.
+func diffTrees(w io.Writer, a, b map[string][]byte, labelA, labelB func(rel string) string) int {
+	var changed int
+	for _, rel := range sortedKeys(a, b) {
+		before, inA := a[rel]
+		after, inB := b[rel]
+		nameA, nameB := labelA(rel), labelB(rel)
+		if !inA {
+			nameA = "/dev/null"
+		}
+		if !inB {
+			nameB = "/dev/null"
+		}
+		diff := diffutil.Unified(nameA, nameB, before, after, diffContext)
+		if diff == "" {
+			continue
+		}
+		fmt.Fprint(w, diff)
+		changed++
+	}
+	return changed
+}
+
+func sortedKeys[V any](ms ...map[string]V) []string {
+	keys := make(map[string]bool)
+	for _, m := range ms {
+		for key := range m {
+			keys[key] = true
+		}
+	}
+	return slices.Sorted(maps.Keys(keys))
+}
@@ -0,0 +1,124 @@
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/xhanio/gopro/pkg/types"
+)
+
+// withDiffContext sets the context lines cobra normally supplies via the
+// --unified default.
+func withDiffContext(t *testing.T) {
+	t.Helper()
+	old := diffContext
+	t.Cleanup(func() { diffContext = old })
+	diffContext = 3
+}
+
+// The diff shows what generate would change in a target -- a rendered file
+// that differs, one that is new and a stale one it would clear -- and leaves
+// the target as it is.
+func TestDiffConfigAgainstTarget(t *testing.T) {
+	t.Chdir(t.TempDir())
+	writeTree(t, "env/default/config/api", "template.conf.yaml", "name: [[ .Name ]]\nport: 8080\n")
+	writeTree(t, "env/default/config/api", "new.yaml", "x: 1\n")
+	writeTree(t, "dist/config/api", "conf.yaml", "name: api\nport: 80\n")
+	writeTree(t, "dist/config/api", "stale.yaml", "old\n")
+	p, e := configProject("env/default/config", "dist/config")
+	withProject(t, p, e)
+	withDiffContext(t)
+
+	var out bytes.Buffer
+	changed, err := diffTargets(&out, configUnits())
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `--- a/dist/config/api/conf.yaml
++++ b/dist/config/api/conf.yaml
+@@ -1,2 +1,2 @@
+ name: api
+-port: 80
++port: 8080
+--- /dev/null
++++ b/dist/config/api/new.yaml
+@@ -0,0 +1 @@
++x: 1
+--- a/dist/config/api/stale.yaml
++++ /dev/null
+@@ -1 +0,0 @@
+-old
+`
+	if changed != 3 || out.String() != want {
+		t.Errorf("%d files differ:\n%s\nwant 3:\n%s", changed, out.String(), want)
+	}
+	if b, _ := os.ReadFile(filepath.Join("dist", "config", "api", "conf.yaml")); string(b) != "name: api\nport: 80\n" {
+		t.Errorf("target was written: %q", b)
+	}
+}
+
+// An in-place target is never cleared, so the files beside the rendered
+// ones, the templates among them, are not part of the diff.
+func TestDiffInPlaceComparesRenderedFilesOnly(t *testing.T) {
+	t.Chdir(t.TempDir())
+	writeTree(t, "env/default/config/api", "template.conf.yaml", "a: 1\n")
+	writeTree(t, "env/default/config/api", "conf.yaml", "a: 1\n")
+	p, e := configProject("env/default/config", "")
+	withProject(t, p, e)
+	withDiffContext(t)
+
+	var out bytes.Buffer
+	changed, err := diffTargets(&out, configUnits())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if changed != 0 {
+		t.Errorf("%d files differ:\n%s", changed, out.String())
+	}
+}
+
+func TestDiffConfigAgainstEnv(t *testing.T) {
+	t.Chdir(t.TempDir())
+	writeTree(t, "env/default/config/api", "template.conf.yaml", "env: [[ .EnvName ]]\nreplicas: 1\n")
+	writeTree(t, "env/prod/config/api", "extra.yaml", "tls: true\n")
+	p, e := configProject("env/default/config", "dist/config")
+	p.Env = map[string]types.EnvSpec{
+		"local": {},
+		"prod":  {ConfigSrc: "env/prod/config"},
+	}
+	withProject(t, p, e)
+	withDiffContext(t)
+	oldName := envName
+	t.Cleanup(func() { envName = oldName })
+	envName = "local"
+
+	var out bytes.Buffer
+	changed, err := diffEnvs(&out, configUnits, "prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `--- local/api/conf.yaml
++++ prod/api/conf.yaml
+@@ -1,2 +1,2 @@
+-env: local
++env: prod
+ replicas: 1
+--- /dev/null
++++ prod/api/extra.yaml
+@@ -0,0 +1 @@
++tls: true
+`
+	if changed != 2 || out.String() != want {
+		t.Errorf("%d files differ:\n%s\nwant 2:\n%s", changed, out.String(), want)
+	}
+	if envName != "local" || env.ConfigSrc != "env/default/config" {
+		t.Errorf("environment not restored: %s %+v", envName, env)
+	}
+
+	if _, err := diffEnvs(&out, configUnits, "staging"); err == nil || !strings.Contains(err.Error(), "staging") {
+		t.Errorf("err = %v, want an undefined environment", err)
+	}
+}
@@ -1,17 +1,25 @@
 package cmd
 
 import (
+	"cmp"
 	"os"
 	"path/filepath"
+	"slices"
 
 	"github.com/spf13/cobra"
+
+	"github.com/xhanio/gopro/pkg/types"
+	"github.com/xhanio/gopro/pkg/utils/kubeschemautil"
 )
 
 var (
-	prefix string
+	prefix       string
+	strictRender bool
 
-	kubernetesOutput string
-	configOutput     string
+	kubernetesOutput   string
+	kubernetesValidate bool
+	kubernetesVersion  string
+	configOutput       string
 )
 
 func NewGenerateCmd() *cobra.Command {
@@ -19,6 +27,8 @@ func NewGenerateCmd() *cobra.Command {
 		Use: "generate",
 	}
 	cmd.PersistentFlags().StringVarP(&prefix, "prefix", "x", "template.", "generate files with given prefix")
+	cmd.PersistentFlags().BoolVarP(&strictRender, "strict", "", true, "fail on a missing map key instead of rendering <no value>")
+	addPlanFlags(cmd)
 
 	cmd.AddCommand(NewGenerateConfigCmd())
 	cmd.AddCommand(NewGenerateKubernetesCmd())
@@ -36,10 +46,109 @@ func NewGenerateConfigCmd() *cobra.Command {
 }
 
 func runGenerateConfig(cmd *cobra.Command, args []string) error {
+	for _, unit := range configUnits() {
+		if err := generate(unit); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func NewGenerateKubernetesCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:  "kubernetes",
+		RunE: runGenerateKubernetes,
+	}
+	cmd.PersistentFlags().StringVarP(&kubernetesOutput, "output", "t", "", "kubernetes output folder to store rendered templates")
+	cmd.Flags().BoolVarP(&kubernetesValidate, "validate", "", false, "check rendered manifests against the bundled schemas before writing them")
+	cmd.Flags().StringVarP(&kubernetesVersion, "kubernetes-version", "", "", "cluster version to validate against (default kubernetes_version, else "+kubeschemautil.LatestVersion+")")
+	return cmd
+}
+
+func runGenerateKubernetes(cmd *cobra.Command, args []string) error {
+	units := kubernetesUnits()
+	if kubernetesValidate {
+		// rendered once for the check and the write, so that secret
+		// providers run and stores are decrypted once
+		for i := range units {
+			rendered, err := renderFiles(units[i].name, units[i].srcs, units[i].patterns)
+			if err != nil {
+				return err
+			}
+			units[i].rendered = rendered
+		}
+		if err := validateManifests(units); err != nil {
+			return err
+		}
+	}
+	for _, unit := range units {
+		if err := generate(unit); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func NewGenerateDockerComposeCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:  "docker-compose",
+		RunE: runGenerateDockerCompose,
+	}
+	return cmd
+}
+
+func runGenerateDockerCompose(cmd *cobra.Command, args []string) error {
+	unit := dockerComposeUnit()
+	// create output directory if needed; a planned file needs none
+	if !dryRun {
+		if err := os.MkdirAll(unit.dst, 0755); err != nil {
+			return err
+		}
+	}
+	return generate(unit)
+}
+
+// renderUnit is one component as generate renders it: the directories it
+// renders from, default layer first, and the directory it renders into.
+type renderUnit struct {
+	// title names the component in progress output, e.g. "config api".
+	title    string
+	name     string
+	srcs     []string
+	dst      string
+	patterns []string
+	// clear empties dst before rendering, so the output reflects only the
+	// current sources. docker-compose shares its target with other files and
+	// is never cleared.
+	clear bool
+	// owned are the directories under dst, as slash paths, that the unit
+	// alone writes to when dst is shared, cleared like dst otherwise is.
+	owned []string
+	// pack, when set, lays the rendered component out in its target, and
+	// returns its files by slash path relative to dst.
+	pack func(unit renderUnit) (map[string][]byte, error)
+	// schema is the JSON Schema the rendered YAML and JSON files must match.
+	schema string
+	// rendered, when set, are the layers already rendered into memory, which
+	// generate and pack take instead of rendering them again.
+	rendered map[string]renderedFile
+}
+
+// layers returns the rendered layers of a unit by slash path, rendering them
+// unless they already are.
+func (unit renderUnit) layers() (map[string][]byte, error) {
+	if unit.rendered == nil {
+		return renderLayers(unit.name, unit.srcs, unit.patterns)
+	}
+	return contents(unit.rendered), nil
+}
+
+func configUnits() []renderUnit {
 	out := configOutput
 	if out == "" {
 		out = env.ConfigTgt
 	}
+	var units []renderUnit
 	for _, name := range env.Configs {
 		if !filterRegex.MatchString(name) {
 			continue
@@ -48,57 +157,41 @@ func runGenerateConfig(cmd *cobra.Command, args []string) error {
 			if name != config.Name {
 				continue
 			}
-			// generate config. With no target configured the output lands
-			// beside the templates, rendering the component in place. Resolved
-			// per component into a local so the fallback cannot leak from one
-			// to the next.
+			// With no target configured the output lands beside the
+			// templates, rendering the component in place. Resolved per
+			// component into a local so the fallback cannot leak from one to
+			// the next.
 			dst := out
 			if dst == "" {
 				dst = env.ConfigSrc
 			}
-			configDst := filepath.Join(dst, config.Name)
-			// The directories the render reads from, not the roots they came
-			// from: an unset config_src still resolves to a real directory
-			// here, and that is exactly the case the guard must catch.
-			defaultConfigSrc := filepath.Join(project.Default.ConfigSrc, config.Name)
-			envConfigSrc := filepath.Join(env.ConfigSrc, config.Name)
-			if err := clearTarget(configDst, defaultConfigSrc, envConfigSrc); err != nil {
-				return err
-			}
-			patterns := config.Files
-			// render default config
-			if fi, err := os.Stat(defaultConfigSrc); err == nil && fi.IsDir() {
-				titlef("Generate config %s from %s", config.Name, defaultConfigSrc)
-				if err := render(config.Name, defaultConfigSrc, configDst, prefix, patterns); err != nil {
-					return err
-				}
-			}
-			// render env config
-			if fi, err := os.Stat(envConfigSrc); err == nil && fi.IsDir() {
-				titlef("Generate config %s from %s", config.Name, envConfigSrc)
-				if err := render(config.Name, envConfigSrc, configDst, prefix, patterns); err != nil {
-					return err
-				}
-			}
+			units = append(units, renderUnit{
+				title: "config " + config.Name,
+				name:  config.Name,
+				// The directories the render reads from, not the roots they
+				// came from: an unset config_src still resolves to a real
+				// directory here, and that is exactly the case the in-place
+				// guard must catch.
+				srcs: []string{
+					filepath.Join(project.Default.ConfigSrc, config.Name),
+					filepath.Join(env.ConfigSrc, config.Name),
+				},
+				dst:      filepath.Join(dst, config.Name),
+				patterns: config.Files,
+				clear:    true,
+				schema:   config.Schema,
+			})
 		}
 	}
-	return nil
+	return units
 }
 
-func NewGenerateKubernetesCmd() *cobra.Command {
-	cmd := &cobra.Command{
-		Use:  "kubernetes",
-		RunE: runGenerateKubernetes,
-	}
-	cmd.PersistentFlags().StringVarP(&kubernetesOutput, "output", "t", "", "kubernetes output folder to store rendered templates")
-	return cmd
-}
-
-func runGenerateKubernetes(cmd *cobra.Command, args []string) error {
+func kubernetesUnits() []renderUnit {
 	out := kubernetesOutput
 	if out == "" {
 		out = env.KubernetesTgt
 	}
+	var units []renderUnit
 	for _, name := range env.KubernetesTemplates {
 		if !filterRegex.MatchString(name) {
 			continue
@@ -107,82 +200,117 @@ func runGenerateKubernetes(cmd *cobra.Command, args []string) error {
 			if name != template.Name {
 				continue
 			}
-			// generate kubernetes template. As with configs, an unset target
-			// renders the component in place beside its templates.
+			// As with configs, an unset target renders the component in place
+			// beside its templates.
 			dst := out
 			if dst == "" {
 				dst = env.KubernetesSrc
 			}
-			kubernetesDst := filepath.Join(dst, template.Name)
-			defaultKubernetesSrc := filepath.Join(project.Default.KubernetesSrc, template.Name)
-			envKubernetesSrc := filepath.Join(env.KubernetesSrc, template.Name)
-			if err := clearTarget(kubernetesDst, defaultKubernetesSrc, envKubernetesSrc); err != nil {
-				return err
-			}
-			patterns := template.Files
-			// render default kubernetes template
-			if fi, err := os.Stat(defaultKubernetesSrc); err == nil && fi.IsDir() {
-				titlef("Generate kubernetes template %s from %s", template.Name, defaultKubernetesSrc)
-				if err := render(template.Name, defaultKubernetesSrc, kubernetesDst, prefix, patterns); err != nil {
-					return err
-				}
+			unit := renderUnit{
+				title: "kubernetes template " + template.Name,
+				name:  template.Name,
+				srcs: []string{
+					filepath.Join(project.Default.KubernetesSrc, template.Name),
+					filepath.Join(env.KubernetesSrc, template.Name),
+				},
+				dst:      filepath.Join(dst, template.Name),
+				patterns: template.Files,
+				clear:    true,
 			}
-			// render env kubernetes template
-			if fi, err := os.Stat(envKubernetesSrc); err == nil && fi.IsDir() {
-				titlef("Generate kubernetes template %s from %s", template.Name, envKubernetesSrc)
-				if err := render(template.Name, envKubernetesSrc, kubernetesDst, prefix, patterns); err != nil {
-					return err
-				}
+			switch template.GetOutput() {
+			case types.KubernetesOutputHelm:
+				unit.pack = helmChart(template)
+			case types.KubernetesOutputKustomize:
+				// the base is the same for every environment and each writes
+				// its own overlay, so they can share a target
+				overlay := selectedEnv()
+				unit.clear = false
+				unit.owned = []string{"base", "overlays/" + overlay}
+				unit.pack = kustomizeLayout(overlay)
 			}
+			units = append(units, unit)
 		}
 	}
-	return nil
+	return units
 }
 
-func NewGenerateDockerComposeCmd() *cobra.Command {
-	cmd := &cobra.Command{
-		Use:  "docker-compose",
-		RunE: runGenerateDockerCompose,
+func dockerComposeUnit() renderUnit {
+	dst := env.DockerComposeTgt
+	if dst == "" {
+		dst = "."
+	}
+	return renderUnit{
+		title:    "docker-compose",
+		name:     "docker-compose",
+		srcs:     []string{project.Default.DockerComposeSrc, env.DockerComposeSrc},
+		dst:      dst,
+		patterns: project.Generate.DockerCompose.Files,
 	}
-	return cmd
 }
 
-func runGenerateDockerCompose(cmd *cobra.Command, args []string) error {
-	// determine output directory
-	outputDir := env.DockerComposeTgt
-	if outputDir == "" {
-		outputDir = "."
+// generate renders a component into its target: the default layer first,
+// then the environment's on top of it. Template failures of both layers are
+// reported together, and the files are checked against the unit's schema
+// before the target is cleared and written, so a failing render leaves it as
+// it was. A unit with pack writes the layout pack returns instead.
+func generate(unit renderUnit) error {
+	if unit.pack != nil {
+		tree, err := unit.pack(unit)
+		if err != nil {
+			return err
+		}
+		if err := clearUnit(unit); err != nil {
+			return err
+		}
+		titlef("Generate %s into %s", unit.title, unit.dst)
+		for _, rel := range sortedKeys(tree) {
+			linef("write %s", rel)
+			if err := writeFile(filepath.Join(unit.dst, filepath.FromSlash(rel)), tree[rel], "write", ""); err != nil {
+				return err
+			}
+		}
+		return nil
 	}
-
-	// create output directory if needed
-	if err := os.MkdirAll(outputDir, 0755); err != nil {
+	rendered := unit.rendered
+	if rendered == nil {
+		var err error
+		if rendered, err = renderFiles(unit.name, unit.srcs, unit.patterns); err != nil {
+			return err
+		}
+	}
+	if unit.schema != "" {
+		if err := validateConfigFiles(unit, rendered); err != nil {
+			return err
+		}
+	}
+	if err := clearUnit(unit); err != nil {
 		return err
 	}
-
-	// get file patterns from configuration
-	patterns := project.Generate.DockerCompose.Files
-
-	// render default docker-compose template
-	defaultSrc := project.Default.DockerComposeSrc
-	if defaultSrc != "" {
-		if fi, err := os.Stat(defaultSrc); err == nil && fi.IsDir() {
-			titlef("Generate docker-compose from %s", defaultSrc)
-			if err := render("docker-compose", defaultSrc, outputDir, prefix, patterns); err != nil {
-				return err
-			}
+	titlef("Generate %s into %s", unit.title, unit.dst)
+	order := sortedKeys(rendered)
+	slices.SortFunc(order, func(a, b string) int { return cmp.Compare(rendered[a].seq, rendered[b].seq) })
+	for _, rel := range order {
+		f := rendered[rel]
+		linef("%s %s from %s", f.action, rel, f.from)
+		if err := writeFile(filepath.Join(unit.dst, filepath.FromSlash(rel)), f.content, f.action, f.from); err != nil {
+			return err
 		}
 	}
+	return nil
+}
 
-	// render env-specific docker-compose template
-	envSrc := env.DockerComposeSrc
-	if envSrc != "" {
-		if fi, err := os.Stat(envSrc); err == nil && fi.IsDir() {
-			titlef("Generate docker-compose from %s", envSrc)
-			if err := render("docker-compose", envSrc, outputDir, prefix, patterns); err != nil {
-				return err
-			}
+// clearUnit empties what the unit alone writes to: its target when clear is
+// set, and the directories it owns under it.
+func clearUnit(unit renderUnit) error {
+	if unit.clear {
+		if err := clearTarget(unit.dst, unit.srcs...); err != nil {
+			return err
+		}
+	}
+	for _, dir := range unit.owned {
+		if err := clearTarget(filepath.Join(unit.dst, filepath.FromSlash(dir)), unit.srcs...); err != nil {
+			return err
 		}
 	}
-
 	return nil
 }
@@ -0,0 +1,243 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/xhanio/errors"
+	"github.com/xhanio/framingo/pkg/types/info"
+
+	"github.com/xhanio/gopro/pkg/types"
+	"github.com/xhanio/gopro/pkg/utils/pkgutil"
+)
+
+var packageOutput string
+
+func NewBuildPackageCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:  "package",
+		RunE: runBuildPackage,
+	}
+	cmd.Flags().StringVarP(&productVersion, "product-version", "", "", "overwrite product version, the version of binaries declaring none")
+	cmd.Flags().StringVarP(&packageOutput, "output", "o", "", "package output dir (default package_tgt, then dist/packages)")
+	return cmd
+}
+
+// runBuildPackage packs the binaries built into binary_tgt, with their
+// rendered config, a systemd unit and their maintainer scripts, into a package
+// of each format for each of their linux platforms.
+func runBuildPackage(cmd *cobra.Command, args []string) error {
+	overwriteBuildInfo()
+	output := packageOutput
+	if output == "" {
+		output = env.GetPackageTgt()
+	}
+	m, err := openManifest()
+	if err != nil {
+		return err
+	}
+	artifacts = m
+	for _, spec := range selectedPackages() {
+		if err = buildPackage(spec, output); err != nil {
+			break
+		}
+	}
+	// whatever was packaged before a failure stays recorded
+	return errors.Combine(err, artifacts.save())
+}
+
+// selectedPackages returns the build.packages entries enabled in the current
+// environment and matched by the filter, in the environment's order.
+func selectedPackages() []types.PackageSpec {
+	var result []types.PackageSpec
+	for _, name := range env.Packages {
+		if !filterRegex.MatchString(name) {
+			continue
+		}
+		for _, spec := range project.Build.Packages {
+			if name == spec.Name {
+				result = append(result, spec)
+			}
+		}
+	}
+	return result
+}
+
+// buildPackage writes the packages of one binary into dir. A binary is
+// packaged for each of its linux platforms, from its {name}_linux_{arch}
+// build.
+func buildPackage(spec types.PackageSpec, dir string) error {
+	var binary types.BinarySpec
+	found := false
+	for _, b := range project.Build.Binaries {
+		if b.Name == spec.Name {
+			binary, found = b, true
+		}
+	}
+	if !found {
+		return errors.Newf("package %s is not a binary defined in build.binaries", spec.Name)
+	}
+	applyApplicationInfo(binary)
+	version := info.ApplicationVersion
+	if version == "" {
+		return errors.Newf("package %s has no version, set the binary's version or the product version", spec.Name)
+	}
+	var arches []string
+	for _, platform := range binary.GetPlatforms() {
+		goos, goarch, _ := strings.Cut(platform.Name, "/")
+		if goos == "linux" {
+			arches = append(arches, goarch)
+		}
+	}
+	if len(arches) == 0 {
+		warnf("binary %s is built for no linux platform, nothing to package", spec.Name)
+		return nil
+	}
+	files, err := packageFiles(spec)
+	if err != nil {
+		return err
+	}
+	scripts, err := packageScripts(spec.Scripts)
+	if err != nil {
+		return err
+	}
+	var formats []string
+	for _, format := range spec.GetFormats() {
+		formats = append(formats, string(format))
+	}
+	titlef("Package %s %s for linux/%s as %s", spec.Name, version, strings.Join(arches, ", linux/"), strings.Join(formats, ", "))
+	if !dryRun {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	modTime := buildTimestamp()
+	for _, goarch := range arches {
+		src := filepath.Join(env.BinaryTgt, fmt.Sprintf("%s_linux_%s", spec.Name, goarch))
+		p := pkgutil.Package{
+			Name:        spec.Name,
+			Version:     version,
+			Release:     spec.GetRelease(),
+			Arch:        goarch,
+			Maintainer:  spec.Maintainer,
+			Description: spec.Description,
+			Homepage:    spec.Homepage,
+			License:     spec.License,
+			Depends:     spec.Depends,
+			Files:       files,
+			Scripts:     scripts,
+			ModTime:     modTime,
+		}
+		if !dryRun {
+			data, err := os.ReadFile(src)
+			if err != nil {
+				return errors.Newf("binary %s for linux/%s is missing, build it first: %s", spec.Name, goarch, err)
+			}
+			p.Files = append([]pkgutil.File{{Path: path.Join(spec.GetBinDir(), spec.Name), Data: data, Mode: 0755}}, files...)
+		}
+		for _, format := range formats {
+			name, err := pkgutil.FileName(format, p)
+			if err != nil {
+				return errors.Newf("package %s: %s", spec.Name, err)
+			}
+			output := filepath.Join(dir, name)
+			if dryRun {
+				currentPlan.add(planStep{Action: "package", Path: output, Source: src})
+				continue
+			}
+			if err := pkgutil.Write(output, format, p); err != nil {
+				return errors.Newf("package %s: %s", spec.Name, err)
+			}
+			linef("wrote %s", output)
+			err = artifacts.recordPackage(packageArtifact{
+				Name:     spec.Name,
+				Version:  version,
+				Format:   format,
+				Platform: "linux/" + goarch,
+				Path:     output,
+			})
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// packageFiles returns what a package installs besides its binary: the
+// rendered config as config files, and the systemd unit running the binary.
+func packageFiles(spec types.PackageSpec) ([]pkgutil.File, error) {
+	var files []pkgutil.File
+	err := walkRenderedConfig(spec.Name, "the package", func(path string, data []byte) {
+		files = append(files, pkgutil.File{Path: path, Data: data, Mode: 0644, Config: true})
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !spec.Systemd.Disabled {
+		files = append(files, pkgutil.File{
+			Path: "/usr/lib/systemd/system/" + spec.Name + ".service",
+			Data: []byte(systemdUnit(spec)),
+			Mode: 0644,
+		})
+	}
+	return files, nil
+}
+
+// systemdUnit returns the unit running the packaged binary as a service,
+// restarted when it fails.
+func systemdUnit(spec types.PackageSpec) string {
+	description, _, _ := strings.Cut(strings.TrimSpace(spec.Description), "\n")
+	if description == "" {
+		description = spec.Name
+	}
+	exec := []string{path.Join(spec.GetBinDir(), spec.Name)}
+	for _, arg := range spec.Systemd.Args {
+		if strings.ContainsAny(arg, " \t\"'\\") {
+			arg = strconv.Quote(arg)
+		}
+		exec = append(exec, arg)
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "[Unit]\nDescription=%s\nWants=network-online.target\nAfter=network-online.target\n\n", description)
+	fmt.Fprintf(&b, "[Service]\nExecStart=%s\n", strings.Join(exec, " "))
+	if spec.Systemd.User != "" {
+		fmt.Fprintf(&b, "User=%s\n", spec.Systemd.User)
+	}
+	for _, e := range spec.Systemd.Environment {
+		fmt.Fprintf(&b, "Environment=%s\n", strconv.Quote(e))
+	}
+	b.WriteString("Restart=on-failure\n\n[Install]\nWantedBy=multi-user.target\n")
+	return b.String()
+}
+
+// packageScripts reads the maintainer scripts of a package, relative to the
+// project root.
+func packageScripts(s types.ScriptsSpec) (pkgutil.Scripts, error) {
+	var scripts pkgutil.Scripts
+	for _, script := range []struct {
+		src  string
+		body *string
+	}{
+		{s.PreInstall, &scripts.PreInstall},
+		{s.PostInstall, &scripts.PostInstall},
+		{s.PreRemove, &scripts.PreRemove},
+		{s.PostRemove, &scripts.PostRemove},
+	} {
+		if script.src == "" {
+			continue
+		}
+		b, err := os.ReadFile(filepath.Join(info.ProjectRoot, script.src))
+		if err != nil {
+			return scripts, err
+		}
+		*script.body = string(b)
+	}
+	return scripts, nil
+}
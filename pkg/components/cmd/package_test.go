@@ -0,0 +1,149 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/xhanio/framingo/pkg/types/info"
+
+	"github.com/xhanio/gopro/pkg/types"
+)
+
+// withPackages lays out built binaries, rendered config and a maintainer
+// script in a project packaging api, and restores the package state
+// afterwards.
+func withPackages(t *testing.T, spec types.PackageSpec) {
+	t.Helper()
+	dir := t.TempDir()
+	t.Chdir(dir)
+	resetInfo(t)
+	withManifestFlags(t)
+	oldRoot, oldTime, oldOutput, oldArtifacts := info.ProjectRoot, info.BuildTime, packageOutput, artifacts
+	t.Cleanup(func() {
+		info.ProjectRoot, info.BuildTime, packageOutput, artifacts = oldRoot, oldTime, oldOutput, oldArtifacts
+	})
+	info.ProjectRoot, info.BuildTime, packageOutput = dir, "2025-01-02T03:04:05Z", ""
+
+	writeTree(t, "bin", "api_linux_amd64", "api amd64")
+	writeTree(t, "bin", "api_linux_arm64", "api arm64")
+	writeTree(t, "bin", "api_windows_amd64", "api windows")
+	writeTree(t, filepath.Join("dist", "config", "api"), "config.yaml", "port: 80\n")
+	writeTree(t, "scripts", "postinst.sh", "systemctl daemon-reload\n")
+	e := types.EnvSpec{BinaryTgt: "bin", ConfigTgt: filepath.Join("dist", "config"), Packages: []string{"api"}}
+	withProject(t, types.Project{
+		Build: types.BuildSpec{
+			Binaries: []types.BinarySpec{{
+				Name:      "api",
+				Version:   "v1.2.3",
+				ConfigDir: "/etc/api",
+				Platforms: []types.PlatformSpec{{Name: "linux/amd64"}, {Name: "linux/arm64"}, {Name: "windows/amd64"}},
+			}},
+			Packages: []types.PackageSpec{spec},
+		},
+	}, e)
+}
+
+func TestBuildPackageWritesEachLinuxPlatform(t *testing.T) {
+	withPackages(t, types.PackageSpec{
+		Name:    "api",
+		Formats: []types.PackageFormat{types.PackageFormatDeb, types.PackageFormatAPK},
+		Scripts: types.ScriptsSpec{PostInstall: "scripts/postinst.sh"},
+	})
+	if err := runBuildPackage(nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	entries, err := os.ReadDir(filepath.Join("dist", "packages"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, e := range entries {
+		got = append(got, e.Name())
+	}
+	want := []string{
+		"api-1.2.3-r1.aarch64.apk",
+		"api-1.2.3-r1.x86_64.apk",
+		"api_1.2.3-1_amd64.deb",
+		"api_1.2.3-1_arm64.deb",
+	}
+	if !slices.Equal(got, want) {
+		t.Fatalf("packaged %q, want %q", got, want)
+	}
+
+	m, err := readManifest(filepath.Join("dist", "build-manifest.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Packages) != 4 || m.Packages[0].Format != "apk" || m.Packages[0].Platform != "linux/arm64" || m.Packages[0].SHA256 == "" {
+		t.Errorf("manifest packages = %+v", m.Packages)
+	}
+}
+
+func TestBuildPackageNeedsTheBinariesBuilt(t *testing.T) {
+	withPackages(t, types.PackageSpec{Name: "api"})
+	if err := os.Remove(filepath.Join("bin", "api_linux_arm64")); err != nil {
+		t.Fatal(err)
+	}
+	err := runBuildPackage(nil, nil)
+	if err == nil || !strings.Contains(err.Error(), "linux/arm64") {
+		t.Errorf("err = %v, want the missing arm64 build", err)
+	}
+}
+
+func TestDryRunBuildPackagePlansEachPackage(t *testing.T) {
+	withPackages(t, types.PackageSpec{Name: "api"})
+	pl := withDryRun(t, "text")
+	if err := runBuildPackage(nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join("dist", "packages")); !os.IsNotExist(err) {
+		t.Errorf("dry run wrote packages (err=%v)", err)
+	}
+	var got []string
+	for _, step := range pl.Steps {
+		got = append(got, step.Action+" "+filepath.Base(step.Path)+" from "+step.Source)
+	}
+	want := []string{
+		"package api_1.2.3-1_amd64.deb from " + filepath.Join("bin", "api_linux_amd64"),
+		"package api-1.2.3-1.x86_64.rpm from " + filepath.Join("bin", "api_linux_amd64"),
+		"package api_1.2.3-1_arm64.deb from " + filepath.Join("bin", "api_linux_arm64"),
+		"package api-1.2.3-1.aarch64.rpm from " + filepath.Join("bin", "api_linux_arm64"),
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("planned %q, want %q", got, want)
+	}
+}
+
+func TestSystemdUnit(t *testing.T) {
+	got := systemdUnit(types.PackageSpec{
+		Name:        "api",
+		Description: "The API server\nServes it.",
+		BinDir:      "/opt/api/bin",
+		Systemd: types.SystemdSpec{
+			Args:        []string{"serve", "--name", "my api"},
+			User:        "api",
+			Environment: []string{"GOMAXPROCS=2"},
+		},
+	})
+	want := `[Unit]
+Description=The API server
+Wants=network-online.target
+After=network-online.target
+
+[Service]
+ExecStart=/opt/api/bin/api serve --name "my api"
+User=api
+Environment="GOMAXPROCS=2"
+Restart=on-failure
+
+[Install]
+WantedBy=multi-user.target
+`
+	if got != want {
+		t.Errorf("unit:\n%s\nwant:\n%s", got, want)
+	}
+}
@@ -0,0 +1,230 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/spf13/cobra"
+
+	"github.com/xhanio/errors"
+	"github.com/xhanio/framingo/pkg/types/info"
+	"github.com/xhanio/framingo/pkg/utils/envutil"
+
+	"github.com/xhanio/gopro/pkg/types"
+	"github.com/xhanio/gopro/pkg/utils/archiveutil"
+)
+
+var (
+	releaseOutput string
+	releaseBuild  bool
+)
+
+func NewReleaseCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:  "release",
+		RunE: runRelease,
+	}
+	addPlanFlags(cmd)
+	cmd.Flags().StringVarP(&buildVersion, "build-version", "", "", "overwrite build version, the version released")
+	cmd.Flags().StringVarP(&releaseOutput, "output", "o", "", "release output dir, holding one dir per version (default release.dir)")
+	cmd.Flags().BoolVarP(&releaseBuild, "build", "", false, "build the binaries before packing them")
+	cmd.Flags().IntVarP(&buildJobs, "jobs", "j", 1, "with --build, number of builds to run concurrently, 0 for one per CPU")
+	cmd.Flags().BoolVarP(&signArtifacts, "sign", "", false, "sign the checksum file, and the binaries built with --build, with the private key named by signing.key_env")
+	return cmd
+}
+
+// releaseArchive is the archive of one platform: every selected binary built
+// for it, and the release files.
+type releaseArchive struct {
+	goos, goarch string
+	files        []archiveutil.File
+}
+
+// runRelease packs the binaries built into binary_tgt into one archive per
+// platform, and lays them out with their checksums in a directory of the
+// version released, replacing whatever an earlier release of it left. Under
+// --sign the checksum file is signed.
+func runRelease(cmd *cobra.Command, args []string) error {
+	overwriteBuildInfo()
+	if releaseBuild {
+		// the build loads the signer too
+		if err := runBuildBinary(cmd, args); err != nil {
+			return err
+		}
+	} else {
+		s, err := openSigner()
+		if err != nil {
+			return err
+		}
+		signer = s
+	}
+	version := info.BuildVersion
+	if version == "" {
+		return errors.Newf("no version to release, tag a commit or pass --build-version")
+	}
+	nameTemplate, err := releaseNameTemplate(project.Release)
+	if err != nil {
+		return err
+	}
+	archives, err := releaseArchives()
+	if err != nil {
+		return err
+	}
+	if len(archives) == 0 {
+		warnf("no binaries selected, nothing to release")
+		return nil
+	}
+	root := releaseOutput
+	if root == "" {
+		root = project.Release.GetDir()
+	}
+	dir := filepath.Join(root, version)
+	titlef("Release %s into %s", version, dir)
+	if err := removeAll(dir); err != nil {
+		return err
+	}
+	if !dryRun {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	modTime := buildTimestamp()
+	sums := make(map[string]string)
+	for _, a := range archives {
+		var name bytes.Buffer
+		err := nameTemplate.Execute(&name, map[string]string{
+			"Product": project.Product,
+			"Version": version,
+			"Os":      a.goos,
+			"Arch":    a.goarch,
+		})
+		if err != nil {
+			return err
+		}
+		format := project.Release.GetFormat(a.goos)
+		output := filepath.Join(dir, name.String()+"."+string(format))
+		if dryRun {
+			var srcs []string
+			for _, f := range a.files {
+				srcs = append(srcs, f.Src)
+			}
+			currentPlan.add(planStep{Action: "archive", Path: output, Source: strings.Join(srcs, ", ")})
+			continue
+		}
+		write := archiveutil.WriteTarGz
+		if format == types.ArchiveFormatZip {
+			write = archiveutil.WriteZip
+		}
+		if err := write(output, a.files, modTime); err != nil {
+			return errors.Newf("release %s/%s: %s", a.goos, a.goarch, err)
+		}
+		digest, err := fileDigest(output)
+		if err != nil {
+			return err
+		}
+		linef("pack %s", output)
+		sums[filepath.Base(output)] = strings.TrimPrefix(digest, "sha256:")
+	}
+	// in the format sha256sum -c reads
+	var b strings.Builder
+	for _, name := range sortedKeys(sums) {
+		fmt.Fprintf(&b, "%s  %s\n", sums[name], name)
+	}
+	checksums := filepath.Join(dir, project.Release.GetChecksums())
+	if err := writeFile(checksums, []byte(b.String()), "checksum", dir); err != nil {
+		return err
+	}
+	// the checksums cover every archive, so their signature covers them too
+	_, err = signFile(checksums)
+	return err
+}
+
+// releaseNameTemplate parses the archive name template of a release.
+func releaseNameTemplate(r types.ReleaseSpec) (*template.Template, error) {
+	return template.New("name_template").Delims("[[", "]]").Option("missingkey=error").Funcs(funcMap()).Parse(r.GetNameTemplate())
+}
+
+// releaseArchives gathers the archives to release, by platform. A binary is
+// released for each of its platforms, from its {name}_{os}_{arch} build; one
+// without platforms is released for the platform its host build is for.
+func releaseArchives() ([]releaseArchive, error) {
+	extra, err := releaseFiles(project.Release.Files)
+	if err != nil {
+		return nil, err
+	}
+	byPlatform := make(map[string]*releaseArchive)
+	for _, binary := range selectedBinaries() {
+		platforms := binary.GetPlatforms()
+		sources := make(map[string]string, len(platforms))
+		for _, platform := range platforms {
+			goos, goarch, _ := strings.Cut(platform.Name, "/")
+			sources[platform.Name] = filepath.Join(env.BinaryTgt, fmt.Sprintf("%s_%s_%s", binary.Name, goos, goarch))
+		}
+		if len(platforms) == 0 {
+			host := hostPlatform(envutil.Merge(env.BinaryBuildEnv, binary.BuildEnv))
+			sources[host] = filepath.Join(env.BinaryTgt, binary.Name)
+		}
+		for _, platform := range sortedKeys(sources) {
+			src := sources[platform]
+			if _, err := os.Stat(src); err != nil && !dryRun {
+				return nil, errors.Newf("binary %s for %s is missing, build it first or pass --build: %s", binary.Name, platform, err)
+			}
+			a, ok := byPlatform[platform]
+			if !ok {
+				goos, goarch, _ := strings.Cut(platform, "/")
+				a = &releaseArchive{goos: goos, goarch: goarch}
+				byPlatform[platform] = a
+			}
+			name := binary.Name
+			if a.goos == "windows" {
+				name += ".exe"
+			}
+			a.files = append(a.files, archiveutil.File{Name: name, Src: src, Mode: 0755})
+		}
+	}
+	var archives []releaseArchive
+	for _, platform := range sortedKeys(byPlatform) {
+		a := byPlatform[platform]
+		a.files = append(a.files, extra...)
+		archives = append(archives, *a)
+	}
+	return archives, nil
+}
+
+// releaseFiles resolves the release files, paths or globs relative to the
+// project root, into the files packed beside the binaries. A directory brings
+// every file under it.
+func releaseFiles(patterns []string) ([]archiveutil.File, error) {
+	var files []archiveutil.File
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(filepath.Join(info.ProjectRoot, pattern))
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) == 0 {
+			return nil, errors.Newf("release file %q matches nothing", pattern)
+		}
+		for _, match := range matches {
+			err := filepath.WalkDir(match, func(path string, d fs.DirEntry, err error) error {
+				if err != nil || d.IsDir() {
+					return err
+				}
+				rel, err := filepath.Rel(info.ProjectRoot, path)
+				if err != nil {
+					return err
+				}
+				files = append(files, archiveutil.File{Name: filepath.ToSlash(rel), Src: path})
+				return nil
+			})
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return files, nil
+}
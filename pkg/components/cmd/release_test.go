@@ -0,0 +1,134 @@
+package cmd
+
+import (
+	"archive/zip"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/xhanio/framingo/pkg/types/info"
+
+	"github.com/xhanio/gopro/pkg/types"
+)
+
+// withRelease lays out built binaries and a README in a project with a
+// release section, and restores the release state afterwards.
+func withRelease(t *testing.T, r types.ReleaseSpec) {
+	t.Helper()
+	dir := t.TempDir()
+	t.Chdir(dir)
+	resetInfo(t)
+	oldRoot, oldTime, oldOutput, oldBuild, oldVersion := info.ProjectRoot, info.BuildTime, releaseOutput, releaseBuild, buildVersion
+	t.Cleanup(func() {
+		info.ProjectRoot, info.BuildTime, releaseOutput, releaseBuild, buildVersion = oldRoot, oldTime, oldOutput, oldBuild, oldVersion
+	})
+	info.ProjectRoot, info.BuildTime, releaseOutput, releaseBuild, buildVersion = dir, "2025-01-02T03:04:05Z", "", false, ""
+	info.BuildVersion = "v1.0.0"
+
+	writeTree(t, "bin", "api_linux_amd64", "api linux")
+	writeTree(t, "bin", "api_windows_amd64", "api windows")
+	writeTree(t, "bin", "cli", "cli host")
+	writeTree(t, ".", "README.md", "# demo\n")
+	e := types.EnvSpec{BinaryTgt: "bin", Binaries: []string{"api", "cli"}}
+	withProject(t, types.Project{
+		Product: "demo",
+		Build: types.BuildSpec{Binaries: []types.BinarySpec{
+			{Name: "api", Platforms: []types.PlatformSpec{{Name: "linux/amd64"}, {Name: "windows/amd64"}}},
+			{Name: "cli"},
+		}},
+		Release: r,
+	}, e)
+}
+
+func TestReleasePacksEachPlatform(t *testing.T) {
+	withRelease(t, types.ReleaseSpec{
+		Files:           []string{"README.md"},
+		FormatOverrides: []types.FormatOverride{{Goos: "windows", Format: types.ArchiveFormatZip}},
+	})
+	writeTree(t, filepath.Join("dist", "release", "v1.0.0"), "stale.tar.gz", "old")
+	if err := runRelease(nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := filepath.Join("dist", "release", "v1.0.0")
+	host := runtime.GOOS + "_" + runtime.GOARCH
+	archives := []string{"demo_v1.0.0_linux_amd64.tar.gz", "demo_v1.0.0_windows_amd64.zip", "demo_v1.0.0_" + host + ".tar.gz"}
+	if host == "linux_amd64" {
+		// the host build of cli joins the linux/amd64 archive of api
+		archives = archives[:2]
+	}
+	slices.Sort(archives)
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, e := range entries {
+		got = append(got, e.Name())
+	}
+	want := append(slices.Clone(archives), "SHA256SUMS")
+	slices.Sort(want)
+	if !slices.Equal(got, want) {
+		t.Fatalf("released %q, want %q", got, want)
+	}
+
+	sums, err := os.ReadFile(filepath.Join(dir, "SHA256SUMS"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wantSums strings.Builder
+	for _, name := range archives {
+		digest, err := fileDigest(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		fmt.Fprintf(&wantSums, "%s  %s\n", strings.TrimPrefix(digest, "sha256:"), name)
+	}
+	if string(sums) != wantSums.String() {
+		t.Errorf("SHA256SUMS:\n%s\nwant:\n%s", sums, wantSums.String())
+	}
+
+	r, err := zip.OpenReader(filepath.Join(dir, "demo_v1.0.0_windows_amd64.zip"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	var names []string
+	for _, f := range r.File {
+		names = append(names, f.Name)
+	}
+	if !slices.Equal(names, []string{"api.exe", "README.md"}) {
+		t.Errorf("windows archive holds %q", names)
+	}
+}
+
+func TestReleaseNamesArchivesByTemplate(t *testing.T) {
+	withRelease(t, types.ReleaseSpec{NameTemplate: "[[ .Product | upper ]]-[[ .Os ]]-[[ .Arch ]]"})
+	releaseOutput = "out"
+	if err := runRelease(nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join("out", "v1.0.0", "DEMO-linux-amd64.tar.gz")); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestReleaseNeedsTheBinariesBuilt(t *testing.T) {
+	withRelease(t, types.ReleaseSpec{})
+	if err := os.Remove(filepath.Join("bin", "api_windows_amd64")); err != nil {
+		t.Fatal(err)
+	}
+	err := runRelease(nil, nil)
+	if err == nil || !strings.Contains(err.Error(), "windows/amd64") {
+		t.Errorf("err = %v, want the missing windows build", err)
+	}
+
+	info.BuildVersion = ""
+	if err := runRelease(nil, nil); err == nil {
+		t.Error("released without a version")
+	}
+}
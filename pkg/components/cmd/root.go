@@ -8,6 +8,7 @@ import (
 	"time"
 
 	"github.com/spf13/cobra"
+	"go.uber.org/config"
 
 	"github.com/xhanio/framingo/pkg/types/info"
 
@@ -49,8 +50,14 @@ func NewRootCmd() *cobra.Command {
 			if cmd.Name() == "version" {
 				return nil
 			}
-			// load project.yaml to setup project & env
-			err := loadConfig()
+			// load project.yaml to setup project & env. Unknown keys fail the
+			// load, except for validate, which reports them itself with their
+			// positions and so has to get past them.
+			var opts []config.YAMLOption
+			if cmd.Name() == "validate" {
+				opts = append(opts, config.Permissive())
+			}
+			err := loadConfig(opts...)
 			if err != nil {
 				return err
 			}
@@ -90,7 +97,7 @@ func NewRootCmd() *cobra.Command {
 				return err
 			}
 			filterRegex = r
-			return nil
+			return startPlan()
 		},
 	}
 	root.PersistentFlags().BoolVar(&help, "help", false, "")
@@ -102,6 +109,14 @@ func NewRootCmd() *cobra.Command {
 	root.AddCommand(NewInitCmd())
 	root.AddCommand(NewBuildCmd())
 	root.AddCommand(NewGenerateCmd())
+	root.AddCommand(NewDiffCmd())
+	root.AddCommand(NewReleaseCmd())
+	root.AddCommand(NewValidateCmd())
+	root.AddCommand(NewVerifyCmd())
+	root.AddCommand(NewWatchCmd())
+	root.AddCommand(NewRunCmd())
+	root.AddCommand(NewTestCmd())
+	root.AddCommand(NewSecretCmd())
 	root.AddCommand(NewExampleCmd())
 	root.AddCommand(NewVersionCmd())
 	return root
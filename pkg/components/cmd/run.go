@@ -0,0 +1,187 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+
+	"github.com/spf13/cobra"
+
+	"github.com/xhanio/errors"
+
+	"github.com/xhanio/gopro/pkg/types"
+)
+
+var runNoBuild bool
+
+func NewRunCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "run <binary> [-- args...]",
+		Short: "Build a binary for the host and run it with its rendered config",
+		Args:  cobra.MinimumNArgs(1),
+		RunE:  runRun,
+	}
+	cmd.Flags().StringVarP(&binaryOutput, "output", "o", "", "build binary output dir")
+	cmd.Flags().BoolVarP(&runNoBuild, "no-build", "", false, "run the binary already in the output dir")
+	cmd.Flags().StringVarP(&prefix, "prefix", "x", "template.", "render config files with given prefix")
+	cmd.Flags().BoolVarP(&strictRender, "strict", "", true, "fail on a missing map key instead of rendering <no value>")
+	return cmd
+}
+
+// exitError is how a binary gopro run ran failed: gopro exits with its code.
+type exitError struct {
+	code int
+}
+
+func (e exitError) Error() string {
+	return fmt.Sprintf("exit status %d", e.code)
+}
+
+// ExitCode returns the code gopro exits with on err: that of the binary
+// gopro run ran, or -1 for a failure of gopro's own.
+func ExitCode(err error) int {
+	if e, ok := err.(exitError); ok {
+		return e.code
+	}
+	return -1
+}
+
+// runRun builds the host binary of the named binary as build binary would,
+// renders its config as generate config would, then runs it in the
+// foreground with the environment of runEnv, forwarding the signals gopro
+// gets and exiting with its code.
+func runRun(cmd *cobra.Command, args []string) error {
+	name, args := args[0], args[1:]
+	i := slices.IndexFunc(project.Build.Binaries, func(b types.BinarySpec) bool { return b.Name == name })
+	if i < 0 {
+		return errors.Newf("binary %s is not defined in build.binaries", name)
+	}
+	binary := project.Build.Binaries[i]
+	overwriteBuildInfo()
+	if binaryOutput == "" {
+		binaryOutput = env.BinaryTgt
+	}
+	if !runNoBuild {
+		// a local run is not a release: nothing to record or sign
+		artifacts, signer = nil, nil
+		builds = loadFingerprints(binaryOutput)
+		src := binarySource(binary)
+		applyApplicationInfo(binary)
+		titlef("Build Binary %s from %s", binary.Name, src)
+		err := executeBuildBinary(binary, types.PlatformSpec{}, src, binaryOutput)
+		if err := errors.Combine(err, builds.save()); err != nil {
+			return err
+		}
+	}
+	var configDir string
+	for _, unit := range configUnits() {
+		if unit.name != name {
+			continue
+		}
+		if err := os.MkdirAll(unit.dst, 0755); err != nil {
+			return err
+		}
+		if err := generate(unit); err != nil {
+			return err
+		}
+		configDir = unit.dst
+	}
+	environ, err := runEnv(configDir, name)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(binaryOutput, name)
+	titlef("Run %s", strings.Join(append([]string{path}, args...), " "))
+	code, err := runForeground(path, args, environ)
+	if err != nil {
+		return err
+	}
+	if code != 0 {
+		// the binary has said why it failed
+		cmd.SilenceErrors, cmd.SilenceUsage = true, true
+		return exitError{code: code}
+	}
+	return nil
+}
+
+// runEnv returns gopro's environment plus the config dir, as the
+// GetEnvKey "CONFIG_DIR" a deployment sets, and every secret key of the
+// component as the default provider, secrets.default, has them: the very
+// values its templates render. Keys take the product prefix unless they
+// already carry it. A provider that looks keys up one by one, env or exec,
+// has none to list, and exports nothing.
+func runEnv(configDir, name string) ([]string, error) {
+	vars := make(map[string]string)
+	p, err := secretProviderFor(project.Secrets.GetDefault())
+	if err != nil {
+		return nil, err
+	}
+	if l, ok := p.(secretLister); ok {
+		kv, err := l.list(name)
+		if err != nil {
+			return nil, err
+		}
+		for key, val := range kv {
+			if !strings.HasPrefix(key, GetEnvKey("")) {
+				key = GetEnvKey(key)
+			}
+			vars[key] = val
+		}
+	}
+	if configDir != "" {
+		abs, err := filepath.Abs(configDir)
+		if err != nil {
+			return nil, err
+		}
+		vars[GetEnvKey("CONFIG_DIR")] = abs
+	}
+	environ := os.Environ()
+	for _, key := range sortedKeys(vars) {
+		if verbose {
+			debugf("export %s", key)
+		}
+		environ = append(environ, key+"="+vars[key])
+	}
+	return environ, nil
+}
+
+// runForeground runs path until it exits, passing on the signals that would
+// stop gopro so the binary can shut down in its own way, and returns its exit
+// code.
+func runForeground(path string, args, environ []string) (int, error) {
+	p := exec.Command(path, args...)
+	p.Env = environ
+	p.Stdin, p.Stdout, p.Stderr = os.Stdin, os.Stdout, os.Stderr
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGQUIT)
+	defer signal.Stop(signals)
+	if err := p.Start(); err != nil {
+		return 0, err
+	}
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		for {
+			select {
+			case sig := <-signals:
+				p.Process.Signal(sig)
+			case <-done:
+				return
+			}
+		}
+	}()
+	err := p.Wait()
+	if exit, ok := err.(*exec.ExitError); ok {
+		// killed by a signal, it exits as a shell reports it
+		if status, ok := exit.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			return 128 + int(status.Signal()), nil
+		}
+		return exit.ExitCode(), nil
+	}
+	return 0, err
+}
@@ -0,0 +1,91 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+
+	"github.com/xhanio/framingo/pkg/types/info"
+
+	"github.com/xhanio/gopro/pkg/types"
+)
+
+func TestRunPassesConfigAndSecretsToTheBinary(t *testing.T) {
+	withGoModule(t)
+	info.ProductName = "demo"
+	// the binary writes the variables it is given to its first arg, then
+	// exits with its second
+	writeTree(t, "cmd/api", "main.go", `package main
+
+import (
+	"os"
+	"strconv"
+	"strings"
+)
+
+func main() {
+	var vars []string
+	for _, kv := range os.Environ() {
+		if strings.HasPrefix(kv, "DEMO_") {
+			vars = append(vars, kv)
+		}
+	}
+	os.WriteFile(os.Args[1], []byte(strings.Join(vars, "\n")), 0644)
+	code, _ := strconv.Atoi(os.Args[2])
+	os.Exit(code)
+}
+`)
+	writeTree(t, "env/default/api", "template.app.yaml", "name: [[ .Name ]]\n")
+	// the secrets of the default provider, age, as its templates render them
+	id := withAgeKey(t)
+	encryptSecretEnv(t, "env/default/api", "DB_PASSWORD=default\nAPI_KEY=key\n", id)
+	encryptSecretEnv(t, "env/local/api", "# local overrides\nDB_PASSWORD=local\nDEMO_TOKEN=token\n", id)
+	spec := types.EnvSpec{BinaryTgt: "bin", ConfigSrc: "env/default", ConfigTgt: "out", Configs: []string{"api"}}
+	e := spec
+	e.ConfigSrc = "env/local"
+	withProject(t, types.Project{
+		Default:  spec,
+		Build:    types.BuildSpec{Binaries: []types.BinarySpec{{Name: "api", Src: "cmd/api"}}},
+		Generate: types.GenerateSpec{Configs: []types.ConfigSpec{{Name: "api"}}},
+		Secrets:  types.SecretsSpec{Default: "age"},
+	}, e)
+	oldNoBuild := runNoBuild
+	t.Cleanup(func() { runNoBuild = oldNoBuild })
+	runNoBuild = false
+
+	dump := filepath.Join(t.TempDir(), "env")
+	if err := runRun(&cobra.Command{}, []string{"api", dump, "0"}); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(dump)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := filepath.Abs(filepath.Join("out", "api"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		GetEnvKey("API_KEY") + "=key",
+		GetEnvKey("CONFIG_DIR") + "=" + config,
+		GetEnvKey("DB_PASSWORD") + "=local",
+		"DEMO_TOKEN=token",
+	}
+	if got := string(b); got != strings.Join(want, "\n") {
+		t.Errorf("binary got\n%s\nwant\n%s", got, strings.Join(want, "\n"))
+	}
+	if _, err := os.Stat(filepath.Join("out", "api", "app.yaml")); err != nil {
+		t.Errorf("config not rendered: %s", err)
+	}
+
+	err = runRun(&cobra.Command{}, []string{"api", dump, "3"})
+	if ExitCode(err) != 3 {
+		t.Errorf("err = %v, want the binary's exit code 3", err)
+	}
+	if err := runRun(&cobra.Command{}, []string{"worker"}); ExitCode(err) != -1 || !strings.Contains(err.Error(), "not defined") {
+		t.Errorf("err = %v, want an undefined binary", err)
+	}
+}
@@ -0,0 +1,416 @@
+package cmd
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"text/template"
+	"text/template/parse"
+
+	"github.com/spf13/cobra"
+
+	"github.com/xhanio/errors"
+
+	"github.com/xhanio/gopro/pkg/types"
+)
+
+var secretLength int
+
+func NewSecretCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "secret",
+		Short: "Manage the encrypted secret.env.age of components",
+	}
+	cmd.AddCommand(&cobra.Command{
+		Use:   "set <component> KEY [VALUE]",
+		Short: "Set a secret, read from stdin when VALUE is not given",
+		Args:  cobra.RangeArgs(2, 3),
+		RunE:  runSecretSet,
+	})
+	cmd.AddCommand(&cobra.Command{
+		Use:   "get <component> KEY",
+		Short: "Print the value a secret has in the environment",
+		Args:  cobra.ExactArgs(2),
+		RunE:  runSecretGet,
+	})
+	cmd.AddCommand(&cobra.Command{
+		Use:   "list <component>",
+		Short: "List the secrets of a component in the environment and where each comes from",
+		Args:  cobra.ExactArgs(1),
+		RunE:  runSecretList,
+	})
+	cmd.AddCommand(&cobra.Command{
+		Use:   "rm <component> KEY",
+		Short: "Remove a secret from the environment's store",
+		Args:  cobra.ExactArgs(2),
+		RunE:  runSecretRm,
+	})
+	rotate := &cobra.Command{
+		Use:   "rotate <component> [KEY]",
+		Short: "Set a secret to a new random value, or re-encrypt the store to the current recipients",
+		Args:  cobra.RangeArgs(1, 2),
+		RunE:  runSecretRotate,
+	}
+	rotate.Flags().IntVarP(&secretLength, "length", "", 32, "random bytes in a rotated value, which is base64url encoded")
+	cmd.AddCommand(rotate)
+	check := &cobra.Command{
+		Use:   "check [component...]",
+		Short: "Report the secrets templates reference that the environment is missing",
+		RunE:  runSecretCheck,
+	}
+	check.Flags().StringVarP(&prefix, "prefix", "x", "template.", "check files with given prefix")
+	cmd.AddCommand(check)
+	return cmd
+}
+
+var secretKeyPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// secretComponent checks that name is a component of generate.configs, the
+// components secret stores belong to.
+func secretComponent(name string) error {
+	if !slices.ContainsFunc(project.Generate.Configs, func(c types.ConfigSpec) bool { return c.Name == name }) {
+		return errors.Newf("config %q is not defined in generate.configs", name)
+	}
+	return nil
+}
+
+func secretKey(key string) error {
+	if !secretKeyPattern.MatchString(key) {
+		return errors.Newf("invalid secret key %q: want letters, digits and _", key)
+	}
+	return nil
+}
+
+// readSecretStore decrypts the store of the environment, empty when there is
+// none yet.
+func readSecretStore(path string) (map[string]string, error) {
+	kv, err := readAgeSecretEnv(path)
+	if os.IsNotExist(err) {
+		return make(map[string]string), nil
+	}
+	if err != nil {
+		return nil, errors.Newf("%s: %s", path, err)
+	}
+	return kv, nil
+}
+
+// runSecretSet sets KEY in the store of the environment, the default's
+// without -e. The value is read from stdin unless given, so it stays out of
+// the shell history.
+func runSecretSet(cmd *cobra.Command, args []string) error {
+	name, key := args[0], args[1]
+	if err := errors.Combine(secretComponent(name), secretKey(key)); err != nil {
+		return err
+	}
+	var value string
+	if len(args) == 3 {
+		value = args[2]
+	} else {
+		b, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
+		value = strings.TrimRight(string(b), "\r\n")
+	}
+	if strings.ContainsAny(value, "\r\n") {
+		return errors.Newf("secret %s spans lines, which secret.env cannot hold", key)
+	}
+	return updateSecretStore(name, func(kv map[string]string) error {
+		kv[key] = value
+		linef("set %s in %s", key, secretStore(name))
+		return nil
+	})
+}
+
+// runSecretGet prints the value of KEY as FromSecret "age" would render it,
+// the environment's store over the default's.
+func runSecretGet(cmd *cobra.Command, args []string) error {
+	name, key := args[0], args[1]
+	if err := secretComponent(name); err != nil {
+		return err
+	}
+	layers, err := secretLayers(name)
+	if err != nil {
+		return err
+	}
+	for i := len(layers) - 1; i >= 0; i-- {
+		if val, ok := layers[i].kv[key]; ok {
+			fmt.Println(val)
+			return nil
+		}
+	}
+	return errors.Newf("secret %s of %s is not set in %s", key, name, strings.Join(secretStores(name), " or "))
+}
+
+// runSecretList lists the keys of a component in the environment, without
+// their values, each with the store it comes from.
+func runSecretList(cmd *cobra.Command, args []string) error {
+	name := args[0]
+	if err := secretComponent(name); err != nil {
+		return err
+	}
+	layers, err := secretLayers(name)
+	if err != nil {
+		return err
+	}
+	from := make(map[string]string)
+	for _, layer := range layers {
+		for key := range layer.kv {
+			from[key] = layer.path
+		}
+	}
+	titlef("Secrets of %s", name)
+	for _, key := range sortedKeys(from) {
+		linef("%s from %s", key, from[key])
+	}
+	return nil
+}
+
+// runSecretRm removes KEY from the store of the environment. A key the
+// environment inherits from the default store is left there.
+func runSecretRm(cmd *cobra.Command, args []string) error {
+	name, key := args[0], args[1]
+	if err := secretComponent(name); err != nil {
+		return err
+	}
+	return updateSecretStore(name, func(kv map[string]string) error {
+		if _, ok := kv[key]; !ok {
+			return errors.Newf("secret %s is not in %s", key, secretStore(name))
+		}
+		delete(kv, key)
+		linef("removed %s from %s", key, secretStore(name))
+		return nil
+	})
+}
+
+// runSecretRotate sets KEY to a new random value, which it does not print.
+// Without KEY it re-encrypts the store, as every write does, to the current
+// recipients, to follow a change of secrets.recipients.
+func runSecretRotate(cmd *cobra.Command, args []string) error {
+	name := args[0]
+	if err := secretComponent(name); err != nil {
+		return err
+	}
+	if len(args) == 1 {
+		return updateSecretStore(name, func(kv map[string]string) error {
+			if len(kv) == 0 {
+				return errors.Newf("no secrets in %s to re-encrypt", secretStore(name))
+			}
+			linef("re-encrypted %s", secretStore(name))
+			return nil
+		})
+	}
+	key := args[1]
+	if err := secretKey(key); err != nil {
+		return err
+	}
+	if secretLength < 16 {
+		return errors.Newf("--length %d is too short for a secret, want at least 16", secretLength)
+	}
+	b := make([]byte, secretLength)
+	if _, err := rand.Read(b); err != nil {
+		return err
+	}
+	return updateSecretStore(name, func(kv map[string]string) error {
+		kv[key] = base64.RawURLEncoding.EncodeToString(b)
+		linef("rotated %s in %s", key, secretStore(name))
+		return nil
+	})
+}
+
+// updateSecretStore decrypts the store of the environment, lets update change
+// it, and encrypts it back.
+func updateSecretStore(name string, update func(kv map[string]string) error) error {
+	path := secretStore(name)
+	kv, err := readSecretStore(path)
+	if err != nil {
+		return err
+	}
+	if err := update(kv); err != nil {
+		return err
+	}
+	return writeAgeSecretEnv(path, kv)
+}
+
+// secretRef is a FromSecretEnv or FromSecret call of a template, with
+// literal arguments.
+type secretRef struct {
+	provider, name, key string
+	// at is where the call is, as file:line:col.
+	at string
+}
+
+// runSecretCheck looks up every secret the templates of the environment's
+// components read, as generate would, and reports those that fail. Only
+// calls with literal arguments, or .Name, can be checked.
+func runSecretCheck(cmd *cobra.Command, args []string) error {
+	units := append(configUnits(), kubernetesUnits()...)
+	units = append(units, dockerComposeUnit())
+	var refs []secretRef
+	seen := make(map[secretRef]bool)
+	for _, unit := range units {
+		found, err := secretRefs(unit)
+		if err != nil {
+			return err
+		}
+		for _, ref := range found {
+			if len(args) > 0 && !slices.Contains(args, ref.name) {
+				continue
+			}
+			at := ref.at
+			ref.at = ""
+			if seen[ref] {
+				continue
+			}
+			seen[ref] = true
+			ref.at = at
+			refs = append(refs, ref)
+		}
+	}
+	where := selectedEnv()
+	titlef("Check %d secrets referenced in %s", len(refs), where)
+	missing := 0
+	for _, ref := range refs {
+		if _, err := FromSecret(ref.provider, ref.name, ref.key); err != nil {
+			missing++
+			warnf("%s: %s", ref.at, err)
+		}
+	}
+	if missing > 0 {
+		return errors.Newf("%d of %d secrets referenced by templates are missing in %s", missing, len(refs), where)
+	}
+	linef("all %d secrets found", len(refs))
+	return nil
+}
+
+// secretRefs parses the templates a unit renders, in every layer, for the
+// secrets they read.
+func secretRefs(unit renderUnit) ([]secretRef, error) {
+	var refs []secretRef
+	for _, src := range unit.srcs {
+		if !isDir(src) {
+			continue
+		}
+		err := filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			after, ok := strings.CutPrefix(d.Name(), prefix)
+			if !ok {
+				return nil
+			}
+			rel, err := filepath.Rel(src, path)
+			if err != nil {
+				return err
+			}
+			if ok, err := matches(filepath.Join(filepath.Dir(rel), after), unit.patterns...); err != nil || !ok {
+				return err
+			}
+			b, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			t, err := template.New(path).Delims("[[", "]]").Funcs(funcMap()).Parse(string(b))
+			if err != nil {
+				return newTemplateError(err)
+			}
+			for _, tt := range t.Templates() {
+				if tt.Tree != nil {
+					refs = append(refs, treeSecretRefs(tt.Tree, tt.Tree.Root, unit.name)...)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return refs, nil
+}
+
+// treeSecretRefs walks a template tree for FromSecretEnv and FromSecret
+// calls. .Name is the unit's name, as the render would have it.
+func treeSecretRefs(tree *parse.Tree, node parse.Node, name string) []secretRef {
+	var refs []secretRef
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return nil
+		}
+		for _, c := range n.Nodes {
+			refs = append(refs, treeSecretRefs(tree, c, name)...)
+		}
+	case *parse.ActionNode:
+		refs = treeSecretRefs(tree, n.Pipe, name)
+	case *parse.TemplateNode:
+		refs = treeSecretRefs(tree, n.Pipe, name)
+	case *parse.IfNode:
+		refs = branchSecretRefs(tree, &n.BranchNode, name)
+	case *parse.RangeNode:
+		refs = branchSecretRefs(tree, &n.BranchNode, name)
+	case *parse.WithNode:
+		refs = branchSecretRefs(tree, &n.BranchNode, name)
+	case *parse.PipeNode:
+		if n == nil {
+			return nil
+		}
+		for _, c := range n.Cmds {
+			refs = append(refs, treeSecretRefs(tree, c, name)...)
+		}
+	case *parse.CommandNode:
+		for _, arg := range n.Args {
+			refs = append(refs, treeSecretRefs(tree, arg, name)...)
+		}
+		if ref, ok := commandSecretRef(n, name); ok {
+			ref.at, _ = tree.ErrorContext(n)
+			refs = append(refs, ref)
+		}
+	}
+	return refs
+}
+
+func branchSecretRefs(tree *parse.Tree, n *parse.BranchNode, name string) []secretRef {
+	refs := treeSecretRefs(tree, n.Pipe, name)
+	refs = append(refs, treeSecretRefs(tree, n.List, name)...)
+	return append(refs, treeSecretRefs(tree, n.ElseList, name)...)
+}
+
+// commandSecretRef returns the secret a command reads, when it is a call of
+// FromSecretEnv or FromSecret whose arguments are known before rendering.
+func commandSecretRef(n *parse.CommandNode, name string) (secretRef, bool) {
+	if len(n.Args) == 0 {
+		return secretRef{}, false
+	}
+	ident, ok := n.Args[0].(*parse.IdentifierNode)
+	if !ok {
+		return secretRef{}, false
+	}
+	var args []string
+	for _, arg := range n.Args[1:] {
+		switch a := arg.(type) {
+		case *parse.StringNode:
+			args = append(args, a.Text)
+		case *parse.FieldNode:
+			if len(a.Ident) != 1 || a.Ident[0] != "Name" {
+				return secretRef{}, false
+			}
+			args = append(args, name)
+		default:
+			return secretRef{}, false
+		}
+	}
+	switch {
+	case ident.Ident == "FromSecretEnv" && len(args) == 2:
+		return secretRef{provider: project.Secrets.GetDefault(), name: args[0], key: args[1]}, true
+	case ident.Ident == "FromSecret" && len(args) == 3:
+		return secretRef{provider: args[0], name: args[1], key: args[2]}, true
+	}
+	return secretRef{}, false
+}
@@ -0,0 +1,160 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+
+	"github.com/xhanio/gopro/pkg/types"
+)
+
+// withAgeKey writes a new identity file to $GOPRO_AGE_KEY_FILE.
+func withAgeKey(t *testing.T) *age.X25519Identity {
+	t.Helper()
+	id, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(t.TempDir(), "keys.txt")
+	if err := os.WriteFile(keyFile, []byte(id.String()+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GOPRO_AGE_KEY_FILE", keyFile)
+	return id
+}
+
+// secretProject selects the default environment, or with name prod, an
+// environment rendering from env/prod over env/default.
+func secretProject(t *testing.T, name string) {
+	t.Helper()
+	spec := types.EnvSpec{ConfigSrc: "env/default", ConfigTgt: "out", Configs: []string{"api"}}
+	p := types.Project{
+		Default:  spec,
+		Generate: types.GenerateSpec{Configs: []types.ConfigSpec{{Name: "api"}}},
+		Secrets:  types.SecretsSpec{Default: "age"},
+	}
+	e := spec
+	if name != "" {
+		e.ConfigSrc = "env/" + name
+	}
+	withProject(t, p, e)
+	oldEnvName := envName
+	t.Cleanup(func() { envName = oldEnvName })
+	envName = name
+	oldLength := secretLength
+	t.Cleanup(func() { secretLength = oldLength })
+	secretLength = 32
+}
+
+func TestSecretStoreLayersEnvOverDefault(t *testing.T) {
+	t.Chdir(t.TempDir())
+	withAgeKey(t)
+	secretProject(t, "")
+	for _, kv := range [][]string{{"DB_PASSWORD", "default"}, {"API_KEY", "key"}} {
+		if err := runSecretSet(nil, []string{"api", kv[0], kv[1]}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	secretProject(t, "prod")
+	if err := runSecretSet(nil, []string{"api", "DB_PASSWORD", "prod"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := runSecretRotate(nil, []string{"api", "TOKEN"}); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(filepath.Join("env", "prod", "api", "secret.env.age"))
+	if err != nil || strings.Contains(string(b), "prod") {
+		t.Fatalf("prod store %q, %v", b, err)
+	}
+	for key, want := range map[string]string{"DB_PASSWORD": "prod", "API_KEY": "key"} {
+		if got, err := FromSecretEnv("api", key); err != nil || got != want {
+			t.Errorf("prod %s = %q, %v, want %q", key, got, err, want)
+		}
+	}
+	token, err := FromSecretEnv("api", "TOKEN")
+	if err != nil || len(token) != 43 {
+		t.Errorf("rotated TOKEN = %q, %v", token, err)
+	}
+
+	// rm only touches the env's store: API_KEY is the default's
+	if err := runSecretRm(nil, []string{"api", "API_KEY"}); err == nil {
+		t.Error("removed the default's API_KEY from prod")
+	}
+	if err := runSecretRm(nil, []string{"api", "DB_PASSWORD"}); err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := FromSecretEnv("api", "DB_PASSWORD"); got != "default" {
+		t.Errorf("prod DB_PASSWORD after rm = %q, want the default's", got)
+	}
+
+	secretProject(t, "")
+	if _, err := FromSecretEnv("api", "TOKEN"); err == nil {
+		t.Error("prod's TOKEN leaked into the default environment")
+	}
+	for _, args := range [][]string{{"web", "KEY", "v"}, {"api", "BAD-KEY", "v"}, {"api", "KEY", "two\nlines"}} {
+		if err := runSecretSet(nil, args); err == nil {
+			t.Errorf("set %q", args)
+		}
+	}
+
+	// re-encrypted to a new key, only that key opens the store
+	old := os.Getenv("GOPRO_AGE_KEY_FILE")
+	id := withAgeKey(t)
+	project.Secrets.Recipients = []string{id.Recipient().String()}
+	t.Setenv("GOPRO_AGE_KEY_FILE", old)
+	if err := runSecretRotate(nil, []string{"api"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := FromSecretEnv("api", "API_KEY"); err == nil {
+		t.Error("the old key still decrypts the rotated store")
+	}
+}
+
+func TestSecretCheckReportsMissingKeys(t *testing.T) {
+	t.Chdir(t.TempDir())
+	withAgeKey(t)
+	secretProject(t, "prod")
+	writeTree(t, "env/default/api", "template.app.yaml", `db: [[ FromSecretEnv .Name "DB_PASSWORD" ]]
+[[ if .EnvName ]]token: [[ FromSecret "age" "api" "TOKEN" | quote ]][[ end ]]
+dynamic: [[ FromSecretEnv .Name (printf "%s_KEY" "API") ]]
+`)
+	writeTree(t, "env/prod/api", "template.extra.yaml", "key: [[ FromSecretEnv \"api\" \"DB_PASSWORD\" ]]\n")
+	if err := runSecretSet(nil, []string{"api", "DB_PASSWORD", "prod"}); err != nil {
+		t.Fatal(err)
+	}
+
+	refs, err := secretRefs(configUnits()[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, ref := range refs {
+		got = append(got, ref.provider+" "+ref.name+" "+ref.key+" "+ref.at)
+	}
+	want := []string{
+		"age api DB_PASSWORD env/default/api/template.app.yaml:1:7",
+		"age api TOKEN env/default/api/template.app.yaml:2:27",
+		"age api DB_PASSWORD env/prod/api/template.extra.yaml:1:8",
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("refs\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	err = runSecretCheck(nil, nil)
+	if err == nil || !strings.Contains(err.Error(), "1 of 2 secrets") {
+		t.Errorf("check = %v, want TOKEN missing", err)
+	}
+	if err := runSecretSet(nil, []string{"api", "TOKEN", "t"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := runSecretCheck(nil, []string{"api"}); err != nil {
+		t.Errorf("check with TOKEN set: %s", err)
+	}
+	if err := runSecretCheck(nil, []string{"web"}); err != nil {
+		t.Errorf("check of another component: %s", err)
+	}
+}
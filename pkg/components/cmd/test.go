@@ -0,0 +1,217 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/xhanio/errors"
+	"github.com/xhanio/framingo/pkg/types/info"
+	"github.com/xhanio/framingo/pkg/utils/envutil"
+
+	"github.com/xhanio/gopro/pkg/types"
+	"github.com/xhanio/gopro/pkg/utils/gotestutil"
+)
+
+var (
+	testRace   bool
+	testCover  string
+	testOutput string
+	testAll    bool
+)
+
+func NewTestCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "test [-- go test flags...]",
+		Short: "Run go test for the selected binaries and report the results",
+		RunE:  runTest,
+	}
+	cmd.Flags().BoolVarP(&testRace, "race", "", false, "run the tests with the race detector (default test.race)")
+	cmd.Flags().StringVarP(&testCover, "cover", "", "", "collect coverage in this mode: set, count or atomic (default test.cover)")
+	cmd.Flags().StringVarP(&testOutput, "output", "o", "", "test report output dir (default test_tgt)")
+	cmd.Flags().BoolVarP(&testAll, "all", "", false, "test the whole module instead of the packages under each binary's src")
+	return cmd
+}
+
+// testRun is one go test invocation: the packages under a binary's src, in
+// its build env, or the whole module.
+type testRun struct {
+	name  string
+	pkgs  []string
+	envs  []string
+	flags []string
+}
+
+// testRuns returns a run per selected binary, or with --all, or when no
+// binary is selected, one for the module. Each runs in the build env of what
+// it tests with test.env merged over it, and with the build args selecting
+// files, such as -tags, so the tests see the packages the build does.
+func testRuns() []testRun {
+	binaries := selectedBinaries()
+	if testAll || len(binaries) == 0 {
+		return []testRun{{
+			name:  "module",
+			pkgs:  []string{"./..."},
+			envs:  envutil.Merge(env.BinaryBuildEnv, env.Test.Env),
+			flags: listFlags(env.BinaryBuildArgs),
+		}}
+	}
+	var runs []testRun
+	for _, binary := range binaries {
+		runs = append(runs, testRun{
+			name:  binary.Name,
+			pkgs:  []string{filepath.Join(info.ProjectRoot, binarySource(binary), "...")},
+			envs:  envutil.Merge(env.BinaryBuildEnv, binary.BuildEnv, env.Test.Env),
+			flags: listFlags(buildArgsFor(env, binary, types.PlatformSpec{})),
+		})
+	}
+	return runs
+}
+
+// runTest runs go test for each of testRuns, printing what go test would
+// without -v, and writes the results of all of them to junit.xml in the
+// output dir, and with coverage on, their merged profile to coverage.out.
+// Every run goes ahead before failing on the tests that failed.
+func runTest(cmd *cobra.Command, args []string) error {
+	out := testOutput
+	if out == "" {
+		out = env.GetTestTgt()
+	}
+	race := testRace || env.Test.Race
+	mode := types.CoverMode(testCover)
+	if mode == "" {
+		mode = env.Test.Cover
+	}
+	switch mode {
+	case "", types.CoverModeSet, types.CoverModeCount, types.CoverModeAtomic:
+	default:
+		return errors.Newf("unknown cover mode %q, want set, count or atomic", mode)
+	}
+	if err := os.MkdirAll(out, 0755); err != nil {
+		return err
+	}
+	report := gotestutil.NewReport()
+	coverage := gotestutil.NewCoverage()
+	var errs []error
+	for _, run := range testRuns() {
+		titlef("Test %s", run.name)
+		goArgs := []string{"test", "-json"}
+		if race {
+			goArgs = append(goArgs, "-race")
+		}
+		var profile string
+		if mode != "" {
+			f, err := os.CreateTemp(out, "coverage-*.out")
+			if err != nil {
+				return err
+			}
+			f.Close()
+			profile = f.Name()
+			goArgs = append(goArgs, "-covermode="+string(mode), "-coverprofile="+profile)
+		}
+		goArgs = append(goArgs, run.flags...)
+		goArgs = append(goArgs, env.Test.Args...)
+		goArgs = append(goArgs, args...)
+		goArgs = append(goArgs, run.pkgs...)
+		if err := goTest(report, goArgs, run.envs); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", run.name, err))
+		}
+		if profile != "" {
+			errs = append(errs, addCoverProfile(coverage, profile))
+		}
+	}
+	var junit bytes.Buffer
+	if err := report.WriteJUnit(&junit); err != nil {
+		return err
+	}
+	junitPath := filepath.Join(out, "junit.xml")
+	if err := writeFile(junitPath, junit.Bytes(), "write", ""); err != nil {
+		return err
+	}
+	tests, failed, skipped := report.Counts()
+	linef("%d tests, %d failed, %d skipped: %s", tests, failed, skipped, junitPath)
+	if coverage.Mode != "" {
+		var b bytes.Buffer
+		if err := coverage.Write(&b); err != nil {
+			return err
+		}
+		coverPath := filepath.Join(out, "coverage.out")
+		if err := writeFile(coverPath, b.Bytes(), "write", ""); err != nil {
+			return err
+		}
+		linef("coverage: %.1f%% of statements: %s", coverage.Percent(), coverPath)
+	}
+	if failed > 0 {
+		return errors.Newf("%d of %d tests failed", failed, tests)
+	}
+	return errors.Combine(errs...)
+}
+
+// goTest runs go test -json, adding its events to report. Without -v, the
+// output of failed tests, of packages and of the compiler is printed, as go
+// test itself would. A run whose tests failed is not an error here: report
+// has the failures.
+func goTest(report *gotestutil.Report, args, envs []string) error {
+	p := command("go", args, envs)
+	if verbose {
+		debugf("executing go %s", strings.Join(args, " "))
+	}
+	p.Stderr = os.Stderr
+	stdout, err := p.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	if err := p.Start(); err != nil {
+		return err
+	}
+	failed := false
+	scanner := bufio.NewScanner(stdout)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var e gotestutil.Event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			fmt.Println(scanner.Text())
+			continue
+		}
+		result := report.Add(e)
+		switch {
+		case verbose && (e.Action == "output" || e.Action == "build-output"):
+			fmt.Print(e.Output)
+		case e.Action == "build-output":
+			fmt.Fprint(os.Stderr, e.Output)
+		case e.Action == "output" && e.Test == "":
+			fmt.Print(e.Output)
+		case result != nil && result.Test != "" && result.Action == "fail":
+			fmt.Print(result.Output)
+		}
+		if result != nil && result.Action == "fail" {
+			failed = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		p.Wait()
+		return err
+	}
+	if err := p.Wait(); err != nil && !failed {
+		return err
+	}
+	return nil
+}
+
+// addCoverProfile merges the profile a run wrote into coverage, then removes
+// it. A run that failed to build writes none.
+func addCoverProfile(coverage *gotestutil.Coverage, profile string) error {
+	defer os.Remove(profile)
+	f, err := os.Open(profile)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return coverage.Add(f)
+}
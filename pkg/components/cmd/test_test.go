@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/xhanio/gopro/pkg/types"
+)
+
+// withTestFlags resets the gopro test flags, which cobra would default.
+func withTestFlags(t *testing.T) {
+	t.Helper()
+	oldRace, oldCover, oldOutput, oldAll := testRace, testCover, testOutput, testAll
+	t.Cleanup(func() { testRace, testCover, testOutput, testAll = oldRace, oldCover, oldOutput, oldAll })
+	testRace, testCover, testOutput, testAll = false, "", "", false
+}
+
+func TestTestReportsEveryRun(t *testing.T) {
+	withGoModule(t)
+	withTestFlags(t)
+	writeTree(t, "cmd/api", "main_test.go", "package main\n\nimport \"testing\"\n\nfunc TestMain(t *testing.T) {}\n")
+	writeTree(t, "lib", "lib_test.go", "package lib\n\nimport \"testing\"\n\nfunc TestName(t *testing.T) {\n\tif Name != \"api\" {\n\t\tt.Fatal(Name)\n\t}\n}\n")
+	writeTree(t, "other", "other_test.go", "package other\n\nimport (\n\t\"os\"\n\t\"testing\"\n)\n\nfunc TestEnv(t *testing.T) {\n\tif os.Getenv(\"WANT\") != \"yes\" {\n\t\tt.Fatal(\"WANT not set\")\n\t}\n}\n")
+	e := types.EnvSpec{
+		Binaries:       []string{"api"},
+		BinaryBuildEnv: []string{"WANT=no"},
+		Test:           types.TestSpec{Cover: types.CoverModeSet},
+	}
+	withProject(t, types.Project{
+		Build: types.BuildSpec{Binaries: []types.BinarySpec{{Name: "api", Src: "cmd/api"}}},
+	}, e)
+
+	// only the packages under the binary's src
+	if err := runTest(nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	junit, err := os.ReadFile(filepath.Join("dist", "test", "junit.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(junit), `name="example.com/app/cmd/api"`) || strings.Contains(string(junit), "example.com/app/lib") {
+		t.Errorf("junit.xml covers more than cmd/api:\n%s", junit)
+	}
+	profile, err := os.ReadFile(filepath.Join("dist", "test", "coverage.out"))
+	if err != nil || !strings.HasPrefix(string(profile), "mode: set\n") {
+		t.Errorf("coverage.out = %q, %v", profile, err)
+	}
+
+	// the whole module, in the build env with test.env over it
+	testAll = true
+	err = runTest(nil, nil)
+	if err == nil || !strings.Contains(err.Error(), "1 of 3 tests failed") {
+		t.Errorf("err = %v, want TestEnv failed", err)
+	}
+	env.Test.Env = []string{"WANT=yes"}
+	testOutput = "reports"
+	if err := runTest(nil, []string{"-count=1"}); err != nil {
+		t.Errorf("with test.env: %s", err)
+	}
+	junit, err = os.ReadFile(filepath.Join("reports", "junit.xml"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(junit), `tests="3" failures="0"`) {
+		t.Errorf("junit.xml does not report the three tests passing:\n%s", junit)
+	}
+	matches, _ := filepath.Glob(filepath.Join("reports", "coverage-*"))
+	if len(matches) > 0 {
+		t.Errorf("left behind %s", matches)
+	}
+}
@@ -2,9 +2,10 @@ package cmd
 
 import (
 	"fmt"
-	"os"
 	"path/filepath"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/xhanio/framingo/pkg/types/info"
 )
@@ -46,26 +47,38 @@ func matches(path string, patterns ...string) (bool, error) {
 // run therefore survives an in-place render; that is the trade the layout
 // makes, since the inputs cannot be told apart from the outputs.
 func clearTarget(dst string, srcs ...string) error {
-	absDst, err := filepath.Abs(dst)
+	in, err := inPlace(dst, srcs...)
 	if err != nil {
 		return err
 	}
+	if in {
+		if verbose {
+			debugf("rendering in place into %s; leaving existing files alone", dst)
+		}
+		return nil
+	}
+	return removeAll(dst)
+}
+
+// inPlace reports whether a render target overlaps any of its sources.
+func inPlace(dst string, srcs ...string) (bool, error) {
+	absDst, err := filepath.Abs(dst)
+	if err != nil {
+		return false, err
+	}
 	for _, src := range srcs {
 		if src == "" {
 			continue
 		}
 		absSrc, err := filepath.Abs(src)
 		if err != nil {
-			return err
+			return false, err
 		}
 		if pathsOverlap(absDst, absSrc) {
-			if verbose {
-				debugf("rendering in place into %s; leaving existing files alone", dst)
-			}
-			return nil
+			return true, nil
 		}
 	}
-	return os.RemoveAll(dst)
+	return false, nil
 }
 
 // pathsOverlap reports whether removing either absolute path would affect the
@@ -78,6 +91,16 @@ func pathsOverlap(a, b string) bool {
 	return strings.HasPrefix(a, b+sep) || strings.HasPrefix(b, a+sep)
 }
 
+// buildTimestamp returns the build time of the run, which stamps what it
+// writes so the same inputs make the same output.
+func buildTimestamp() time.Time {
+	t, err := time.Parse(time.RFC3339, info.BuildTime)
+	if err != nil {
+		return time.Now()
+	}
+	return t
+}
+
 func infoString(key string, val any) string {
 	return fmt.Sprintf("-X github.com/xhanio/framingo/pkg/types/info.%s=%v", key, val)
 }
@@ -87,6 +110,8 @@ func injectInfo() []string {
 	for key, val := range info.INJECTION {
 		infos = append(infos, infoString(key, *val))
 	}
+	// in a stable order, for the build args to read the same every run
+	slices.Sort(infos)
 	return []string{
 		"-ldflags",
 		strings.Join(infos, " "),
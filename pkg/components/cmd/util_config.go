@@ -18,8 +18,9 @@ var (
 	env     types.EnvSpec
 )
 
-func loadConfig() error {
-	p, err := config.NewYAML(config.File(projectPath))
+func loadConfig(opts ...config.YAMLOption) error {
+	opts = append([]config.YAMLOption{config.File(projectPath)}, opts...)
+	p, err := config.NewYAML(opts...)
 	if err != nil {
 		return err
 	}
@@ -42,3 +43,11 @@ func loadConfig() error {
 	}
 	return nil
 }
+
+// selectedEnv names the selected environment, default without -e.
+func selectedEnv() string {
+	if envName == "" {
+		return "default"
+	}
+	return envName
+}
@@ -0,0 +1,347 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/xhanio/gopro/pkg/types"
+)
+
+// containerEngine runs the container commands an image build needs. Each
+// engine speaks its own CLI; buildImage only says what to build.
+type containerEngine interface {
+	// Build builds b.image as b.targets. An image with platforms is built for
+	// all of them as one manifest list, pushed by the build when b.push is
+	// set; anything else is left in the local image store.
+	Build(b imageBuild) error
+	Pull(image string) error
+	Tag(src, tgt string) error
+	Push(image string) error
+	// Digest returns the manifest digest of an image the engine pushed, as
+	// the registry holds it.
+	Digest(ref string) (string, error)
+}
+
+// imageBuild is one Dockerfile build, independent of the engine running it.
+type imageBuild struct {
+	image   types.ImageSpec
+	src     string
+	base    string
+	targets []string
+	push    bool
+}
+
+// runFunc runs one engine command. Engines never call execute directly, so
+// the same engine can run its commands or only plan them.
+type runFunc func(cmd string, args []string, env []string) error
+
+// engine is the container engine of the current build image run.
+var engine containerEngine
+
+// selectEngine resolves the engine from --engine, falling back to the
+// environment's image_engine, then docker. The dry-run engine plans the
+// commands of the engine the environment configures instead of running them,
+// as --dry-run does for every engine.
+func selectEngine(name string, e types.EnvSpec) (containerEngine, error) {
+	if name == "" {
+		name = string(e.ImageEngine)
+	}
+	if types.ImageEngineType(name) != types.ImageEngineDryRun {
+		return newEngine(types.ImageEngineType(name), runPlanned)
+	}
+	configured := e.ImageEngine
+	if configured == types.ImageEngineDryRun {
+		configured = ""
+	}
+	return newEngine(configured, currentPlan.run)
+}
+
+// enginePlans reports whether the engine selectEngine resolves from the same
+// arguments only plans its commands.
+func enginePlans(name string, e types.EnvSpec) bool {
+	if name == "" {
+		name = string(e.ImageEngine)
+	}
+	return types.ImageEngineType(name) == types.ImageEngineDryRun
+}
+
+// newEngine returns the engine of the given type, running its commands with
+// run. An empty type is docker.
+func newEngine(t types.ImageEngineType, run runFunc) (containerEngine, error) {
+	switch t {
+	case "", types.ImageEngineDocker:
+		return &dockerEngine{cli: cli{bin: "docker", run: run}}, nil
+	case types.ImageEnginePodman:
+		return &podmanEngine{cli: cli{bin: "podman", run: run}, digests: make(map[string]string)}, nil
+	case types.ImageEngineNerdctl:
+		return &nerdctlEngine{cli: cli{bin: "nerdctl", run: run}}, nil
+	}
+	return nil, fmt.Errorf("unknown image engine %q", t)
+}
+
+// cli holds what the engines share: the pull, tag and push commands of every
+// supported CLI are the same as docker's.
+type cli struct {
+	bin string
+	run runFunc
+}
+
+func (c *cli) Pull(image string) error {
+	linef("pull image %s", image)
+	return c.run(c.bin, []string{"pull", image}, nil)
+}
+
+func (c *cli) Tag(src, tgt string) error {
+	linef("tag image from %s to %s", src, tgt)
+	return c.run(c.bin, []string{"tag", src, tgt}, env.ImageBuildEnv)
+}
+
+func (c *cli) Push(image string) error {
+	return c.run(c.bin, []string{"push", image}, nil)
+}
+
+// inspectDigest runs an inspect command printing an image descriptor as JSON
+// and returns its digest. It only reads, so it runs even when the engine
+// plans its commands.
+func (c *cli) inspectDigest(args []string) (string, error) {
+	out, err := execute(c.bin, args, env.ImageBuildEnv, false)
+	if err != nil {
+		return "", fmt.Errorf("%s %s: %w", c.bin, args[0], err)
+	}
+	return descriptorDigest(out)
+}
+
+// descriptorDigest reads the digest of a JSON image descriptor.
+func descriptorDigest(out string) (string, error) {
+	var descriptor struct {
+		Digest string `json:"digest"`
+	}
+	if err := json.Unmarshal([]byte(out), &descriptor); err != nil {
+		return "", err
+	}
+	if descriptor.Digest == "" {
+		return "", errors.New("no digest in " + strings.TrimSpace(out))
+	}
+	return descriptor.Digest, nil
+}
+
+func (c *cli) build(b imageBuild, args []string) error {
+	if verbose {
+		debugf("building image %s %s from base %s", b.src, strings.Join(b.targets, ", "), b.base)
+		debugf("args: %s", strings.Join(args, " "))
+	}
+	return c.run(c.bin, args, env.ImageBuildEnv)
+}
+
+// dockerEngine builds with docker build, switching to docker buildx for what
+// only buildx does: multiple platforms and a dir cache.
+type dockerEngine struct {
+	cli
+}
+
+func (d *dockerEngine) Build(b imageBuild) error {
+	cache := imageCache(b.image)
+	multi := len(b.image.Platforms) > 0
+	buildx := multi || cache.Type == types.ImageCacheDir
+	cacheArgs, err := imageCacheArgs(b.image, cache, buildx)
+	if err != nil {
+		return err
+	}
+	var args []string
+	if buildx {
+		args = append(args, "buildx", "build")
+	} else {
+		args = append(args, "build")
+	}
+	if multi {
+		// BuildKit supplies TARGETOS, TARGETARCH and TARGETVARIANT per
+		// platform on its own, so they are not passed as build args: one
+		// value would override them for every platform at once.
+		args = append(args, "--platform", strings.Join(b.image.Platforms, ","))
+	}
+	for _, target := range b.targets {
+		args = append(args, "-t", target)
+	}
+	args = append(args, cacheArgs...)
+	switch {
+	case multi && b.push:
+		args = append(args, "--push")
+	case multi:
+		// A manifest list cannot be loaded into docker's image store.
+		warnf("multi-platform image %s is kept in the build cache only; use --push to publish it", b.targets[0])
+	case buildx:
+		args = append(args, "--load")
+	}
+	args = append(args, imageBuildFlags(b.image, b.src, b.base)...)
+	return d.build(b, args)
+}
+
+// Digest asks the registry, through buildx, for the manifest it holds.
+func (d *dockerEngine) Digest(ref string) (string, error) {
+	return d.inspectDigest([]string{"buildx", "imagetools", "inspect", "--format", "{{json .Manifest}}", ref})
+}
+
+// podmanEngine builds with podman build, which has no BuildKit cache
+// exporters: a registry cache is a repository of cached layers, and a dir
+// cache is not supported.
+//
+// podman can only tell the digest of an image as pushed while pushing it, so
+// when a build manifest is recorded each push writes it to a file, by ref.
+type podmanEngine struct {
+	cli
+	digests map[string]string
+}
+
+func (p *podmanEngine) Push(image string) error {
+	args, err := p.digestArgs(image)
+	if err != nil {
+		return err
+	}
+	return p.run(p.bin, append(append([]string{"push"}, args...), image), nil)
+}
+
+// digestArgs returns the flag having a push of ref write its digest, none
+// when no build manifest wants it.
+func (p *podmanEngine) digestArgs(ref string) ([]string, error) {
+	if artifacts == nil {
+		return nil, nil
+	}
+	file, err := os.CreateTemp("", "gopro-digest-")
+	if err != nil {
+		return nil, err
+	}
+	file.Close()
+	p.digests[ref] = file.Name()
+	return []string{"--digestfile", file.Name()}, nil
+}
+
+func (p *podmanEngine) Digest(ref string) (string, error) {
+	path, ok := p.digests[ref]
+	if !ok {
+		return "", fmt.Errorf("%s was not pushed by podman", ref)
+	}
+	defer os.Remove(path)
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	digest := strings.TrimSpace(string(b))
+	if digest == "" {
+		return "", fmt.Errorf("podman wrote no digest of %s", ref)
+	}
+	return digest, nil
+}
+
+func (p *podmanEngine) Build(b imageBuild) error {
+	cacheArgs, err := podmanCacheArgs(b.image, imageCache(b.image))
+	if err != nil {
+		return err
+	}
+	multi := len(b.image.Platforms) > 0
+	var args []string
+	args = append(args, "build")
+	if multi {
+		// podman adds to an existing manifest list rather than replacing
+		// it, so drop the one a previous build left. It may not exist.
+		_ = p.run(p.bin, []string{"manifest", "rm", b.targets[0]}, nil)
+		args = append(args, "--platform", strings.Join(b.image.Platforms, ","))
+		args = append(args, "--manifest", b.targets[0])
+	} else {
+		for _, target := range b.targets {
+			args = append(args, "-t", target)
+		}
+	}
+	args = append(args, cacheArgs...)
+	args = append(args, imageBuildFlags(b.image, b.src, b.base)...)
+	if err := p.build(b, args); err != nil {
+		return err
+	}
+	if !multi || !b.push {
+		return nil
+	}
+	for _, target := range b.targets {
+		args, err := p.digestArgs(target)
+		if err != nil {
+			return err
+		}
+		args = append(append([]string{"manifest", "push", "--all"}, args...), b.targets[0], "docker://"+target)
+		if err := p.run(p.bin, args, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// podmanCacheArgs returns the cache flags of a podman build.
+func podmanCacheArgs(image types.ImageSpec, cache types.CacheSpec) ([]string, error) {
+	switch cache.Type {
+	case types.ImageCacheNone:
+		return []string{"--no-cache"}, nil
+	case types.ImageCacheLocal:
+		return nil, nil
+	case types.ImageCacheRegistry:
+		ref := cache.Ref
+		if ref == "" {
+			ref = untagged(image.GetImageName(env))
+		}
+		return []string{"--layers", "--cache-from", ref, "--cache-to", ref}, nil
+	case types.ImageCacheDir:
+		return nil, errors.New("podman does not support a dir cache")
+	}
+	return nil, fmt.Errorf("unknown image cache type %q", cache.Type)
+}
+
+// untagged strips the tag from an image reference, leaving the repository
+// podman keeps cached layers in.
+func untagged(ref string) string {
+	if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+		return ref[:i]
+	}
+	return ref
+}
+
+// nerdctlEngine builds with nerdctl build, which is BuildKit underneath: it
+// takes buildx cache flags, and keeps a multi-platform image in containerd's
+// store, to be pushed with all its platforms after the build.
+type nerdctlEngine struct {
+	cli
+}
+
+// Digest reads the digest of the image in containerd's store, which is the
+// one pushed: nerdctl pushes the stored manifest as it is.
+func (n *nerdctlEngine) Digest(ref string) (string, error) {
+	return n.inspectDigest([]string{"image", "inspect", "--mode=native", "--format", "{{json .Image.Target}}", ref})
+}
+
+func (n *nerdctlEngine) Build(b imageBuild) error {
+	cacheArgs, err := imageCacheArgs(b.image, imageCache(b.image), true)
+	if err != nil {
+		return err
+	}
+	multi := len(b.image.Platforms) > 0
+	var args []string
+	args = append(args, "build")
+	if multi {
+		args = append(args, "--platform", strings.Join(b.image.Platforms, ","))
+	}
+	for _, target := range b.targets {
+		args = append(args, "-t", target)
+	}
+	args = append(args, cacheArgs...)
+	args = append(args, imageBuildFlags(b.image, b.src, b.base)...)
+	if err := n.build(b, args); err != nil {
+		return err
+	}
+	if !multi || !b.push {
+		return nil
+	}
+	for _, target := range b.targets {
+		if err := n.run(n.bin, []string{"push", "--all-platforms", target}, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
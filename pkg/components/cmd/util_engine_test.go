@@ -0,0 +1,236 @@
+package cmd
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/xhanio/framingo/pkg/types/info"
+
+	"github.com/xhanio/gopro/pkg/types"
+)
+
+// usePlan points the image code at engine t, planning its commands instead
+// of running them, and restores the package state afterwards.
+func usePlan(t *testing.T, engineType types.ImageEngineType, e types.EnvSpec, images ...types.ImageSpec) *plan {
+	t.Helper()
+	oldEngine, oldEnv, oldProject, oldRoot := engine, env, project, info.ProjectRoot
+	oldPush, oldLatest, oldNoCache := pushImage, pushLatest, noCache
+	t.Cleanup(func() {
+		engine, env, project, info.ProjectRoot = oldEngine, oldEnv, oldProject, oldRoot
+		pushImage, pushLatest, noCache = oldPush, oldLatest, oldNoCache
+	})
+	r := &plan{}
+	eng, err := newEngine(engineType, r.run)
+	if err != nil {
+		t.Fatal(err)
+	}
+	engine, env, info.ProjectRoot = eng, e, "/p"
+	project = types.Project{Build: types.BuildSpec{Images: images}}
+	pushImage, pushLatest, noCache = false, false, false
+	return r
+}
+
+// checkCommands compares recorded commands by prefix, leaving out the build
+// args, Dockerfile and context every build ends with.
+func checkCommands(t *testing.T, got, want []string) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Fatalf("ran %d commands, want %d:\n%s", len(got), len(want), strings.Join(got, "\n"))
+	}
+	for i := range want {
+		if !strings.HasPrefix(got[i], want[i]) {
+			t.Errorf("command %d = %q, want prefix %q", i, got[i], want[i])
+		}
+	}
+}
+
+func TestBuildImageCommands(t *testing.T) {
+	prod := types.EnvSpec{ImagePrefix: "reg.io", ImageTag: "v1"}
+	single := types.ImageSpec{Name: "api", BuildSrc: "docker/api"}
+	multi := types.ImageSpec{Name: "api", BuildSrc: "docker/api", Platforms: []string{"linux/amd64", "linux/arm64"}}
+	dirCache := types.ImageSpec{Name: "api", BuildSrc: "docker/api", Cache: types.CacheSpec{Type: types.ImageCacheDir, Dir: "c"}}
+	pulled := types.ImageSpec{Name: "redis", BuildFrom: "redis:7"}
+
+	tests := []struct {
+		name   string
+		engine types.ImageEngineType
+		image  types.ImageSpec
+		push   bool
+		want   []string
+	}{
+		{
+			name:   "docker builds and pushes",
+			engine: types.ImageEngineDocker,
+			image:  single,
+			push:   true,
+			want:   []string{"docker build -t reg.io/api:v1 --build-arg", "docker push reg.io/api:v1"},
+		},
+		{
+			name:   "docker takes buildx for a dir cache and loads the result",
+			engine: types.ImageEngineDocker,
+			image:  dirCache,
+			want:   []string{"docker buildx build -t reg.io/api:v1 --cache-from type=local,src=c/api --cache-to type=local,dest=c/api,mode=max --load --build-arg"},
+		},
+		{
+			name:   "docker pushes a manifest list from buildx",
+			engine: types.ImageEngineDocker,
+			image:  multi,
+			push:   true,
+			want:   []string{"docker buildx build --platform linux/amd64,linux/arm64 -t reg.io/api:v1 --push --build-arg"},
+		},
+		{
+			name:   "podman builds a manifest list and pushes it",
+			engine: types.ImageEnginePodman,
+			image:  multi,
+			push:   true,
+			want: []string{
+				"podman manifest rm reg.io/api:v1",
+				"podman build --platform linux/amd64,linux/arm64 --manifest reg.io/api:v1 --build-arg",
+				"podman manifest push --all reg.io/api:v1 docker://reg.io/api:v1",
+			},
+		},
+		{
+			name:   "nerdctl builds all platforms then pushes them",
+			engine: types.ImageEngineNerdctl,
+			image:  multi,
+			push:   true,
+			want: []string{
+				"nerdctl build --platform linux/amd64,linux/arm64 -t reg.io/api:v1 --build-arg",
+				"nerdctl push --all-platforms reg.io/api:v1",
+			},
+		},
+		{
+			name:   "nerdctl keeps a dir cache without buildx",
+			engine: types.ImageEngineNerdctl,
+			image:  dirCache,
+			want:   []string{"nerdctl build -t reg.io/api:v1 --cache-from type=local,src=c/api"},
+		},
+		{
+			name:   "build_from pulls and tags with the engine",
+			engine: types.ImageEnginePodman,
+			image:  pulled,
+			want:   []string{"podman pull redis:7", "podman tag redis:7 reg.io/redis:v1"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := usePlan(t, tt.engine, prod, tt.image)
+			pushImage = tt.push
+			if err := buildImage(tt.image); err != nil {
+				t.Fatal(err)
+			}
+			checkCommands(t, r.commands(), tt.want)
+		})
+	}
+}
+
+func TestPodmanRejectsDirCache(t *testing.T) {
+	image := types.ImageSpec{Name: "api", Cache: types.CacheSpec{Type: types.ImageCacheDir}}
+	r := usePlan(t, types.ImageEnginePodman, types.EnvSpec{}, image)
+	if err := buildImage(image); err == nil {
+		t.Fatal("expected an error")
+	}
+	if len(r.commands()) != 0 {
+		t.Errorf("ran %q before failing", r.commands())
+	}
+}
+
+func TestUntagged(t *testing.T) {
+	for ref, want := range map[string]string{
+		"reg.io/api:v1":      "reg.io/api",
+		"reg.io:5000/api":    "reg.io:5000/api",
+		"reg.io:5000/api:v1": "reg.io:5000/api",
+		"api":                "api",
+	} {
+		if got := untagged(ref); got != want {
+			t.Errorf("untagged(%q) = %q, want %q", ref, got, want)
+		}
+	}
+}
+
+func TestSelectEngine(t *testing.T) {
+	tests := []struct {
+		name    string
+		flag    string
+		env     types.EnvSpec
+		wantBin string
+		dryRun  bool
+		wantErr bool
+	}{
+		{name: "docker by default", wantBin: "docker"},
+		{name: "image_engine from the environment", env: types.EnvSpec{ImageEngine: types.ImageEnginePodman}, wantBin: "podman"},
+		{name: "--engine outranks image_engine", flag: "nerdctl", env: types.EnvSpec{ImageEngine: types.ImageEnginePodman}, wantBin: "nerdctl"},
+		{name: "dry-run records the configured engine", flag: "dry-run", env: types.EnvSpec{ImageEngine: types.ImageEnginePodman}, wantBin: "podman", dryRun: true},
+		{name: "dry-run as image_engine records docker", env: types.EnvSpec{ImageEngine: types.ImageEngineDryRun}, wantBin: "docker", dryRun: true},
+		{name: "unknown engine", flag: "buildah", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			oldEnv := env
+			t.Cleanup(func() { env = oldEnv })
+			env = tt.env
+
+			e, err := selectEngine(tt.flag, tt.env)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var c *cli
+			switch e := e.(type) {
+			case *dockerEngine:
+				c = &e.cli
+			case *podmanEngine:
+				c = &e.cli
+			case *nerdctlEngine:
+				c = &e.cli
+			}
+			if c.bin != tt.wantBin {
+				t.Errorf("engine runs %s, want %s", c.bin, tt.wantBin)
+			}
+			// A push of something that does not exist only succeeds when
+			// nothing really runs.
+			if tt.dryRun {
+				if err := e.Push("gopro.invalid/none:none"); err != nil {
+					t.Errorf("dry-run ran the command: %v", err)
+				}
+			}
+		})
+	}
+}
+
+// With a build manifest to record it in, a podman push writes the digest of
+// the pushed image, which is then recorded with the image.
+func TestPodmanRecordsPushedDigest(t *testing.T) {
+	image := types.ImageSpec{Name: "api", BuildSrc: "docker/api"}
+	r := usePlan(t, types.ImageEnginePodman, types.EnvSpec{ImagePrefix: "reg.io", ImageTag: "v1"}, image)
+	oldArtifacts := artifacts
+	t.Cleanup(func() { artifacts = oldArtifacts })
+	artifacts = &buildManifest{}
+	pushImage = true
+
+	// the planned push writes nothing, so stand in for podman
+	p := engine.(*podmanEngine)
+	p.run = func(cmd string, args []string, env []string) error {
+		for i, arg := range args {
+			if arg == "--digestfile" {
+				if err := os.WriteFile(args[i+1], []byte("sha256:abc\n"), 0644); err != nil {
+					return err
+				}
+			}
+		}
+		return r.run(cmd, args, env)
+	}
+	if err := buildImage(image); err != nil {
+		t.Fatal(err)
+	}
+	checkCommands(t, r.commands(), []string{"podman build -t reg.io/api:v1", "podman push --digestfile "})
+	if len(artifacts.Images) != 1 || artifacts.Images[0].Digest != "sha256:abc" || !artifacts.Images[0].Pushed {
+		t.Errorf("recorded %+v", artifacts.Images)
+	}
+}
@@ -8,6 +8,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
 	"strings"
 
 	"github.com/xhanio/framingo/pkg/types/info"
@@ -16,61 +17,124 @@ import (
 	"github.com/xhanio/gopro/pkg/types"
 )
 
-func executeBuildImage(name, src, image, base string) error {
-	if verbose {
-		debugf("building image %s %s from base %s", src, image, base)
+// defaultImageCacheDir is where a dir cache without a dir of its own lives.
+const defaultImageCacheDir = "dist/cache"
+
+// imageCache resolves the cache for an image build. --no-cache outranks
+// anything configured.
+func imageCache(image types.ImageSpec) types.CacheSpec {
+	if noCache {
+		return types.CacheSpec{Type: types.ImageCacheNone}
+	}
+	return image.GetCache(env)
+}
+
+// imageCacheArgs returns the cache flags of one image build. buildx takes
+// typed cache sources and can export a cache anywhere; a plain docker build
+// can only import one, from an image carrying inline cache metadata.
+func imageCacheArgs(image types.ImageSpec, cache types.CacheSpec, buildx bool) ([]string, error) {
+	switch cache.Type {
+	case types.ImageCacheNone:
+		return []string{"--no-cache"}, nil
+	case types.ImageCacheLocal:
+		return nil, nil
+	case types.ImageCacheRegistry:
+		ref := cache.Ref
+		if ref == "" {
+			ref = image.GetImageName(env)
+		}
+		// The image built carries its own cache metadata either way, so
+		// pushing it publishes the cache the next build imports.
+		if buildx {
+			return []string{"--cache-from", "type=registry,ref=" + ref, "--cache-to", "type=inline"}, nil
+		}
+		return []string{"--cache-from", ref, "--build-arg", "BUILDKIT_INLINE_CACHE=1"}, nil
+	case types.ImageCacheDir:
+		if !buildx {
+			return nil, errors.New("a dir cache needs docker buildx")
+		}
+		dir := cache.Dir
+		if dir == "" {
+			dir = defaultImageCacheDir
+		}
+		dir = filepath.Join(dir, image.Name)
+		return []string{
+			"--cache-from", "type=local,src=" + dir,
+			"--cache-to", "type=local,dest=" + dir + ",mode=max",
+		}, nil
 	}
+	return nil, fmt.Errorf("unknown image cache type %q", cache.Type)
+}
+
+// imageBuildFlags returns the build args, Dockerfile and context shared by
+// every image build.
+func imageBuildFlags(image types.ImageSpec, src, base string) []string {
 	var args []string
-	args = append(args, "build")
-	args = append(args, "-t", image)
-	args = append(args, "--no-cache")
-	args = append(args, "--build-arg", fmt.Sprintf("NAME=%s", name))
-	args = append(args, "--build-arg", fmt.Sprintf("BASE=%s", base))
-	args = append(args, "--build-arg", fmt.Sprintf("CONFIG_TGT=%s", env.ConfigTgt))
-	args = append(args, "--build-arg", fmt.Sprintf("CONFIG_DIR=%s", GetConfigDir(name)))
+	for _, arg := range imageBuildArgs(env, image, base) {
+		args = append(args, "--build-arg", arg)
+	}
 	args = append(args, "-f", filepath.Join(info.ProjectRoot, src, "Dockerfile"))
 	args = append(args, info.ProjectRoot)
-	if verbose {
-		debugf("args: %s", strings.Join(args, " "))
-	}
-	_, err := execute("docker", args, env.ImageBuildEnv, true)
-	return err
+	return args
 }
 
-func executePullImage(image string) error {
-	linef("pull image %s", image)
-	var args []string
-	args = append(args, "pull")
-	args = append(args, image)
-	_, err := execute("docker", args, nil, true)
-	return err
+// buildArgRef matches a ${NAME} reference in a build arg value.
+var buildArgRef = regexp.MustCompile(`\$\{([A-Z_]+)\}`)
+
+// buildArgVars is the project metadata a build arg value may reference as
+// ${NAME}, read when the build runs so flag overrides are reflected.
+func buildArgVars() map[string]string {
+	return map[string]string{
+		"PRODUCT_NAME":    info.ProductName,
+		"PRODUCT_MODEL":   info.ProductModel,
+		"PRODUCT_VERSION": info.ProductVersion,
+		"BUILD_VERSION":   info.BuildVersion,
+		"BUILD_TYPE":      info.BuildType,
+		"BUILD_DATE":      info.BuildDate,
+		"BUILD_TIME":      info.BuildTime,
+		"GIT_BRANCH":      info.GitBranch,
+		"GIT_TAG":         info.GitTag,
+		"GIT_COMMIT":      info.GitCommit,
+	}
 }
 
-func executeTagImage(src, tgt string) error {
-	linef("tag image from %s to %s", src, tgt)
-	var args []string
-	args = append(args, "tag")
-	args = append(args, src)
-	args = append(args, tgt)
-	_, err := execute("docker", args, env.ImageBuildEnv, true)
-	return err
+// imageBuildArgs returns the docker build args for an image. The built-in
+// NAME, BASE, BINARY_TGT, CONFIG_TGT and CONFIG_DIR come first, then image_build_args,
+// then the image's own build_args. Like build env, each level is merged
+// key-wise, overriding only the args it names. A reference to anything other
+// than the metadata in buildArgVars is left as written.
+func imageBuildArgs(e types.EnvSpec, image types.ImageSpec, base string) []string {
+	builtin := []string{
+		"NAME=" + image.Name,
+		"BASE=" + base,
+		"BINARY_TGT=" + e.BinaryTgt,
+		"CONFIG_TGT=" + e.ConfigTgt,
+		"CONFIG_DIR=" + GetConfigDir(image.Name),
+	}
+	args := envutil.Merge(builtin, e.ImageBuildArgs, image.BuildArgs)
+	for i, arg := range args {
+		args[i] = expandBuildVars(arg)
+	}
+	return args
 }
 
-func executePushImage(image string) error {
-	var args []string
-	args = append(args, "push")
-	args = append(args, image)
-	_, err := execute("docker", args, nil, true)
-	return err
+// expandBuildVars replaces the ${NAME} references to buildArgVars in s,
+// leaving any other reference as written.
+func expandBuildVars(s string) string {
+	vars := buildArgVars()
+	return buildArgRef.ReplaceAllStringFunc(s, func(ref string) string {
+		if val, ok := vars[buildArgRef.FindStringSubmatch(ref)[1]]; ok {
+			return val
+		}
+		return ref
+	})
 }
 
 func execute(cmd string, args []string, env []string, print bool) (string, error) {
+	p := command(cmd, args, env)
 	if verbose {
 		debugf("executing %s %s", cmd, strings.Join(args, "\n"))
 	}
-	p := exec.Command(cmd, args...)
-	p.Env = os.Environ()
-	p.Env = append(p.Env, env...)
 	if len(env) > 0 && verbose {
 		debugf("env: \n%s", strings.Join(env, "\n"))
 	}
@@ -90,6 +154,30 @@ func execute(cmd string, args []string, env []string, print bool) (string, error
 	return buffer.String(), err
 }
 
+// executeTo runs cmd with both output streams sent to w and no stdin, for
+// commands running alongside others whose output must not interleave.
+func executeTo(cmd string, args []string, env []string, w io.Writer) error {
+	p := command(cmd, args, env)
+	if verbose {
+		fmt.Fprintf(w, "executing %s %s\n", cmd, strings.Join(args, "\n"))
+	}
+	if len(env) > 0 && verbose {
+		fmt.Fprintf(w, "env: \n%s\n", strings.Join(env, "\n"))
+	}
+	p.Stdout = w
+	p.Stderr = w
+	return p.Run()
+}
+
+// command prepares cmd with env added to the environment. It prints nothing,
+// so that each caller logs it where its output goes: a job logs into its own.
+func command(cmd string, args []string, env []string) *exec.Cmd {
+	p := exec.Command(cmd, args...)
+	p.Env = os.Environ()
+	p.Env = append(p.Env, env...)
+	return p
+}
+
 // buildArgsFor returns the most specific build args declared. Unlike build env
 // these replace rather than merge: go build flags are positional and
 // repeatable, so a key-wise merge cannot tell an override from an
@@ -107,27 +195,144 @@ func buildArgsFor(e types.EnvSpec, binary types.BinarySpec, platform types.Platf
 	return args
 }
 
-// executeBuildBinary builds one binary for one platform. A zero PlatformSpec
-// builds for the host, inheriting everything and pinning no GOOS/GOARCH.
-func executeBuildBinary(binary types.BinarySpec, platform types.PlatformSpec, src, dst string) error {
+// binaryBuild is one resolved go build invocation. Everything it reads from
+// the package state is captured when it is created, so it can run later and
+// on any goroutine.
+type binaryBuild struct {
+	name     string
+	version  string
+	platform string
+	args     []string
+	envs     []string
+
+	// what the build is fingerprinted by, and the manifest it is tracked in
+	src       string
+	output    string
+	buildArgs []string
+	info      []string
+	manifest  *fingerprints
+
+	// the build manifest the binary is recorded in once built, and the
+	// SBOMs and signature written beside it
+	artifacts *buildManifest
+	sbom      []types.SBOMFormat
+	sboms     []string
+	signature string
+
+	// out is the output of the job the build runs as, if it does
+	out io.Writer
+}
+
+// newBinaryBuild resolves the build of one binary for one platform. A zero
+// PlatformSpec builds for the host, inheriting everything and pinning no
+// GOOS/GOARCH. The injected info is read here, so the application info must
+// already be applied for this binary.
+func newBinaryBuild(binary types.BinarySpec, platform types.PlatformSpec, src, dst string) (*binaryBuild, error) {
 	name := binary.Name
 	// Each level overrides only the variables it names, inheriting the rest.
 	envs := envutil.Merge(env.BinaryBuildEnv, binary.BuildEnv, platform.Env)
 	if platform.Name != "" {
 		parts := strings.Split(platform.Name, "/")
 		if len(parts) != 2 {
-			return errors.New("unknown platform " + platform.Name)
+			return nil, errors.New("unknown platform " + platform.Name)
 		}
 		name = fmt.Sprintf("%s_%s_%s", name, parts[0], parts[1])
 		// The platform being built for outranks any GOOS/GOARCH in build_env.
 		envs = envutil.Merge(envs, []string{"GOOS=" + parts[0], "GOARCH=" + parts[1]})
 	}
+	buildArgs := buildArgsFor(env, binary, platform)
+	output := filepath.Join(dst, name)
+	src = filepath.Join(info.ProjectRoot, src)
 	var args []string
 	args = append(args, "build")
-	args = append(args, buildArgsFor(env, binary, platform)...)
+	args = append(args, buildArgs...)
 	args = append(args, injectInfo()...)
-	args = append(args, "-o", filepath.Join(dst, name))
-	args = append(args, filepath.Join(info.ProjectRoot, src))
-	_, err := execute("go", args, envs, true)
-	return err
+	args = append(args, "-o", output)
+	args = append(args, src)
+	return &binaryBuild{
+		name:      binary.Name,
+		version:   info.ApplicationVersion,
+		platform:  platform.Name,
+		args:      args,
+		envs:      envs,
+		src:       src,
+		output:    output,
+		buildArgs: buildArgs,
+		info:      fingerprintInfo(),
+		manifest:  builds,
+		artifacts: artifacts,
+		sbom:      binary.SBOM,
+	}, nil
+}
+
+// String names the build the way its output is labelled.
+func (b *binaryBuild) String() string {
+	if b.platform == "" {
+		return b.name
+	}
+	return b.name + " " + b.platform
+}
+
+func (b *binaryBuild) run(w io.Writer) error {
+	b.out = w
+	skipped, err := b.build(func() error {
+		if dryRun {
+			return currentPlan.run("go", b.args, b.envs)
+		}
+		return executeTo("go", b.args, b.envs, w)
+	})
+	if skipped {
+		fmt.Fprintf(w, "%s is up to date\n", b.output)
+	}
+	if err != nil {
+		return err
+	}
+	return b.finish()
+}
+
+// finish writes the SBOMs and signature of the built binary and records it
+// in the build manifest.
+func (b *binaryBuild) finish() error {
+	sboms, err := writeBinarySBOMs(b)
+	if err != nil {
+		return err
+	}
+	b.sboms = sboms
+	signature, err := signFile(b.output)
+	if err != nil {
+		return err
+	}
+	b.signature = signature
+	return b.artifacts.recordBinary(b)
+}
+
+// debugf prints a --verbose detail of the build, into the output of its job
+// when it runs as one.
+func (b *binaryBuild) debugf(format string, args ...any) {
+	if !verbose {
+		return
+	}
+	if b.out != nil {
+		fmt.Fprintf(b.out, format+"\n", args...)
+		return
+	}
+	debugf(format, args...)
+}
+
+// executeBuildBinary builds one binary for one platform, in the foreground.
+func executeBuildBinary(binary types.BinarySpec, platform types.PlatformSpec, src, dst string) error {
+	b, err := newBinaryBuild(binary, platform, src, dst)
+	if err != nil {
+		return err
+	}
+	skipped, err := b.build(func() error {
+		return runPlanned("go", b.args, b.envs)
+	})
+	if skipped {
+		linef("%s is up to date", b.output)
+	}
+	if err != nil {
+		return err
+	}
+	return b.finish()
 }
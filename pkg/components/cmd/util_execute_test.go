@@ -2,8 +2,12 @@ package cmd
 
 import (
 	"reflect"
+	"slices"
+	"strings"
 	"testing"
 
+	"github.com/xhanio/framingo/pkg/types/info"
+
 	"github.com/xhanio/gopro/pkg/types"
 )
 
@@ -73,3 +77,157 @@ func TestBuildArgsFor(t *testing.T) {
 		})
 	}
 }
+
+// A build resolves its flags when it is created, not when it runs, so builds
+// queued for concurrent execution each keep their own application info.
+func TestNewBinaryBuildCapturesInfoAtCreation(t *testing.T) {
+	resetInfo(t)
+	oldEnv := env
+	t.Cleanup(func() { env = oldEnv })
+	env = types.EnvSpec{}
+
+	applyApplicationInfo(types.BinarySpec{Name: "api"})
+	api, err := newBinaryBuild(types.BinarySpec{Name: "api"}, types.PlatformSpec{Name: "linux/arm64"}, "cmd/api", "bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	applyApplicationInfo(types.BinarySpec{Name: "worker"})
+
+	flags := strings.Join(api.args, " ")
+	if !strings.Contains(flags, "=api") || strings.Contains(flags, "=worker") {
+		t.Errorf("args %q lost the application name they were created with", flags)
+	}
+	if !slices.Contains(api.envs, "GOOS=linux") || !slices.Contains(api.envs, "GOARCH=arm64") {
+		t.Errorf("envs = %q, want the platform pinned", api.envs)
+	}
+	if got := api.String(); got != "api linux/arm64" {
+		t.Errorf("String() = %q", got)
+	}
+}
+
+func TestNewBinaryBuildRejectsMalformedPlatform(t *testing.T) {
+	if _, err := newBinaryBuild(types.BinarySpec{Name: "api"}, types.PlatformSpec{Name: "linux"}, "cmd/api", "bin"); err == nil {
+		t.Fatal("expected an error for a platform without an arch")
+	}
+}
+
+func TestImageBuildArgs(t *testing.T) {
+	resetInfo(t)
+	oldProject, oldCommit := project, info.GitCommit
+	t.Cleanup(func() { project, info.GitCommit = oldProject, oldCommit })
+	project = types.Project{Build: types.BuildSpec{Binaries: []types.BinarySpec{{Name: "api", ConfigDir: "/etc/api"}}}}
+	info.GitCommit = "abc123"
+	info.BuildVersion = "v1.4.0"
+
+	tests := []struct {
+		name  string
+		env   types.EnvSpec
+		image types.ImageSpec
+		want  []string
+	}{
+		{
+			name:  "built-ins only",
+			env:   types.EnvSpec{BinaryTgt: "bin", ConfigTgt: "dist/config"},
+			image: types.ImageSpec{Name: "api"},
+			want:  []string{"NAME=api", "BASE=alpine", "BINARY_TGT=bin", "CONFIG_TGT=dist/config", "CONFIG_DIR=/etc/api"},
+		},
+		{
+			name:  "env args add to the built-ins",
+			env:   types.EnvSpec{ImageBuildArgs: []string{"HTTP_PROXY=http://proxy"}},
+			image: types.ImageSpec{Name: "api"},
+			want:  []string{"NAME=api", "BASE=alpine", "BINARY_TGT=", "CONFIG_TGT=", "CONFIG_DIR=/etc/api", "HTTP_PROXY=http://proxy"},
+		},
+		{
+			name:  "image args merge over env args key-wise",
+			env:   types.EnvSpec{ImageBuildArgs: []string{"A=env", "B=env"}},
+			image: types.ImageSpec{Name: "api", BuildArgs: []string{"B=image"}},
+			want:  []string{"NAME=api", "BASE=alpine", "BINARY_TGT=", "CONFIG_TGT=", "CONFIG_DIR=/etc/api", "A=env", "B=image"},
+		},
+		{
+			name:  "values reference project metadata",
+			image: types.ImageSpec{Name: "api", BuildArgs: []string{"REVISION=${GIT_COMMIT}", "VERSION=${BUILD_VERSION}-${UNKNOWN}"}},
+			want:  []string{"NAME=api", "BASE=alpine", "BINARY_TGT=", "CONFIG_TGT=", "CONFIG_DIR=/etc/api", "REVISION=abc123", "VERSION=v1.4.0-${UNKNOWN}"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// docker does not care about order, so neither does the test
+			got := slices.Sorted(slices.Values(imageBuildArgs(tt.env, tt.image, "alpine")))
+			want := slices.Sorted(slices.Values(tt.want))
+			if !slices.Equal(got, want) {
+				t.Fatalf("imageBuildArgs() = %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestImageCacheArgs(t *testing.T) {
+	oldEnv := env
+	t.Cleanup(func() { env = oldEnv })
+	env = types.EnvSpec{ImagePrefix: "reg.io", ImageTag: "v1"}
+	image := types.ImageSpec{Name: "api"}
+
+	tests := []struct {
+		name    string
+		cache   types.CacheSpec
+		buildx  bool
+		want    []string
+		wantErr bool
+	}{
+		{name: "none", cache: types.CacheSpec{Type: types.ImageCacheNone}, want: []string{"--no-cache"}},
+		{name: "local", cache: types.CacheSpec{Type: types.ImageCacheLocal}, want: nil},
+		{
+			name:  "registry defaults to the image itself",
+			cache: types.CacheSpec{Type: types.ImageCacheRegistry},
+			want:  []string{"--cache-from", "reg.io/api:v1", "--build-arg", "BUILDKIT_INLINE_CACHE=1"},
+		},
+		{
+			name:   "registry under buildx",
+			cache:  types.CacheSpec{Type: types.ImageCacheRegistry, Ref: "reg.io/api:cache"},
+			buildx: true,
+			want:   []string{"--cache-from", "type=registry,ref=reg.io/api:cache", "--cache-to", "type=inline"},
+		},
+		{
+			name:   "dir keeps one directory per image",
+			cache:  types.CacheSpec{Type: types.ImageCacheDir, Dir: ".cache"},
+			buildx: true,
+			want:   []string{"--cache-from", "type=local,src=.cache/api", "--cache-to", "type=local,dest=.cache/api,mode=max"},
+		},
+		{name: "dir needs buildx", cache: types.CacheSpec{Type: types.ImageCacheDir}, wantErr: true},
+		{name: "unknown type", cache: types.CacheSpec{Type: "s3"}, buildx: true, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := imageCacheArgs(image, tt.cache, tt.buildx)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatal("expected an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !equalEntries(got, tt.want) {
+				t.Fatalf("imageCacheArgs() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// --no-cache forces the uncached build whatever the image configures.
+func TestNoCacheFlagOutranksConfig(t *testing.T) {
+	oldEnv, oldFlag := env, noCache
+	t.Cleanup(func() { env, noCache = oldEnv, oldFlag })
+	env = types.EnvSpec{ImageCache: types.CacheSpec{Type: types.ImageCacheRegistry}}
+	image := types.ImageSpec{Name: "api", Cache: types.CacheSpec{Type: types.ImageCacheDir}}
+
+	noCache = false
+	if got := imageCache(image).Type; got != types.ImageCacheDir {
+		t.Errorf("cache = %q, want the image's", got)
+	}
+	noCache = true
+	if got := imageCache(image).Type; got != types.ImageCacheNone {
+		t.Errorf("cache = %q, want none under --no-cache", got)
+	}
+}
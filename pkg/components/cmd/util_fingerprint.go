@@ -0,0 +1,318 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/xhanio/framingo/pkg/types/info"
+)
+
+// fingerprintsFile is the manifest of binary builds, kept in the directory
+// the binaries are built into.
+const fingerprintsFile = ".fingerprints.json"
+
+var (
+	forceBuild bool
+
+	// builds tracks the binary builds of the current run, nil when they
+	// are not tracked.
+	builds *fingerprints
+)
+
+// fingerprints records, for every binary built into a directory, the
+// fingerprint of the inputs it was built from and the digest of the binary
+// that came out. A build whose inputs are unchanged and whose binary is still
+// the one recorded is skipped. Concurrent builds record into it at once,
+// hence the lock.
+type fingerprints struct {
+	mu      sync.Mutex
+	path    string
+	skipped []string
+	Builds  map[string]builtFrom `json:"builds"`
+}
+
+type builtFrom struct {
+	Fingerprint string `json:"fingerprint"`
+	Digest      string `json:"digest"`
+}
+
+// loadFingerprints reads the fingerprints file of the binaries in dir. A
+// missing or unreadable one starts empty, so everything is built.
+func loadFingerprints(dir string) *fingerprints {
+	f := &fingerprints{path: filepath.Join(dir, fingerprintsFile), Builds: make(map[string]builtFrom)}
+	b, err := os.ReadFile(f.path)
+	if err != nil {
+		return f
+	}
+	if err := json.Unmarshal(b, f); err != nil || f.Builds == nil {
+		warnf("ignoring unreadable fingerprints file %s: %v", f.path, err)
+		f.Builds = make(map[string]builtFrom)
+	}
+	return f
+}
+
+// upToDate reports whether output was built from inputs with fingerprint fp
+// and has not been replaced since.
+func (f *fingerprints) upToDate(output, fp string) bool {
+	f.mu.Lock()
+	built, ok := f.Builds[filepath.Base(output)]
+	f.mu.Unlock()
+	if !ok || built.Fingerprint != fp {
+		return false
+	}
+	digest, err := fileDigest(output)
+	return err == nil && digest == built.Digest
+}
+
+// record notes that output was just built from inputs with fingerprint fp.
+func (f *fingerprints) record(output, fp string) error {
+	digest, err := fileDigest(output)
+	if err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.Builds[filepath.Base(output)] = builtFrom{Fingerprint: fp, Digest: digest}
+	return nil
+}
+
+func (f *fingerprints) skip(name string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.skipped = append(f.skipped, name)
+}
+
+// report lists the builds skipped as up to date.
+func (f *fingerprints) report() {
+	if len(f.skipped) == 0 {
+		return
+	}
+	titlef("Skipped %d up-to-date builds, use --force to rebuild", len(f.skipped))
+	for _, name := range f.skipped {
+		linef("%s", name)
+	}
+}
+
+func (f *fingerprints) save() error {
+	if dryRun {
+		return nil
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	b, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(f.path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(f.path, append(b, '\n'), 0644)
+}
+
+func fileDigest(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", err
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// fingerprintInfo returns the injected info a build is fingerprinted by, in
+// a stable order. BuildTime is left out: it changes on every run, and would
+// otherwise make every build look changed. A skipped binary keeps the build
+// time of when it was last built.
+func fingerprintInfo() []string {
+	var infos []string
+	for key, val := range info.INJECTION {
+		if key == "BuildTime" {
+			continue
+		}
+		infos = append(infos, key+"="+*val)
+	}
+	slices.Sort(infos)
+	return infos
+}
+
+// goEnvKeys are the go env settings that change what go build produces
+// without showing in the build's own env or args.
+var goEnvKeys = []string{
+	"GOVERSION", "GOOS", "GOARCH", "GOAMD64", "GOARM", "GOARM64", "GO386",
+	"GOMIPS", "GOMIPS64", "GOPPC64", "GORISCV64", "GOWASM",
+	"CGO_ENABLED", "GOFLAGS", "GOEXPERIMENT", "CC", "CGO_CFLAGS", "CGO_LDFLAGS",
+}
+
+// listedPackage is the part of go list -json a fingerprint is made of.
+type listedPackage struct {
+	ImportPath string
+	Dir        string
+	Standard   bool
+	Module     *struct {
+		Path    string
+		Version string
+		Main    bool
+		GoMod   string
+		Replace *struct {
+			Path    string
+			Version string
+		}
+	}
+	GoFiles, CgoFiles, CFiles, CXXFiles, HFiles, SFiles, SysoFiles, EmbedFiles []string
+}
+
+// local reports whether the package's own files are inputs of the build,
+// rather than its module version: it is in the main module, or in a module
+// replaced by a local directory.
+func (pkg listedPackage) local() bool {
+	if pkg.Standard {
+		return false
+	}
+	m := pkg.Module
+	return m == nil || m.Main || (m.Replace != nil && m.Replace.Version == "")
+}
+
+// files returns the names of the package's files the build reads.
+func (pkg listedPackage) files() []string {
+	files := slices.Concat(pkg.GoFiles, pkg.CgoFiles, pkg.CFiles, pkg.CXXFiles, pkg.HFiles, pkg.SFiles, pkg.SysoFiles, pkg.EmbedFiles)
+	slices.Sort(files)
+	return files
+}
+
+// fingerprint hashes everything the build reads: its output name, source,
+// args, env and injected info, the go toolchain settings, and every package
+// it depends on. The standard library is covered by the go version and a
+// module dependency by its version; the files of the main module and of
+// modules replaced by a local directory are hashed.
+func (b *binaryBuild) fingerprint() (string, error) {
+	h := sha256.New()
+	fmt.Fprintf(h, "output %s\nsrc %s\n", filepath.Base(b.output), b.src)
+	for _, arg := range b.buildArgs {
+		fmt.Fprintf(h, "arg %s\n", arg)
+	}
+	for _, e := range b.envs {
+		fmt.Fprintf(h, "env %s\n", e)
+	}
+	for _, i := range b.info {
+		fmt.Fprintf(h, "info %s\n", i)
+	}
+	goEnv, err := b.goOutput(append([]string{"env"}, goEnvKeys...))
+	if err != nil {
+		return "", err
+	}
+	fmt.Fprintf(h, "go env\n%s", goEnv)
+
+	pkgs, err := b.packages()
+	if err != nil {
+		return "", err
+	}
+	for _, pkg := range pkgs {
+		if pkg.Standard {
+			continue
+		}
+		if !pkg.local() {
+			m := pkg.Module
+			fmt.Fprintf(h, "pkg %s %s@%s", pkg.ImportPath, m.Path, m.Version)
+			if m.Replace != nil {
+				fmt.Fprintf(h, " => %s@%s", m.Replace.Path, m.Replace.Version)
+			}
+			fmt.Fprintln(h)
+			continue
+		}
+		fmt.Fprintf(h, "pkg %s\n", pkg.ImportPath)
+		for _, name := range pkg.files() {
+			digest, err := fileDigest(filepath.Join(pkg.Dir, name))
+			if err != nil {
+				return "", err
+			}
+			fmt.Fprintf(h, "file %s %s\n", name, digest)
+		}
+	}
+	return "sha256:" + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// packages lists every package the build compiles, as go list -deps sees
+// them.
+func (b *binaryBuild) packages() ([]listedPackage, error) {
+	listed, err := b.goOutput(append(append([]string{"list", "-deps", "-json"}, listFlags(b.buildArgs)...), b.src))
+	if err != nil {
+		return nil, err
+	}
+	var pkgs []listedPackage
+	decoder := json.NewDecoder(strings.NewReader(listed))
+	for decoder.More() {
+		var pkg listedPackage
+		if err := decoder.Decode(&pkg); err != nil {
+			return nil, err
+		}
+		pkgs = append(pkgs, pkg)
+	}
+	return pkgs, nil
+}
+
+// listFlags returns the build args go list and go test need to see the same
+// packages as go build: the ones selecting files, such as -tags.
+func listFlags(buildArgs []string) []string {
+	var flags []string
+	for i, arg := range buildArgs {
+		for _, name := range []string{"-tags", "-mod", "-modfile"} {
+			if arg == name && i+1 < len(buildArgs) {
+				flags = append(flags, arg, buildArgs[i+1])
+			} else if strings.HasPrefix(arg, name+"=") {
+				flags = append(flags, arg)
+			}
+		}
+	}
+	return flags
+}
+
+// goOutput runs a go command in the build's env and returns its output.
+func (b *binaryBuild) goOutput(args []string) (string, error) {
+	p := command("go", args, b.envs)
+	b.debugf("executing go %s", strings.Join(args, " "))
+	var stdout, stderr bytes.Buffer
+	p.Stdout, p.Stderr = &stdout, &stderr
+	if err := p.Run(); err != nil {
+		return "", fmt.Errorf("go %s: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.String(), nil
+}
+
+// build runs exec, the go build of b, unless the manifest shows its output up
+// to date, in which case it reports the build skipped. Once it has run, the
+// new fingerprint is recorded; --force only skips the check.
+func (b *binaryBuild) build(exec func() error) (bool, error) {
+	if b.manifest == nil {
+		return false, exec()
+	}
+	fp, err := b.fingerprint()
+	if err != nil {
+		// go build reports whatever go list tripped over better than the
+		// fingerprint could
+		b.debugf("building %s untracked: %s", b, err)
+		return false, exec()
+	}
+	if !forceBuild && b.manifest.upToDate(b.output, fp) {
+		b.manifest.skip(b.String())
+		return true, nil
+	}
+	if err := exec(); err != nil {
+		return false, err
+	}
+	if dryRun {
+		return false, nil
+	}
+	return false, b.manifest.record(b.output, fp)
+}
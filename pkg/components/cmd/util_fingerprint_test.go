@@ -0,0 +1,122 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/xhanio/framingo/pkg/types/info"
+
+	"github.com/xhanio/gopro/pkg/types"
+)
+
+// withGoModule lays out a module with a binary importing a package of its
+// own, and points the build at it.
+func withGoModule(t *testing.T) {
+	t.Helper()
+	dir := t.TempDir()
+	t.Chdir(dir)
+	writeTree(t, ".", "go.mod", "module example.com/app\n\ngo 1.21\n")
+	writeTree(t, "cmd/api", "main.go", "package main\n\nimport \"example.com/app/lib\"\n\nfunc main() { println(lib.Name) }\n")
+	writeTree(t, "lib", "lib.go", "package lib\n\nconst Name = \"api\"\n")
+	writeTree(t, "other", "other.go", "package other\n")
+	resetInfo(t)
+	oldRoot, oldBuilds, oldArtifacts, oldForce, oldOutput, oldJobs := info.ProjectRoot, builds, artifacts, forceBuild, binaryOutput, buildJobs
+	t.Cleanup(func() {
+		info.ProjectRoot, builds, artifacts, forceBuild, binaryOutput, buildJobs = oldRoot, oldBuilds, oldArtifacts, oldForce, oldOutput, oldJobs
+	})
+	info.ProjectRoot, builds, artifacts, forceBuild, binaryOutput, buildJobs = dir, nil, nil, false, "", 1
+}
+
+func TestFingerprintTracksWhatTheBuildReads(t *testing.T) {
+	withGoModule(t)
+	withProject(t, types.Project{}, types.EnvSpec{})
+	oldTime, oldCommit := info.BuildTime, info.GitCommit
+	t.Cleanup(func() { info.BuildTime, info.GitCommit = oldTime, oldCommit })
+	fingerprint := func(e types.EnvSpec) string {
+		t.Helper()
+		env = e
+		b, err := newBinaryBuild(types.BinarySpec{Name: "api"}, types.PlatformSpec{}, "cmd/api", "bin")
+		if err != nil {
+			t.Fatal(err)
+		}
+		fp, err := b.fingerprint()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return fp
+	}
+	base := fingerprint(types.EnvSpec{})
+	if again := fingerprint(types.EnvSpec{}); again != base {
+		t.Errorf("fingerprint is not stable: %s then %s", base, again)
+	}
+
+	info.BuildTime = "2000-01-01T00:00:00Z"
+	if got := fingerprint(types.EnvSpec{}); got != base {
+		t.Error("the build time changed the fingerprint")
+	}
+	writeTree(t, "other", "more.go", "package other\n\nconst X = 1\n")
+	if got := fingerprint(types.EnvSpec{}); got != base {
+		t.Error("a package the binary does not import changed the fingerprint")
+	}
+
+	changed := map[string]func(){
+		"dependency source": func() { writeTree(t, "lib", "lib.go", "package lib\n\nconst Name = \"API\"\n") },
+		"injected info":     func() { info.GitCommit = "abc123" },
+	}
+	for name, change := range changed {
+		change()
+		if got := fingerprint(types.EnvSpec{}); got == base {
+			t.Errorf("%s did not change the fingerprint", name)
+		}
+	}
+	if got := fingerprint(types.EnvSpec{BinaryBuildEnv: []string{"CGO_ENABLED=0"}}); got == fingerprint(types.EnvSpec{}) {
+		t.Error("build env did not change the fingerprint")
+	}
+	if got := fingerprint(types.EnvSpec{BinaryBuildArgs: []string{"-trimpath"}}); got == fingerprint(types.EnvSpec{}) {
+		t.Error("build args did not change the fingerprint")
+	}
+}
+
+// A second build of unchanged inputs is skipped and reported, --force builds
+// it anyway, and a binary removed behind gopro's back is rebuilt.
+func TestBuildBinarySkipsUpToDateBuilds(t *testing.T) {
+	withGoModule(t)
+	e := types.EnvSpec{BinaryTgt: "bin", Binaries: []string{"api"}}
+	withProject(t, types.Project{Build: types.BuildSpec{Binaries: []types.BinarySpec{{Name: "api", Src: "cmd/api"}}}}, e)
+	output := filepath.Join("bin", "api")
+	build := func() []string {
+		t.Helper()
+		binaryOutput = ""
+		if err := runBuildBinary(nil, nil); err != nil {
+			t.Fatal(err)
+		}
+		return builds.skipped
+	}
+
+	if skipped := build(); len(skipped) != 0 {
+		t.Fatalf("first build skipped %q", skipped)
+	}
+	if _, err := os.Stat(filepath.Join("bin", fingerprintsFile)); err != nil {
+		t.Fatalf("manifest not written: %v", err)
+	}
+	if skipped := build(); len(skipped) != 1 || skipped[0] != "api" {
+		t.Errorf("second build skipped %q, want api", skipped)
+	}
+
+	forceBuild = true
+	if skipped := build(); len(skipped) != 0 {
+		t.Errorf("forced build skipped %q", skipped)
+	}
+	forceBuild = false
+
+	if err := os.Remove(output); err != nil {
+		t.Fatal(err)
+	}
+	if skipped := build(); len(skipped) != 0 {
+		t.Errorf("removed binary was not rebuilt, skipped %q", skipped)
+	}
+	if _, err := os.Stat(output); err != nil {
+		t.Errorf("removed binary is still missing: %v", err)
+	}
+}
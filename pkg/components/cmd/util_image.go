@@ -0,0 +1,95 @@
+package cmd
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/xhanio/errors"
+	"github.com/xhanio/gopro/pkg/types"
+)
+
+// orderImages resolves the images to build for the selected names, ordered so
+// that every image comes after the image its $name base refers to.
+//
+// With withBases, a selected image's bases are pulled into the build even when
+// the filter left them out, since building on a stale or missing base tag is
+// never what was asked for. A base the environment does not enable is refused
+// rather than assumed to exist. Without it, only the selected images build,
+// still ordered among themselves, and any other base is trusted to exist.
+//
+// Otherwise images keep the order they are listed in, and names with no
+// build.images entry are skipped as before.
+func orderImages(images []types.ImageSpec, enabled, selected []string, withBases bool) ([]types.ImageSpec, error) {
+	byName := make(map[string]types.ImageSpec, len(images))
+	for _, image := range images {
+		byName[image.Name] = image
+	}
+	const (
+		visiting = 1
+		done     = 2
+	)
+	var (
+		result []types.ImageSpec
+		state  = make(map[string]int)
+		stack  []string
+	)
+	var visit func(name string) error
+	visit = func(name string) error {
+		switch state[name] {
+		case done:
+			return nil
+		case visiting:
+			cycle := append(slices.Clone(stack[slices.Index(stack, name):]), name)
+			return errors.Newf("image bases form a cycle: %s", strings.Join(cycle, " -> "))
+		}
+		image := byName[name]
+		state[name] = visiting
+		stack = append(stack, name)
+		if base, ok := image.BaseImage(); ok {
+			_, defined := byName[base]
+			switch {
+			case !withBases && slices.Contains(selected, base):
+				if err := visit(base); err != nil {
+					return err
+				}
+			case !withBases:
+			case !defined:
+				return errors.Newf("image %s is based on %s, which is not defined in build.images", name, base)
+			case !slices.Contains(enabled, base):
+				return errors.Newf("image %s is based on %s, which is not enabled in this environment", name, base)
+			default:
+				if !slices.Contains(selected, base) && state[base] == 0 {
+					linef("include base image %s of %s", base, name)
+				}
+				if err := visit(base); err != nil {
+					return err
+				}
+			}
+		}
+		stack = stack[:len(stack)-1]
+		state[name] = done
+		result = append(result, image)
+		return nil
+	}
+	for _, name := range selected {
+		if _, ok := byName[name]; !ok {
+			continue
+		}
+		if err := visit(name); err != nil {
+			return nil, err
+		}
+	}
+	return result, nil
+}
+
+// selectedImages returns the images to build in the current environment, in
+// dependency order.
+func selectedImages(withBases bool) ([]types.ImageSpec, error) {
+	var selected []string
+	for _, name := range env.Images {
+		if filterRegex.MatchString(name) {
+			selected = append(selected, name)
+		}
+	}
+	return orderImages(project.Build.Images, env.Images, selected, withBases)
+}
@@ -0,0 +1,119 @@
+package cmd
+
+import (
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/xhanio/gopro/pkg/types"
+)
+
+func imageNames(images []types.ImageSpec) []string {
+	var names []string
+	for _, image := range images {
+		names = append(names, image.Name)
+	}
+	return names
+}
+
+// base <- runtime <- api, with worker on an external base and tools on api.
+var layeredImages = []types.ImageSpec{
+	{Name: "api", Base: "$runtime"},
+	{Name: "worker", Base: "ubuntu:22.04"},
+	{Name: "runtime", Base: "$base"},
+	{Name: "base", BuildFrom: "alpine:3"},
+	{Name: "tools", Base: "$api"},
+}
+
+func TestOrderImages(t *testing.T) {
+	all := []string{"tools", "api", "worker", "runtime", "base"}
+	tests := []struct {
+		name      string
+		enabled   []string
+		selected  []string
+		withBases bool
+		want      []string
+		wantErr   string
+	}{
+		{
+			name:      "bases build before the images on them, whatever the listed order",
+			enabled:   all,
+			selected:  all,
+			withBases: true,
+			want:      []string{"base", "runtime", "api", "tools", "worker"},
+		},
+		{
+			name:      "images without $ bases keep their listed order",
+			enabled:   []string{"worker", "base"},
+			selected:  []string{"worker", "base"},
+			withBases: true,
+			want:      []string{"worker", "base"},
+		},
+		{
+			name:      "a filtered selection pulls in the bases it needs",
+			enabled:   all,
+			selected:  []string{"api"},
+			withBases: true,
+			want:      []string{"base", "runtime", "api"},
+		},
+		{
+			name:      "a base the environment does not enable is refused",
+			enabled:   []string{"api", "runtime"},
+			selected:  []string{"api"},
+			withBases: true,
+			wantErr:   "runtime is based on base, which is not enabled",
+		},
+		{
+			name:     "without bases only the selection builds, still ordered",
+			enabled:  all,
+			selected: []string{"tools", "api"},
+			want:     []string{"api", "tools"},
+		},
+		{
+			name:     "names without a build.images entry are skipped",
+			enabled:  []string{"missing", "worker"},
+			selected: []string{"missing", "worker"},
+			want:     []string{"worker"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := orderImages(layeredImages, tt.enabled, tt.selected, tt.withBases)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("err = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if names := imageNames(got); !slices.Equal(names, tt.want) {
+				t.Fatalf("order = %q, want %q", names, tt.want)
+			}
+		})
+	}
+}
+
+func TestOrderImagesDetectsCycles(t *testing.T) {
+	images := []types.ImageSpec{
+		{Name: "a", Base: "$b"},
+		{Name: "b", Base: "$c"},
+		{Name: "c", Base: "$a"},
+	}
+	names := []string{"a", "b", "c"}
+	_, err := orderImages(images, names, names, true)
+	if err == nil {
+		t.Fatal("expected a cycle error")
+	}
+	if !strings.Contains(err.Error(), "a -> b -> c -> a") {
+		t.Errorf("err = %v, want the cycle spelled out", err)
+	}
+}
+
+func TestOrderImagesRefusesUndefinedBase(t *testing.T) {
+	images := []types.ImageSpec{{Name: "api", Base: "$nope"}}
+	if _, err := orderImages(images, []string{"api"}, []string{"api"}, true); err == nil {
+		t.Fatal("expected an error for an undefined base")
+	}
+}
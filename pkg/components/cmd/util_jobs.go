@@ -0,0 +1,97 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"runtime"
+	"strings"
+	"sync"
+
+	"github.com/xhanio/errors"
+)
+
+// job is one unit of work for runJobs, named for the output it produces.
+type job struct {
+	name string
+	run  func(w io.Writer) error
+}
+
+// runJobs runs jobs on at most n workers, one per CPU when n is below one.
+//
+// Each job writes into its own buffer, printed as a single block prefixed
+// with the job name once the job finishes, so concurrent output never
+// interleaves. A failure does not stop the others: every job runs, and the
+// failures are printed together at the end, in job order, and summarized
+// by name in the error returned.
+func runJobs(n int, jobs []job) error {
+	if n < 1 {
+		n = runtime.NumCPU()
+	}
+	var (
+		mu    sync.Mutex
+		wg    sync.WaitGroup
+		queue = make(chan int)
+		errs  = make([]error, len(jobs))
+	)
+	for range min(n, len(jobs)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range queue {
+				var buffer bytes.Buffer
+				err := jobs[i].run(&buffer)
+				if err != nil {
+					errs[i] = fmt.Errorf("%s: %w", jobs[i].name, err)
+				}
+				mu.Lock()
+				printJob(jobs[i].name, &buffer, err)
+				mu.Unlock()
+			}
+		}()
+	}
+	for i := range jobs {
+		queue <- i
+	}
+	close(queue)
+	wg.Wait()
+
+	// the failures are printed here in full, and returned only by name so
+	// the caller's report does not repeat them
+	var failed []string
+	for i, err := range errs {
+		if err != nil {
+			failed = append(failed, jobs[i].name)
+			linef("%s", err)
+		}
+	}
+	if len(failed) == 0 {
+		return nil
+	}
+	return errors.Newf("%d of %d failed: %s", len(failed), len(jobs), strings.Join(failed, ", "))
+}
+
+func printJob(name string, output io.Reader, err error) {
+	if err != nil {
+		warnf("%s failed", name)
+	} else {
+		titlef("%s done", name)
+	}
+	// read by line with no bound on its length, as a long -ldflags or vet
+	// report would overflow a scanner
+	r := bufio.NewReader(output)
+	for {
+		line, err := r.ReadString('\n')
+		if line != "" {
+			linef("[%s] %s", name, strings.TrimSuffix(line, "\n"))
+		}
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			warnf("[%s] %s", name, err)
+			return
+		}
+	}
+}
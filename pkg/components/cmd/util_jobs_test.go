@@ -0,0 +1,107 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/fatih/color"
+)
+
+// A failure must not stop the other jobs: every job runs, and every failure
+// is reported at the end rather than only the first.
+func TestRunJobsReportsEveryFailure(t *testing.T) {
+	var ran atomic.Int32
+	var jobs []job
+	for i := range 6 {
+		jobs = append(jobs, job{
+			name: fmt.Sprintf("job%d", i),
+			run: func(w io.Writer) error {
+				ran.Add(1)
+				if i%2 == 1 {
+					return errors.New("boom")
+				}
+				return nil
+			},
+		})
+	}
+
+	err := runJobs(3, jobs)
+
+	if got := ran.Load(); got != 6 {
+		t.Fatalf("%d jobs ran, want all 6", got)
+	}
+	if err == nil {
+		t.Fatal("expected the failures to be reported")
+	}
+	for _, name := range []string{"job1", "job3", "job5"} {
+		if !strings.Contains(err.Error(), name) {
+			t.Errorf("error %q does not name %s", err, name)
+		}
+	}
+	for _, name := range []string{"job0", "job2", "job4"} {
+		if strings.Contains(err.Error(), name) {
+			t.Errorf("error %q names %s, which succeeded", err, name)
+		}
+	}
+}
+
+func TestRunJobsBoundsConcurrency(t *testing.T) {
+	var running, peak atomic.Int32
+	var jobs []job
+	for i := range 8 {
+		jobs = append(jobs, job{
+			name: fmt.Sprintf("job%d", i),
+			run: func(w io.Writer) error {
+				n := running.Add(1)
+				defer running.Add(-1)
+				for {
+					p := peak.Load()
+					if n <= p || peak.CompareAndSwap(p, n) {
+						break
+					}
+				}
+				time.Sleep(10 * time.Millisecond)
+				return nil
+			},
+		})
+	}
+
+	if err := runJobs(2, jobs); err != nil {
+		t.Fatal(err)
+	}
+	if got := peak.Load(); got > 2 {
+		t.Errorf("%d jobs ran at once, want at most 2", got)
+	}
+}
+
+func TestRunJobsWithNothingToDo(t *testing.T) {
+	if err := runJobs(4, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// A job's output is printed whole, however long its lines.
+func TestRunJobsPrintsLongLines(t *testing.T) {
+	oldOut, oldNoColor := printOut, color.NoColor
+	t.Cleanup(func() { printOut, color.NoColor = oldOut, oldNoColor })
+	var out bytes.Buffer
+	printOut, color.NoColor = &out, true
+
+	long := strings.Repeat("x", 100*1024)
+	jobs := []job{{name: "vet", run: func(w io.Writer) error {
+		fmt.Fprintf(w, "%s\nlast", long)
+		return nil
+	}}}
+	if err := runJobs(1, jobs); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "[vet] "+long+"\n") || !strings.Contains(out.String(), "[vet] last\n") {
+		t.Errorf("output lost lines: %.200s", out.String())
+	}
+}
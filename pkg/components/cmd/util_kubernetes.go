@@ -0,0 +1,390 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/xhanio/errors"
+	"github.com/xhanio/framingo/pkg/types/info"
+
+	"github.com/xhanio/gopro/pkg/types"
+	"github.com/xhanio/gopro/pkg/utils/kubeschemautil"
+	"github.com/xhanio/gopro/pkg/utils/schemautil"
+)
+
+// helmChartFiles are the files of a chart's root, which a template of the
+// same name provides instead of gopro.
+var helmChartFiles = []string{"Chart.yaml", "values.yaml"}
+
+// chartVersionPattern is the SemVer 2 a chart version must be, without the v
+// of a Git tag.
+var chartVersionPattern = regexp.MustCompile(`^\d+\.\d+\.\d+(-[0-9A-Za-z.-]+)?(\+[0-9A-Za-z.-]+)?$`)
+
+type helmChartMeta struct {
+	APIVersion  string `yaml:"apiVersion"`
+	Name        string `yaml:"name"`
+	Description string `yaml:"description,omitempty"`
+	Type        string `yaml:"type"`
+	Version     string `yaml:"version"`
+	AppVersion  string `yaml:"appVersion,omitempty"`
+}
+
+type helmValues struct {
+	Product     string               `yaml:"product"`
+	Version     string               `yaml:"version"`
+	Environment string               `yaml:"environment"`
+	Images      map[string]helmImage `yaml:"images,omitempty"`
+}
+
+type helmImage struct {
+	Repository string `yaml:"repository"`
+	Tag        string `yaml:"tag"`
+}
+
+// helmChart packs a component as a Helm chart: its rendered templates under
+// templates/, a Chart.yaml versioned by the product version with the version
+// of its binary as appVersion, and a values.yaml of the environment. gopro
+// renders [[ ]] only, so {{ .Values... }} in a template is left for Helm.
+func helmChart(spec types.KubernetesSpec) func(unit renderUnit) (map[string][]byte, error) {
+	return func(unit renderUnit) (map[string][]byte, error) {
+		if err := packedOutsideSources(unit); err != nil {
+			return nil, err
+		}
+		rendered, err := unit.layers()
+		if err != nil {
+			return nil, err
+		}
+		version := strings.TrimPrefix(info.ProductVersion, "v")
+		if !chartVersionPattern.MatchString(version) {
+			return nil, errors.Newf("chart %s: product version %q is not a semantic version, set version or tag vX.Y.Z", spec.Name, info.ProductVersion)
+		}
+		appVersion := info.ProductVersion
+		if i := slices.IndexFunc(project.Build.Binaries, func(b types.BinarySpec) bool { return b.Name == spec.GetBinary() }); i >= 0 && project.Build.Binaries[i].Version != "" {
+			appVersion = project.Build.Binaries[i].Version
+		}
+		chart, err := marshalYAML(helmChartMeta{
+			APIVersion:  "v2",
+			Name:        spec.Name,
+			Description: strings.TrimSpace(project.Product + " " + spec.Name),
+			Type:        "application",
+			Version:     version,
+			AppVersion:  appVersion,
+		})
+		if err != nil {
+			return nil, err
+		}
+		values, err := marshalYAML(chartValues())
+		if err != nil {
+			return nil, err
+		}
+		tree := map[string][]byte{"Chart.yaml": chart, "values.yaml": values}
+		for rel, b := range rendered {
+			if slices.Contains(helmChartFiles, rel) {
+				tree[rel] = b
+				continue
+			}
+			tree["templates/"+rel] = b
+		}
+		return tree, nil
+	}
+}
+
+// chartValues are the values of a chart for the environment: what it is, and
+// the images it enables, split as charts conventionally take them.
+func chartValues() helmValues {
+	values := helmValues{
+		Product:     project.Product,
+		Version:     info.ProductVersion,
+		Environment: selectedEnv(),
+	}
+	for _, image := range project.Build.Images {
+		if !slices.Contains(env.Images, image.Name) {
+			continue
+		}
+		if values.Images == nil {
+			values.Images = make(map[string]helmImage)
+		}
+		// the tag is always there, after any registry port
+		ref := image.GetImageName(env)
+		i := strings.LastIndex(ref, ":")
+		values.Images[image.Name] = helmImage{Repository: ref[:i], Tag: ref[i+1:]}
+	}
+	return values
+}
+
+type kustomization struct {
+	APIVersion string           `yaml:"apiVersion"`
+	Kind       string           `yaml:"kind"`
+	Resources  []string         `yaml:"resources,omitempty"`
+	Patches    []kustomizePatch `yaml:"patches,omitempty"`
+}
+
+type kustomizePatch struct {
+	Path string `yaml:"path"`
+}
+
+// kustomizeLayout lays a component out for kustomize: base/ is its default
+// layer rendered for the default environment, and overlays/<overlay>/ holds
+// what rendering it for the environment changes. A manifest the base has
+// becomes a patch of it, a new one a resource of the overlay. Patches merge,
+// so an environment can change and add fields but not remove them.
+func kustomizeLayout(overlay string) func(unit renderUnit) (map[string][]byte, error) {
+	return func(unit renderUnit) (map[string][]byte, error) {
+		if err := packedOutsideSources(unit); err != nil {
+			return nil, err
+		}
+		var base map[string][]byte
+		err := inEnv("", func() error {
+			var err error
+			base, err = renderLayers(unit.name, unit.srcs[:1], unit.patterns)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		rendered, err := unit.layers()
+		if err != nil {
+			return nil, err
+		}
+		tree := make(map[string][]byte)
+		var manifests []string
+		for _, rel := range sortedKeys(base) {
+			tree["base/"+rel] = base[rel]
+			if isManifest(rel) {
+				manifests = append(manifests, rel)
+			}
+		}
+		overlayDir := "overlays/" + overlay + "/"
+		resources := []string{"../../base"}
+		var patches []kustomizePatch
+		for _, rel := range sortedKeys(rendered) {
+			b := rendered[rel]
+			was, inBase := base[rel]
+			if inBase && bytes.Equal(was, b) {
+				continue
+			}
+			tree[overlayDir+rel] = b
+			switch {
+			case !isManifest(rel):
+			case inBase:
+				patches = append(patches, kustomizePatch{Path: rel})
+			default:
+				resources = append(resources, rel)
+			}
+		}
+		// a kustomization among the templates is kept as it is
+		if _, ok := base["kustomization.yaml"]; !ok {
+			if tree["base/kustomization.yaml"], err = marshalYAML(newKustomization(manifests, nil)); err != nil {
+				return nil, err
+			}
+		}
+		if _, ok := rendered["kustomization.yaml"]; !ok {
+			if tree[overlayDir+"kustomization.yaml"], err = marshalYAML(newKustomization(resources, patches)); err != nil {
+				return nil, err
+			}
+		}
+		return tree, nil
+	}
+}
+
+func newKustomization(resources []string, patches []kustomizePatch) kustomization {
+	return kustomization{
+		APIVersion: "kustomize.config.k8s.io/v1beta1",
+		Kind:       "Kustomization",
+		Resources:  resources,
+		Patches:    patches,
+	}
+}
+
+// isManifest reports whether a rendered file is a Kubernetes manifest
+// kustomize takes as a resource or patch.
+func isManifest(rel string) bool {
+	switch path.Ext(rel) {
+	case ".yaml", ".yml", ".json":
+		return path.Base(rel) != "kustomization.yaml"
+	}
+	return false
+}
+
+// packedOutsideSources refuses to lay a component out beside its templates,
+// where the next render would take the layout for templates.
+func packedOutsideSources(unit renderUnit) error {
+	in, err := inPlace(unit.dst, unit.srcs...)
+	if err != nil {
+		return err
+	}
+	if in {
+		return errors.Newf("%s: a chart or kustomize layout needs a kubernetes_tgt outside its templates, not %s", unit.title, unit.dst)
+	}
+	return nil
+}
+
+// marshalYAML encodes v as YAML indented by two spaces, as Kubernetes
+// tooling writes it.
+func marshalYAML(v any) ([]byte, error) {
+	var b bytes.Buffer
+	encoder := yaml.NewEncoder(&b)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(v); err != nil {
+		return nil, err
+	}
+	if err := encoder.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// podContainers are the fields of a pod spec listing containers, wherever it
+// is nested in a manifest.
+var podContainers = []string{"initContainers", "containers", "ephemeralContainers"}
+
+// validateManifests checks every document of the manifests the units render
+// in memory against the bundled schemas of the cluster version, and
+// that the project's images they run are those GetImageName resolves. Kinds
+// without a bundled schema, such as custom resources, are skipped, and so
+// are the files of a chart holding Helm's {{ }}.
+func validateManifests(units []renderUnit) error {
+	version := kubernetesVersion
+	if version == "" {
+		version = env.KubernetesVersion
+	}
+	if version == "" {
+		version = kubeschemautil.LatestVersion
+	}
+	schemas, err := kubeschemautil.Load(version)
+	if err != nil {
+		return err
+	}
+	titlef("Validate kubernetes manifests against Kubernetes %s", schemas.Version())
+	problems := 0
+	for _, unit := range units {
+		helm := slices.ContainsFunc(project.Generate.Kubernetes, func(k types.KubernetesSpec) bool {
+			return k.Name == unit.name && k.GetOutput() == types.KubernetesOutputHelm
+		})
+		rendered, err := unit.layers()
+		if err != nil {
+			return err
+		}
+		for _, rel := range sortedKeys(rendered) {
+			file := path.Join(unit.name, rel)
+			if !isManifest(rel) {
+				continue
+			}
+			if helm && bytes.Contains(rendered[rel], []byte("{{")) {
+				if verbose {
+					debugf("skip %s, left for Helm", file)
+				}
+				continue
+			}
+			docs, err := schemautil.Documents(rendered[rel])
+			if err != nil {
+				problems++
+				warnf("%s: %s", file, err)
+				continue
+			}
+			for i, doc := range docs {
+				if doc == nil {
+					continue
+				}
+				at := fmt.Sprintf("%s[%d]", file, i)
+				obj, ok := doc.(map[string]any)
+				if !ok {
+					problems++
+					warnf("%s: not a Kubernetes object", at)
+					continue
+				}
+				errs, known := schemas.Validate(obj)
+				if !known && verbose {
+					debugf("skip %s: no bundled schema for %s %s", at, obj["apiVersion"], obj["kind"])
+				}
+				errs = append(errs, imageErrors(obj, "")...)
+				for _, e := range errs {
+					warnf("%s %s: %s", at, objectName(obj), e)
+				}
+				problems += len(errs)
+			}
+		}
+	}
+	if problems > 0 {
+		return errors.Newf("%d problems found in rendered kubernetes manifests", problems)
+	}
+	linef("no problems found")
+	return nil
+}
+
+// imageErrors checks the images of the containers found under v: an image
+// of a repository the project builds must be the reference GetImageName
+// resolves for the environment, not a stale tag or another registry. Images
+// the project does not build are left alone.
+func imageErrors(v any, at string) []schemautil.Error {
+	var errs []schemautil.Error
+	switch v := v.(type) {
+	case map[string]any:
+		for _, key := range sortedKeys(v) {
+			field := key
+			if at != "" {
+				field = at + "." + key
+			}
+			containers, ok := v[key].([]any)
+			if !ok || !slices.Contains(podContainers, key) {
+				errs = append(errs, imageErrors(v[key], field)...)
+				continue
+			}
+			for i, c := range containers {
+				// a container that is not an object is the schema's to report
+				cm, ok := c.(map[string]any)
+				if !ok {
+					continue
+				}
+				ref, _ := cm["image"].(string)
+				if want, ok := projectImage(ref); ok && ref != want {
+					errs = append(errs, schemautil.Error{
+						Path: fmt.Sprintf("%s[%d].image", field, i),
+						Msg:  fmt.Sprintf("%q is not %q, the image GetImageName resolves", ref, want),
+					})
+				}
+			}
+		}
+	case []any:
+		for i, e := range v {
+			errs = append(errs, imageErrors(e, fmt.Sprintf("%s[%d]", at, i))...)
+		}
+	}
+	return errs
+}
+
+// projectImage returns the reference GetImageName resolves for the image of
+// the project that ref is of, by repository, if any.
+func projectImage(ref string) (string, bool) {
+	if ref == "" {
+		return "", false
+	}
+	repo, _, _ := strings.Cut(ref, "@")
+	if i := strings.LastIndex(repo, ":"); i > strings.LastIndex(repo, "/") {
+		repo = repo[:i]
+	}
+	for _, image := range project.Build.Images {
+		name := image.Repo
+		if name == "" {
+			name = image.Name
+		}
+		if repo == name || strings.HasSuffix(repo, "/"+name) {
+			return image.GetImageName(env), true
+		}
+	}
+	return "", false
+}
+
+// objectName names a manifest as kind/name.
+func objectName(obj map[string]any) string {
+	kind, _ := obj["kind"].(string)
+	meta, _ := obj["metadata"].(map[string]any)
+	name, _ := meta["name"].(string)
+	return kind + "/" + name
+}
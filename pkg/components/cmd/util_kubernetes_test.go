@@ -0,0 +1,277 @@
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/xhanio/framingo/pkg/types/info"
+
+	"github.com/xhanio/gopro/pkg/types"
+)
+
+// kubernetesLayoutProject renders the api templates of env/default, and with
+// name prod those of env/prod over them, into dist/kubernetes.
+func kubernetesLayoutProject(t *testing.T, output types.KubernetesOutput, name string) {
+	t.Helper()
+	spec := types.EnvSpec{
+		KubernetesSrc:       "env/default",
+		KubernetesTgt:       "dist/kubernetes",
+		KubernetesTemplates: []string{"api"},
+		Images:              []string{"api"},
+		ImagePrefix:         "registry.test:5000",
+		ImageTag:            "1.2.3",
+	}
+	p := types.Project{
+		Product: "demo",
+		Default: spec,
+		Build: types.BuildSpec{
+			Binaries: []types.BinarySpec{{Name: "api", Version: "2.0.0"}},
+			Images:   []types.ImageSpec{{Name: "api", Repo: "acme/api"}},
+		},
+		Generate: types.GenerateSpec{Kubernetes: []types.KubernetesSpec{{Name: "api", Output: output}}},
+	}
+	e := spec
+	if name != "" {
+		e.KubernetesSrc = "env/" + name
+	}
+	withProject(t, p, e)
+	oldEnvName := envName
+	t.Cleanup(func() { envName = oldEnvName })
+	envName = name
+	oldVersion := info.ProductVersion
+	t.Cleanup(func() { info.ProductVersion = oldVersion })
+	info.ProductVersion = "v1.4.0"
+}
+
+func readKubernetesTarget(t *testing.T, rel string) string {
+	t.Helper()
+	b, err := os.ReadFile(filepath.Join("dist", "kubernetes", "api", rel))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestGenerateKubernetesHelmChart(t *testing.T) {
+	t.Chdir(t.TempDir())
+	writeTree(t, "env/default/api", "template.deployment.yaml", "name: [[ .Name ]]\nimage: {{ .Values.images.api.repository }}\n")
+	writeTree(t, "dist/kubernetes/api", "stale.yaml", "old\n")
+	kubernetesLayoutProject(t, types.KubernetesOutputHelm, "")
+
+	if err := runGenerateKubernetes(nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"Chart.yaml": `apiVersion: v2
+name: api
+description: demo api
+type: application
+version: 1.4.0
+appVersion: 2.0.0
+`,
+		"values.yaml": `product: demo
+version: v1.4.0
+environment: default
+images:
+  api:
+    repository: registry.test:5000/acme/api
+    tag: 1.2.3
+`,
+		"templates/deployment.yaml": "name: api\nimage: {{ .Values.images.api.repository }}\n",
+	}
+	for rel, body := range want {
+		if got := readKubernetesTarget(t, rel); got != body {
+			t.Errorf("%s =\n%s\nwant\n%s", rel, got, body)
+		}
+	}
+	if _, err := os.Stat(filepath.Join("dist", "kubernetes", "api", "stale.yaml")); !os.IsNotExist(err) {
+		t.Errorf("stale output should have been cleared (err=%v)", err)
+	}
+
+	info.ProductVersion = "main"
+	if err := runGenerateKubernetes(nil, nil); err == nil || !strings.Contains(err.Error(), "not a semantic version") {
+		t.Errorf("chart of version main: %v", err)
+	}
+}
+
+func TestGenerateKubernetesKustomizeLayout(t *testing.T) {
+	t.Chdir(t.TempDir())
+	writeTree(t, "env/default/api", "template.deployment.yaml", "replicas: [[ if eq .EnvName \"prod\" ]]3[[ else ]]1[[ end ]]\n")
+	writeTree(t, "env/default/api", "service.yaml", "kind: Service\n")
+	writeTree(t, "env/prod/api", "ingress.yaml", "kind: Ingress\n")
+	kubernetesLayoutProject(t, types.KubernetesOutputKustomize, "prod")
+
+	if err := runGenerateKubernetes(nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{
+		"base/deployment.yaml": "replicas: 1\n",
+		"base/service.yaml":    "kind: Service\n",
+		"base/kustomization.yaml": `apiVersion: kustomize.config.k8s.io/v1beta1
+kind: Kustomization
+resources:
+  - deployment.yaml
+  - service.yaml
+`,
+		"overlays/prod/deployment.yaml": "replicas: 3\n",
+		"overlays/prod/ingress.yaml":    "kind: Ingress\n",
+		"overlays/prod/kustomization.yaml": `apiVersion: kustomize.config.k8s.io/v1beta1
+kind: Kustomization
+resources:
+  - ../../base
+  - ingress.yaml
+patches:
+  - path: deployment.yaml
+`,
+	}
+	for rel, body := range want {
+		if got := readKubernetesTarget(t, rel); got != body {
+			t.Errorf("%s =\n%s\nwant\n%s", rel, got, body)
+		}
+	}
+	if _, err := os.Stat(filepath.Join("dist", "kubernetes", "api", "overlays", "prod", "service.yaml")); !os.IsNotExist(err) {
+		t.Errorf("an unchanged manifest was copied to the overlay (err=%v)", err)
+	}
+
+	// the default environment writes its own overlay beside prod's
+	kubernetesLayoutProject(t, types.KubernetesOutputKustomize, "")
+	if err := runGenerateKubernetes(nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := readKubernetesTarget(t, "overlays/default/kustomization.yaml"); !strings.Contains(got, "- ../../base\n") || strings.Contains(got, "patches") {
+		t.Errorf("default overlay =\n%s", got)
+	}
+	readKubernetesTarget(t, "overlays/prod/kustomization.yaml")
+
+	withDiffContext(t)
+	var out bytes.Buffer
+	changed, err := diffTargets(&out, kubernetesUnits())
+	if err != nil || changed != 0 {
+		t.Errorf("%d files differ after generate, %v:\n%s", changed, err, out.String())
+	}
+}
+
+func TestGenerateKubernetesLayoutNeedsTarget(t *testing.T) {
+	t.Chdir(t.TempDir())
+	writeTree(t, "env/default/api", "deployment.yaml", "kind: Deployment\n")
+	kubernetesLayoutProject(t, types.KubernetesOutputHelm, "")
+	env.KubernetesTgt = ""
+	if err := runGenerateKubernetes(nil, nil); err == nil || !strings.Contains(err.Error(), "outside its templates") {
+		t.Errorf("in place chart: %v", err)
+	}
+}
+
+func TestGenerateKubernetesValidate(t *testing.T) {
+	t.Chdir(t.TempDir())
+	kubernetesLayoutProject(t, types.KubernetesOutputManifests, "")
+	oldValidate, oldVersion := kubernetesValidate, kubernetesVersion
+	t.Cleanup(func() { kubernetesValidate, kubernetesVersion = oldValidate, oldVersion })
+	kubernetesValidate, kubernetesVersion = true, ""
+	deployment := `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: [[ .Name ]]
+spec:
+  replica: 2
+  selector: {matchLabels: {app: api}}
+  template:
+    metadata: {labels: {app: api}}
+    spec:
+      containers:
+        - name: api
+          image: acme/api:latest
+        - name: cache
+          image: redis:7
+`
+	writeTree(t, "env/default/api", "template.deployment.yaml", deployment)
+	writeTree(t, "env/default/api", "monitor.yaml", "apiVersion: monitoring.coreos.com/v1\nkind: ServiceMonitor\nspec: {anything: 1}\n---\napiVersion: v1\nkind: ConfigMap\ndata: {port: 8080}\n")
+
+	err := runGenerateKubernetes(nil, nil)
+	if err == nil || !strings.Contains(err.Error(), "3 problems") {
+		t.Fatalf("generate = %v, want 3 problems", err)
+	}
+	if _, err := os.Stat(filepath.Join("dist", "kubernetes", "api")); !os.IsNotExist(err) {
+		t.Errorf("invalid manifests were written (err=%v)", err)
+	}
+
+	fixed := strings.NewReplacer("replica:", "replicas:", "acme/api:latest", `[[ GetImageName "api" ]]`).Replace(deployment)
+	writeTree(t, "env/default/api", "template.deployment.yaml", fixed)
+	writeTree(t, "env/default/api", "monitor.yaml", "apiVersion: monitoring.coreos.com/v1\nkind: ServiceMonitor\nspec: {anything: 1}\n")
+	if err := runGenerateKubernetes(nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := readKubernetesTarget(t, "deployment.yaml"); !strings.Contains(got, "image: registry.test:5000/acme/api:1.2.3") {
+		t.Errorf("deployment.yaml =\n%s", got)
+	}
+
+	// an older cluster does not serve a field added since
+	writeTree(t, "env/default/api", "pod.yaml", "apiVersion: v1\nkind: Pod\nspec:\n  hostUsers: false\n  containers: [{name: api}]\n")
+	kubernetesVersion = "1.24"
+	if err := runGenerateKubernetes(nil, nil); err == nil || !strings.Contains(err.Error(), "1 problems") {
+		t.Errorf("generate for 1.24 = %v", err)
+	}
+	kubernetesVersion = "1.2"
+	if err := runGenerateKubernetes(nil, nil); err == nil {
+		t.Error("validated against 1.2")
+	}
+}
+
+// TestGenerateKubernetesValidateRendersOnce checks that --validate writes
+// the manifests it checked, with no second render to run secret providers
+// again.
+func TestGenerateKubernetesValidateRendersOnce(t *testing.T) {
+	t.Chdir(t.TempDir())
+	kubernetesLayoutProject(t, types.KubernetesOutputManifests, "prod")
+	oldValidate, oldVersion := kubernetesValidate, kubernetesVersion
+	t.Cleanup(func() { kubernetesValidate, kubernetesVersion = oldValidate, oldVersion })
+	kubernetesValidate, kubernetesVersion = true, ""
+	project.Secrets.Providers = []types.SecretProviderSpec{
+		{Name: "vault", Type: types.SecretProviderExec, Command: []string{"sh", "-c", "echo call >> calls; echo s3cret"}},
+	}
+	writeTree(t, "env/default/api", "template.secret.yaml", "apiVersion: v1\nkind: Secret\nmetadata: {name: api}\nstringData: {token: [[ FromSecret \"vault\" \"api\" \"TOKEN\" ]]}\n")
+
+	if err := runGenerateKubernetes(nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := readKubernetesTarget(t, "secret.yaml"); !strings.Contains(got, "token: s3cret") {
+		t.Errorf("secret.yaml =\n%s", got)
+	}
+	calls, err := os.ReadFile("calls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(calls), "call"); n != 1 {
+		t.Errorf("secret provider ran %d times, want 1", n)
+	}
+}
+
+func TestImageErrors(t *testing.T) {
+	kubernetesLayoutProject(t, types.KubernetesOutputManifests, "")
+	docs := map[string]string{
+		"registry.test:5000/acme/api:1.2.3": "",
+		"acme/api:latest":                   `spec.jobTemplate.spec.template.spec.initContainers[0].image: "acme/api:latest" is not "registry.test:5000/acme/api:1.2.3", the image GetImageName resolves`,
+		"other.test/acme/api@sha256:00":     `spec.jobTemplate.spec.template.spec.initContainers[0].image: "other.test/acme/api@sha256:00" is not "registry.test:5000/acme/api:1.2.3", the image GetImageName resolves`,
+		"acme/apiserver:1.2.3":              "",
+		"registry.test:5000/api":            "",
+	}
+	for image, want := range docs {
+		obj := map[string]any{"spec": map[string]any{"jobTemplate": map[string]any{"spec": map[string]any{"template": map[string]any{"spec": map[string]any{
+			"initContainers": []any{map[string]any{"name": "init", "image": image}},
+		}}}}}}
+		var got []string
+		for _, e := range imageErrors(obj, "") {
+			got = append(got, e.Error())
+		}
+		if strings.Join(got, "\n") != want {
+			t.Errorf("%s: %q, want %q", image, got, want)
+		}
+	}
+	// containers that are not objects are left to the schema
+	obj := map[string]any{"spec": map[string]any{"containers": []any{"nginx", nil, map[string]any{"image": "acme/api:latest"}}}}
+	if errs := imageErrors(obj, ""); len(errs) != 1 || errs[0].Path != "spec.containers[2].image" {
+		t.Errorf("non-object containers: %v", errs)
+	}
+}
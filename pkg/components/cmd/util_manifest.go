@@ -0,0 +1,389 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/xhanio/framingo/pkg/types/info"
+	"github.com/xhanio/framingo/pkg/utils/envutil"
+
+	"github.com/xhanio/gopro/pkg/types"
+)
+
+var (
+	manifestPath   string
+	manifestFormat string
+
+	// artifacts records what the current build run produces, nil when
+	// nothing is recorded, as under --dry-run.
+	artifacts *buildManifest
+)
+
+// buildManifest is the record of the binaries, images and packages a project
+// build produced, and of the source they were built from.
+//
+// A run only records what it built, so build binary, build image and build
+// package add to the same manifest. What an earlier run recorded is kept as
+// long as it was built from the same commit; a manifest from another commit
+// starts over.
+type buildManifest struct {
+	mu       sync.Mutex
+	Project  string            `json:"project,omitempty"`
+	Env      string            `json:"env,omitempty"`
+	Info     map[string]string `json:"info"`
+	Binaries []binaryArtifact  `json:"binaries,omitempty"`
+	Images   []imageArtifact   `json:"images,omitempty"`
+	Packages []packageArtifact `json:"packages,omitempty"`
+
+	path   string
+	format types.ManifestFormat
+}
+
+type binaryArtifact struct {
+	Name     string `json:"name"`
+	Version  string `json:"version,omitempty"`
+	Platform string `json:"platform"`
+	Path     string `json:"path"`
+	SHA256   string `json:"sha256"`
+	Size     int64  `json:"size"`
+	// Env and Args are what go build ran with: the merged build env, and
+	// the args including the injected -ldflags.
+	Env  []string `json:"env,omitempty"`
+	Args []string `json:"args"`
+	// SBOMs are the documents written beside the binary.
+	SBOMs []string `json:"sboms,omitempty"`
+	// Signature is the signature written beside the binary under --sign.
+	Signature string `json:"signature,omitempty"`
+}
+
+type imageArtifact struct {
+	Name      string   `json:"name"`
+	Refs      []string `json:"refs"`
+	Platforms []string `json:"platforms,omitempty"`
+	Pushed    bool     `json:"pushed"`
+	// Digest is the manifest digest the registry holds the image under, known
+	// once it is pushed, or that of an oci build_mode image right away.
+	Digest string `json:"digest,omitempty"`
+	// Path is the layout or tarball an oci build_mode image is written to.
+	Path string `json:"path,omitempty"`
+	// SBOMs are the documents written for the image.
+	SBOMs []string `json:"sboms,omitempty"`
+}
+
+type packageArtifact struct {
+	Name     string `json:"name"`
+	Version  string `json:"version,omitempty"`
+	Format   string `json:"format"`
+	Platform string `json:"platform"`
+	Path     string `json:"path"`
+	SHA256   string `json:"sha256"`
+	Size     int64  `json:"size"`
+}
+
+// openManifest starts the manifest of a build run, resolving its path and
+// format from the flags, then the environment's build_manifest.
+func openManifest() (*buildManifest, error) {
+	if dryRun {
+		return nil, nil
+	}
+	spec := env.GetBuildManifest()
+	if manifestPath != "" {
+		spec.Path = manifestPath
+	}
+	if manifestFormat != "" {
+		spec.Format = types.ManifestFormat(manifestFormat)
+	}
+	if spec.Format != types.ManifestFormatGopro && spec.Format != types.ManifestFormatSLSA {
+		return nil, fmt.Errorf("unknown build manifest format %q, want gopro or slsa", spec.Format)
+	}
+	m := &buildManifest{
+		Project: project.Module,
+		Env:     envName,
+		Info:    manifestInfo(),
+		path:    spec.Path,
+		format:  spec.Format,
+	}
+	previous, err := readManifest(spec.Path)
+	if err != nil {
+		warnf("starting a new build manifest, %s is unreadable: %s", spec.Path, err)
+		return m, nil
+	}
+	if previous != nil && previous.Info["GitCommit"] == m.Info["GitCommit"] && previous.Env == m.Env {
+		m.Binaries, m.Images, m.Packages = previous.Binaries, previous.Images, previous.Packages
+	}
+	return m, nil
+}
+
+// manifestInfo returns the injected info describing the whole run. The
+// application name and version differ per binary and are recorded with each.
+func manifestInfo() map[string]string {
+	infos := make(map[string]string)
+	for key, val := range info.INJECTION {
+		if key == "ApplicationName" || key == "ApplicationVersion" {
+			continue
+		}
+		infos[key] = *val
+	}
+	return infos
+}
+
+// readManifest reads a manifest written in either format, nil when there is
+// none yet.
+func readManifest(path string) (*buildManifest, error) {
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var probe struct {
+		Type string `json:"_type"`
+	}
+	if err := json.Unmarshal(b, &probe); err != nil {
+		return nil, err
+	}
+	if probe.Type == inTotoStatementType {
+		var statement inTotoStatement
+		if err := json.Unmarshal(b, &statement); err != nil {
+			return nil, err
+		}
+		return statement.Predicate.BuildDefinition.ExternalParameters, nil
+	}
+	var m buildManifest
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+	return &m, nil
+}
+
+// recordBinary notes a binary built, or found up to date, by b.
+func (m *buildManifest) recordBinary(b *binaryBuild) error {
+	if m == nil {
+		return nil
+	}
+	fi, err := os.Stat(b.output)
+	if err != nil {
+		return err
+	}
+	digest, err := fileDigest(b.output)
+	if err != nil {
+		return err
+	}
+	platform := b.platform
+	if platform == "" {
+		platform = hostPlatform(b.envs)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Binaries = slices.DeleteFunc(m.Binaries, func(a binaryArtifact) bool { return a.Path == b.output })
+	m.Binaries = append(m.Binaries, binaryArtifact{
+		Name:      b.name,
+		Version:   b.version,
+		Platform:  platform,
+		Path:      b.output,
+		SHA256:    strings.TrimPrefix(digest, "sha256:"),
+		Size:      fi.Size(),
+		Env:       b.envs,
+		Args:      b.args,
+		SBOMs:     b.sboms,
+		Signature: b.signature,
+	})
+	return nil
+}
+
+// hostPlatform returns the platform a build pinning none is for: the GOOS
+// and GOARCH of its env, else of gopro's own environment, else the host's.
+func hostPlatform(envs []string) string {
+	goos, goarch := runtime.GOOS, runtime.GOARCH
+	for _, e := range envutil.Merge(os.Environ(), envs) {
+		if val, ok := strings.CutPrefix(e, "GOOS="); ok && val != "" {
+			goos = val
+		}
+		if val, ok := strings.CutPrefix(e, "GOARCH="); ok && val != "" {
+			goarch = val
+		}
+	}
+	return goos + "/" + goarch
+}
+
+func (m *buildManifest) addImage(a imageArtifact) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Images = slices.DeleteFunc(m.Images, func(other imageArtifact) bool { return other.Name == a.Name })
+	m.Images = append(m.Images, a)
+}
+
+// recordPackage notes a package written.
+func (m *buildManifest) recordPackage(a packageArtifact) error {
+	if m == nil {
+		return nil
+	}
+	fi, err := os.Stat(a.Path)
+	if err != nil {
+		return err
+	}
+	digest, err := fileDigest(a.Path)
+	if err != nil {
+		return err
+	}
+	a.SHA256, a.Size = strings.TrimPrefix(digest, "sha256:"), fi.Size()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Packages = slices.DeleteFunc(m.Packages, func(other packageArtifact) bool { return other.Path == a.Path })
+	m.Packages = append(m.Packages, a)
+	return nil
+}
+
+// save writes the manifest, its artifacts in a stable order.
+func (m *buildManifest) save() error {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	slices.SortFunc(m.Binaries, func(a, b binaryArtifact) int { return strings.Compare(a.Path, b.Path) })
+	slices.SortFunc(m.Images, func(a, b imageArtifact) int { return strings.Compare(a.Name, b.Name) })
+	slices.SortFunc(m.Packages, func(a, b packageArtifact) int { return strings.Compare(a.Path, b.Path) })
+	var v any = m
+	if m.format == types.ManifestFormatSLSA {
+		v = m.statement()
+	}
+	b, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(m.path), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(m.path, append(b, '\n'), 0644); err != nil {
+		return err
+	}
+	linef("wrote build manifest %s", m.path)
+	return nil
+}
+
+const (
+	inTotoStatementType = "https://in-toto.io/Statement/v1"
+	slsaProvenanceType  = "https://slsa.dev/provenance/v1"
+	goproBuildType      = "https://github.com/xhanio/gopro/build/v1"
+	goproBuilderID      = "https://github.com/xhanio/gopro"
+)
+
+// inTotoStatement is an in-toto attestation statement carrying SLSA
+// provenance, the form attestation tooling signs and verifies.
+type inTotoStatement struct {
+	Type          string          `json:"_type"`
+	Subject       []inTotoSubject `json:"subject"`
+	PredicateType string          `json:"predicateType"`
+	Predicate     slsaProvenance  `json:"predicate"`
+}
+
+type inTotoSubject struct {
+	Name   string            `json:"name"`
+	Digest map[string]string `json:"digest"`
+}
+
+type slsaProvenance struct {
+	BuildDefinition slsaBuildDefinition `json:"buildDefinition"`
+	RunDetails      slsaRunDetails      `json:"runDetails"`
+}
+
+// slsaBuildDefinition describes the build by the manifest itself: it is what
+// the build was asked for, and holds every artifact with how it was built.
+type slsaBuildDefinition struct {
+	BuildType            string                   `json:"buildType"`
+	ExternalParameters   *buildManifest           `json:"externalParameters"`
+	ResolvedDependencies []slsaResourceDescriptor `json:"resolvedDependencies,omitempty"`
+}
+
+type slsaResourceDescriptor struct {
+	URI    string            `json:"uri"`
+	Digest map[string]string `json:"digest"`
+}
+
+type slsaRunDetails struct {
+	Builder  slsaBuilder  `json:"builder"`
+	Metadata slsaMetadata `json:"metadata"`
+}
+
+type slsaBuilder struct {
+	ID string `json:"id"`
+}
+
+type slsaMetadata struct {
+	StartedOn  string `json:"startedOn,omitempty"`
+	FinishedOn string `json:"finishedOn"`
+}
+
+// statement returns the manifest as an in-toto statement. Its subjects are
+// the binaries and the images with a known digest, and the source it was
+// built from is the commit.
+func (m *buildManifest) statement() inTotoStatement {
+	s := inTotoStatement{
+		Type:          inTotoStatementType,
+		Subject:       []inTotoSubject{},
+		PredicateType: slsaProvenanceType,
+		Predicate: slsaProvenance{
+			BuildDefinition: slsaBuildDefinition{
+				BuildType:          goproBuildType,
+				ExternalParameters: m,
+			},
+			RunDetails: slsaRunDetails{
+				Builder: slsaBuilder{ID: goproBuilderID},
+				Metadata: slsaMetadata{
+					StartedOn:  m.Info["BuildTime"],
+					FinishedOn: time.Now().UTC().Format(time.RFC3339),
+				},
+			},
+		},
+	}
+	for _, a := range m.Binaries {
+		s.Subject = append(s.Subject, inTotoSubject{Name: a.Path, Digest: map[string]string{"sha256": a.SHA256}})
+	}
+	for _, a := range m.Packages {
+		s.Subject = append(s.Subject, inTotoSubject{Name: a.Path, Digest: map[string]string{"sha256": a.SHA256}})
+	}
+	for _, a := range m.Images {
+		algorithm, hex, ok := strings.Cut(a.Digest, ":")
+		if !ok {
+			continue
+		}
+		name := a.Refs[0]
+		if a.Path != "" {
+			name = a.Path
+		}
+		s.Subject = append(s.Subject, inTotoSubject{Name: name, Digest: map[string]string{algorithm: hex}})
+	}
+	if commit := m.Info["GitCommit"]; commit != "" {
+		s.Predicate.BuildDefinition.ResolvedDependencies = []slsaResourceDescriptor{{
+			URI:    sourceURI(),
+			Digest: map[string]string{"gitCommit": commit},
+		}}
+	}
+	return s
+}
+
+// sourceURI names the repository built, as SLSA does: git+ the remote, at the
+// branch built when it is known.
+func sourceURI() string {
+	uri := info.ProjectRoot
+	if remote, err := execute("git", []string{"config", "--get", "remote.origin.url"}, nil, false); err == nil && strings.TrimSpace(remote) != "" {
+		uri = strings.TrimSpace(remote)
+	}
+	uri = "git+" + uri
+	if info.GitBranch != "" && info.GitBranch != "HEAD" {
+		uri += "@refs/heads/" + info.GitBranch
+	}
+	return uri
+}
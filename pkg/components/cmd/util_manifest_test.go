@@ -0,0 +1,183 @@
+package cmd
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/xhanio/framingo/pkg/types/info"
+
+	"github.com/xhanio/gopro/pkg/types"
+)
+
+// withManifestFlags clears the manifest flags and restores them afterwards.
+func withManifestFlags(t *testing.T) {
+	t.Helper()
+	oldPath, oldFormat, oldCommit := manifestPath, manifestFormat, info.GitCommit
+	t.Cleanup(func() { manifestPath, manifestFormat, info.GitCommit = oldPath, oldFormat, oldCommit })
+	manifestPath, manifestFormat = "", ""
+}
+
+func TestBuildBinaryWritesManifest(t *testing.T) {
+	withGoModule(t)
+	withManifestFlags(t)
+	e := types.EnvSpec{BinaryTgt: "bin", Binaries: []string{"api"}}
+	withProject(t, types.Project{Build: types.BuildSpec{Binaries: []types.BinarySpec{{Name: "api", Src: "cmd/api", Version: "1.2.0"}}}}, e)
+	info.GitCommit = "abc123"
+
+	read := func() *buildManifest {
+		t.Helper()
+		m, err := readManifest(filepath.Join("dist", "build-manifest.json"))
+		if err != nil || m == nil {
+			t.Fatalf("manifest not written: %v", err)
+		}
+		return m
+	}
+	if err := runBuildBinary(nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	m := read()
+	if len(m.Binaries) != 1 {
+		t.Fatalf("recorded %d binaries, want 1", len(m.Binaries))
+	}
+	output := filepath.Join("bin", "api")
+	fi, err := os.Stat(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest, err := fileDigest(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := m.Binaries[0]
+	want := binaryArtifact{
+		Name:     "api",
+		Version:  "1.2.0",
+		Platform: runtime.GOOS + "/" + runtime.GOARCH,
+		Path:     output,
+		SHA256:   strings.TrimPrefix(digest, "sha256:"),
+		Size:     fi.Size(),
+	}
+	if got.Name != want.Name || got.Version != want.Version || got.Platform != want.Platform ||
+		got.Path != want.Path || got.SHA256 != want.SHA256 || got.Size != want.Size {
+		t.Errorf("recorded %+v, want %+v", got, want)
+	}
+	if !strings.Contains(strings.Join(got.Args, " "), "info.GitCommit=abc123") {
+		t.Errorf("args %q do not carry the injected info", got.Args)
+	}
+	if m.Info["GitCommit"] != "abc123" {
+		t.Errorf("info = %v", m.Info)
+	}
+
+	// an up-to-date binary is recorded all the same
+	if err := runBuildBinary(nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if m := read(); len(builds.skipped) != 1 || len(m.Binaries) != 1 {
+		t.Errorf("skipped %q and recorded %d binaries, want api skipped and recorded", builds.skipped, len(m.Binaries))
+	}
+}
+
+// What an earlier run recorded is kept for the same commit, as when images
+// are built after the binaries, and dropped for another.
+func TestOpenManifestKeepsArtifactsOfTheSameCommit(t *testing.T) {
+	t.Chdir(t.TempDir())
+	withManifestFlags(t)
+	withProject(t, types.Project{}, types.EnvSpec{})
+	info.GitCommit = "abc123"
+
+	for _, format := range []string{"gopro", "slsa"} {
+		t.Run(format, func(t *testing.T) {
+			manifestFormat = format
+			m, err := openManifest()
+			if err != nil {
+				t.Fatal(err)
+			}
+			m.addImage(imageArtifact{Name: "api", Refs: []string{"reg.io/api:v1"}})
+			if err := m.save(); err != nil {
+				t.Fatal(err)
+			}
+
+			m, err = openManifest()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(m.Images) != 1 {
+				t.Errorf("kept %d images of the same commit, want 1", len(m.Images))
+			}
+			info.GitCommit = "def456"
+			t.Cleanup(func() { info.GitCommit = "abc123" })
+			m, err = openManifest()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(m.Images) != 0 {
+				t.Errorf("kept %d images of another commit", len(m.Images))
+			}
+		})
+	}
+
+	manifestFormat = "cyclonedx"
+	if _, err := openManifest(); err == nil {
+		t.Error("expected an unknown format to fail")
+	}
+}
+
+func TestManifestStatement(t *testing.T) {
+	m := &buildManifest{
+		Info: map[string]string{"GitCommit": "abc123", "BuildTime": "2025-01-02T03:04:05Z"},
+		Binaries: []binaryArtifact{
+			{Name: "api", Path: "bin/api", SHA256: "aaaa"},
+		},
+		Images: []imageArtifact{
+			{Name: "api", Refs: []string{"reg.io/api:v1"}, Pushed: true, Digest: "sha256:bbbb"},
+			{Name: "web", Refs: []string{"reg.io/web:v1"}},
+			{Name: "oci", Refs: []string{"reg.io/oci:v1"}, Digest: "sha256:cccc", Path: "dist/oci"},
+		},
+	}
+	s := m.statement()
+	want := []inTotoSubject{
+		{Name: "bin/api", Digest: map[string]string{"sha256": "aaaa"}},
+		{Name: "reg.io/api:v1", Digest: map[string]string{"sha256": "bbbb"}},
+		{Name: "dist/oci", Digest: map[string]string{"sha256": "cccc"}},
+	}
+	got, _ := json.Marshal(s.Subject)
+	wanted, _ := json.Marshal(want)
+	if string(got) != string(wanted) {
+		t.Errorf("subjects = %s, want %s", got, wanted)
+	}
+	if s.Type != inTotoStatementType || s.PredicateType != slsaProvenanceType {
+		t.Errorf("statement types = %s, %s", s.Type, s.PredicateType)
+	}
+	deps := s.Predicate.BuildDefinition.ResolvedDependencies
+	if len(deps) != 1 || deps[0].Digest["gitCommit"] != "abc123" || !strings.HasPrefix(deps[0].URI, "git+") {
+		t.Errorf("resolved dependencies = %+v", deps)
+	}
+	if s.Predicate.RunDetails.Metadata.StartedOn != "2025-01-02T03:04:05Z" {
+		t.Errorf("started on %s", s.Predicate.RunDetails.Metadata.StartedOn)
+	}
+}
+
+func TestDescriptorDigest(t *testing.T) {
+	tests := []struct {
+		name    string
+		out     string
+		want    string
+		wantErr bool
+	}{
+		{name: "descriptor", out: `{"mediaType":"application/vnd.oci.image.index.v1+json","digest":"sha256:abc","size":1}` + "\n", want: "sha256:abc"},
+		{name: "no digest", out: `{"mediaType":"x"}`, wantErr: true},
+		{name: "not json", out: "error: not found", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := descriptorDigest(tt.out)
+			if (err != nil) != tt.wantErr || got != tt.want {
+				t.Errorf("descriptorDigest = %q, %v; want %q", got, err, tt.want)
+			}
+		})
+	}
+}
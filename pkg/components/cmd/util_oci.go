@@ -0,0 +1,176 @@
+package cmd
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/xhanio/errors"
+
+	"github.com/xhanio/gopro/pkg/types"
+	"github.com/xhanio/gopro/pkg/utils/ociutil"
+)
+
+// buildOCIImage assembles an oci build_mode image without a container engine:
+// its base layout, plus one layer holding the cross-compiled binary and the
+// rendered config, for each of its platforms. Nothing is pushed; the result
+// is an OCI layout or tarball for skopeo, crane or an engine to take from
+// there.
+func buildOCIImage(image types.ImageSpec, ref string) error {
+	output := image.GetOCIOutput()
+	basePath, err := ociBase(image)
+	if err != nil {
+		return err
+	}
+	platforms := image.Platforms
+	if len(platforms) == 0 {
+		// images run on linux whatever the host builds for
+		platforms = []string{"linux/" + runtime.GOARCH}
+	}
+	from := basePath
+	if from == "" {
+		from = "scratch"
+	}
+	titlef("Assemble Image %s on %s as %s for %s", image.Name, from, ref, strings.Join(platforms, ", "))
+	if pushImage && !image.NoPush {
+		warnf("%s is assembled into %s and not pushed; push it with skopeo or crane", ref, output)
+	}
+	if dryRun {
+		currentPlan.add(planStep{Action: "assemble", Path: output, Source: from})
+		_, err := writeImageSBOMs(image, imageArtifact{Name: image.Name, Refs: []string{ref}}, output)
+		return err
+	}
+
+	var base *ociutil.Base
+	if basePath != "" {
+		base, err = ociutil.OpenBase(basePath)
+		if err != nil {
+			return err
+		}
+		defer base.Close()
+	}
+	configFiles, err := ociConfigFiles(image)
+	if err != nil {
+		return err
+	}
+	created := buildTimestamp()
+	entrypoint := image.OCI.Entrypoint
+	if entrypoint == nil {
+		entrypoint = []string{image.GetOCIBinaryPath()}
+	}
+	labels := make(map[string]string, len(image.OCI.Labels))
+	for key, val := range image.OCI.Labels {
+		labels[key] = expandBuildVars(val)
+	}
+	var images []ociutil.Image
+	for _, name := range platforms {
+		platform, err := ociutil.ParsePlatform(name)
+		if err != nil {
+			return err
+		}
+		binary, err := os.ReadFile(ociBinarySource(image, platform))
+		if err != nil {
+			return errors.Newf("binary of image %s for %s is missing, build it first: %s", image.Name, name, err)
+		}
+		files := append([]ociutil.File{{Path: image.GetOCIBinaryPath(), Mode: 0755, Data: binary}}, configFiles...)
+		images = append(images, ociutil.Image{
+			Platform:   platform,
+			Files:      files,
+			Entrypoint: entrypoint,
+			Labels:     labels,
+			Created:    created,
+			CreatedBy:  "gopro build image " + image.Name,
+		})
+	}
+	top, err := ociutil.Write(output, ref, base, images)
+	if err != nil {
+		return err
+	}
+	linef("wrote %s (%s)", output, top.Digest)
+	a := imageArtifact{Name: image.Name, Refs: []string{ref}, Platforms: platforms, Digest: top.Digest, Path: output}
+	// the SBOMs go beside the layout or tarball they describe
+	a.SBOMs, err = writeImageSBOMs(image, a, output)
+	if err != nil {
+		return err
+	}
+	artifacts.addImage(a)
+	return nil
+}
+
+// ociBase returns the layout an oci image builds on, empty for scratch. A
+// $name base is the output of that image, which must be assembled as well:
+// there is no engine to take a Dockerfile-built base from.
+func ociBase(image types.ImageSpec) (string, error) {
+	name, ok := image.BaseImage()
+	if !ok {
+		return image.Base, nil
+	}
+	for _, other := range project.Build.Images {
+		if other.Name != name {
+			continue
+		}
+		if other.BuildMode != types.ImageBuildModeOCI {
+			return "", errors.Newf("image %s is based on %s, which is not an oci image", image.Name, name)
+		}
+		return other.GetOCIOutput(), nil
+	}
+	return "", errors.Newf("image %s is based on %s, which is not defined in build.images", image.Name, name)
+}
+
+// ociBinarySource returns the binary the image carries for a platform: the
+// same-named binary cross-compiled for it, or the host build when the
+// platform is the host's. A variant such as linux/arm/v7 takes the
+// linux/arm binary.
+func ociBinarySource(image types.ImageSpec, platform ociutil.Platform) string {
+	name := fmt.Sprintf("%s_%s_%s", image.Name, platform.OS, platform.Architecture)
+	cross := filepath.Join(env.BinaryTgt, name)
+	if _, err := os.Stat(cross); err != nil && platform.OS == runtime.GOOS && platform.Architecture == runtime.GOARCH {
+		return filepath.Join(env.BinaryTgt, image.Name)
+	}
+	return cross
+}
+
+// ociConfigFiles returns the rendered config of the image's component, from
+// config_tgt/<name>, placed at the config_dir of the same-named binary.
+func ociConfigFiles(image types.ImageSpec) ([]ociutil.File, error) {
+	var files []ociutil.File
+	err := walkRenderedConfig(image.Name, "the image", func(path string, data []byte) {
+		files = append(files, ociutil.File{Path: path, Mode: 0644, Data: data})
+	})
+	return files, err
+}
+
+// walkRenderedConfig calls fn with each file of the rendered config of a
+// component, from config_tgt/<name>, and the path it installs to under the
+// config_dir of the same-named binary. A component without a config_dir
+// leaves its config out of what it goes into.
+func walkRenderedConfig(name, into string, fn func(path string, data []byte)) error {
+	src := filepath.Join(env.ConfigTgt, name)
+	if fi, err := os.Stat(src); err != nil || !fi.IsDir() {
+		return nil
+	}
+	dir := GetConfigDir(name)
+	if dir == "" {
+		warnf("binary %s has no config_dir; leaving the config in %s out of %s", name, src, into)
+		return nil
+	}
+	return filepath.WalkDir(src, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(src, p)
+		if err != nil {
+			return err
+		}
+		b, err := os.ReadFile(p)
+		if err != nil {
+			return err
+		}
+		fn(path.Join(dir, filepath.ToSlash(rel)), b)
+		return nil
+	})
+}
@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/xhanio/gopro/pkg/types"
+	"github.com/xhanio/gopro/pkg/utils/ociutil"
+)
+
+// An oci image builds on another oci image of the project, carrying the
+// cross-compiled binary and rendered config of its component for every
+// platform, with no engine involved.
+func TestBuildOCIImageOnOCIBase(t *testing.T) {
+	t.Chdir(t.TempDir())
+	writeTree(t, "bin", "api_linux_amd64", "amd64 binary")
+	writeTree(t, "bin", "api_linux_arm64", "arm64 binary")
+	writeTree(t, "dist/config/api", "app.yaml", "port: 80\n")
+	platforms := []string{"linux/amd64", "linux/arm64"}
+	images := []types.ImageSpec{
+		{Name: "base", BuildMode: types.ImageBuildModeOCI, Platforms: platforms, OCI: types.OCISpec{BinaryPath: "/bin/base"}},
+		{
+			Name:      "api",
+			Base:      "$base",
+			BuildMode: types.ImageBuildModeOCI,
+			Platforms: platforms,
+			OCI: types.OCISpec{
+				Output: "dist/oci/api",
+				Labels: map[string]string{"org.opencontainers.image.version": "${PRODUCT_VERSION}"},
+			},
+		},
+	}
+	e := types.EnvSpec{BinaryTgt: "bin", ConfigTgt: "dist/config", ImagePrefix: "reg.io", ImageTag: "v1"}
+	usePlan(t, types.ImageEngineDocker, e, images...)
+	project.Build.Binaries = []types.BinarySpec{{Name: "api", ConfigDir: "/etc/api"}}
+	writeTree(t, "bin", "base_linux_amd64", "base")
+	writeTree(t, "bin", "base_linux_arm64", "base")
+	resetInfo(t)
+
+	ordered, err := orderImages(images, []string{"base", "api"}, []string{"api"}, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, image := range ordered {
+		if err := buildImage(image); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	layout, err := ociutil.OpenBase(filepath.Join("dist", "oci", "api"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer layout.Close()
+	for _, name := range platforms {
+		platform, _ := ociutil.ParsePlatform(name)
+		manifest, config, err := layout.Image(platform)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(manifest.Layers) != 2 {
+			t.Errorf("%s: %d layers, want the base's and the api's", name, len(manifest.Layers))
+		}
+		if !slices.Equal(config.Config.Entrypoint, []string{"/usr/local/bin/api"}) {
+			t.Errorf("%s: entrypoint = %q", name, config.Config.Entrypoint)
+		}
+		if _, ok := config.Config.Labels["org.opencontainers.image.version"]; !ok {
+			t.Errorf("%s: labels = %v", name, config.Config.Labels)
+		}
+	}
+}
+
+func TestBuildOCIImageNeedsOCIBase(t *testing.T) {
+	images := []types.ImageSpec{
+		{Name: "base", BuildSrc: "docker/base"},
+		{Name: "api", Base: "$base", BuildMode: types.ImageBuildModeOCI},
+	}
+	usePlan(t, types.ImageEngineDocker, types.EnvSpec{}, images...)
+	if err := buildImage(images[1]); err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestBuildOCIImageNeedsTheBinary(t *testing.T) {
+	t.Chdir(t.TempDir())
+	image := types.ImageSpec{Name: "api", BuildMode: types.ImageBuildModeOCI, Platforms: []string{"linux/s390x"}}
+	usePlan(t, types.ImageEngineDocker, types.EnvSpec{BinaryTgt: "bin"}, image)
+	if err := buildImage(image); err == nil {
+		t.Fatal("expected an error")
+	}
+}
@@ -0,0 +1,159 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	dryRun     bool
+	planFormat string
+
+	// currentPlan collects what a --dry-run would have done.
+	currentPlan = &plan{}
+	// written, when set, collects the path of every file writeFile writes.
+	written map[string]bool
+)
+
+// addPlanFlags adds --dry-run to a command tree, for the commands that
+// change something: every command doing so goes through runPlanned,
+// writeFile and removeAll, which only record the change under --dry-run.
+func addPlanFlags(cmd *cobra.Command) {
+	cmd.PersistentFlags().BoolVarP(&dryRun, "dry-run", "", false, "print what would be run, written and removed without doing it")
+	cmd.PersistentFlags().StringVarP(&planFormat, "plan-format", "", "text", "dry-run output: text, or json for tooling")
+	cmd.PersistentPostRunE = func(cmd *cobra.Command, args []string) error {
+		if dryRun && planFormat == "json" {
+			return currentPlan.encode(os.Stdout)
+		}
+		return nil
+	}
+}
+
+// startPlan checks the plan flags before a command runs. A JSON plan owns
+// stdout, so the progress output moves to stderr.
+func startPlan() error {
+	if !dryRun {
+		return nil
+	}
+	switch planFormat {
+	case "text":
+	case "json":
+		printOut = os.Stderr
+	default:
+		return fmt.Errorf("unknown plan format %q, want text or json", planFormat)
+	}
+	currentPlan.Env = envName
+	return nil
+}
+
+// planStep is one thing a command would do: run a command, or write or
+// remove a path.
+type planStep struct {
+	Action  string   `json:"action"`
+	Command string   `json:"command,omitempty"`
+	Args    []string `json:"args,omitempty"`
+	Env     []string `json:"env,omitempty"`
+	Path    string   `json:"path,omitempty"`
+	Source  string   `json:"source,omitempty"`
+}
+
+// plan records steps instead of taking them. Binary builds may be planned
+// from several jobs at once, hence the lock.
+type plan struct {
+	mu    sync.Mutex
+	Env   string     `json:"env"`
+	Steps []planStep `json:"steps"`
+}
+
+func (p *plan) add(s planStep) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.Steps = append(p.Steps, s)
+	if planFormat == "json" {
+		return
+	}
+	switch s.Action {
+	case "run":
+		if len(s.Env) > 0 {
+			linef("would run with env: %s", strings.Join(s.Env, " "))
+		}
+		linef("would run: %s", strings.Join(append([]string{s.Command}, s.Args...), " "))
+	case "remove":
+		linef("would remove %s", s.Path)
+	default:
+		linef("would %s %s from %s", s.Action, s.Path, s.Source)
+	}
+}
+
+// run is a runFunc recording the command instead of running it.
+func (p *plan) run(cmd string, args []string, env []string) error {
+	p.add(planStep{Action: "run", Command: cmd, Args: args, Env: env})
+	return nil
+}
+
+// commands returns the planned commands as command lines.
+func (p *plan) commands() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	var result []string
+	for _, s := range p.Steps {
+		if s.Action == "run" {
+			result = append(result, strings.Join(append([]string{s.Command}, s.Args...), " "))
+		}
+	}
+	return result
+}
+
+func (p *plan) encode(w io.Writer) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(p)
+}
+
+// runPlanned runs a command that changes something, printing its output, or
+// plans it under --dry-run.
+func runPlanned(cmd string, args []string, env []string) error {
+	if dryRun {
+		return currentPlan.run(cmd, args, env)
+	}
+	_, err := execute(cmd, args, env, true)
+	return err
+}
+
+// writeFile writes a generated file, creating its parents, or plans it under
+// --dry-run. action says how it came about, render or copy, and src from
+// where.
+func writeFile(path string, b []byte, action, src string) error {
+	if dryRun {
+		currentPlan.add(planStep{Action: action, Path: path, Source: src})
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if written != nil {
+		written[filepath.Clean(path)] = true
+	}
+	return os.WriteFile(path, b, 0644)
+}
+
+// removeAll removes a path, or plans it under --dry-run when there is
+// something to remove.
+func removeAll(path string) error {
+	if dryRun {
+		if _, err := os.Stat(path); err == nil {
+			currentPlan.add(planStep{Action: "remove", Path: path})
+		}
+		return nil
+	}
+	return os.RemoveAll(path)
+}
@@ -0,0 +1,116 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/xhanio/gopro/pkg/types"
+)
+
+// withDryRun plans into a fresh plan for one test.
+func withDryRun(t *testing.T, format string) *plan {
+	t.Helper()
+	oldDryRun, oldFormat, oldPlan, oldOut := dryRun, planFormat, currentPlan, printOut
+	t.Cleanup(func() {
+		dryRun, planFormat, currentPlan, printOut = oldDryRun, oldFormat, oldPlan, oldOut
+	})
+	dryRun, planFormat, currentPlan = true, format, &plan{}
+	if err := startPlan(); err != nil {
+		t.Fatal(err)
+	}
+	return currentPlan
+}
+
+// A dry run of generate must leave the target alone -- it would otherwise be
+// cleared before anything is rendered -- while still planning the removal and
+// every file the render would write.
+func TestDryRunGenerateConfigTouchesNothing(t *testing.T) {
+	seedConfigSource(t)
+	writeTree(t, filepath.Join("env", "default", "config", "api"), "plain.txt", "plain\n")
+	p, e := configProject("env/default/config", "dist/config")
+	e.ConfigSrc = filepath.Join("env", "local", "config")
+	withProject(t, p, e)
+	stale := filepath.Join("dist", "config", "api", "stale.yaml")
+	writeTree(t, ".", stale, "old\n")
+	pl := withDryRun(t, "text")
+
+	if err := runGenerateConfig(nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(stale); err != nil {
+		t.Errorf("dry run removed the target: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join("dist", "config", "api", "conf.yaml")); !os.IsNotExist(err) {
+		t.Errorf("dry run wrote a file (err=%v)", err)
+	}
+	want := []planStep{
+		{Action: "remove", Path: filepath.Join("dist", "config", "api")},
+		{Action: "copy", Path: filepath.Join("dist", "config", "api", "plain.txt"), Source: filepath.Join("env", "default", "config", "api", "plain.txt")},
+		{Action: "render", Path: filepath.Join("dist", "config", "api", "conf.yaml"), Source: filepath.Join("env", "default", "config", "api", "template.conf.yaml")},
+	}
+	if len(pl.Steps) != len(want) {
+		t.Fatalf("planned %+v, want %+v", pl.Steps, want)
+	}
+	for i := range want {
+		got := pl.Steps[i]
+		if got.Action != want[i].Action || got.Path != want[i].Path || got.Source != want[i].Source {
+			t.Errorf("step %d = %+v, want %+v", i, got, want[i])
+		}
+	}
+}
+
+// A planned binary build carries everything that would reach go build: the
+// merged env and the injected ldflags.
+func TestDryRunBuildBinaryPlansTheResolvedCommand(t *testing.T) {
+	withProject(t, types.Project{}, types.EnvSpec{BinaryBuildEnv: []string{"CGO_ENABLED=0"}})
+	pl := withDryRun(t, "json")
+
+	binary := types.BinarySpec{Name: "api", BuildEnv: []string{"CGO_ENABLED=1"}}
+	platform := types.PlatformSpec{Name: "linux/arm64"}
+	if err := executeBuildBinary(binary, platform, "cmd/api", "bin"); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(pl.Steps) != 1 {
+		t.Fatalf("planned %+v, want one step", pl.Steps)
+	}
+	step := pl.Steps[0]
+	if step.Action != "run" || step.Command != "go" {
+		t.Fatalf("step = %+v, want a go run", step)
+	}
+	env := slices.Clone(step.Env)
+	slices.Sort(env)
+	if !equalEntries(env, []string{"CGO_ENABLED=1", "GOARCH=arm64", "GOOS=linux"}) {
+		t.Errorf("env = %q", step.Env)
+	}
+	if !strings.Contains(strings.Join(step.Args, " "), "-ldflags") {
+		t.Errorf("args %q carry no ldflags", step.Args)
+	}
+
+	var buffer bytes.Buffer
+	if err := pl.encode(&buffer); err != nil {
+		t.Fatal(err)
+	}
+	var decoded plan
+	if err := json.Unmarshal(buffer.Bytes(), &decoded); err != nil {
+		t.Fatalf("plan is not JSON: %v\n%s", err, buffer.String())
+	}
+	if len(decoded.Steps) != 1 || decoded.Steps[0].Command != "go" {
+		t.Errorf("decoded plan = %+v", decoded.Steps)
+	}
+}
+
+func TestStartPlanRejectsUnknownFormat(t *testing.T) {
+	oldDryRun, oldFormat := dryRun, planFormat
+	t.Cleanup(func() { dryRun, planFormat = oldDryRun, oldFormat })
+	dryRun, planFormat = true, "yaml"
+	if err := startPlan(); err == nil {
+		t.Fatal("expected an error")
+	}
+}
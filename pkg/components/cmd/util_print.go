@@ -2,10 +2,15 @@ package cmd
 
 import (
 	"fmt"
+	"io"
+	"os"
 
 	"github.com/fatih/color"
 )
 
+// printOut is where progress is printed; stderr when stdout carries a plan.
+var printOut io.Writer = os.Stdout
+
 func linef(format string, args ...any) {
 	printf(color.FgHiWhite, false, false, format, args...)
 }
@@ -35,5 +40,5 @@ func printf(c color.Attribute, env bool, bold bool, format string, args ...any)
 	if env && envName != "" {
 		info = ec.Sprintf("[ %s ] ", envName) + info
 	}
-	fmt.Println(info)
+	fmt.Fprintln(printOut, info)
 }
@@ -1,18 +1,21 @@
 package cmd
 
 import (
-	"bufio"
 	"bytes"
 	"fmt"
 	"io/fs"
 	"os"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
 	"text/template"
 
 	"github.com/Masterminds/sprig/v3"
 	"github.com/tidwall/gjson"
 
+	"github.com/xhanio/errors"
 	"github.com/xhanio/framingo/pkg/types/info"
 	"github.com/xhanio/framingo/pkg/utils/envutil"
 	"github.com/xhanio/gopro/pkg/types"
@@ -28,6 +31,7 @@ func funcMap() template.FuncMap {
 	fm["FromConfigFile"] = FromConfigFile
 	fm["FromConfigJSON"] = FromConfigJSON
 	fm["FromSecretEnv"] = FromSecretEnv
+	fm["FromSecret"] = FromSecret
 	return fm
 }
 
@@ -66,54 +70,45 @@ func GetImageNameWithTag(name, tag string) string {
 	return ""
 }
 
-func FromFile(name string) string {
+func FromFile(name string) (string, error) {
 	b, err := os.ReadFile(name)
 	if err != nil {
-		panic(fmt.Errorf("failed to render from file %s: %s", name, err.Error()))
+		return "", fmt.Errorf("failed to render from file %s: %w", name, err)
 	}
-	return string(b)
+	return string(b), nil
 }
 
-func FromConfigFile(name, filename string) string {
+func FromConfigFile(name, filename string) (string, error) {
 	b, err := os.ReadFile(filepath.Join(env.ConfigTgt, name, filename))
 	if err != nil {
-		panic(fmt.Errorf("failed to render from file %s: %s", name, err.Error()))
+		return "", fmt.Errorf("failed to render from %s config %s: %w", name, filename, err)
 	}
-	return string(b)
+	return string(b), nil
 }
 
-func FromConfigJSON(name, filename, jsonpath string) string {
+func FromConfigJSON(name, filename, jsonpath string) (string, error) {
 	b, err := os.ReadFile(filepath.Join(env.ConfigTgt, name, filename))
 	if err != nil {
-		panic(fmt.Errorf("failed to render from file %s: %s", name, err.Error()))
+		return "", fmt.Errorf("failed to render from %s config %s: %w", name, filename, err)
 	}
 	result := gjson.GetBytes(b, jsonpath)
-	return result.String()
+	return result.String(), nil
 }
 
-func FromSecretEnv(name, key string) string {
-	b, err := os.ReadFile(filepath.Join(env.ConfigSrc, name, "secret.env"))
+// FromSecretEnv renders the secret key of a component from the default
+// secret provider, secrets.default, which unless set is its secret.env.
+func FromSecretEnv(name, key string) (string, error) {
+	return FromSecret(project.Secrets.GetDefault(), name, key)
+}
+
+// readSecretEnv reads the KEY=value lines of a secret.env, skipping blank
+// lines and # comments.
+func readSecretEnv(path string) (map[string]string, error) {
+	b, err := os.ReadFile(path)
 	if err != nil {
-		panic(fmt.Errorf("failed to render from %s secret.env: %s", name, err.Error()))
-	}
-	scanner := bufio.NewScanner(bytes.NewReader(b))
-	kv := make(map[string]string)
-	for scanner.Scan() {
-		line := scanner.Text()
-		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
-			continue
-		}
-		parts := strings.SplitN(line, "=", 2)
-		if len(parts) == 2 {
-			key := strings.TrimSpace(parts[0])
-			value := strings.TrimSpace(parts[1])
-			kv[key] = value
-		}
-	}
-	if val, ok := kv[key]; ok {
-		return val
+		return nil, err
 	}
-	panic(fmt.Errorf("failed to render from %s secret.env: key %s not found", name, key))
+	return parseSecretEnv(b)
 }
 
 type renderContext struct {
@@ -123,8 +118,14 @@ type renderContext struct {
 	Env     types.EnvSpec
 }
 
-func render(name, srcDir, dstDir, prefix string, patterns []string) error {
-	return filepath.WalkDir(srcDir, func(path string, d fs.DirEntry, err error) error {
+// renderTo renders the templates and copies the other files of srcDir,
+// handing every output file to write with its path relative to the target. A
+// template that fails to parse or execute does not stop the others: every
+// template failure is returned together as templateErrors, while any other
+// error ends the render at once.
+func renderTo(name, srcDir, prefix string, patterns []string, write func(rel string, b []byte, action, src string) error) error {
+	var failed templateErrors
+	err := filepath.WalkDir(srcDir, func(path string, d fs.DirEntry, err error) error {
 		if err != nil {
 			return err
 		}
@@ -157,30 +158,123 @@ func render(name, srcDir, dstDir, prefix string, patterns []string) error {
 		if er != nil {
 			return er
 		}
+		action := "copy"
 		if templated {
-			linef("render %s from %s", outRel, path)
-			t, er := template.New(d.Name()).Delims("[[", "]]").Funcs(funcMap()).Parse(string(b))
+			action = "render"
+			b, er = executeTemplate(name, path, b)
 			if er != nil {
-				return er
+				failed = append(failed, newTemplateError(er))
+				return nil
 			}
-			var buffer bytes.Buffer
-			er = t.Execute(&buffer, &renderContext{
-				Name:    name,
-				Project: project,
-				EnvName: envName,
-				Env:     env,
-			})
-			if er != nil {
-				return er
-			}
-			b = buffer.Bytes()
-		} else {
-			linef("copy %s from %s", outRel, path)
-		}
-		dstFile := filepath.Join(dstDir, outRel)
-		if er := os.MkdirAll(filepath.Dir(dstFile), 0755); er != nil {
-			return er
 		}
-		return os.WriteFile(dstFile, b, 0644)
+		return write(outRel, b, action, path)
+	})
+	if err != nil {
+		return err
+	}
+	if len(failed) > 0 {
+		return failed
+	}
+	return nil
+}
+
+// executeTemplate renders one template file. The template is named by its
+// path, so errors point at the file. In strict mode a missing map key fails
+// the render instead of printing <no value>.
+func executeTemplate(name, path string, b []byte) ([]byte, error) {
+	t := template.New(path).Delims("[[", "]]").Funcs(funcMap())
+	if strictRender {
+		t = t.Option("missingkey=error")
+	}
+	t, err := t.Parse(string(b))
+	if err != nil {
+		return nil, err
+	}
+	var buffer bytes.Buffer
+	err = t.Execute(&buffer, &renderContext{
+		Name:    name,
+		Project: project,
+		EnvName: envName,
+		Env:     env,
 	})
+	if err != nil {
+		return nil, err
+	}
+	return buffer.Bytes(), nil
+}
+
+// templateErrorPattern picks the position out of a text/template error:
+// "template: NAME:LINE: MSG" from parsing, "template: NAME:LINE:COL:
+// executing "NAME" at <ACTION>: MSG" from executing.
+var templateErrorPattern = regexp.MustCompile(`^template: (.+?):(\d+):(?:(\d+):)? (?:executing ".*?" )?(.*)$`)
+
+// templateError is a template failure at a position in a template file.
+type templateError struct {
+	file string
+	line int
+	col  int
+	msg  string
+}
+
+func newTemplateError(err error) error {
+	m := templateErrorPattern.FindStringSubmatch(err.Error())
+	if m == nil {
+		return err
+	}
+	line, _ := strconv.Atoi(m[2])
+	col, _ := strconv.Atoi(m[3])
+	return &templateError{file: m[1], line: line, col: col, msg: m[4]}
+}
+
+func (e *templateError) Error() string {
+	if e.col == 0 {
+		return fmt.Sprintf("%s:%d: %s", e.file, e.line, e.msg)
+	}
+	return fmt.Sprintf("%s:%d:%d: %s", e.file, e.line, e.col, e.msg)
+}
+
+// templateErrors are the template failures of one render.
+type templateErrors []error
+
+func (e templateErrors) Error() string {
+	var msgs []string
+	for _, err := range e {
+		msgs = append(msgs, err.Error())
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// componentErrors gathers the template failures of one component across the
+// directories it renders from, so a single run reports all of them.
+type componentErrors struct {
+	name   string
+	failed templateErrors
+}
+
+// add keeps the template failures of err, returning any other error as it is.
+// An environment rendering from the default source fails the same way twice,
+// which is only reported once.
+func (c *componentErrors) add(err error) error {
+	failed, ok := err.(templateErrors)
+	if !ok {
+		return err
+	}
+	for _, f := range failed {
+		if !slices.ContainsFunc(c.failed, func(e error) bool { return e.Error() == f.Error() }) {
+			c.failed = append(c.failed, f)
+		}
+	}
+	return nil
+}
+
+// err prints the failures gathered and fails the component, if there were
+// any.
+func (c *componentErrors) err() error {
+	if len(c.failed) == 0 {
+		return nil
+	}
+	for _, err := range c.failed {
+		warnf("%s", err)
+	}
+	return errors.Newf("%d template errors in %s", len(c.failed), c.name)
 }
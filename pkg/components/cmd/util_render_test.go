@@ -3,7 +3,10 @@ package cmd
 import (
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
+
+	"github.com/xhanio/gopro/pkg/types"
 )
 
 // writeTree writes body to rel under dir, creating parents as needed.
@@ -18,6 +21,14 @@ func writeTree(t *testing.T, dir, rel, body string) {
 	}
 }
 
+// renderInto renders src as component api into dst, with the template.
+// prefix.
+func renderInto(src, dst string, patterns []string) error {
+	return renderTo("api", src, "template.", patterns, func(rel string, b []byte, action, from string) error {
+		return writeFile(filepath.Join(dst, rel), b, action, from)
+	})
+}
+
 // A template's directory is part of its output path. Stripping the prefix from
 // the file name must not also flatten the file into the output root, which
 // would silently overwrite same-named templates from sibling directories.
@@ -28,7 +39,7 @@ func TestRenderKeepsTemplatesInTheirSubdirectories(t *testing.T) {
 	writeTree(t, src, "sub/deep/template.conf.yaml", "from: deep\n")
 	writeTree(t, src, "other/template.conf.yaml", "from: other\n")
 
-	if err := render("api", src, dst, "template.", nil); err != nil {
+	if err := renderInto(src, dst, nil); err != nil {
 		t.Fatal(err)
 	}
 
@@ -56,7 +67,7 @@ func TestRenderKeepsPlainFilesInTheirSubdirectories(t *testing.T) {
 	src, dst := t.TempDir(), t.TempDir()
 	writeTree(t, src, "sub/plain.txt", "plain\n")
 
-	if err := render("api", src, dst, "template.", nil); err != nil {
+	if err := renderInto(src, dst, nil); err != nil {
 		t.Fatal(err)
 	}
 
@@ -78,7 +89,7 @@ func TestRenderPatternMatchesNestedFiles(t *testing.T) {
 	writeTree(t, src, "sub/template.nested.yaml", "b: nested\n")
 	writeTree(t, src, "sub/skipped.txt", "not yaml\n")
 
-	if err := render("api", src, dst, "template.", []string{"*.yaml"}); err != nil {
+	if err := renderInto(src, dst, []string{"*.yaml"}); err != nil {
 		t.Fatal(err)
 	}
 
@@ -99,7 +110,7 @@ func TestRenderDirectoryPatternStillScopesToThatDirectory(t *testing.T) {
 	writeTree(t, src, "cert/server.pem", "cert\n")
 	writeTree(t, src, "elsewhere/server.pem", "other\n")
 
-	if err := render("api", src, dst, "template.", []string{"cert/*"}); err != nil {
+	if err := renderInto(src, dst, []string{"cert/*"}); err != nil {
 		t.Fatal(err)
 	}
 
@@ -110,3 +121,113 @@ func TestRenderDirectoryPatternStillScopesToThatDirectory(t *testing.T) {
 		t.Errorf("elsewhere/server.pem should not match cert/* (err=%v)", err)
 	}
 }
+
+func withStrictRender(t *testing.T, strict bool) {
+	t.Helper()
+	old := strictRender
+	t.Cleanup(func() { strictRender = old })
+	strictRender = strict
+}
+
+// A missing key renders as <no value> only when strict mode is turned off;
+// by default it fails at the position of the action.
+func TestRenderStrictMissingKey(t *testing.T) {
+	body := "a: 1\nb: [[ $d := dict \"a\" 1 ]][[ $d.b ]]\n"
+	tests := []struct {
+		name    string
+		strict  bool
+		want    string
+		wantErr string
+	}{
+		{name: "strict", strict: true, wantErr: "template.conf.yaml:2:"},
+		{name: "lenient", strict: false, want: "a: 1\nb: <no value>\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			withStrictRender(t, tt.strict)
+			src, dst := t.TempDir(), t.TempDir()
+			writeTree(t, src, "template.conf.yaml", body)
+
+			err := renderInto(src, dst, nil)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) || !strings.Contains(err.Error(), `map has no entry for key "b"`) {
+					t.Fatalf("err = %v, want a missing key at %s", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, _ := os.ReadFile(filepath.Join(dst, "conf.yaml"))
+			if string(got) != tt.want {
+				t.Errorf("rendered %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// Every failing template of a render is reported, not just the first, and
+// the templates that do render are still written.
+func TestRenderCollectsTemplateErrors(t *testing.T) {
+	withStrictRender(t, true)
+	oldEnv := env
+	t.Cleanup(func() { env = oldEnv })
+	src, dst := t.TempDir(), t.TempDir()
+	env = types.EnvSpec{ConfigSrc: src}
+	writeTree(t, src, "template.a.yaml", "x: [[ FromSecretEnv \"api\" \"TOKEN\" ]]\n")
+	writeTree(t, src, "template.b.yaml", "y: [[ if ]]\n")
+	writeTree(t, src, "template.c.yaml", "z: [[ .Name ]]\n")
+
+	err := renderInto(src, dst, nil)
+	failed, ok := err.(templateErrors)
+	if !ok || len(failed) != 2 {
+		t.Fatalf("err = %v, want two template errors", err)
+	}
+	wantPrefixes := []string{
+		filepath.Join(src, "template.a.yaml") + ":1:",
+		filepath.Join(src, "template.b.yaml") + ":1: ",
+	}
+	for i, want := range wantPrefixes {
+		if !strings.HasPrefix(failed[i].Error(), want) {
+			t.Errorf("error %d = %q, want it to start with %q", i, failed[i], want)
+		}
+	}
+	if !strings.Contains(failed[0].Error(), "secret.env") || strings.Contains(failed[0].Error(), "executing") {
+		t.Errorf("helper error = %q, want the helper's own message", failed[0])
+	}
+	if got, _ := os.ReadFile(filepath.Join(dst, "c.yaml")); string(got) != "z: api\n" {
+		t.Errorf("c.yaml = %q, want it rendered", got)
+	}
+}
+
+// Both the default and the environment templates of a component are rendered
+// before it fails, so one run shows every problem.
+func TestGenerateConfigReportsAllTemplateErrorsOfAComponent(t *testing.T) {
+	withStrictRender(t, true)
+	t.Chdir(t.TempDir())
+	writeTree(t, "env/default/config/api", "template.a.yaml", "[[ .Nope ]]\n")
+	writeTree(t, "env/local/config/api", "template.b.yaml", "[[ .Nope ]]\n")
+	p, e := configProject("env/default/config", "dist/config")
+	e.ConfigSrc = "env/local/config"
+	withProject(t, p, e)
+
+	err := runGenerateConfig(nil, nil)
+	if err == nil || !strings.Contains(err.Error(), "2 template errors in api") {
+		t.Fatalf("err = %v, want both templates reported", err)
+	}
+}
+
+// An environment that renders from the default source fails the same way
+// twice; that is one problem, reported once.
+func TestGenerateConfigReportsARepeatedFailureOnce(t *testing.T) {
+	withStrictRender(t, true)
+	t.Chdir(t.TempDir())
+	writeTree(t, "env/default/config/api", "template.a.yaml", "[[ .Nope ]]\n")
+	p, e := configProject("env/default/config", "dist/config")
+	withProject(t, p, e)
+
+	err := runGenerateConfig(nil, nil)
+	if err == nil || !strings.Contains(err.Error(), "1 template errors in api") {
+		t.Fatalf("err = %v, want the failure once", err)
+	}
+}
@@ -0,0 +1,184 @@
+package cmd
+
+import (
+	"debug/buildinfo"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/xhanio/errors"
+
+	"github.com/xhanio/gopro/pkg/types"
+	"github.com/xhanio/gopro/pkg/utils/ociutil"
+	"github.com/xhanio/gopro/pkg/utils/sbomutil"
+)
+
+// sbomPaths returns where the SBOMs of an artifact named base go, one per
+// format.
+func sbomPaths(base string, formats []types.SBOMFormat) ([]string, error) {
+	var paths []string
+	for _, format := range formats {
+		p, err := sbomutil.FileName(base, string(format))
+		if err != nil {
+			return nil, err
+		}
+		paths = append(paths, p)
+	}
+	return paths, nil
+}
+
+// writeSBOMs writes the document at paths, one per format, or plans to under
+// --dry-run. What it wrote is told to debugf, under --verbose.
+func writeSBOMs(paths []string, formats []types.SBOMFormat, source string, doc func() (sbomutil.Document, error), debugf func(format string, args ...any)) error {
+	if dryRun {
+		for _, p := range paths {
+			currentPlan.add(planStep{Action: "sbom", Path: p, Source: source})
+		}
+		return nil
+	}
+	d, err := doc()
+	if err != nil {
+		return err
+	}
+	for i, p := range paths {
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			return err
+		}
+		if err := sbomutil.Write(p, string(formats[i]), d); err != nil {
+			return err
+		}
+		if verbose {
+			debugf("wrote %s", p)
+		}
+	}
+	return nil
+}
+
+// binaryComponents returns the main module of a built Go binary, as the
+// binary itself, and the modules it depends on, from the build info go
+// embeds in it.
+func binaryComponents(file string) (sbomutil.Component, []sbomutil.Component, error) {
+	bi, err := buildinfo.ReadFile(file)
+	if err != nil {
+		return sbomutil.Component{}, nil, err
+	}
+	main, deps := sbomutil.FromBuildInfo(bi)
+	digest, err := fileDigest(file)
+	if err != nil {
+		return sbomutil.Component{}, nil, err
+	}
+	main.SHA256 = strings.TrimPrefix(digest, "sha256:")
+	return main, deps, nil
+}
+
+// writeBinarySBOMs writes the SBOMs the binary asks for beside its output,
+// and returns their paths.
+func writeBinarySBOMs(b *binaryBuild) ([]string, error) {
+	if len(b.sbom) == 0 {
+		return nil, nil
+	}
+	paths, err := sbomPaths(b.output, b.sbom)
+	if err != nil {
+		return nil, err
+	}
+	err = writeSBOMs(paths, b.sbom, b.output, func() (sbomutil.Document, error) {
+		main, deps, err := binaryComponents(b.output)
+		if err != nil {
+			return sbomutil.Document{}, errors.Newf("build info of %s is unreadable: %s", b.output, err)
+		}
+		main.Name = b.name
+		if b.version != "" {
+			main.Version = b.version
+		}
+		return sbomutil.Document{Subject: main, Components: deps, Created: buildTimestamp()}, nil
+	}, b.debugf)
+	return paths, err
+}
+
+// writeImageSBOMs writes the SBOMs the image asks for as base plus the
+// format's extension, and returns their paths. An image is made of the
+// same-named binaries it carries, for each of its platforms, and of the image
+// it is based on.
+func writeImageSBOMs(image types.ImageSpec, a imageArtifact, base string) ([]string, error) {
+	if len(image.SBOM) == 0 {
+		return nil, nil
+	}
+	paths, err := sbomPaths(base, image.SBOM)
+	if err != nil {
+		return nil, err
+	}
+	err = writeSBOMs(paths, image.SBOM, a.Refs[0], func() (sbomutil.Document, error) {
+		subject := sbomutil.Component{Kind: sbomutil.KindContainer, Name: a.Refs[0]}
+		if hex, ok := strings.CutPrefix(a.Digest, "sha256:"); ok {
+			subject.SHA256 = hex
+			subject.PURL = ociPURL(a.Refs[0], a.Digest)
+		}
+		var components []sbomutil.Component
+		for _, file := range imageBinaries(image) {
+			main, deps, err := binaryComponents(file)
+			if os.IsNotExist(err) {
+				if verbose {
+					debugf("%s is not built, leaving it out of the sbom of %s", file, image.Name)
+				}
+				continue
+			}
+			if err != nil {
+				return sbomutil.Document{}, errors.Newf("build info of %s is unreadable: %s", file, err)
+			}
+			main.Name = filepath.Base(file)
+			subject.DependsOn = append(subject.DependsOn, main.Ref())
+			components = append(components, main)
+			components = append(components, deps...)
+		}
+		if from := imageFrom(image); from != "" && from != "scratch" {
+			subject.DependsOn = append(subject.DependsOn, from)
+			components = append(components, sbomutil.Component{Kind: sbomutil.KindContainer, Name: from})
+		}
+		return sbomutil.Document{Subject: subject, Components: components, Created: buildTimestamp()}, nil
+	}, debugf)
+	return paths, err
+}
+
+// imageBinaries returns the built binaries an image carries: the same-named
+// binary, cross-compiled for each of its platforms, or built for the host.
+// An image pulled from elsewhere carries none of them.
+func imageBinaries(image types.ImageSpec) []string {
+	if image.BuildFrom != "" {
+		return nil
+	}
+	platforms := image.Platforms
+	if len(platforms) == 0 {
+		if image.BuildMode != types.ImageBuildModeOCI {
+			return []string{filepath.Join(env.BinaryTgt, image.Name)}
+		}
+		platforms = []string{"linux/" + runtime.GOARCH}
+	}
+	var files []string
+	for _, name := range platforms {
+		platform, err := ociutil.ParsePlatform(name)
+		if err != nil {
+			continue
+		}
+		files = append(files, ociBinarySource(image, platform))
+	}
+	return files
+}
+
+// imageFrom returns the image an image is based on, or pulled from.
+func imageFrom(image types.ImageSpec) string {
+	if image.BuildFrom != "" {
+		return image.BuildFrom
+	}
+	return imageBase(image)
+}
+
+// ociPURL returns the package URL of the image ref holding digest.
+func ociPURL(ref, digest string) string {
+	repository := ref
+	if i := strings.LastIndex(repository, ":"); i > strings.LastIndex(repository, "/") {
+		repository = repository[:i]
+	}
+	return "pkg:oci/" + strings.ToLower(path.Base(repository)) + "@" + strings.ReplaceAll(digest, ":", "%3A") + "?repository_url=" + repository
+}
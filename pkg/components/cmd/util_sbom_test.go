@@ -0,0 +1,118 @@
+package cmd
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/xhanio/gopro/pkg/types"
+)
+
+func readJSON(t *testing.T, path string) map[string]any {
+	t.Helper()
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc map[string]any
+	if err := json.Unmarshal(b, &doc); err != nil {
+		t.Fatal(err)
+	}
+	return doc
+}
+
+func TestBuildBinaryWritesSBOMs(t *testing.T) {
+	withGoModule(t)
+	withManifestFlags(t)
+	e := types.EnvSpec{BinaryTgt: "bin", Binaries: []string{"api"}}
+	withProject(t, types.Project{Build: types.BuildSpec{Binaries: []types.BinarySpec{{
+		Name:    "api",
+		Src:     "cmd/api",
+		Version: "1.2.0",
+		SBOM:    []types.SBOMFormat{types.SBOMFormatSPDX, types.SBOMFormatCycloneDX},
+	}}}}, e)
+	if err := runBuildBinary(nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	output := filepath.Join("bin", "api")
+	m, err := readManifest(filepath.Join("dist", "build-manifest.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{output + ".spdx.json", output + ".cdx.json"}
+	if len(m.Binaries) != 1 || !slices.Equal(m.Binaries[0].SBOMs, want) {
+		t.Fatalf("manifest binaries = %+v, want sboms %q", m.Binaries, want)
+	}
+
+	spdx := readJSON(t, want[0])
+	subject := spdx["packages"].([]any)[0].(map[string]any)
+	checksum := subject["checksums"].([]any)[0].(map[string]any)["checksumValue"]
+	if subject["name"] != "api" || subject["versionInfo"] != "1.2.0" || checksum != m.Binaries[0].SHA256 {
+		t.Errorf("spdx subject %v", subject)
+	}
+	cdx := readJSON(t, want[1])
+	component := cdx["metadata"].(map[string]any)["component"].(map[string]any)
+	if !strings.HasPrefix(component["purl"].(string), "pkg:golang/example.com/app?") {
+		t.Errorf("cyclonedx subject %v", component)
+	}
+}
+
+func TestDryRunBuildBinaryPlansSBOMs(t *testing.T) {
+	withGoModule(t)
+	withManifestFlags(t)
+	e := types.EnvSpec{BinaryTgt: "bin", Binaries: []string{"api"}}
+	withProject(t, types.Project{Build: types.BuildSpec{Binaries: []types.BinarySpec{{
+		Name: "api",
+		Src:  "cmd/api",
+		SBOM: []types.SBOMFormat{types.SBOMFormatCycloneDX},
+	}}}}, e)
+	pl := withDryRun(t, "text")
+	if err := runBuildBinary(nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	output := filepath.Join("bin", "api")
+	if !slices.ContainsFunc(pl.Steps, func(s planStep) bool {
+		return s.Action == "sbom" && s.Path == output+".cdx.json" && s.Source == output
+	}) {
+		t.Errorf("sbom not planned: %+v", pl.Steps)
+	}
+	if _, err := os.Stat(output + ".cdx.json"); !os.IsNotExist(err) {
+		t.Errorf("dry run wrote the sbom (err=%v)", err)
+	}
+}
+
+func TestImageSBOMDescribesItsBinariesAndBase(t *testing.T) {
+	withGoModule(t)
+	withManifestFlags(t)
+	e := types.EnvSpec{BinaryTgt: "bin", Binaries: []string{"api"}}
+	withProject(t, types.Project{Build: types.BuildSpec{Binaries: []types.BinarySpec{{Name: "api", Src: "cmd/api"}}}}, e)
+	if err := runBuildBinary(nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	image := types.ImageSpec{Name: "api", Base: "alpine:3.19", SBOM: []types.SBOMFormat{types.SBOMFormatCycloneDX}}
+	a := imageArtifact{Name: "api", Refs: []string{"reg.io/team/api:v1"}, Digest: "sha256:abc"}
+	paths, err := writeImageSBOMs(image, a, filepath.Join("dist", "api"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !slices.Equal(paths, []string{filepath.Join("dist", "api.cdx.json")}) {
+		t.Fatalf("wrote %q", paths)
+	}
+
+	cdx := readJSON(t, paths[0])
+	subject := cdx["metadata"].(map[string]any)["component"].(map[string]any)
+	if subject["type"] != "container" || subject["purl"] != "pkg:oci/api@sha256%3Aabc?repository_url=reg.io/team/api" {
+		t.Errorf("subject %v", subject)
+	}
+	var names []string
+	for _, c := range cdx["components"].([]any) {
+		names = append(names, c.(map[string]any)["name"].(string))
+	}
+	if !slices.Contains(names, "api") || !slices.Contains(names, "alpine:3.19") || !slices.Contains(names, "stdlib") {
+		t.Errorf("components %q, want the binary, its stdlib and the base", names)
+	}
+}
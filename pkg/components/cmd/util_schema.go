@@ -0,0 +1,83 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/xhanio/errors"
+
+	"github.com/xhanio/gopro/pkg/utils/schemautil"
+)
+
+// renderedFile is a file rendered into memory, with the action that writes
+// it, render or copy, and the template or file it came from.
+type renderedFile struct {
+	content []byte
+	action  string
+	from    string
+	// seq is the order the file was first rendered in, which it is written
+	// in.
+	seq int
+}
+
+// loadSchema compiles a JSON Schema file, JSON or YAML.
+func loadSchema(file string) (*schemautil.Schema, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	s, err := schemautil.Parse(b)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file, err)
+	}
+	return s, nil
+}
+
+// validateConfigFiles checks the YAML and JSON files a unit rendered against
+// its schema. Every problem is reported with the file it goes to, the document
+// when it holds several, the path of the value and the layer it came from.
+func validateConfigFiles(unit renderUnit, rendered map[string]renderedFile) error {
+	schema, err := loadSchema(unit.schema)
+	if err != nil {
+		return errors.Newf("%s: schema: %s", unit.title, err)
+	}
+	titlef("Validate %s against %s", unit.title, unit.schema)
+	problems, checked := 0, 0
+	for _, rel := range sortedKeys(rendered) {
+		switch path.Ext(rel) {
+		case ".yaml", ".yml", ".json":
+		default:
+			continue
+		}
+		checked++
+		file := filepath.Join(unit.dst, filepath.FromSlash(rel))
+		from := rendered[rel].from
+		docs, err := schemautil.Documents(rendered[rel].content)
+		if err != nil {
+			problems++
+			warnf("%s: %s (from %s)", file, err, from)
+			continue
+		}
+		if len(docs) == 0 {
+			// an empty file is an empty document
+			docs = []any{nil}
+		}
+		for i, doc := range docs {
+			at := file
+			if len(docs) > 1 {
+				at = fmt.Sprintf("%s[%d]", file, i)
+			}
+			for _, e := range schema.Validate(doc) {
+				problems++
+				warnf("%s: %s (from %s)", at, e, from)
+			}
+		}
+	}
+	if problems > 0 {
+		return errors.Newf("%d problems found in %s against %s", problems, unit.title, unit.schema)
+	}
+	linef("%d files match %s", checked, unit.schema)
+	return nil
+}
@@ -0,0 +1,90 @@
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/fatih/color"
+)
+
+const configSchema = `{
+  "type": "object",
+  "required": ["server"],
+  "additionalProperties": false,
+  "properties": {
+    "server": {
+      "type": "object",
+      "required": ["port"],
+      "properties": {
+        "port": {"type": "integer", "minimum": 1, "maximum": 65535},
+        "mode": {"enum": ["debug", "release"]}
+      }
+    },
+    "features": {"type": "array", "items": {"type": "string"}}
+  }
+}
+`
+
+// A bad override in the env's layer fails generate with the file written,
+// the path of the value and the template it came from.
+func TestGenerateConfigValidatesSchema(t *testing.T) {
+	t.Chdir(t.TempDir())
+	writeTree(t, "schemas", "api.json", configSchema)
+	writeTree(t, "env/default/config/api", "template.app.yaml", "server:\n  port: 8080\n  mode: [[ .EnvName | default \"debug\" ]]\n")
+	writeTree(t, "env/default/config/api", "features.json", `{"server": {"port": 80}, "features": ["a"]}`)
+	writeTree(t, "env/default/config/api", "notes.txt", "not checked\n")
+	p, e := configProject("env/default/config", "dist/config")
+	p.Generate.Configs[0].Schema = "schemas/api.json"
+	withProject(t, p, e)
+
+	if err := runGenerateConfig(nil, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// the env's layer overrides app.yaml
+	env.ConfigSrc = "env/prod/config"
+	writeTree(t, "env/prod/config/api", "template.app.yaml", "server:\n  port: \"8080\"\n  mode: [[ .Name ]]\nfeature: [b]\n---\nserver: {}\n")
+	oldOut, oldNoColor := printOut, color.NoColor
+	t.Cleanup(func() { printOut, color.NoColor = oldOut, oldNoColor })
+	var out bytes.Buffer
+	printOut, color.NoColor = &out, true
+
+	err := runGenerateConfig(nil, nil)
+	if err == nil || !strings.Contains(err.Error(), "4 problems found in config api against schemas/api.json") {
+		t.Fatalf("generate = %v", err)
+	}
+	app := filepath.Join("dist", "config", "api", "app.yaml")
+	from := filepath.Join("env", "prod", "config", "api", "template.app.yaml")
+	for _, want := range []string{
+		app + "[0]: feature: is not a known field (from " + from + ")",
+		app + `[0]: server.mode: got "api", want one of "debug", "release" (from ` + from + ")",
+		app + "[0]: server.port: got string, want integer (from " + from + ")",
+		app + "[1]: server.port: is required (from " + from + ")",
+	} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("output lacks %q:\n%s", want, out.String())
+		}
+	}
+	if strings.Contains(out.String(), "features.json:") {
+		t.Errorf("the default's valid file was reported:\n%s", out.String())
+	}
+	// the target keeps what the last good render wrote
+	for rel, want := range map[string]string{
+		"app.yaml":  "server:\n  port: 8080\n  mode: debug\n",
+		"notes.txt": "not checked\n",
+	} {
+		if b, err := os.ReadFile(filepath.Join("dist", "config", "api", rel)); err != nil || string(b) != want {
+			t.Errorf("%s after the failed generate = %q, %v, want %q", rel, b, err, want)
+		}
+	}
+
+	if err := os.Remove(filepath.Join("schemas", "api.json")); err != nil {
+		t.Fatal(err)
+	}
+	if err := runGenerateConfig(nil, nil); err == nil || !strings.Contains(err.Error(), "schema") {
+		t.Errorf("generate without its schema = %v", err)
+	}
+}
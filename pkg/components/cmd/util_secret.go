@@ -0,0 +1,355 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+	"github.com/xhanio/errors"
+	"github.com/xhanio/framingo/pkg/utils/envutil"
+
+	"github.com/xhanio/gopro/pkg/types"
+)
+
+// secretProvider looks up the secret key of a component.
+type secretProvider interface {
+	lookup(name, key string) (string, error)
+}
+
+// secretLister is a provider that can list every secret key of a component,
+// as lookup finds them.
+type secretLister interface {
+	list(name string) (map[string]string, error)
+}
+
+// secretProviderFor returns the provider of that name: one of
+// secrets.providers, else the builtin provider of that type.
+func secretProviderFor(provider string) (secretProvider, error) {
+	spec := types.SecretProviderSpec{Name: provider, Type: types.SecretProviderType(provider)}
+	if i := slices.IndexFunc(project.Secrets.Providers, func(p types.SecretProviderSpec) bool { return p.Name == provider }); i >= 0 {
+		spec = project.Secrets.Providers[i]
+	}
+	switch spec.Type {
+	case types.SecretProviderFile:
+		return fileSecrets{}, nil
+	case types.SecretProviderAge:
+		return ageSecrets{}, nil
+	case types.SecretProviderEnv:
+		return envSecrets{prefix: spec.Prefix}, nil
+	case types.SecretProviderExec:
+		if len(spec.Command) == 0 {
+			return nil, errors.Newf("secret provider %s has no command", spec.Name)
+		}
+		return execSecrets{command: spec.Command}, nil
+	}
+	return nil, errors.Newf("unknown secret provider %q", provider)
+}
+
+// FromSecret renders the secret key of a component from the named provider.
+func FromSecret(provider, name, key string) (string, error) {
+	p, err := secretProviderFor(provider)
+	if err != nil {
+		return "", fmt.Errorf("failed to render from %s secret %s: %w", name, key, err)
+	}
+	return p.lookup(name, key)
+}
+
+// fileSecrets reads the plaintext secret.env of a component in the env's
+// config source.
+type fileSecrets struct{}
+
+// list returns the keys of the secret.env, none when there is no such file.
+func (fileSecrets) list(name string) (map[string]string, error) {
+	kv, err := readSecretEnv(filepath.Join(env.ConfigSrc, name, "secret.env"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return kv, err
+}
+
+func (fileSecrets) lookup(name, key string) (string, error) {
+	kv, err := readSecretEnv(filepath.Join(env.ConfigSrc, name, "secret.env"))
+	if err != nil {
+		return "", fmt.Errorf("failed to render from %s secret.env: %w", name, err)
+	}
+	if val, ok := kv[key]; ok {
+		return val, nil
+	}
+	return "", fmt.Errorf("failed to render from %s secret.env: key %s not found", name, key)
+}
+
+// ageSecrets decrypts the secret.env.age of a component, in the default
+// config source and the env's, the env's keys overriding the default's.
+type ageSecrets struct{}
+
+// list returns the keys of every store, the env's overriding the default's.
+func (ageSecrets) list(name string) (map[string]string, error) {
+	layers, err := secretLayers(name)
+	if err != nil {
+		return nil, err
+	}
+	kv := make(map[string]string)
+	for _, layer := range layers {
+		maps.Copy(kv, layer.kv)
+	}
+	return kv, nil
+}
+
+func (ageSecrets) lookup(name, key string) (string, error) {
+	layers, err := secretLayers(name)
+	if err != nil {
+		return "", fmt.Errorf("failed to render from %s secret.env.age: %w", name, err)
+	}
+	if len(layers) == 0 {
+		return "", fmt.Errorf("failed to render from %s secret.env.age: no %s found", name, strings.Join(secretStores(name), " or "))
+	}
+	for i := len(layers) - 1; i >= 0; i-- {
+		if val, ok := layers[i].kv[key]; ok {
+			return val, nil
+		}
+	}
+	return "", fmt.Errorf("failed to render from %s secret.env.age: key %s not found", name, key)
+}
+
+// secretStores returns the secret.env.age files of a component, the
+// default's before the env's, once when both sources are the same.
+func secretStores(name string) []string {
+	stores := []string{filepath.Join(project.Default.ConfigSrc, name, "secret.env.age")}
+	if path := secretStore(name); path != stores[0] {
+		stores = append(stores, path)
+	}
+	return stores
+}
+
+// secretStore returns the secret.env.age of a component that the selected
+// environment writes to.
+func secretStore(name string) string {
+	return filepath.Join(env.ConfigSrc, name, "secret.env.age")
+}
+
+// secretLayer is the decrypted content of a secret.env.age.
+type secretLayer struct {
+	path string
+	kv   map[string]string
+}
+
+// secretLayers decrypts the secret.env.age stores of a component that exist,
+// in the order secretStores lists them.
+func secretLayers(name string) ([]secretLayer, error) {
+	var layers []secretLayer
+	for _, path := range secretStores(name) {
+		kv, err := readAgeSecretEnv(path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		layers = append(layers, secretLayer{path: path, kv: kv})
+	}
+	return layers, nil
+}
+
+// readAgeSecretEnv decrypts a secret.env.age with the identity file in
+// secrets.key_env and reads it as readSecretEnv does.
+func readAgeSecretEnv(path string) (map[string]string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	ids, err := ageIdentities()
+	if err != nil {
+		return nil, err
+	}
+	plain, err := ageDecrypt(b, ids...)
+	if err != nil {
+		return nil, err
+	}
+	return parseSecretEnv(plain)
+}
+
+// writeAgeSecretEnv encrypts kv as sorted KEY=value lines to the recipients
+// of secretRecipients and writes it to path, or removes path when kv is
+// empty.
+func writeAgeSecretEnv(path string, kv map[string]string) error {
+	if len(kv) == 0 {
+		return removeAll(path)
+	}
+	recipients, err := secretRecipients()
+	if err != nil {
+		return err
+	}
+	var b bytes.Buffer
+	for _, key := range sortedKeys(kv) {
+		fmt.Fprintf(&b, "%s=%s\n", key, kv[key])
+	}
+	encrypted, err := ageEncrypt(b.Bytes(), recipients...)
+	if err != nil {
+		return err
+	}
+	return writeFile(path, encrypted, "write", "")
+}
+
+// secretRecipients returns the recipients of secrets.recipients, or unset,
+// those of the identity file.
+func secretRecipients() ([]age.Recipient, error) {
+	var recipients []age.Recipient
+	for i, s := range project.Secrets.Recipients {
+		r, err := age.ParseX25519Recipient(s)
+		if err != nil {
+			return nil, errors.Newf("secrets.recipients[%d]: %s", i, err)
+		}
+		recipients = append(recipients, r)
+	}
+	if len(recipients) > 0 {
+		return recipients, nil
+	}
+	ids, err := ageIdentities()
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range ids {
+		x, ok := id.(*age.X25519Identity)
+		if !ok {
+			return nil, errors.Newf("secrets.recipients is needed to encrypt to a %T", id)
+		}
+		recipients = append(recipients, x.Recipient())
+	}
+	return recipients, nil
+}
+
+// ageIdentities reads the age identity file named by secrets.key_env.
+func ageIdentities() ([]age.Identity, error) {
+	keyEnv := project.Secrets.GetKeyEnv()
+	path := os.Getenv(keyEnv)
+	if path == "" {
+		return nil, errors.Newf("decrypting secrets needs the path of an age identity file in $%s", keyEnv)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	ids, err := age.ParseIdentities(f)
+	if err != nil {
+		return nil, errors.Newf("age identity file %s: %s", path, err)
+	}
+	return ids, nil
+}
+
+// ageEncrypt encrypts plaintext to the recipients as an armored age file, the
+// text form best kept in a repository.
+func ageEncrypt(plaintext []byte, recipients ...age.Recipient) ([]byte, error) {
+	var b bytes.Buffer
+	aw := armor.NewWriter(&b)
+	w, err := age.Encrypt(aw, recipients...)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(plaintext); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	if err := aw.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// ageDecrypt decrypts an age file, armored or not, with whichever of the
+// identities it was encrypted to.
+func ageDecrypt(ciphertext []byte, identities ...age.Identity) ([]byte, error) {
+	var r io.Reader = bytes.NewReader(ciphertext)
+	if bytes.HasPrefix(bytes.TrimSpace(ciphertext), []byte(armor.Header)) {
+		r = armor.NewReader(r)
+	}
+	pr, err := age.Decrypt(r, identities...)
+	if err != nil {
+		return nil, err
+	}
+	return io.ReadAll(pr)
+}
+
+// envSecrets reads the env var <prefix><NAME>_<KEY>, the component name in
+// env var form.
+type envSecrets struct {
+	prefix string
+}
+
+func (p envSecrets) lookup(name, key string) (string, error) {
+	variable := p.prefix + envutil.EnvPrefix(name) + "_" + key
+	if val, ok := os.LookupEnv(variable); ok {
+		return val, nil
+	}
+	return "", fmt.Errorf("failed to render from %s secret %s: $%s is not set", name, key, variable)
+}
+
+// execSecrets runs a command printing the secret, such as a password manager
+// or vault CLI. ${NAME}, ${KEY} and ${ENV} in its args expand to the
+// component, the key and the environment, which it is also given as
+// GOPRO_SECRET_NAME, GOPRO_SECRET_KEY and GOPRO_SECRET_ENV. The secret is its
+// output without the trailing newline.
+type execSecrets struct {
+	command []string
+}
+
+func (p execSecrets) lookup(name, key string) (string, error) {
+	vars := map[string]string{"NAME": name, "KEY": key, "ENV": envName}
+	expand := func(s string) string {
+		return os.Expand(s, func(v string) string {
+			if val, ok := vars[v]; ok {
+				return val
+			}
+			return "${" + v + "}"
+		})
+	}
+	var args []string
+	for _, arg := range p.command[1:] {
+		args = append(args, expand(arg))
+	}
+	c := command(expand(p.command[0]), args, []string{
+		"GOPRO_SECRET_NAME=" + name,
+		"GOPRO_SECRET_KEY=" + key,
+		"GOPRO_SECRET_ENV=" + envName,
+	})
+	if verbose {
+		debugf("executing %s", strings.Join(c.Args, " "))
+	}
+	var stderr bytes.Buffer
+	c.Stderr = &stderr
+	out, err := c.Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to render from %s secret %s: %s: %w: %s", name, key, p.command[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimRight(string(out), "\r\n"), nil
+}
+
+// parseSecretEnv reads the KEY=value lines of a secret.env, skipping blank
+// lines and # comments.
+func parseSecretEnv(b []byte) (map[string]string, error) {
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	kv := make(map[string]string)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) == 2 {
+			key := strings.TrimSpace(parts[0])
+			value := strings.TrimSpace(parts[1])
+			kv[key] = value
+		}
+	}
+	return kv, scanner.Err()
+}
@@ -0,0 +1,130 @@
+package cmd
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"filippo.io/age"
+	"filippo.io/age/armor"
+
+	"github.com/xhanio/gopro/pkg/types"
+)
+
+// encryptSecretEnv encrypts body to id as dir/secret.env.age.
+func encryptSecretEnv(t *testing.T, dir, body string, id *age.X25519Identity) {
+	t.Helper()
+	b, err := ageEncrypt([]byte(body), id.Recipient())
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTree(t, dir, "secret.env.age", string(b))
+}
+
+func TestSecretProviders(t *testing.T) {
+	t.Chdir(t.TempDir())
+	id, err := age.GenerateX25519Identity()
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyFile := filepath.Join(t.TempDir(), "keys.txt")
+	if err := os.WriteFile(keyFile, []byte("# test key\n"+id.String()+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GOPRO_AGE_KEY_FILE", keyFile)
+	t.Setenv("CI_API_TOKEN", "from-env")
+	writeTree(t, "env/default/api", "secret.env", "DB_PASSWORD=plain\n")
+	encryptSecretEnv(t, "env/default/api", "DB_PASSWORD=default\nAPI_KEY=key\n", id)
+	encryptSecretEnv(t, "env/prod/api", "DB_PASSWORD=prod\n", id)
+	withProject(t, types.Project{
+		Default: types.EnvSpec{ConfigSrc: "env/default"},
+		Secrets: types.SecretsSpec{
+			Default: "age",
+			Providers: []types.SecretProviderSpec{
+				{Name: "ci", Type: types.SecretProviderEnv, Prefix: "CI_"},
+				{Name: "vault", Type: types.SecretProviderExec, Command: []string{"sh", "-c", `echo "$0/$1/$GOPRO_SECRET_ENV"`, "${NAME}", "${KEY}"}},
+				{Name: "broken", Type: types.SecretProviderExec, Command: []string{"sh", "-c", "echo nope >&2; exit 3"}},
+			},
+		},
+	}, types.EnvSpec{ConfigSrc: "env/prod"})
+	oldEnvName := envName
+	t.Cleanup(func() { envName = oldEnvName })
+	envName = "prod"
+
+	tests := []struct {
+		provider, key string
+		want          string
+		err           string
+	}{
+		// the env's store over the default's
+		{provider: "age", key: "DB_PASSWORD", want: "prod"},
+		{provider: "age", key: "API_KEY", want: "key"},
+		{provider: "age", key: "MISSING", err: "key MISSING not found"},
+		// the builtin file provider, in the env's source only
+		{provider: "file", key: "DB_PASSWORD", err: "no such file"},
+		{provider: "env", key: "TOKEN", err: "$API_TOKEN is not set"},
+		{provider: "ci", key: "TOKEN", want: "from-env"},
+		{provider: "vault", key: "TOKEN", want: "api/TOKEN/prod"},
+		{provider: "broken", key: "TOKEN", err: "exit status 3: nope"},
+		{provider: "nope", key: "TOKEN", err: `unknown secret provider "nope"`},
+	}
+	for _, tt := range tests {
+		got, err := FromSecret(tt.provider, "api", tt.key)
+		switch {
+		case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+			t.Errorf("%s %s: err = %v, want %s", tt.provider, tt.key, err, tt.err)
+		case tt.err == "" && (err != nil || got != tt.want):
+			t.Errorf("%s %s = %q, %v, want %q", tt.provider, tt.key, got, err, tt.want)
+		}
+	}
+
+	// FromSecretEnv reads the default provider
+	out, err := executeTemplate("api", "template.app.yaml", []byte(`[[ FromSecretEnv "api" "DB_PASSWORD" ]] [[ FromSecret "ci" "api" "TOKEN" ]]`))
+	if err != nil || string(out) != "prod from-env" {
+		t.Errorf("rendered %q, %v", out, err)
+	}
+	t.Setenv("GOPRO_AGE_KEY_FILE", "")
+	if _, err := FromSecretEnv("api", "DB_PASSWORD"); err == nil || !strings.Contains(err.Error(), "$GOPRO_AGE_KEY_FILE") {
+		t.Errorf("without a key: %v", err)
+	}
+}
+
+// TestAgeFiles checks that secret files are age files both ways: what
+// ageEncrypt writes opens with age, and what age writes, armored or not,
+// opens with ageDecrypt.
+func TestAgeFiles(t *testing.T) {
+	id, _ := age.GenerateX25519Identity()
+	other, _ := age.GenerateX25519Identity()
+	plaintext := []byte("DB_PASSWORD=secret\n")
+
+	armored, err := ageEncrypt(plaintext, id.Recipient())
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := age.Decrypt(armor.NewReader(bytes.NewReader(armored)), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, err := io.ReadAll(r); err != nil || !bytes.Equal(got, plaintext) {
+		t.Errorf("age decrypted %q, %v", got, err)
+	}
+
+	var binary bytes.Buffer
+	w, err := age.Encrypt(&binary, id.Recipient())
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(plaintext)
+	w.Close()
+	for name, b := range map[string][]byte{"armored": armored, "binary": binary.Bytes()} {
+		if got, err := ageDecrypt(b, other, id); err != nil || !bytes.Equal(got, plaintext) {
+			t.Errorf("%s: decrypted %q, %v", name, got, err)
+		}
+		if _, err := ageDecrypt(b, other); err == nil {
+			t.Errorf("%s: decrypted without the recipient's identity", name)
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/xhanio/errors"
+
+	"github.com/xhanio/gopro/pkg/utils/signutil"
+)
+
+var (
+	signArtifacts bool
+	// signer signs what is built under --sign, nil otherwise
+	signer signutil.Signer
+)
+
+// openSigner loads the private key signing.key_env names, when --sign asks
+// for signatures. A key that is not the one signing.public_key verifies is
+// only worth a warning: verifying is up to whoever holds the public key.
+func openSigner() (signutil.Signer, error) {
+	if !signArtifacts {
+		return nil, nil
+	}
+	keyEnv := project.Signing.GetKeyEnv()
+	path := os.Getenv(keyEnv)
+	if path == "" {
+		return nil, errors.Newf("--sign needs the path of the private key in $%s", keyEnv)
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	s, err := signutil.LoadPrivateKey(b, []byte(os.Getenv(project.Signing.GetPasswordEnv())))
+	if err != nil {
+		return nil, errors.Newf("signing key %s: %s", path, err)
+	}
+	if project.Signing.PublicKey != "" {
+		if v, err := signutil.ParsePublicKey(project.Signing.PublicKey); err == nil && v.ID() != s.ID() {
+			warnf("signing key %s is %s, but signing.public_key is %s; gopro verify will reject its signatures", path, s.ID(), v.ID())
+		}
+	}
+	if verbose {
+		debugf("signing with key %s", s.ID())
+	}
+	return s, nil
+}
+
+// signFile writes the signature of file beside it, and returns its path. It
+// does nothing without a signer.
+func signFile(file string) (string, error) {
+	if signer == nil {
+		return "", nil
+	}
+	output := file + signer.Ext()
+	if dryRun {
+		currentPlan.add(planStep{Action: "sign", Path: output, Source: file})
+		return output, nil
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	sig, err := signer.Sign(filepath.Base(file), data, buildTimestamp())
+	if err != nil {
+		return "", errors.Newf("sign %s: %s", file, err)
+	}
+	return output, os.WriteFile(output, sig, 0644)
+}
@@ -0,0 +1,624 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+
+	"filippo.io/age"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+
+	"github.com/xhanio/errors"
+	"github.com/xhanio/gopro/pkg/types"
+	"github.com/xhanio/gopro/pkg/utils/kubeschemautil"
+	"github.com/xhanio/gopro/pkg/utils/signutil"
+)
+
+func NewValidateCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "validate",
+		Short: "Check project.yaml against the project model",
+		RunE:  runValidate,
+	}
+	return cmd
+}
+
+// issue is one problem found in project.yaml, located by the YAML path of the
+// node it concerns, e.g. env.prod.binaries[0].
+type issue struct {
+	path string
+	msg  string
+}
+
+func runValidate(cmd *cobra.Command, args []string) error {
+	b, err := os.ReadFile(projectPath)
+	if err != nil {
+		return err
+	}
+	var root yaml.Node
+	if err := yaml.Unmarshal(b, &root); err != nil {
+		return err
+	}
+	titlef("Validate %s", projectPath)
+	var platforms []string
+	if out, err := execute("go", []string{"tool", "dist", "list"}, nil, false); err == nil {
+		platforms = strings.Fields(out)
+	} else {
+		warnf("go tool dist list unavailable, checking platform names by form only")
+	}
+	issues := validateProject(project, &root, platforms)
+	if envName != "" {
+		if _, ok := project.Env[envName]; !ok {
+			issues = append(issues, issue{path: "env", msg: fmt.Sprintf("environment %q is not defined", envName)})
+		}
+	}
+	if len(issues) == 0 {
+		linef("no problems found")
+		return nil
+	}
+	for _, is := range issues {
+		line, column := locate(&root, is.path)
+		warnf("%s:%d:%d: %s: %s", projectPath, line, column, is.path, is.msg)
+	}
+	return errors.Newf("%d problems found in %s", len(issues), projectPath)
+}
+
+// validateProject lints a loaded project against the YAML it was loaded from.
+// platforms lists the GOOS/GOARCH pairs the toolchain supports; when empty,
+// platform names are only checked for their os/arch form.
+func validateProject(p types.Project, root *yaml.Node, platforms []string) []issue {
+	var issues []issue
+	if root != nil && len(root.Content) > 0 {
+		issues = append(issues, unknownKeys(root.Content[0], reflect.TypeOf(p), "")...)
+	}
+	issues = append(issues, validateEnvNames("default", p.Default, p)...)
+	for _, name := range sortedEnvNames(p) {
+		issues = append(issues, validateEnvNames("env."+name, p.Env[name], p)...)
+	}
+	issues = append(issues, validateImageBases(p)...)
+	issues = append(issues, validatePlatforms(p, platforms)...)
+	issues = append(issues, validateImageSettings(p)...)
+	issues = append(issues, validateBuildManifest(p)...)
+	issues = append(issues, validateTest(p)...)
+	issues = append(issues, validateRelease(p.Release)...)
+	issues = append(issues, validatePackages(p)...)
+	issues = append(issues, validateSBOMs(p)...)
+	issues = append(issues, validateConfigSchemas(p)...)
+	issues = append(issues, validateKubernetes(p)...)
+	issues = append(issues, validateSigning(p.Signing)...)
+	issues = append(issues, validateSecrets(p.Secrets)...)
+	issues = append(issues, validateSources(p)...)
+	return issues
+}
+
+func sortedEnvNames(p types.Project) []string {
+	var names []string
+	for name := range p.Env {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// validateEnvNames checks that every component an environment section enables
+// is defined under build or generate. The section is checked as written, not
+// merged, so a problem is reported where it appears.
+func validateEnvNames(section string, e types.EnvSpec, p types.Project) []issue {
+	var binaries, images, packages, configs, kubernetes []string
+	for _, b := range p.Build.Binaries {
+		binaries = append(binaries, b.Name)
+	}
+	for _, i := range p.Build.Images {
+		images = append(images, i.Name)
+	}
+	for _, pkg := range p.Build.Packages {
+		packages = append(packages, pkg.Name)
+	}
+	for _, c := range p.Generate.Configs {
+		configs = append(configs, c.Name)
+	}
+	for _, k := range p.Generate.Kubernetes {
+		kubernetes = append(kubernetes, k.Name)
+	}
+	var issues []issue
+	check := func(key string, names, defined []string, kind, where string) {
+		for i, name := range names {
+			if !slices.Contains(defined, name) {
+				issues = append(issues, issue{
+					path: fmt.Sprintf("%s.%s[%d]", section, key, i),
+					msg:  fmt.Sprintf("%s %q is not defined in %s", kind, name, where),
+				})
+			}
+		}
+	}
+	check("binaries", e.Binaries, binaries, "binary", "build.binaries")
+	check("images", e.Images, images, "image", "build.images")
+	check("packages", e.Packages, packages, "package", "build.packages")
+	check("configs", e.Configs, configs, "config", "generate.configs")
+	check("kubernetes_templates", e.KubernetesTemplates, kubernetes, "kubernetes template", "generate.kubernetes")
+	return issues
+}
+
+func validateImageBases(p types.Project) []issue {
+	var (
+		issues []issue
+		names  []string
+	)
+	for i, image := range p.Build.Images {
+		names = append(names, image.Name)
+		baseName, ok := image.BaseImage()
+		if !ok {
+			continue
+		}
+		if !slices.ContainsFunc(p.Build.Images, func(other types.ImageSpec) bool { return other.Name == baseName }) {
+			issues = append(issues, issue{
+				path: fmt.Sprintf("build.images[%d].base", i),
+				msg:  fmt.Sprintf("base image %q is not defined in build.images", baseName),
+			})
+		}
+	}
+	// build image orders images by their bases, which a cycle makes impossible
+	if _, err := orderImages(p.Build.Images, names, names, false); err != nil {
+		issues = append(issues, issue{path: "build.images", msg: err.Error()})
+	}
+	return issues
+}
+
+func validatePlatforms(p types.Project, platforms []string) []issue {
+	var issues []issue
+	check := func(path, name string) {
+		parts := strings.Split(name, "/")
+		switch {
+		case len(parts) != 2 || parts[0] == "" || parts[1] == "":
+			issues = append(issues, issue{path: path, msg: fmt.Sprintf("platform %q is not an os/arch pair", name)})
+		case len(platforms) > 0 && !slices.Contains(platforms, name):
+			issues = append(issues, issue{path: path, msg: fmt.Sprintf("platform %q is not supported by go tool dist list", name)})
+		}
+	}
+	for i, binary := range p.Build.Binaries {
+		for j, name := range binary.Platform {
+			check(fmt.Sprintf("build.binaries[%d].platform[%d]", i, j), name)
+		}
+		for j, platform := range binary.Platforms {
+			check(fmt.Sprintf("build.binaries[%d].platforms[%d].name", i, j), platform.Name)
+		}
+	}
+	for i, image := range p.Build.Images {
+		for j, name := range image.Platforms {
+			// an image platform may carry a variant, as in linux/arm/v7
+			if parts := strings.Split(name, "/"); len(parts) == 3 && parts[2] != "" {
+				name = parts[0] + "/" + parts[1]
+			}
+			check(fmt.Sprintf("build.images[%d].platforms[%d]", i, j), name)
+		}
+	}
+	return issues
+}
+
+// validateImageSettings checks the image cache types, engines and build modes
+// named in the default section, every environment and every image.
+func validateImageSettings(p types.Project) []issue {
+	var issues []issue
+	checkCache := func(path string, cache types.CacheSpec) {
+		switch cache.Type {
+		case "", types.ImageCacheNone, types.ImageCacheLocal, types.ImageCacheRegistry, types.ImageCacheDir:
+		default:
+			issues = append(issues, issue{path: path, msg: fmt.Sprintf("unknown image cache type %q", cache.Type)})
+		}
+	}
+	checkEngine := func(path string, engine types.ImageEngineType) {
+		if _, err := newEngine(engine, nil); err != nil && engine != types.ImageEngineDryRun {
+			issues = append(issues, issue{path: path, msg: err.Error()})
+		}
+	}
+	checkCache("default.image_cache.type", p.Default.ImageCache)
+	checkEngine("default.image_engine", p.Default.ImageEngine)
+	for _, name := range sortedEnvNames(p) {
+		checkCache("env."+name+".image_cache.type", p.Env[name].ImageCache)
+		checkEngine("env."+name+".image_engine", p.Env[name].ImageEngine)
+	}
+	for i, image := range p.Build.Images {
+		checkCache(fmt.Sprintf("build.images[%d].cache.type", i), image.Cache)
+		switch image.BuildMode {
+		case "", types.ImageBuildModeOCI:
+		default:
+			issues = append(issues, issue{
+				path: fmt.Sprintf("build.images[%d].build_mode", i),
+				msg:  fmt.Sprintf("unknown build mode %q", image.BuildMode),
+			})
+		}
+	}
+	return issues
+}
+
+// validateBuildManifest checks the build manifest format named in the default
+// section and every environment.
+func validateBuildManifest(p types.Project) []issue {
+	var issues []issue
+	check := func(path string, format types.ManifestFormat) {
+		switch format {
+		case "", types.ManifestFormatGopro, types.ManifestFormatSLSA:
+		default:
+			issues = append(issues, issue{path: path, msg: fmt.Sprintf("unknown build manifest format %q", format)})
+		}
+	}
+	check("default.build_manifest.format", p.Default.BuildManifest.Format)
+	for _, name := range sortedEnvNames(p) {
+		check("env."+name+".build_manifest.format", p.Env[name].BuildManifest.Format)
+	}
+	return issues
+}
+
+// validateTest checks the cover mode named in the default section and every
+// environment.
+func validateTest(p types.Project) []issue {
+	var issues []issue
+	check := func(path string, mode types.CoverMode) {
+		switch mode {
+		case "", types.CoverModeSet, types.CoverModeCount, types.CoverModeAtomic:
+		default:
+			issues = append(issues, issue{path: path, msg: fmt.Sprintf("unknown cover mode %q, want set, count or atomic", mode)})
+		}
+	}
+	check("default.test.cover", p.Default.Test.Cover)
+	for _, name := range sortedEnvNames(p) {
+		check("env."+name+".test.cover", p.Env[name].Test.Cover)
+	}
+	return issues
+}
+
+// validateConfigSchemas checks that the schema of each config is a JSON
+// Schema gopro can compile.
+func validateConfigSchemas(p types.Project) []issue {
+	var issues []issue
+	for i, config := range p.Generate.Configs {
+		if config.Schema == "" {
+			continue
+		}
+		if _, err := loadSchema(config.Schema); err != nil {
+			issues = append(issues, issue{path: fmt.Sprintf("generate.configs[%d].schema", i), msg: err.Error()})
+		}
+	}
+	return issues
+}
+
+// validateKubernetes checks the cluster versions manifests are validated
+// against, the output of each Kubernetes template and the binary a Helm chart
+// takes its appVersion from.
+func validateKubernetes(p types.Project) []issue {
+	var issues []issue
+	checkVersion := func(path, version string) {
+		if version == "" {
+			return
+		}
+		if _, err := kubeschemautil.Load(version); err != nil {
+			issues = append(issues, issue{path: path, msg: err.Error()})
+		}
+	}
+	checkVersion("default.kubernetes_version", p.Default.KubernetesVersion)
+	for _, name := range sortedEnvNames(p) {
+		checkVersion("env."+name+".kubernetes_version", p.Env[name].KubernetesVersion)
+	}
+	for i, k := range p.Generate.Kubernetes {
+		at := fmt.Sprintf("generate.kubernetes[%d]", i)
+		switch k.Output {
+		case "", types.KubernetesOutputManifests, types.KubernetesOutputHelm, types.KubernetesOutputKustomize:
+		default:
+			issues = append(issues, issue{path: at + ".output", msg: fmt.Sprintf("unknown kubernetes output %q, want manifests, helm or kustomize", k.Output)})
+		}
+		if k.Binary != "" && !slices.ContainsFunc(p.Build.Binaries, func(b types.BinarySpec) bool { return b.Name == k.Binary }) {
+			issues = append(issues, issue{path: at + ".binary", msg: fmt.Sprintf("binary %q is not defined in build.binaries", k.Binary)})
+		}
+	}
+	return issues
+}
+
+// validateRelease checks the archive formats and the name template of the
+// release section.
+func validateRelease(r types.ReleaseSpec) []issue {
+	var issues []issue
+	check := func(path string, format types.ArchiveFormat) {
+		switch format {
+		case "", types.ArchiveFormatTarGz, types.ArchiveFormatZip:
+		default:
+			issues = append(issues, issue{path: path, msg: fmt.Sprintf("unknown archive format %q, want tar.gz or zip", format)})
+		}
+	}
+	check("release.format", r.Format)
+	for i, o := range r.FormatOverrides {
+		check(fmt.Sprintf("release.format_overrides[%d].format", i), o.Format)
+	}
+	if _, err := releaseNameTemplate(r); err != nil {
+		issues = append(issues, issue{path: "release.name_template", msg: err.Error()})
+	}
+	return issues
+}
+
+// validatePackages checks that each package packs a binary of build.binaries,
+// in formats gopro writes.
+func validatePackages(p types.Project) []issue {
+	var issues []issue
+	for i, pkg := range p.Build.Packages {
+		path := fmt.Sprintf("build.packages[%d]", i)
+		if !slices.ContainsFunc(p.Build.Binaries, func(b types.BinarySpec) bool { return b.Name == pkg.Name }) {
+			issues = append(issues, issue{path: path + ".name", msg: fmt.Sprintf("package %q is not a binary defined in build.binaries", pkg.Name)})
+		}
+		for j, format := range pkg.Formats {
+			switch format {
+			case types.PackageFormatDeb, types.PackageFormatRPM, types.PackageFormatAPK:
+			default:
+				issues = append(issues, issue{path: fmt.Sprintf("%s.formats[%d]", path, j), msg: fmt.Sprintf("unknown package format %q, want deb, rpm or apk", format)})
+			}
+		}
+	}
+	return issues
+}
+
+// validateSBOMs checks the SBOM formats binaries and images ask for.
+func validateSBOMs(p types.Project) []issue {
+	var issues []issue
+	check := func(path string, formats []types.SBOMFormat) {
+		for i, format := range formats {
+			switch format {
+			case types.SBOMFormatSPDX, types.SBOMFormatCycloneDX:
+			default:
+				issues = append(issues, issue{path: fmt.Sprintf("%s.sbom[%d]", path, i), msg: fmt.Sprintf("unknown sbom format %q, want spdx or cyclonedx", format)})
+			}
+		}
+	}
+	for i, binary := range p.Build.Binaries {
+		check(fmt.Sprintf("build.binaries[%d]", i), binary.SBOM)
+	}
+	for i, image := range p.Build.Images {
+		check(fmt.Sprintf("build.images[%d]", i), image.SBOM)
+	}
+	return issues
+}
+
+// validateSigning checks that the public key signatures are verified against
+// parses.
+func validateSigning(s types.SigningSpec) []issue {
+	if s.PublicKey == "" {
+		return nil
+	}
+	if _, err := signutil.ParsePublicKey(s.PublicKey); err != nil {
+		return []issue{{path: "signing.public_key", msg: err.Error()}}
+	}
+	return nil
+}
+
+// validateSecrets checks that each declared secret provider is of a known
+// type and complete, that the default provider is one there is, and that the
+// recipients parse.
+func validateSecrets(s types.SecretsSpec) []issue {
+	var issues []issue
+	names := []string{string(types.SecretProviderFile), string(types.SecretProviderAge), string(types.SecretProviderEnv)}
+	declared := make(map[string]bool)
+	for i, p := range s.Providers {
+		at := fmt.Sprintf("secrets.providers[%d]", i)
+		switch {
+		case p.Name == "":
+			issues = append(issues, issue{path: at + ".name", msg: "secret provider has no name"})
+		case declared[p.Name]:
+			issues = append(issues, issue{path: at + ".name", msg: fmt.Sprintf("secret provider %q is declared twice", p.Name)})
+		}
+		declared[p.Name] = true
+		names = append(names, p.Name)
+		switch p.Type {
+		case types.SecretProviderFile, types.SecretProviderAge, types.SecretProviderEnv:
+		case types.SecretProviderExec:
+			if len(p.Command) == 0 {
+				issues = append(issues, issue{path: at + ".command", msg: "exec secret provider has no command"})
+			}
+		default:
+			issues = append(issues, issue{path: at + ".type", msg: fmt.Sprintf("unknown secret provider type %q, want file, age, env or exec", p.Type)})
+		}
+	}
+	if !slices.Contains(names, s.GetDefault()) {
+		issues = append(issues, issue{path: "secrets.default", msg: fmt.Sprintf("secret provider %q is not declared in secrets.providers", s.GetDefault())})
+	}
+	for i, r := range s.Recipients {
+		if _, err := age.ParseX25519Recipient(r); err != nil {
+			issues = append(issues, issue{path: fmt.Sprintf("secrets.recipients[%d]", i), msg: err.Error()})
+		}
+	}
+	return issues
+}
+
+// validateSources checks that each enabled component has sources to build or
+// render from, in the default section and in every environment. Source roots
+// can differ per environment, so each is checked as the commands would
+// resolve it, and a problem shared by several environments is reported once.
+func validateSources(p types.Project) []issue {
+	var issues []issue
+	seen := make(map[issue]bool)
+	add := func(is issue) {
+		if !seen[is] {
+			seen[is] = true
+			issues = append(issues, is)
+		}
+	}
+	envs := []string{""}
+	envs = append(envs, sortedEnvNames(p)...)
+	for _, name := range envs {
+		e := p.GetEnv(name)
+		for i, binary := range p.Build.Binaries {
+			if !slices.Contains(e.Binaries, binary.Name) {
+				continue
+			}
+			src := binary.Src
+			if src == "" {
+				src = filepath.Join(e.BinarySrc, binary.Name)
+			}
+			if !isDir(src) {
+				add(issue{path: fmt.Sprintf("build.binaries[%d]", i), msg: fmt.Sprintf("binary source %s does not exist", src)})
+			}
+		}
+		for i, image := range p.Build.Images {
+			if !slices.Contains(e.Images, image.Name) || image.BuildFrom != "" {
+				continue
+			}
+			src := image.BuildSrc
+			if src == "" {
+				src = filepath.Join(e.ImageBuildSrc, image.Name)
+			}
+			if _, err := os.Stat(filepath.Join(src, "Dockerfile")); err != nil {
+				add(issue{path: fmt.Sprintf("build.images[%d]", i), msg: fmt.Sprintf("image source %s has no Dockerfile", src)})
+			}
+		}
+		for i, config := range p.Generate.Configs {
+			if !slices.Contains(e.Configs, config.Name) {
+				continue
+			}
+			defaultSrc := filepath.Join(p.Default.ConfigSrc, config.Name)
+			envSrc := filepath.Join(e.ConfigSrc, config.Name)
+			if !isDir(defaultSrc) && !isDir(envSrc) {
+				add(issue{path: fmt.Sprintf("generate.configs[%d]", i), msg: fmt.Sprintf("config source %s does not exist", envSrc)})
+			}
+		}
+		for i, template := range p.Generate.Kubernetes {
+			if !slices.Contains(e.KubernetesTemplates, template.Name) {
+				continue
+			}
+			defaultSrc := filepath.Join(p.Default.KubernetesSrc, template.Name)
+			envSrc := filepath.Join(e.KubernetesSrc, template.Name)
+			if !isDir(defaultSrc) && !isDir(envSrc) {
+				add(issue{path: fmt.Sprintf("generate.kubernetes[%d]", i), msg: fmt.Sprintf("kubernetes source %s does not exist", envSrc)})
+			}
+		}
+	}
+	return issues
+}
+
+func isDir(path string) bool {
+	fi, err := os.Stat(path)
+	return err == nil && fi.IsDir()
+}
+
+// unknownKeys reports mapping keys with no matching yaml tag on t, each at
+// its line and column. The strict load of the other commands fails on the
+// first unknown key it meets, with no position to go by.
+func unknownKeys(node *yaml.Node, t reflect.Type, path string) []issue {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	var issues []issue
+	switch node.Kind {
+	case yaml.MappingNode:
+		switch t.Kind() {
+		case reflect.Struct:
+			fields := yamlFields(t)
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				key, value := node.Content[i].Value, node.Content[i+1]
+				if key == "<<" {
+					// a merge key splices its anchor's keys into this mapping
+					issues = append(issues, unknownKeys(value, t, path)...)
+					continue
+				}
+				sub := joinPath(path, key)
+				ft, ok := fields[key]
+				if !ok {
+					issues = append(issues, issue{path: sub, msg: fmt.Sprintf("unknown key %q", key)})
+					continue
+				}
+				issues = append(issues, unknownKeys(value, ft, sub)...)
+			}
+		case reflect.Map:
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				sub := joinPath(path, node.Content[i].Value)
+				issues = append(issues, unknownKeys(node.Content[i+1], t.Elem(), sub)...)
+			}
+		}
+	case yaml.SequenceNode:
+		if t.Kind() == reflect.Slice {
+			for i, item := range node.Content {
+				issues = append(issues, unknownKeys(item, t.Elem(), fmt.Sprintf("%s[%d]", path, i))...)
+			}
+		}
+	case yaml.AliasNode:
+		if node.Alias != nil {
+			issues = append(issues, unknownKeys(node.Alias, t, path)...)
+		}
+	}
+	return issues
+}
+
+func yamlFields(t reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("yaml"), ",")
+		if name == "" || name == "-" {
+			continue
+		}
+		fields[name] = f.Type
+	}
+	return fields
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// locate returns the position of the node at path, or of its nearest existing
+// ancestor when the path runs off the document.
+func locate(root *yaml.Node, path string) (int, int) {
+	if len(root.Content) == 0 {
+		return 1, 1
+	}
+	node := root.Content[0]
+	line, column := node.Line, node.Column
+	for _, segment := range splitPath(path) {
+		var next *yaml.Node
+		switch node.Kind {
+		case yaml.MappingNode:
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				if node.Content[i].Value == segment {
+					// point at the key, which is where the problem is written
+					line, column = node.Content[i].Line, node.Content[i].Column
+					next = node.Content[i+1]
+					break
+				}
+			}
+		case yaml.SequenceNode:
+			if i, err := strconv.Atoi(segment); err == nil && i >= 0 && i < len(node.Content) {
+				next = node.Content[i]
+				line, column = next.Line, next.Column
+			}
+		}
+		if next == nil {
+			break
+		}
+		if next.Kind == yaml.AliasNode && next.Alias != nil {
+			next = next.Alias
+		}
+		node = next
+	}
+	return line, column
+}
+
+// splitPath splits env.prod.binaries[0] into env, prod, binaries and 0.
+func splitPath(path string) []string {
+	var segments []string
+	for _, part := range strings.Split(path, ".") {
+		name, rest, _ := strings.Cut(part, "[")
+		if name != "" {
+			segments = append(segments, name)
+		}
+		for rest != "" {
+			var index string
+			index, rest, _ = strings.Cut(rest, "]")
+			segments = append(segments, index)
+			rest = strings.TrimPrefix(rest, "[")
+		}
+	}
+	return segments
+}
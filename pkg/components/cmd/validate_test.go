@@ -0,0 +1,479 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.uber.org/config"
+	"gopkg.in/yaml.v3"
+
+	"github.com/xhanio/gopro/pkg/types"
+)
+
+// loadForValidate loads body the way the root command does for validate,
+// tolerating unknown keys, and parses it again for positions.
+func loadForValidate(t *testing.T, body string) (types.Project, *yaml.Node) {
+	t.Helper()
+	dir := t.TempDir()
+	t.Chdir(dir)
+	conf := filepath.Join(dir, "project.yaml")
+	if err := os.WriteFile(conf, []byte(body), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	provider, err := config.NewYAML(config.File(conf), config.Permissive())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var p types.Project
+	if err := provider.Get(config.Root).Populate(&p); err != nil {
+		t.Fatal(err)
+	}
+	var root yaml.Node
+	if err := yaml.Unmarshal([]byte(body), &root); err != nil {
+		t.Fatal(err)
+	}
+	return p, &root
+}
+
+func findIssue(issues []issue, path string) (issue, bool) {
+	for _, is := range issues {
+		if is.path == path {
+			return is, true
+		}
+	}
+	return issue{}, false
+}
+
+func TestValidateFlagsUndefinedNames(t *testing.T) {
+	p, root := loadForValidate(t, `product: demo
+module: demo.test/demo
+default:
+  binaries: [api]
+env:
+  prod:
+    binaries: [apii]
+    images: [api]
+    configs: [api]
+    kubernetes_templates: [api]
+build:
+  binaries:
+    - name: api
+`)
+	issues := validateProject(p, root, nil)
+	for _, path := range []string{
+		"env.prod.binaries[0]",
+		"env.prod.images[0]",
+		"env.prod.configs[0]",
+		"env.prod.kubernetes_templates[0]",
+	} {
+		if _, ok := findIssue(issues, path); !ok {
+			t.Errorf("no issue reported at %s: %+v", path, issues)
+		}
+	}
+	if is, ok := findIssue(issues, "default.binaries[0]"); ok {
+		t.Errorf("defined binary flagged: %+v", is)
+	}
+	line, column := locate(root, "env.prod.binaries[0]")
+	if line != 7 || column != 16 {
+		t.Errorf("env.prod.binaries[0] located at %d:%d, want 7:16", line, column)
+	}
+}
+
+func TestValidateFlagsUnresolvedImageBase(t *testing.T) {
+	p, root := loadForValidate(t, `product: demo
+module: demo.test/demo
+build:
+  images:
+    - name: base
+      build_from: alpine
+    - name: api
+      base: $base
+    - name: worker
+      base: $nonexistent
+    - name: loop
+      base: $loop
+`)
+	issues := validateProject(p, root, nil)
+	if _, ok := findIssue(issues, "build.images[1].base"); ok {
+		t.Error("resolvable $base flagged")
+	}
+	if _, ok := findIssue(issues, "build.images[2].base"); !ok {
+		t.Errorf("$nonexistent not flagged: %+v", issues)
+	}
+	if is, ok := findIssue(issues, "build.images"); !ok || !strings.Contains(is.msg, "loop -> loop") {
+		t.Errorf("self-referencing base not flagged as a cycle: %+v", issues)
+	}
+}
+
+func TestValidateFlagsPlatforms(t *testing.T) {
+	p, root := loadForValidate(t, `product: demo
+module: demo.test/demo
+build:
+  binaries:
+    - name: api
+      platform: [linux/amd64, linux]
+      platforms:
+        - name: plan9/sparc
+  images:
+    - name: api
+      platforms: [linux/amd64, linux/arm/v7, linux/amd64/]
+`)
+	issues := validateProject(p, root, []string{"linux/amd64", "linux/arm", "darwin/arm64"})
+	if _, ok := findIssue(issues, "build.binaries[0].platform[0]"); ok {
+		t.Error("known platform flagged")
+	}
+	if _, ok := findIssue(issues, "build.binaries[0].platform[1]"); !ok {
+		t.Errorf("malformed platform not flagged: %+v", issues)
+	}
+	if _, ok := findIssue(issues, "build.binaries[0].platforms[0].name"); !ok {
+		t.Errorf("unsupported platform not flagged: %+v", issues)
+	}
+	for _, path := range []string{"build.images[0].platforms[0]", "build.images[0].platforms[1]"} {
+		if is, ok := findIssue(issues, path); ok {
+			t.Errorf("valid image platform flagged: %+v", is)
+		}
+	}
+	if _, ok := findIssue(issues, "build.images[0].platforms[2]"); !ok {
+		t.Errorf("malformed image platform not flagged: %+v", issues)
+	}
+}
+
+func TestValidateFlagsUnknownKeys(t *testing.T) {
+	p, root := loadForValidate(t, `product: demo
+module: demo.test/demo
+defaults:
+  binaries: [api]
+env:
+  prod:
+    image_prefx: reg.io
+build:
+  binaries:
+    - name: api
+      platforms:
+        - name: linux/amd64
+          envs: [CGO_ENABLED=1]
+`)
+	issues := validateProject(p, root, nil)
+	for _, path := range []string{
+		"defaults",
+		"env.prod.image_prefx",
+		"build.binaries[0].platforms[0].envs",
+	} {
+		is, ok := findIssue(issues, path)
+		if !ok {
+			t.Errorf("unknown key %s not flagged: %+v", path, issues)
+			continue
+		}
+		if !strings.Contains(is.msg, "unknown key") {
+			t.Errorf("%s: %s", path, is.msg)
+		}
+	}
+	if line, _ := locate(root, "env.prod.image_prefx"); line != 7 {
+		t.Errorf("env.prod.image_prefx located on line %d, want 7", line)
+	}
+}
+
+func TestValidateFlagsMissingSources(t *testing.T) {
+	p, root := loadForValidate(t, `product: demo
+module: demo.test/demo
+default:
+  binary_src: build/binary
+  image_build_src: build/image
+  config_src: env/default/config
+  binaries: [api, worker]
+  images: [api]
+  configs: [api]
+build:
+  binaries:
+    - name: api
+    - name: worker
+  images:
+    - name: api
+generate:
+  configs:
+    - name: api
+`)
+	if err := os.MkdirAll(filepath.Join("build", "binary", "api"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	issues := validateProject(p, root, nil)
+	if _, ok := findIssue(issues, "build.binaries[0]"); ok {
+		t.Error("existing binary source flagged")
+	}
+	for _, path := range []string{"build.binaries[1]", "build.images[0]", "generate.configs[0]"} {
+		if _, ok := findIssue(issues, path); !ok {
+			t.Errorf("missing source for %s not flagged: %+v", path, issues)
+		}
+	}
+}
+
+func TestValidateCleanProject(t *testing.T) {
+	p, root := loadForValidate(t, `product: demo
+module: demo.test/demo
+default: &default
+  binaries: [api]
+env:
+  prod:
+    <<: *default
+build:
+  binaries:
+    - name: api
+      src: .
+`)
+	if issues := validateProject(p, root, nil); len(issues) != 0 {
+		t.Errorf("unexpected issues: %+v", issues)
+	}
+}
+
+func TestValidateFlagsUnknownCacheType(t *testing.T) {
+	p, root := loadForValidate(t, `product: demo
+module: demo.test/demo
+env:
+  prod:
+    image_cache:
+      type: registry
+build:
+  images:
+    - name: api
+      build_from: alpine
+      cache:
+        type: s3
+`)
+	issues := validateProject(p, root, nil)
+	if _, ok := findIssue(issues, "env.prod.image_cache.type"); ok {
+		t.Error("known cache type flagged")
+	}
+	if _, ok := findIssue(issues, "build.images[0].cache.type"); !ok {
+		t.Errorf("unknown cache type not flagged: %+v", issues)
+	}
+}
+
+func TestValidateFlagsUnknownBuildManifestFormat(t *testing.T) {
+	p, root := loadForValidate(t, `product: demo
+module: demo.test/demo
+default:
+  build_manifest:
+    format: slsa
+env:
+  prod:
+    build_manifest:
+      format: spdx
+`)
+	issues := validateProject(p, root, nil)
+	if _, ok := findIssue(issues, "default.build_manifest.format"); ok {
+		t.Error("known format flagged")
+	}
+	if _, ok := findIssue(issues, "env.prod.build_manifest.format"); !ok {
+		t.Errorf("unknown format not flagged: %+v", issues)
+	}
+}
+
+func TestValidateFlagsUnknownCoverMode(t *testing.T) {
+	p, root := loadForValidate(t, `product: demo
+module: demo.test/demo
+default:
+  test:
+    cover: atomic
+env:
+  ci:
+    test:
+      cover: branch
+`)
+	issues := validateProject(p, root, nil)
+	if _, ok := findIssue(issues, "default.test.cover"); ok {
+		t.Error("known mode flagged")
+	}
+	if _, ok := findIssue(issues, "env.ci.test.cover"); !ok {
+		t.Errorf("unknown mode not flagged: %+v", issues)
+	}
+}
+
+func TestValidateFlagsReleaseSettings(t *testing.T) {
+	p, root := loadForValidate(t, `product: demo
+module: demo.test/demo
+release:
+  name_template: "[[ .Product ]_[[ .Os ]]"
+  format: zip
+  format_overrides:
+    - goos: linux
+      format: rar
+`)
+	issues := validateProject(p, root, nil)
+	for _, path := range []string{"release.name_template", "release.format_overrides[0].format"} {
+		if _, ok := findIssue(issues, path); !ok {
+			t.Errorf("%s not flagged: %+v", path, issues)
+		}
+	}
+	if _, ok := findIssue(issues, "release.format"); ok {
+		t.Error("known format flagged")
+	}
+}
+
+func TestValidateFlagsPackageSettings(t *testing.T) {
+	p, root := loadForValidate(t, `product: demo
+module: demo.test/demo
+build:
+  binaries:
+    - name: api
+  packages:
+    - name: api
+      formats: [deb, msi]
+    - name: worker
+default:
+  packages: [api, cli]
+`)
+	issues := validateProject(p, root, nil)
+	for _, path := range []string{"build.packages[0].formats[1]", "build.packages[1].name", "default.packages[1]"} {
+		if _, ok := findIssue(issues, path); !ok {
+			t.Errorf("%s not flagged: %+v", path, issues)
+		}
+	}
+	for _, path := range []string{"build.packages[0].formats[0]", "build.packages[0].name", "default.packages[0]"} {
+		if _, ok := findIssue(issues, path); ok {
+			t.Errorf("%s flagged", path)
+		}
+	}
+}
+
+func TestValidateFlagsUnknownSBOMFormats(t *testing.T) {
+	p, root := loadForValidate(t, `product: demo
+module: demo.test/demo
+build:
+  binaries:
+    - name: api
+      sbom: [spdx, swid]
+  images:
+    - name: api
+      sbom: [cyclonedx, spdx-tv]
+`)
+	issues := validateProject(p, root, nil)
+	for _, path := range []string{"build.binaries[0].sbom[1]", "build.images[0].sbom[1]"} {
+		if _, ok := findIssue(issues, path); !ok {
+			t.Errorf("%s not flagged: %+v", path, issues)
+		}
+	}
+	for _, path := range []string{"build.binaries[0].sbom[0]", "build.images[0].sbom[0]"} {
+		if _, ok := findIssue(issues, path); ok {
+			t.Errorf("%s flagged", path)
+		}
+	}
+}
+
+func TestValidateFlagsUnparsablePublicKey(t *testing.T) {
+	p, root := loadForValidate(t, `product: demo
+module: demo.test/demo
+signing:
+  public_key: RWQnotakey
+`)
+	if _, ok := findIssue(validateProject(p, root, nil), "signing.public_key"); !ok {
+		t.Error("signing.public_key not flagged")
+	}
+}
+
+func TestValidateFlagsUnknownImageEngine(t *testing.T) {
+	p, root := loadForValidate(t, `product: demo
+module: demo.test/demo
+default:
+  image_engine: podman
+env:
+  prod:
+    image_engine: buildah
+`)
+	issues := validateProject(p, root, nil)
+	if _, ok := findIssue(issues, "default.image_engine"); ok {
+		t.Error("known engine flagged")
+	}
+	if _, ok := findIssue(issues, "env.prod.image_engine"); !ok {
+		t.Errorf("unknown engine not flagged: %+v", issues)
+	}
+}
+
+func TestValidateFlagsSecretProviders(t *testing.T) {
+	p, root := loadForValidate(t, `product: demo
+module: demo.test/demo
+secrets:
+  default: vault
+  providers:
+    - name: ci
+      type: env
+    - name: ci
+      type: exec
+    - name: sealed
+      type: kms
+  recipients: [age1notarecipient]
+`)
+	issues := validateProject(p, root, nil)
+	for _, path := range []string{
+		"secrets.default",
+		"secrets.providers[1].name",
+		"secrets.providers[1].command",
+		"secrets.providers[2].type",
+		"secrets.recipients[0]",
+	} {
+		if _, ok := findIssue(issues, path); !ok {
+			t.Errorf("%s not flagged: %+v", path, issues)
+		}
+	}
+	if _, ok := findIssue(issues, "secrets.providers[0].name"); ok {
+		t.Error("first ci flagged")
+	}
+}
+
+func TestValidateFlagsKubernetes(t *testing.T) {
+	p, root := loadForValidate(t, `product: demo
+module: demo.test/demo
+default:
+  kubernetes_version: "1.30"
+env:
+  old:
+    kubernetes_version: "1.12"
+build:
+  binaries:
+    - name: api
+generate:
+  kubernetes:
+    - name: api
+      output: helm
+    - name: web
+      output: chart
+      binary: web
+`)
+	issues := validateProject(p, root, nil)
+	for _, path := range []string{"env.old.kubernetes_version", "generate.kubernetes[1].output", "generate.kubernetes[1].binary"} {
+		if _, ok := findIssue(issues, path); !ok {
+			t.Errorf("%s not flagged: %+v", path, issues)
+		}
+	}
+	for _, path := range []string{"default.kubernetes_version", "generate.kubernetes[0].output"} {
+		if _, ok := findIssue(issues, path); ok {
+			t.Errorf("%s flagged", path)
+		}
+	}
+}
+
+func TestValidateFlagsConfigSchemas(t *testing.T) {
+	p, root := loadForValidate(t, `product: demo
+module: demo.test/demo
+generate:
+  configs:
+    - name: api
+      schema: schemas/api.yaml
+    - name: web
+      schema: schemas/web.json
+    - name: worker
+      schema: schemas/missing.json
+`)
+	writeTree(t, "schemas", "api.yaml", "type: object\nproperties:\n  port: {type: integer}\n")
+	writeTree(t, "schemas", "web.json", `{"$ref": "#/definitions/nope"}`)
+	issues := validateProject(p, root, nil)
+	for _, path := range []string{"generate.configs[1].schema", "generate.configs[2].schema"} {
+		if _, ok := findIssue(issues, path); !ok {
+			t.Errorf("%s not flagged: %+v", path, issues)
+		}
+	}
+	if is, ok := findIssue(issues, "generate.configs[0].schema"); ok {
+		t.Errorf("valid schema flagged: %s", is.msg)
+	}
+}
@@ -0,0 +1,107 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/xhanio/errors"
+
+	"github.com/xhanio/gopro/pkg/utils/signutil"
+)
+
+var verifyKey string
+
+func NewVerifyCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "verify [file...]",
+		Short: "Check the signatures of files against the project's public key",
+		RunE:  runVerify,
+	}
+	cmd.Flags().StringVarP(&verifyKey, "key", "", "", "public key file to verify against (default signing.public_key)")
+	return cmd
+}
+
+// runVerify checks the signature beside each file given, or, without any,
+// beside every build of the selected binaries found in binary_tgt. Every
+// file is checked before failing on those that did not verify.
+func runVerify(cmd *cobra.Command, args []string) error {
+	public := project.Signing.PublicKey
+	source := "signing.public_key"
+	if verifyKey != "" {
+		b, err := os.ReadFile(verifyKey)
+		if err != nil {
+			return err
+		}
+		public, source = string(b), verifyKey
+	}
+	if public == "" {
+		return errors.Newf("no public key to verify against, set signing.public_key or pass --key")
+	}
+	verifier, err := signutil.ParsePublicKey(public)
+	if err != nil {
+		return errors.Newf("%s: %s", source, err)
+	}
+	files := args
+	if len(files) == 0 {
+		files = binaryBuilds()
+	}
+	if len(files) == 0 {
+		warnf("no binaries built, nothing to verify")
+		return nil
+	}
+	titlef("Verify %d files against key %s", len(files), verifier.ID())
+	var failed []string
+	for _, file := range files {
+		if err := verifyFile(verifier, file); err != nil {
+			warnf("%s: %s", file, err)
+			failed = append(failed, file)
+			continue
+		}
+		linef("%s: ok", file)
+	}
+	if len(failed) > 0 {
+		return errors.Newf("%d of %d files failed to verify: %s", len(failed), len(files), strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// verifyFile checks the signature beside file.
+func verifyFile(v signutil.Verifier, file string) error {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return err
+	}
+	sig, err := os.ReadFile(file + v.Ext())
+	if os.IsNotExist(err) {
+		return errors.Newf("not signed, %s is missing", file+v.Ext())
+	}
+	if err != nil {
+		return err
+	}
+	return v.Verify(data, sig)
+}
+
+// binaryBuilds returns the builds of the selected binaries found in
+// binary_tgt: the host build and the {name}_{os}_{arch} build of each
+// platform.
+func binaryBuilds() []string {
+	var files []string
+	for _, binary := range selectedBinaries() {
+		names := []string{binary.Name}
+		for _, platform := range binary.GetPlatforms() {
+			goos, goarch, _ := strings.Cut(platform.Name, "/")
+			names = append(names, fmt.Sprintf("%s_%s_%s", binary.Name, goos, goarch))
+		}
+		for _, name := range names {
+			file := filepath.Join(env.BinaryTgt, name)
+			if _, err := os.Stat(file); err == nil {
+				files = append(files, file)
+			}
+		}
+	}
+	return files
+}
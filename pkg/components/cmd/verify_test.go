@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/xhanio/gopro/pkg/types"
+)
+
+// withSigningKey writes an ed25519 private key where $GOPRO_SIGNING_KEY
+// points, turns --sign on, and returns the public key.
+func withSigningKey(t *testing.T) string {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := filepath.Join(t.TempDir(), "signing.key")
+	if err := os.WriteFile(key, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GOPRO_SIGNING_KEY", key)
+	oldSign, oldSigner, oldKey := signArtifacts, signer, verifyKey
+	t.Cleanup(func() { signArtifacts, signer, verifyKey = oldSign, oldSigner, oldKey })
+	signArtifacts, verifyKey = true, ""
+	der, err = x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+}
+
+func TestBuildBinarySignsWhatVerifyChecks(t *testing.T) {
+	withGoModule(t)
+	withManifestFlags(t)
+	public := withSigningKey(t)
+	e := types.EnvSpec{BinaryTgt: "bin", Binaries: []string{"api"}}
+	withProject(t, types.Project{
+		Build:   types.BuildSpec{Binaries: []types.BinarySpec{{Name: "api", Src: "cmd/api"}}},
+		Signing: types.SigningSpec{PublicKey: public},
+	}, e)
+	if err := runBuildBinary(nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	output := filepath.Join("bin", "api")
+	m, err := readManifest(filepath.Join("dist", "build-manifest.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.Binaries) != 1 || m.Binaries[0].Signature != output+".sig" {
+		t.Fatalf("manifest binaries = %+v", m.Binaries)
+	}
+	if err := runVerify(nil, nil); err != nil {
+		t.Fatalf("verify: %s", err)
+	}
+
+	if err := os.WriteFile(output, []byte("tampered"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	err = runVerify(nil, []string{output})
+	if err == nil || !strings.Contains(err.Error(), "1 of 1") {
+		t.Errorf("err = %v, want the tampered binary rejected", err)
+	}
+}
+
+func TestSignNeedsTheKey(t *testing.T) {
+	withGoModule(t)
+	withManifestFlags(t)
+	withSigningKey(t)
+	t.Setenv("GOPRO_SIGNING_KEY", "")
+	withProject(t, types.Project{}, types.EnvSpec{})
+	err := runBuildBinary(nil, nil)
+	if err == nil || !strings.Contains(err.Error(), "$GOPRO_SIGNING_KEY") {
+		t.Errorf("err = %v, want the missing key env", err)
+	}
+}
+
+func TestReleaseSignsTheChecksums(t *testing.T) {
+	withRelease(t, types.ReleaseSpec{})
+	project.Signing.PublicKey = withSigningKey(t)
+	if err := runRelease(nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	checksums := filepath.Join("dist", "release", "v1.0.0", "SHA256SUMS")
+	if err := runVerify(nil, []string{checksums}); err != nil {
+		t.Errorf("verify: %s", err)
+	}
+	if err := runVerify(nil, []string{filepath.Join("bin", "cli")}); err == nil {
+		t.Error("verified an unsigned file")
+	}
+}
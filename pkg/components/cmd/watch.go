@@ -0,0 +1,338 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/xhanio/errors"
+
+	"github.com/xhanio/gopro/pkg/types"
+	"github.com/xhanio/gopro/pkg/utils/watchutil"
+)
+
+var (
+	watchInterval time.Duration
+	watchDebounce time.Duration
+	watchRun      string
+)
+
+func NewWatchCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "watch [-- args...]",
+		Short: "Rebuild binaries and regenerate output as their sources change",
+		RunE:  runWatch,
+	}
+	cmd.Flags().DurationVarP(&watchInterval, "interval", "", 500*time.Millisecond, "how often to look for changes")
+	cmd.Flags().DurationVarP(&watchDebounce, "debounce", "", 300*time.Millisecond, "how long changes must settle before acting on them")
+	cmd.Flags().StringVarP(&watchRun, "run", "", "", "run this binary's host build and restart it after each rebuild, with the args after --")
+	cmd.Flags().StringVarP(&binaryOutput, "output", "o", "", "build binary output dir")
+	cmd.Flags().StringVarP(&prefix, "prefix", "x", "template.", "generate files with given prefix")
+	cmd.Flags().BoolVarP(&strictRender, "strict", "", true, "fail on a missing map key instead of rendering <no value>")
+	return cmd
+}
+
+// watchStep is the build or generate step of one component, rerun when a
+// file it reads changes.
+type watchStep struct {
+	title string
+	// targets resolves what the step reads. It is resolved again after every
+	// run, as a binary's packages change with its imports.
+	targets func() ([]watchutil.Target, error)
+	run     func() error
+	// restarts is set on the steps of the binary --run keeps running: its
+	// build and its config.
+	restarts bool
+	// outputs are what the step writes, never watched: they may lie among
+	// the sources, as a config rendered in place does.
+	outputs []string
+
+	watched []watchutil.Target
+}
+
+// resolve refreshes what the step watches. A binary whose packages cannot
+// be listed, say for a syntax error, keeps watching what it did.
+func (s *watchStep) resolve() {
+	targets, err := s.targets()
+	if err != nil {
+		if s.watched == nil {
+			warnf("%s: %s", s.title, err)
+		}
+		return
+	}
+	s.watched = targets
+}
+
+// affectedBy reports whether any of the paths is read by the step.
+func (s *watchStep) affectedBy(paths []string) bool {
+	return slices.ContainsFunc(paths, func(path string) bool {
+		return slices.ContainsFunc(s.watched, func(t watchutil.Target) bool { return t.Contains(path) })
+	})
+}
+
+// runWatch brings every selected component up to date, then reruns the
+// build or generate step of each component a change touches, until
+// interrupted. Failures are reported and watching goes on, as the next
+// change may fix them.
+func runWatch(cmd *cobra.Command, args []string) error {
+	overwriteBuildInfo()
+	if binaryOutput == "" {
+		binaryOutput = env.BinaryTgt
+	}
+	// watching builds for the desk, not for the record
+	artifacts, signer = nil, nil
+	builds = loadFingerprints(binaryOutput)
+	steps := watchSteps()
+	if len(steps) == 0 {
+		warnf("no components selected, nothing to watch")
+		return nil
+	}
+	var r *runner
+	if watchRun != "" {
+		if !slices.ContainsFunc(selectedBinaries(), func(b types.BinarySpec) bool { return b.Name == watchRun }) {
+			return errors.Newf("--run %s is not a selected binary", watchRun)
+		}
+		r = &runner{path: filepath.Join(binaryOutput, watchRun), args: args}
+		defer r.stop()
+	}
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// the snapshot is taken before the steps run and never again, so a file
+	// saved while they do is still seen as changed
+	targets := watchTargets(steps)
+	since := watchutil.Scan(targets)
+	runWatchSteps(steps, r, true)
+	for {
+		// a step reads more or less once rerun, as a binary does with its
+		// imports
+		next := watchTargets(steps)
+		since, targets = since.Retarget(targets, next), next
+		titlef("Watching %d files, ctrl-c to stop", len(since))
+		var changed []string
+		var err error
+		changed, since, err = watchutil.Wait(ctx, targets, since, watchInterval, watchDebounce)
+		if err != nil {
+			return nil
+		}
+		if verbose {
+			debugf("changed: %s", strings.Join(changed, ", "))
+		}
+		if slices.Contains(changed, filepath.Clean(projectPath)) {
+			warnf("%s changed, restart watch to pick it up", projectPath)
+		}
+		var affected []*watchStep
+		for _, s := range steps {
+			if s.affectedBy(changed) {
+				affected = append(affected, s)
+			}
+		}
+		runWatchSteps(affected, r, false)
+	}
+}
+
+// watchTargets returns what the steps read and the project file, leaving out
+// what any of them writes.
+func watchTargets(steps []*watchStep) []watchutil.Target {
+	var outputs []string
+	for _, s := range steps {
+		outputs = append(outputs, s.outputs...)
+	}
+	targets := []watchutil.Target{{Path: projectPath}}
+	for _, s := range steps {
+		targets = append(targets, s.watched...)
+	}
+	for i := range targets {
+		targets[i].Exclude = outputs
+	}
+	return targets
+}
+
+// runWatchSteps runs steps, then restarts the binary --run keeps running if
+// one of its steps succeeded, or starts it on the first pass.
+func runWatchSteps(steps []*watchStep, r *runner, first bool) {
+	restart := first
+	for _, s := range steps {
+		if err := s.run(); err != nil {
+			warnf("%s failed: %s", s.title, err)
+		} else if s.restarts {
+			restart = true
+		}
+		s.resolve()
+	}
+	if err := builds.save(); err != nil {
+		warnf("%s", err)
+	}
+	if r != nil && restart {
+		if err := r.restart(); err != nil {
+			warnf("run %s: %s", r.path, err)
+		}
+	}
+}
+
+// watchSteps returns a step per selected binary, config and kubernetes
+// template, and one for docker-compose when it has sources. A binary is built
+// for the host only, from the packages go list says it compiles; a render
+// step reads both layers of its component.
+func watchSteps() []*watchStep {
+	var steps []*watchStep
+	for _, binary := range selectedBinaries() {
+		src := binarySource(binary)
+		steps = append(steps, &watchStep{
+			title: "binary " + binary.Name,
+			targets: func() ([]watchutil.Target, error) {
+				applyApplicationInfo(binary)
+				b, err := newBinaryBuild(binary, types.PlatformSpec{}, src, binaryOutput)
+				if err != nil {
+					return nil, err
+				}
+				return binaryTargets(b)
+			},
+			run: func() error {
+				titlef("Build Binary %s from %s", binary.Name, src)
+				applyApplicationInfo(binary)
+				return executeBuildBinary(binary, types.PlatformSpec{}, src, binaryOutput)
+			},
+			restarts: binary.Name == watchRun,
+			outputs:  []string{filepath.Join(binaryOutput, binary.Name)},
+		})
+	}
+	units := slices.Concat(configUnits(), kubernetesUnits())
+	compose := dockerComposeUnit()
+	if slices.ContainsFunc(compose.srcs, isDir) {
+		units = append(units, compose)
+	}
+	for _, unit := range units {
+		var targets []watchutil.Target
+		for _, src := range unit.srcs {
+			targets = append(targets, watchutil.Target{Path: src, Recursive: true})
+		}
+		s := &watchStep{
+			title:    unit.title,
+			targets:  func() ([]watchutil.Target, error) { return targets, nil },
+			restarts: unit.title == "config "+watchRun,
+		}
+		s.run = func() error {
+			if !dryRun {
+				if err := os.MkdirAll(unit.dst, 0755); err != nil {
+					return err
+				}
+			}
+			written = make(map[string]bool)
+			defer func() { written = nil }()
+			err := generate(unit)
+			s.outputs = renderOutputs(unit, written)
+			return err
+		}
+		s.outputs = renderOutputs(unit, nil)
+		steps = append(steps, s)
+	}
+	for _, s := range steps {
+		s.resolve()
+	}
+	return steps
+}
+
+// renderOutputs returns what a render step writes: its target, unless the
+// target overlaps its sources, as when rendering in place or into the
+// project root, and the files it wrote.
+func renderOutputs(unit renderUnit, files map[string]bool) []string {
+	var outputs []string
+	if in, err := inPlace(unit.dst, unit.srcs...); err == nil && !in {
+		outputs = append(outputs, unit.dst)
+	}
+	return append(outputs, sortedKeys(files)...)
+}
+
+// binaryTargets returns what a build reads: the directory of each package it
+// compiles from the main module or a module replaced by a local directory,
+// and their go.mod and go.sum.
+func binaryTargets(b *binaryBuild) ([]watchutil.Target, error) {
+	pkgs, err := b.packages()
+	if err != nil {
+		return nil, err
+	}
+	var targets []watchutil.Target
+	seen := make(map[string]bool)
+	add := func(path string) {
+		if !seen[path] {
+			seen[path] = true
+			targets = append(targets, watchutil.Target{Path: path})
+		}
+	}
+	for _, pkg := range pkgs {
+		if !pkg.local() {
+			continue
+		}
+		add(pkg.Dir)
+		if m := pkg.Module; m != nil && m.GoMod != "" {
+			add(m.GoMod)
+			add(filepath.Join(filepath.Dir(m.GoMod), "go.sum"))
+		}
+	}
+	return targets, nil
+}
+
+// runner keeps a binary running, restarting it on demand.
+type runner struct {
+	path string
+	args []string
+
+	cmd *exec.Cmd
+	// exited is closed once cmd has exited, stopping is closed before it is
+	// stopped on purpose
+	exited   chan struct{}
+	stopping chan struct{}
+}
+
+// restart stops the binary if it runs, and starts it again.
+func (r *runner) restart() error {
+	r.stop()
+	titlef("Run %s", r.path)
+	cmd := exec.Command(r.path, r.args...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	exited, stopping := make(chan struct{}), make(chan struct{})
+	go func() {
+		err := cmd.Wait()
+		close(exited)
+		select {
+		case <-stopping:
+		default:
+			if err != nil {
+				warnf("%s exited: %s", r.path, err)
+			} else {
+				linef("%s exited", r.path)
+			}
+		}
+	}()
+	r.cmd, r.exited, r.stopping = cmd, exited, stopping
+	return nil
+}
+
+// stop interrupts the binary and waits for it to exit, killing it if it does
+// not within five seconds.
+func (r *runner) stop() {
+	if r == nil || r.cmd == nil {
+		return
+	}
+	close(r.stopping)
+	r.cmd.Process.Signal(os.Interrupt)
+	select {
+	case <-r.exited:
+	case <-time.After(5 * time.Second):
+		r.cmd.Process.Kill()
+		<-r.exited
+	}
+	r.cmd = nil
+}
@@ -0,0 +1,85 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/xhanio/gopro/pkg/types"
+)
+
+func TestWatchStepsRerunOnlyWhatAChangeAffects(t *testing.T) {
+	withGoModule(t)
+	writeTree(t, "env/api", "template.app.yaml", "name: api\n")
+	e := types.EnvSpec{
+		BinaryTgt: "bin",
+		Binaries:  []string{"api"},
+		ConfigSrc: "env",
+		ConfigTgt: "out",
+		Configs:   []string{"api"},
+	}
+	withProject(t, types.Project{
+		Default: e,
+		Build:   types.BuildSpec{Binaries: []types.BinarySpec{{Name: "api", Src: "cmd/api"}}},
+		Generate: types.GenerateSpec{
+			Configs: []types.ConfigSpec{{Name: "api"}},
+		},
+	}, e)
+	binaryOutput = "bin"
+	builds = loadFingerprints(binaryOutput)
+	steps := watchSteps()
+	if len(steps) != 2 {
+		t.Fatalf("%d steps, want the binary and the config", len(steps))
+	}
+	binary, config := steps[0], steps[1]
+
+	root, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	watched := func(s *watchStep, path string) bool {
+		return s.affectedBy([]string{path})
+	}
+	for path, want := range map[string]bool{
+		filepath.Join(root, "cmd", "api", "main.go"): true,
+		filepath.Join(root, "lib", "lib.go"):         true,
+		filepath.Join(root, "go.mod"):                true,
+		filepath.Join(root, "go.sum"):                true,
+		filepath.Join(root, "other", "other.go"):     false,
+		filepath.Join("env", "api", "app.yaml"):      false,
+	} {
+		if got := watched(binary, path); got != want {
+			t.Errorf("binary step affected by %s = %v, want %v", path, got, want)
+		}
+	}
+	if !watched(config, filepath.Join("env", "api", "template.app.yaml")) || watched(config, filepath.Join(root, "lib", "lib.go")) {
+		t.Errorf("config step watches %+v", config.watched)
+	}
+
+	runWatchSteps(steps, nil, true)
+	for _, path := range []string{filepath.Join("bin", "api"), filepath.Join("out", "api", "app.yaml")} {
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("first pass: %s", err)
+		}
+	}
+	if want := []string{filepath.Join("out", "api"), filepath.Join("out", "api", "app.yaml")}; !slices.Equal(config.outputs, want) {
+		t.Errorf("config step writes %q, want %q", config.outputs, want)
+	}
+
+	// a new import widens what the binary step watches once it reruns
+	writeTree(t, "cmd/api", "main.go", "package main\n\nimport (\n\t\"example.com/app/lib\"\n\t\"example.com/app/other\"\n)\n\nvar _ = other.X\n\nfunc main() { println(lib.Name) }\n")
+	writeTree(t, "other", "other.go", "package other\n\nconst X = 1\n")
+	runWatchSteps([]*watchStep{binary}, nil, false)
+	if !watched(binary, filepath.Join(root, "other", "other.go")) {
+		t.Errorf("binary step still watches %+v", binary.watched)
+	}
+
+	// a change that does not compile keeps the step watching what it did
+	writeTree(t, "cmd/api", "main.go", "package main\n\nimport \"example.com/app/missing\"\n")
+	before := binary.watched
+	runWatchSteps([]*watchStep{binary}, nil, false)
+	if len(binary.watched) != len(before) {
+		t.Errorf("watched %+v after a failed build, want %+v", binary.watched, before)
+	}
+}
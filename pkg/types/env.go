@@ -13,21 +13,96 @@ type EnvSpec struct {
 	BinaryBuildEnv  []string `yaml:"binary_build_env,omitempty"`
 	BinaryBuildArgs []string `yaml:"binary_build_args,omitempty"`
 
-	ImageBuildSrc  string   `yaml:"image_build_src,omitempty"`
-	Images         []string `yaml:"images,omitempty"`
-	ImagePrefix    string   `yaml:"image_prefix,omitempty"`
-	ImageTag       string   `yaml:"image_tag,omitempty"`
-	ImageBuildEnv  []string `yaml:"image_build_env,omitempty"`
-	ImageBuildArgs []string `yaml:"image_build_args,omitempty"`
+	ImageBuildSrc  string    `yaml:"image_build_src,omitempty"`
+	Images         []string  `yaml:"images,omitempty"`
+	ImagePrefix    string    `yaml:"image_prefix,omitempty"`
+	ImageTag       string    `yaml:"image_tag,omitempty"`
+	ImageBuildEnv  []string  `yaml:"image_build_env,omitempty"`
+	ImageBuildArgs []string  `yaml:"image_build_args,omitempty"`
+	ImageCache     CacheSpec `yaml:"image_cache,omitempty"`
+	// ImageEngine is the container CLI images are built with: docker
+	// (default), podman or nerdctl.
+	ImageEngine ImageEngineType `yaml:"image_engine,omitempty"`
+	// BuildManifest is where build binary and build image record what they
+	// produced.
+	BuildManifest ManifestSpec `yaml:"build_manifest,omitempty"`
+	// SBOMTgt is where the SBOMs of images built by an engine are written;
+	// those of binaries and oci images are written beside them.
+	SBOMTgt string `yaml:"sbom_tgt,omitempty"`
+	// Test configures how gopro test runs go test, and TestTgt is where it
+	// writes the JUnit report and coverage profile.
+	Test    TestSpec `yaml:"test,omitempty"`
+	TestTgt string   `yaml:"test_tgt,omitempty"`
+
+	PackageTgt string   `yaml:"package_tgt,omitempty"`
+	Packages   []string `yaml:"packages,omitempty"`
 
 	KubernetesSrc       string   `yaml:"kubernetes_src,omitempty"`
 	KubernetesTgt       string   `yaml:"kubernetes_tgt,omitempty"`
 	KubernetesTemplates []string `yaml:"kubernetes_templates,omitempty"`
+	// KubernetesVersion is the cluster minor version, such as 1.30, that
+	// generate kubernetes --validate checks manifests against.
+	KubernetesVersion string `yaml:"kubernetes_version,omitempty"`
 
 	DockerComposeSrc string `yaml:"docker_compose_src,omitempty"`
 	DockerComposeTgt string `yaml:"docker_compose_tgt,omitempty"`
 }
 
+type ManifestSpec struct {
+	Path   string         `yaml:"path,omitempty"`
+	Format ManifestFormat `yaml:"format,omitempty"`
+}
+
+type TestSpec struct {
+	// Race runs the tests with the race detector.
+	Race bool `yaml:"race,omitempty"`
+	// Cover collects a coverage profile in this mode; none is collected when
+	// unset.
+	Cover CoverMode `yaml:"cover,omitempty"`
+	// Env is merged over the binary's build env, and Args are passed to go
+	// test after the flags gopro sets, e.g. [-short, -timeout, 5m].
+	Env  []string `yaml:"env,omitempty"`
+	Args []string `yaml:"args,omitempty"`
+}
+
+// GetBuildManifest resolves where and how the build manifest is written, by
+// default as dist/build-manifest.json in gopro's own format.
+func (e EnvSpec) GetBuildManifest() ManifestSpec {
+	m := e.BuildManifest
+	if m.Path == "" {
+		m.Path = "dist/build-manifest.json"
+	}
+	if m.Format == "" {
+		m.Format = ManifestFormatGopro
+	}
+	return m
+}
+
+// GetPackageTgt returns where Linux packages are written, by default
+// dist/packages.
+func (e EnvSpec) GetPackageTgt() string {
+	if e.PackageTgt != "" {
+		return e.PackageTgt
+	}
+	return "dist/packages"
+}
+
+// GetSBOMTgt returns where image SBOMs are written, by default dist/sbom.
+func (e EnvSpec) GetSBOMTgt() string {
+	if e.SBOMTgt != "" {
+		return e.SBOMTgt
+	}
+	return "dist/sbom"
+}
+
+// GetTestTgt returns where test reports are written, by default dist/test.
+func (e EnvSpec) GetTestTgt() string {
+	if e.TestTgt != "" {
+		return e.TestTgt
+	}
+	return "dist/test"
+}
+
 func (p *Project) GetEnv(env string) EnvSpec {
 	e, ok := p.Env[env]
 	if !ok {
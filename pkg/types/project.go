@@ -5,6 +5,7 @@ import (
 	"os"
 	"path"
 	"path/filepath"
+	"strings"
 
 	"go.uber.org/config"
 	"golang.org/x/mod/modfile"
@@ -23,6 +24,104 @@ var (
 	ResourceTypeDockerCompose = ResourceType("docker-compose")
 )
 
+type ImageCacheType string
+
+var (
+	// ImageCacheNone builds without any cache, as --no-cache.
+	ImageCacheNone = ImageCacheType("none")
+	// ImageCacheLocal uses the engine's own layer cache.
+	ImageCacheLocal = ImageCacheType("local")
+	// ImageCacheRegistry imports cache from an image in a registry, and
+	// exports it inline into the image pushed.
+	ImageCacheRegistry = ImageCacheType("registry")
+	// ImageCacheDir imports and exports a buildx cache in a local directory.
+	ImageCacheDir = ImageCacheType("dir")
+)
+
+type ImageEngineType string
+
+var (
+	ImageEngineDocker  = ImageEngineType("docker")
+	ImageEnginePodman  = ImageEngineType("podman")
+	ImageEngineNerdctl = ImageEngineType("nerdctl")
+	// ImageEngineDryRun prints the commands of the configured engine
+	// instead of running them.
+	ImageEngineDryRun = ImageEngineType("dry-run")
+)
+
+type ManifestFormat string
+
+var (
+	ManifestFormatGopro = ManifestFormat("gopro")
+	// ManifestFormatSLSA writes the manifest as an in-toto statement with a
+	// SLSA provenance predicate, ready to be signed as an attestation.
+	ManifestFormatSLSA = ManifestFormat("slsa")
+)
+
+type SBOMFormat string
+
+var (
+	SBOMFormatSPDX      = SBOMFormat("spdx")
+	SBOMFormatCycloneDX = SBOMFormat("cyclonedx")
+)
+
+type CoverMode string
+
+var (
+	CoverModeSet    = CoverMode("set")
+	CoverModeCount  = CoverMode("count")
+	CoverModeAtomic = CoverMode("atomic")
+)
+
+type SecretProviderType string
+
+var (
+	// SecretProviderFile reads the plaintext secret.env of a component.
+	SecretProviderFile = SecretProviderType("file")
+	// SecretProviderAge decrypts the secret.env.age of a component, an age
+	// encrypted secret.env committed beside its configs.
+	SecretProviderAge = SecretProviderType("age")
+	// SecretProviderEnv reads gopro's own environment variables.
+	SecretProviderEnv = SecretProviderType("env")
+	// SecretProviderExec runs a command that prints the secret.
+	SecretProviderExec = SecretProviderType("exec")
+)
+
+type KubernetesOutput string
+
+var (
+	// KubernetesOutputManifests writes the rendered templates as they are.
+	KubernetesOutputManifests = KubernetesOutput("manifests")
+	// KubernetesOutputHelm packages them as the templates of a Helm chart.
+	KubernetesOutputHelm = KubernetesOutput("helm")
+	// KubernetesOutputKustomize writes the default layer as a kustomize base
+	// and what the environment changes as its overlay.
+	KubernetesOutputKustomize = KubernetesOutput("kustomize")
+)
+
+type ImageBuildMode string
+
+var (
+	// ImageBuildModeOCI assembles the image in-process from a base layout and
+	// the cross-compiled binary, with no container engine involved.
+	ImageBuildModeOCI = ImageBuildMode("oci")
+)
+
+type ArchiveFormat string
+
+var (
+	ArchiveFormatTarGz = ArchiveFormat("tar.gz")
+	ArchiveFormatZip   = ArchiveFormat("zip")
+)
+
+type PackageFormat string
+
+var (
+	PackageFormatDeb = PackageFormat("deb")
+	PackageFormatRPM = PackageFormat("rpm")
+	PackageFormatAPK = PackageFormat("apk")
+)
+
 type Project struct {
 	Product  string             `yaml:"product"`
 	Model    string             `yaml:"model"`
@@ -33,6 +132,9 @@ type Project struct {
 	Env      map[string]EnvSpec `yaml:"env"`
 	Build    BuildSpec          `yaml:"build"`
 	Generate GenerateSpec       `yaml:"generate"`
+	Release  ReleaseSpec        `yaml:"release,omitempty"`
+	Signing  SigningSpec        `yaml:"signing,omitempty"`
+	Secrets  SecretsSpec        `yaml:"secrets,omitempty"`
 }
 
 func (p *Project) Load(confPath string) error {
@@ -55,8 +157,9 @@ func (p *Project) Load(confPath string) error {
 }
 
 type BuildSpec struct {
-	Binaries []BinarySpec `yaml:"binaries"`
-	Images   []ImageSpec  `yaml:"images"`
+	Binaries []BinarySpec  `yaml:"binaries"`
+	Images   []ImageSpec   `yaml:"images"`
+	Packages []PackageSpec `yaml:"packages,omitempty"`
 }
 
 type BinarySpec struct {
@@ -72,6 +175,8 @@ type BinarySpec struct {
 	BuildEnv  []string       `yaml:"build_env,omitempty"`
 	BuildArgs []string       `yaml:"build_args,omitempty"`
 	ConfigDir string         `yaml:"config_dir,omitempty"`
+	// SBOM lists the SBOM formats written beside each build, none by default.
+	SBOM []SBOMFormat `yaml:"sbom,omitempty"`
 }
 
 type PlatformSpec struct {
@@ -111,10 +216,145 @@ type ImageSpec struct {
 	Base      string `yaml:"base,omitempty"`
 	BuildSrc  string `yaml:"build_src,omitempty"`
 	BuildFrom string `yaml:"build_from,omitempty"`
-	Prefix    string `yaml:"prefix,omitempty"`
-	Repo      string `yaml:"repo,omitempty"`
-	Tag       string `yaml:"tag,omitempty"`
-	NoPush    bool   `yaml:"no_push,omitempty"`
+	// BuildArgs are KEY=VALUE docker build args, merged over the env's
+	// image_build_args.
+	BuildArgs []string `yaml:"build_args,omitempty"`
+	// Platforms, as os/arch[/variant], builds a multi-platform image with
+	// docker buildx instead of a single-platform docker build.
+	Platforms []string  `yaml:"platforms,omitempty"`
+	Cache     CacheSpec `yaml:"cache,omitempty"`
+	// BuildMode oci assembles the image without a container engine, as
+	// configured by OCI; unset, the image builds from its Dockerfile.
+	BuildMode ImageBuildMode `yaml:"build_mode,omitempty"`
+	OCI       OCISpec        `yaml:"oci,omitempty"`
+	Prefix    string         `yaml:"prefix,omitempty"`
+	Repo      string         `yaml:"repo,omitempty"`
+	Tag       string         `yaml:"tag,omitempty"`
+	NoPush    bool           `yaml:"no_push,omitempty"`
+	// SBOM lists the SBOM formats written for the image, none by default.
+	SBOM []SBOMFormat `yaml:"sbom,omitempty"`
+}
+
+type OCISpec struct {
+	// Output is the image written: a tarball when it ends in .tar, an OCI
+	// image layout directory otherwise.
+	Output string `yaml:"output,omitempty"`
+	// BinaryPath is where the binary is installed in the image.
+	BinaryPath string            `yaml:"binary_path,omitempty"`
+	Entrypoint []string          `yaml:"entrypoint,omitempty"`
+	Labels     map[string]string `yaml:"labels,omitempty"`
+}
+
+// PackageSpec is a Linux package of a binary of build.binaries, built for
+// each of its linux platforms.
+type PackageSpec struct {
+	// Name is the binary packaged, and the name of the package.
+	Name string `yaml:"name"`
+	// Formats lists the package formats built, by default deb and rpm.
+	Formats []PackageFormat `yaml:"formats,omitempty"`
+	// Release is the package revision of the binary's version.
+	Release     string `yaml:"release,omitempty"`
+	Maintainer  string `yaml:"maintainer,omitempty"`
+	Description string `yaml:"description,omitempty"`
+	Homepage    string `yaml:"homepage,omitempty"`
+	License     string `yaml:"license,omitempty"`
+	// Depends are the packages it needs installed, as each distribution
+	// names them.
+	Depends []string `yaml:"depends,omitempty"`
+	// BinDir is where the binary is installed.
+	BinDir  string      `yaml:"bin_dir,omitempty"`
+	Systemd SystemdSpec `yaml:"systemd,omitempty"`
+	Scripts ScriptsSpec `yaml:"scripts,omitempty"`
+}
+
+// SystemdSpec configures the systemd unit generated for a package.
+type SystemdSpec struct {
+	// Disabled leaves the unit out of the package.
+	Disabled bool `yaml:"disabled,omitempty"`
+	// Args are passed to the binary by ExecStart.
+	Args        []string `yaml:"args,omitempty"`
+	User        string   `yaml:"user,omitempty"`
+	Environment []string `yaml:"environment,omitempty"`
+}
+
+// ScriptsSpec names the maintainer scripts of a package, as paths relative to
+// the project root.
+type ScriptsSpec struct {
+	PreInstall  string `yaml:"pre_install,omitempty"`
+	PostInstall string `yaml:"post_install,omitempty"`
+	PreRemove   string `yaml:"pre_remove,omitempty"`
+	PostRemove  string `yaml:"post_remove,omitempty"`
+}
+
+// GetFormats returns the formats a package is built in, by default deb and
+// rpm.
+func (p PackageSpec) GetFormats() []PackageFormat {
+	if len(p.Formats) > 0 {
+		return p.Formats
+	}
+	return []PackageFormat{PackageFormatDeb, PackageFormatRPM}
+}
+
+// GetRelease returns the package revision, by default 1.
+func (p PackageSpec) GetRelease() string {
+	if p.Release != "" {
+		return p.Release
+	}
+	return "1"
+}
+
+// GetBinDir returns where the binary is installed, by default /usr/bin.
+func (p PackageSpec) GetBinDir() string {
+	if p.BinDir != "" {
+		return p.BinDir
+	}
+	return "/usr/bin"
+}
+
+type CacheSpec struct {
+	Type ImageCacheType `yaml:"type,omitempty"`
+	// Ref is the image a registry cache imports from; unset, the image's own
+	// reference, so each build reuses the cache of the one pushed before it.
+	Ref string `yaml:"ref,omitempty"`
+	// Dir is the root of a dir cache, holding one subdirectory per image.
+	Dir string `yaml:"dir,omitempty"`
+}
+
+// GetCache resolves the build cache for an image: its own cache, falling back
+// to the environment's image_cache, then to the engine's local layer cache.
+// The image's setting replaces the environment's wholesale rather than
+// merging, since a ref or dir means nothing to a different cache type.
+func (i ImageSpec) GetCache(env EnvSpec) CacheSpec {
+	if i.Cache.Type != "" {
+		return i.Cache
+	}
+	if env.ImageCache.Type != "" {
+		return env.ImageCache
+	}
+	return CacheSpec{Type: ImageCacheLocal}
+}
+
+// GetOCIOutput returns where an oci build_mode image is written, by default
+// a tarball under dist/oci.
+func (i ImageSpec) GetOCIOutput() string {
+	if i.OCI.Output != "" {
+		return i.OCI.Output
+	}
+	return path.Join("dist", "oci", i.Name+".tar")
+}
+
+// GetOCIBinaryPath returns where an oci build_mode image installs its binary.
+func (i ImageSpec) GetOCIBinaryPath() string {
+	if i.OCI.BinaryPath != "" {
+		return i.OCI.BinaryPath
+	}
+	return path.Join("/usr/local/bin", i.Name)
+}
+
+// BaseImage returns the name of the image this one is based on when its base
+// is a $name reference to another entry of build.images.
+func (i ImageSpec) BaseImage() (string, bool) {
+	return strings.CutPrefix(i.Base, "$")
 }
 
 // GetImageNameWithTag resolves the fully-qualified image reference for an
@@ -159,15 +399,181 @@ type ConfigSpec struct {
 	Name  string   `yaml:"name"`
 	Src   string   `yaml:"src,omitempty"`
 	Files []string `yaml:"files,omitempty"`
+	// Schema is a JSON Schema file, JSON or YAML, that every rendered YAML
+	// and JSON file of the config must match.
+	Schema string `yaml:"schema,omitempty"`
 }
 
 type KubernetesSpec struct {
 	Name  string   `yaml:"name"`
 	Src   string   `yaml:"src,omitempty"`
 	Files []string `yaml:"files,omitempty"`
+	// Output is how the rendered templates are laid out in the target: as
+	// they are, as a Helm chart, or as a kustomize base with an overlay per
+	// environment.
+	Output KubernetesOutput `yaml:"output,omitempty"`
+	// Binary is the binary of build.binaries whose version a Helm chart
+	// takes as its appVersion; unset, the binary of the same name.
+	Binary string `yaml:"binary,omitempty"`
+}
+
+// GetOutput returns the layout of the rendered templates, by default
+// manifests.
+func (k KubernetesSpec) GetOutput() KubernetesOutput {
+	if k.Output != "" {
+		return k.Output
+	}
+	return KubernetesOutputManifests
+}
+
+// GetBinary returns the binary a Helm chart is versioned by, by default the
+// one of the same name.
+func (k KubernetesSpec) GetBinary() string {
+	if k.Binary != "" {
+		return k.Binary
+	}
+	return k.Name
 }
 
 type DockerComposeSpec struct {
 	Src   string   `yaml:"src,omitempty"`
 	Files []string `yaml:"files,omitempty"`
 }
+
+// ReleaseSpec configures gopro release: how the cross-compiled binaries are
+// packed into one archive per platform, and where the archives go.
+type ReleaseSpec struct {
+	// Dir is where releases are laid out, one directory per version.
+	Dir string `yaml:"dir,omitempty"`
+	// NameTemplate names the archive of a platform, without its extension.
+	// It is rendered with [[ ]] delimiters from .Product, .Version, .Os and
+	// .Arch.
+	NameTemplate string        `yaml:"name_template,omitempty"`
+	Format       ArchiveFormat `yaml:"format,omitempty"`
+	// FormatOverrides packs the platforms of a GOOS in another format, such
+	// as zip for windows.
+	FormatOverrides []FormatOverride `yaml:"format_overrides,omitempty"`
+	// Files are packed into every archive beside the binaries, as paths or
+	// globs relative to the project root.
+	Files []string `yaml:"files,omitempty"`
+	// Checksums names the file listing the sha256 of every archive.
+	Checksums string `yaml:"checksums,omitempty"`
+}
+
+type FormatOverride struct {
+	Goos   string        `yaml:"goos"`
+	Format ArchiveFormat `yaml:"format"`
+}
+
+// GetDir returns where releases are laid out, by default dist/release.
+func (r ReleaseSpec) GetDir() string {
+	if r.Dir != "" {
+		return r.Dir
+	}
+	return path.Join("dist", "release")
+}
+
+// GetNameTemplate returns the archive name template, by default
+// product_version_os_arch.
+func (r ReleaseSpec) GetNameTemplate() string {
+	if r.NameTemplate != "" {
+		return r.NameTemplate
+	}
+	return "[[ .Product ]]_[[ .Version ]]_[[ .Os ]]_[[ .Arch ]]"
+}
+
+// GetFormat returns the archive format of a GOOS: its override, else the
+// release format, else tar.gz.
+func (r ReleaseSpec) GetFormat(goos string) ArchiveFormat {
+	for _, o := range r.FormatOverrides {
+		if o.Goos == goos {
+			return o.Format
+		}
+	}
+	if r.Format != "" {
+		return r.Format
+	}
+	return ArchiveFormatTarGz
+}
+
+// GetChecksums returns the name of the checksum file, by default SHA256SUMS.
+func (r ReleaseSpec) GetChecksums() string {
+	if r.Checksums != "" {
+		return r.Checksums
+	}
+	return "SHA256SUMS"
+}
+
+// SigningSpec is how artifacts are signed with --sign, and verified. The
+// private key stays out of project.yaml: its path, and the password of an
+// encrypted one, are read from env vars.
+type SigningSpec struct {
+	// PublicKey is what gopro verify checks signatures against: a minisign
+	// public key, or a PEM public key as cosign and openssl write it.
+	PublicKey string `yaml:"public_key,omitempty"`
+	// KeyEnv names the env var holding the path of the private key.
+	KeyEnv string `yaml:"key_env,omitempty"`
+	// PasswordEnv names the env var holding the password of the private key.
+	PasswordEnv string `yaml:"password_env,omitempty"`
+}
+
+// GetKeyEnv returns the env var naming the private key, by default
+// GOPRO_SIGNING_KEY.
+func (s SigningSpec) GetKeyEnv() string {
+	if s.KeyEnv != "" {
+		return s.KeyEnv
+	}
+	return "GOPRO_SIGNING_KEY"
+}
+
+// GetPasswordEnv returns the env var holding the password of the private
+// key, by default GOPRO_SIGNING_PASSWORD.
+func (s SigningSpec) GetPasswordEnv() string {
+	if s.PasswordEnv != "" {
+		return s.PasswordEnv
+	}
+	return "GOPRO_SIGNING_PASSWORD"
+}
+
+// SecretsSpec is where FromSecretEnv and FromSecret read secrets from. The
+// providers file, age and env are always there under their type's name;
+// Providers adds others, or configures those.
+type SecretsSpec struct {
+	// Default is the provider FromSecretEnv reads from.
+	Default string `yaml:"default,omitempty"`
+	// KeyEnv names the env var holding the path of the age identity file
+	// that decrypts secret.env.age.
+	KeyEnv string `yaml:"key_env,omitempty"`
+	// Recipients are the age1... public keys gopro secret encrypts
+	// secret.env.age to; unset, those of the identity file.
+	Recipients []string             `yaml:"recipients,omitempty"`
+	Providers  []SecretProviderSpec `yaml:"providers,omitempty"`
+}
+
+type SecretProviderSpec struct {
+	Name string             `yaml:"name"`
+	Type SecretProviderType `yaml:"type"`
+	// Command is the exec command and its args, in which ${NAME}, ${KEY} and
+	// ${ENV} expand to the component, the key and the environment.
+	Command []string `yaml:"command,omitempty"`
+	// Prefix is put before the variable names an env provider reads.
+	Prefix string `yaml:"prefix,omitempty"`
+}
+
+// GetDefault returns the provider FromSecretEnv reads from, by default file,
+// the plaintext secret.env.
+func (s SecretsSpec) GetDefault() string {
+	if s.Default != "" {
+		return s.Default
+	}
+	return string(SecretProviderFile)
+}
+
+// GetKeyEnv returns the env var naming the age identity file, by default
+// GOPRO_AGE_KEY_FILE.
+func (s SecretsSpec) GetKeyEnv() string {
+	if s.KeyEnv != "" {
+		return s.KeyEnv
+	}
+	return "GOPRO_AGE_KEY_FILE"
+}
@@ -253,3 +253,80 @@ func TestGetImageNameLatestGuard(t *testing.T) {
 		t.Fatalf("expected primary %q to equal latest sibling %q", primary, latest)
 	}
 }
+
+func TestBaseImage(t *testing.T) {
+	tests := []struct {
+		base   string
+		want   string
+		wantOK bool
+	}{
+		{base: "$base", want: "base", wantOK: true},
+		{base: "ubuntu:22.04", want: "ubuntu:22.04", wantOK: false},
+		{base: "", want: "", wantOK: false},
+	}
+	for _, tt := range tests {
+		got, ok := ImageSpec{Base: tt.base}.BaseImage()
+		if got != tt.want || ok != tt.wantOK {
+			t.Errorf("BaseImage(%q) = %q, %v, want %q, %v", tt.base, got, ok, tt.want, tt.wantOK)
+		}
+	}
+}
+
+func TestGetCache(t *testing.T) {
+	tests := []struct {
+		name  string
+		image ImageSpec
+		env   EnvSpec
+		want  CacheSpec
+	}{
+		{
+			name: "defaults to the local layer cache",
+			want: CacheSpec{Type: ImageCacheLocal},
+		},
+		{
+			name: "env cache applies to every image",
+			env:  EnvSpec{ImageCache: CacheSpec{Type: ImageCacheDir, Dir: ".cache"}},
+			want: CacheSpec{Type: ImageCacheDir, Dir: ".cache"},
+		},
+		{
+			name:  "image cache replaces the env's wholesale",
+			image: ImageSpec{Cache: CacheSpec{Type: ImageCacheRegistry}},
+			env:   EnvSpec{ImageCache: CacheSpec{Type: ImageCacheDir, Dir: ".cache"}},
+			want:  CacheSpec{Type: ImageCacheRegistry},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.image.GetCache(tt.env); got != tt.want {
+				t.Fatalf("GetCache() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// An environment overriding only the cache type still inherits the default's
+// cache dir, as any nested key does.
+func TestGetEnvMergesImageCache(t *testing.T) {
+	p := Project{
+		Default: EnvSpec{ImageCache: CacheSpec{Type: ImageCacheNone, Dir: ".cache"}},
+		Env:     map[string]EnvSpec{"local": {ImageCache: CacheSpec{Type: ImageCacheDir}}},
+	}
+	want := CacheSpec{Type: ImageCacheDir, Dir: ".cache"}
+	if got := p.GetEnv("local").ImageCache; got != want {
+		t.Fatalf("ImageCache = %+v, want %+v", got, want)
+	}
+}
+
+func TestReleaseGetFormat(t *testing.T) {
+	r := ReleaseSpec{FormatOverrides: []FormatOverride{{Goos: "windows", Format: ArchiveFormatZip}}}
+	if got := r.GetFormat("linux"); got != ArchiveFormatTarGz {
+		t.Errorf("linux format = %s, want tar.gz", got)
+	}
+	if got := r.GetFormat("windows"); got != ArchiveFormatZip {
+		t.Errorf("windows format = %s, want zip", got)
+	}
+	r.Format = ArchiveFormatZip
+	if got := r.GetFormat("linux"); got != ArchiveFormatZip {
+		t.Errorf("linux format = %s, want the release format zip", got)
+	}
+}
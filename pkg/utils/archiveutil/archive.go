@@ -0,0 +1,148 @@
+// Package archiveutil writes release archives, tar.gz and zip, from files on
+// disk. Every entry is stamped with the same time and owned by root, so the
+// same files always make the same archive.
+package archiveutil
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"time"
+)
+
+// File is one file of an archive.
+type File struct {
+	// Name is the slash-separated path of the file in the archive.
+	Name string
+	// Src is the file on disk its content is read from.
+	Src  string
+	Mode fs.FileMode
+}
+
+// WriteTarGz writes files as a gzip-compressed tarball at output.
+func WriteTarGz(output string, files []File, modTime time.Time) error {
+	return write(output, files, func(w io.Writer) entryWriter {
+		gz := gzip.NewWriter(w)
+		return &tarWriter{gz: gz, tw: tar.NewWriter(gz), modTime: modTime}
+	})
+}
+
+// WriteZip writes files as a zip archive at output.
+func WriteZip(output string, files []File, modTime time.Time) error {
+	return write(output, files, func(w io.Writer) entryWriter {
+		return &zipWriter{zw: zip.NewWriter(w), modTime: modTime}
+	})
+}
+
+// entryWriter adds entries to an archive being written.
+type entryWriter interface {
+	add(name string, mode fs.FileMode, size int64, r io.Reader) error
+	Close() error
+}
+
+// write creates output and adds each file to it, removing what was written
+// when anything fails.
+func write(output string, files []File, open func(io.Writer) entryWriter) (err error) {
+	f, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(output)
+		}
+	}()
+	w := open(f)
+	for _, file := range files {
+		if err := addFile(w, file); err != nil {
+			return err
+		}
+	}
+	return w.Close()
+}
+
+func addFile(w entryWriter, file File) error {
+	name := strings.TrimPrefix(path.Clean("/"+file.Name), "/")
+	if name == "" {
+		return fmt.Errorf("invalid archive path %q", file.Name)
+	}
+	in, err := os.Open(file.Src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	fi, err := in.Stat()
+	if err != nil {
+		return err
+	}
+	if !fi.Mode().IsRegular() {
+		return fmt.Errorf("%s is not a regular file", file.Src)
+	}
+	mode := file.Mode
+	if mode == 0 {
+		mode = fi.Mode().Perm()
+	}
+	return w.add(name, mode, fi.Size(), in)
+}
+
+type tarWriter struct {
+	gz      *gzip.Writer
+	tw      *tar.Writer
+	modTime time.Time
+}
+
+func (t *tarWriter) add(name string, mode fs.FileMode, size int64, r io.Reader) error {
+	h := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     int64(mode.Perm()),
+		Size:     size,
+		ModTime:  t.modTime,
+		Format:   tar.FormatPAX,
+	}
+	if err := t.tw.WriteHeader(h); err != nil {
+		return err
+	}
+	_, err := io.Copy(t.tw, r)
+	return err
+}
+
+func (t *tarWriter) Close() error {
+	if err := t.tw.Close(); err != nil {
+		return err
+	}
+	return t.gz.Close()
+}
+
+type zipWriter struct {
+	zw      *zip.Writer
+	modTime time.Time
+}
+
+func (z *zipWriter) add(name string, mode fs.FileMode, size int64, r io.Reader) error {
+	h := &zip.FileHeader{
+		Name:     name,
+		Method:   zip.Deflate,
+		Modified: z.modTime,
+	}
+	h.SetMode(mode.Perm())
+	w, err := z.zw.CreateHeader(h)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, r)
+	return err
+}
+
+func (z *zipWriter) Close() error {
+	return z.zw.Close()
+}
@@ -0,0 +1,148 @@
+package archiveutil
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var modTime = time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+type entry struct {
+	name    string
+	mode    fs.FileMode
+	content string
+}
+
+func readTarGz(t *testing.T, path string) []entry {
+	t.Helper()
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entries []entry
+	r := tar.NewReader(gz)
+	for {
+		h, err := r.Next()
+		if err == io.EOF {
+			return entries
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !h.ModTime.Equal(modTime) || h.Uid != 0 || h.Gid != 0 {
+			t.Errorf("%s stamped %s owned by %d:%d", h.Name, h.ModTime, h.Uid, h.Gid)
+		}
+		b, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, entry{h.Name, fs.FileMode(h.Mode), string(b)})
+	}
+}
+
+func readZip(t *testing.T, path string) []entry {
+	t.Helper()
+	r, err := zip.OpenReader(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	var entries []entry
+	for _, f := range r.File {
+		if !f.Modified.Equal(modTime) {
+			t.Errorf("%s stamped %s", f.Name, f.Modified)
+		}
+		in, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(in)
+		in.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries = append(entries, entry{f.Name, f.Mode().Perm(), string(b)})
+	}
+	return entries
+}
+
+func TestWrite(t *testing.T) {
+	dir := t.TempDir()
+	binary := filepath.Join(dir, "api_linux_amd64")
+	readme := filepath.Join(dir, "README.md")
+	if err := os.WriteFile(binary, []byte("\x7fELF"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(readme, []byte("# api\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	files := []File{
+		{Name: "api", Src: binary, Mode: 0755},
+		{Name: "/docs/README.md", Src: readme},
+	}
+	want := []entry{
+		{"api", 0755, "\x7fELF"},
+		{"docs/README.md", 0644, "# api\n"},
+	}
+
+	tests := []struct {
+		name  string
+		write func(string, []File, time.Time) error
+		read  func(*testing.T, string) []entry
+	}{
+		{name: "tar.gz", write: WriteTarGz, read: readTarGz},
+		{name: "zip", write: WriteZip, read: readZip},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, b := filepath.Join(dir, "a."+tt.name), filepath.Join(dir, "b."+tt.name)
+			if err := tt.write(a, files, modTime); err != nil {
+				t.Fatal(err)
+			}
+			got := tt.read(t, a)
+			if len(got) != len(want) {
+				t.Fatalf("archived %+v, want %+v", got, want)
+			}
+			for i := range want {
+				if got[i] != want[i] {
+					t.Errorf("entry %d = %+v, want %+v", i, got[i], want[i])
+				}
+			}
+
+			// the same files make the same archive
+			if err := tt.write(b, files, modTime); err != nil {
+				t.Fatal(err)
+			}
+			first, _ := os.ReadFile(a)
+			second, _ := os.ReadFile(b)
+			if !bytes.Equal(first, second) {
+				t.Error("archives of the same files differ")
+			}
+		})
+	}
+}
+
+func TestWriteRemovesAFailedArchive(t *testing.T) {
+	dir := t.TempDir()
+	output := filepath.Join(dir, "a.tar.gz")
+	err := WriteTarGz(output, []File{{Name: "missing", Src: filepath.Join(dir, "missing")}}, modTime)
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+	if _, err := os.Stat(output); !os.IsNotExist(err) {
+		t.Errorf("failed archive left behind: %v", err)
+	}
+}
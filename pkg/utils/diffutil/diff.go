@@ -0,0 +1,210 @@
+// Package diffutil compares text line by line and prints the differences in
+// the unified format of diff -u and git diff.
+package diffutil
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// maxEdits bounds the edit distance searched for. Past it the two sides have
+// little in common, and the changed region is shown as removed and added in
+// full rather than spending quadratic memory on the shortest script.
+const maxEdits = 2000
+
+type op byte
+
+const (
+	opEqual  op = ' '
+	opDelete op = '-'
+	opInsert op = '+'
+)
+
+type edit struct {
+	op   op
+	line string
+}
+
+// Unified returns the unified diff turning a into b, with aName and bName in
+// its header and context unchanged lines around each change, or "" when the
+// two are equal. Either name may be /dev/null for a file added or removed.
+func Unified(aName, bName string, a, b []byte, context int) string {
+	if bytes.Equal(a, b) {
+		return ""
+	}
+	if bytes.IndexByte(a, 0) >= 0 || bytes.IndexByte(b, 0) >= 0 {
+		return fmt.Sprintf("Binary files %s and %s differ\n", aName, bName)
+	}
+	edits := diffLines(splitLines(a), splitLines(b))
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "--- %s\n+++ %s\n", aName, bName)
+	writeHunks(&sb, edits, context)
+	return sb.String()
+}
+
+// splitLines splits text into lines, each keeping its newline. Only the last
+// line may lack one.
+func splitLines(b []byte) []string {
+	if len(b) == 0 {
+		return nil
+	}
+	lines := strings.SplitAfter(string(b), "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// diffLines returns an edit script turning a into b. The lines both share at
+// either end are matched first; what remains in between goes to the search.
+func diffLines(a, b []string) []edit {
+	var head, tail []edit
+	for len(a) > 0 && len(b) > 0 && a[0] == b[0] {
+		head = append(head, edit{opEqual, a[0]})
+		a, b = a[1:], b[1:]
+	}
+	for len(a) > 0 && len(b) > 0 && a[len(a)-1] == b[len(b)-1] {
+		tail = append(tail, edit{opEqual, a[len(a)-1]})
+		a, b = a[:len(a)-1], b[:len(b)-1]
+	}
+	edits := append(head, myers(a, b)...)
+	for i := len(tail) - 1; i >= 0; i-- {
+		edits = append(edits, tail[i])
+	}
+	return edits
+}
+
+// myers finds a shortest edit script with Myers' O(ND) algorithm. It keeps
+// the furthest reaching x of every diagonal k after each step d, and walks
+// those back from the end to recover the path.
+func myers(a, b []string) []edit {
+	n, m := len(a), len(b)
+	limit := min(n+m, maxEdits)
+	// v[offset+k] is the furthest x reached on diagonal k = x-y.
+	offset := limit + 1
+	v := make([]int, 2*offset+1)
+	var trace [][]int
+	for d := 0; d <= limit; d++ {
+		trace = append(trace, append([]int(nil), v...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1] // down: insert from b
+			} else {
+				x = v[offset+k-1] + 1 // right: delete from a
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x, y = x+1, y+1
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				return backtrack(a, b, trace, offset)
+			}
+		}
+	}
+	edits := make([]edit, 0, n+m)
+	for _, line := range a {
+		edits = append(edits, edit{opDelete, line})
+	}
+	for _, line := range b {
+		edits = append(edits, edit{opInsert, line})
+	}
+	return edits
+}
+
+func backtrack(a, b []string, trace [][]int, offset int) []edit {
+	var edits []edit
+	x, y := len(a), len(b)
+	for d := len(trace) - 1; d >= 0; d-- {
+		v := trace[d]
+		k := x - y
+		prevK := k - 1
+		if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+			prevK = k + 1
+		}
+		prevX := v[offset+prevK]
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			edits = append(edits, edit{opEqual, a[x-1]})
+			x, y = x-1, y-1
+		}
+		if d == 0 {
+			break
+		}
+		if x == prevX {
+			edits = append(edits, edit{opInsert, b[y-1]})
+		} else {
+			edits = append(edits, edit{opDelete, a[x-1]})
+		}
+		x, y = prevX, prevY
+	}
+	for i, j := 0, len(edits)-1; i < j; i, j = i+1, j-1 {
+		edits[i], edits[j] = edits[j], edits[i]
+	}
+	return edits
+}
+
+// writeHunks writes the changes of edits as hunks, each with up to context
+// unchanged lines on either side. Changes closer than twice that share a
+// hunk.
+func writeHunks(sb *strings.Builder, edits []edit, context int) {
+	// aAt[i] and bAt[i] count the lines of a and b before edits[i].
+	aAt, bAt := make([]int, len(edits)+1), make([]int, len(edits)+1)
+	for i, e := range edits {
+		aAt[i+1], bAt[i+1] = aAt[i], bAt[i]
+		if e.op != opInsert {
+			aAt[i+1]++
+		}
+		if e.op != opDelete {
+			bAt[i+1]++
+		}
+	}
+	for i := 0; i < len(edits); {
+		for i < len(edits) && edits[i].op == opEqual {
+			i++
+		}
+		if i == len(edits) {
+			return
+		}
+		start := max(i-context, 0)
+		end := i
+		for {
+			for end < len(edits) && edits[end].op != opEqual {
+				end++
+			}
+			next := end
+			for next < len(edits) && edits[next].op == opEqual {
+				next++
+			}
+			if next == len(edits) || next-end > 2*context {
+				break
+			}
+			end = next
+		}
+		end = min(end+context, len(edits))
+		fmt.Fprintf(sb, "@@ -%s +%s @@\n", hunkRange(aAt[start], aAt[end]-aAt[start]), hunkRange(bAt[start], bAt[end]-bAt[start]))
+		for _, e := range edits[start:end] {
+			sb.WriteByte(byte(e.op))
+			sb.WriteString(e.line)
+			if !strings.HasSuffix(e.line, "\n") {
+				sb.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+		i = end
+	}
+}
+
+// hunkRange formats the lines a hunk covers on one side: the first line and
+// the count, which is left out when it is one. An empty range names the line
+// before it.
+func hunkRange(before, count int) string {
+	switch count {
+	case 0:
+		return fmt.Sprintf("%d,0", before)
+	case 1:
+		return fmt.Sprintf("%d", before+1)
+	}
+	return fmt.Sprintf("%d,%d", before+1, count)
+}
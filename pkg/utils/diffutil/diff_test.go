@@ -0,0 +1,83 @@
+package diffutil
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestUnified(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		want string
+	}{
+		{name: "equal", a: "x\ny\n", b: "x\ny\n", want: ""},
+		{
+			name: "changed line",
+			a:    "1\n2\n3\n4\n5\n6\n7\n8\n9\n",
+			b:    "1\n2\n3\n4\nfive\n6\n7\n8\n9\n",
+			want: "--- a\n+++ b\n@@ -2,7 +2,7 @@\n 2\n 3\n 4\n-5\n+five\n 6\n 7\n 8\n",
+		},
+		{
+			name: "far apart changes make two hunks",
+			a:    "a\n1\n2\n3\n4\n5\n6\n7\nb\n",
+			b:    "A\n1\n2\n3\n4\n5\n6\n7\nB\n",
+			want: "--- a\n+++ b\n@@ -1,4 +1,4 @@\n-a\n+A\n 1\n 2\n 3\n@@ -6,4 +6,4 @@\n 5\n 6\n 7\n-b\n+B\n",
+		},
+		{
+			name: "added file",
+			a:    "",
+			b:    "x\n",
+			want: "--- a\n+++ b\n@@ -0,0 +1 @@\n+x\n",
+		},
+		{
+			name: "missing newline",
+			a:    "x\n",
+			b:    "x",
+			want: "--- a\n+++ b\n@@ -1 +1 @@\n-x\n+x\n\\ No newline at end of file\n",
+		},
+		{
+			name: "binary",
+			a:    "x\x00",
+			b:    "y\x00",
+			want: "Binary files a and b differ\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Unified("a", "b", []byte(tt.a), []byte(tt.b), 3); got != tt.want {
+				t.Errorf("Unified() =\n%s\nwant\n%s", got, tt.want)
+			}
+		})
+	}
+}
+
+// The diff is one patch(1) applies: it turns a into b.
+func TestUnifiedApplies(t *testing.T) {
+	patch, err := exec.LookPath("patch")
+	if err != nil {
+		t.Skip("patch is not installed")
+	}
+	a := strings.Repeat("keep\n", 5) + "old\nmiddle\n" + strings.Repeat("same\n", 10) + "gone\n" + "tail\n"
+	b := "new head\n" + strings.Repeat("keep\n", 5) + "middle\nadded\n" + strings.Repeat("same\n", 10) + "tail\n"
+	dir := t.TempDir()
+	file := filepath.Join(dir, "file")
+	if err := os.WriteFile(file, []byte(a), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(patch, "-s", file)
+	cmd.Stdin = strings.NewReader(Unified("file", "file", []byte(a), []byte(b), 3))
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("patch: %s: %s", err, out)
+	}
+	got, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != b {
+		t.Errorf("patched to\n%s\nwant\n%s", got, b)
+	}
+}
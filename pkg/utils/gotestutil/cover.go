@@ -0,0 +1,101 @@
+package gotestutil
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+type block struct {
+	statements int
+	count      int64
+}
+
+// Coverage is a coverage profile merged from those of several go test runs.
+// A block profiled by more than one run is counted once: its counts are added
+// up, or in set mode, it is covered if any run covered it.
+type Coverage struct {
+	Mode   string
+	blocks map[string]*block
+}
+
+func NewCoverage() *Coverage {
+	return &Coverage{blocks: make(map[string]*block)}
+}
+
+// Add merges the profile read from r, as go test -coverprofile writes it.
+func (c *Coverage) Add(r io.Reader) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if mode, ok := strings.CutPrefix(line, "mode: "); ok {
+			if c.Mode != "" && c.Mode != mode {
+				return fmt.Errorf("cannot merge a %s coverage profile into a %s one", mode, c.Mode)
+			}
+			c.Mode = mode
+			continue
+		}
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		// file.go:1.2,3.4 statements count
+		fields := strings.Fields(line)
+		if len(fields) != 3 {
+			return fmt.Errorf("malformed coverage line %q", line)
+		}
+		statements, err := strconv.Atoi(fields[1])
+		if err != nil {
+			return fmt.Errorf("malformed coverage line %q", line)
+		}
+		count, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			return fmt.Errorf("malformed coverage line %q", line)
+		}
+		b, ok := c.blocks[fields[0]]
+		if !ok {
+			c.blocks[fields[0]] = &block{statements: statements, count: count}
+			continue
+		}
+		if c.Mode == "set" {
+			b.count = max(b.count, count)
+		} else {
+			b.count += count
+		}
+	}
+	return scanner.Err()
+}
+
+// Write writes the merged profile, its blocks sorted, in the format go tool
+// cover reads.
+func (c *Coverage) Write(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, "mode: %s\n", c.Mode)
+	keys := make([]string, 0, len(c.blocks))
+	for key := range c.blocks {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+	for _, key := range keys {
+		b := c.blocks[key]
+		fmt.Fprintf(bw, "%s %d %d\n", key, b.statements, b.count)
+	}
+	return bw.Flush()
+}
+
+// Percent returns the share of statements covered, as go test reports it.
+func (c *Coverage) Percent() float64 {
+	var covered, total int
+	for _, b := range c.blocks {
+		total += b.statements
+		if b.count > 0 {
+			covered += b.statements
+		}
+	}
+	if total == 0 {
+		return 0
+	}
+	return 100 * float64(covered) / float64(total)
+}
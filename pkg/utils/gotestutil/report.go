@@ -0,0 +1,257 @@
+// Package gotestutil collects the results of go test runs: the events of go
+// test -json into a JUnit XML report, and coverage profiles into one.
+package gotestutil
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Event is one line of go test -json, as cmd/test2json documents it. Build
+// output comes as build-output events naming the ImportPath built rather than
+// the Package tested.
+type Event struct {
+	Time        time.Time `json:"Time"`
+	Action      string    `json:"Action"`
+	Package     string    `json:"Package"`
+	ImportPath  string    `json:"ImportPath"`
+	Test        string    `json:"Test"`
+	Elapsed     float64   `json:"Elapsed"`
+	Output      string    `json:"Output"`
+	FailedBuild string    `json:"FailedBuild"`
+}
+
+// Result is a test, or a package when Test is empty, once it has finished.
+type Result struct {
+	Package string
+	Test    string
+	// Action is pass, fail or skip.
+	Action  string
+	Elapsed float64
+	// Output is everything the test printed, or for a package, what it
+	// printed outside its tests and, when it failed to build, the compiler's
+	// errors.
+	Output      string
+	FailedBuild bool
+}
+
+type suite struct {
+	result Result
+	tests  []*Result
+	// running are the tests started and not yet finished, by name
+	running map[string]*Result
+	started time.Time
+}
+
+// Report gathers the results of one or more go test -json runs, by package in
+// the order they started.
+type Report struct {
+	suites map[string]*suite
+	order  []string
+	// builds is the compiler output of each package built for testing
+	builds map[string]*strings.Builder
+}
+
+func NewReport() *Report {
+	return &Report{suites: make(map[string]*suite), builds: make(map[string]*strings.Builder)}
+}
+
+func (r *Report) suite(pkg string) *suite {
+	s, ok := r.suites[pkg]
+	if !ok {
+		s = &suite{result: Result{Package: pkg}, running: make(map[string]*Result)}
+		r.suites[pkg] = s
+		r.order = append(r.order, pkg)
+	}
+	return s
+}
+
+// Add records an event, and returns the test or package it finishes, if any.
+func (r *Report) Add(e Event) *Result {
+	if e.Action == "build-output" {
+		// "example.com/pkg [example.com/pkg.test]" builds example.com/pkg
+		pkg, _, _ := strings.Cut(e.ImportPath, " ")
+		b, ok := r.builds[pkg]
+		if !ok {
+			b = new(strings.Builder)
+			r.builds[pkg] = b
+		}
+		b.WriteString(e.Output)
+		return nil
+	}
+	if e.Package == "" {
+		return nil
+	}
+	s := r.suite(e.Package)
+	if e.Action == "start" && s.started.IsZero() {
+		s.started = e.Time
+	}
+	if e.Test == "" {
+		switch e.Action {
+		case "output":
+			s.result.Output += e.Output
+		case "pass", "fail", "skip":
+			s.result.Action, s.result.Elapsed = e.Action, e.Elapsed
+			if e.FailedBuild != "" {
+				s.result.FailedBuild = true
+				pkg, _, _ := strings.Cut(e.FailedBuild, " ")
+				if b, ok := r.builds[pkg]; ok {
+					s.result.Output = b.String() + s.result.Output
+				}
+			}
+			result := s.result
+			return &result
+		}
+		return nil
+	}
+	t, ok := s.running[e.Test]
+	if !ok {
+		t = &Result{Package: e.Package, Test: e.Test}
+		s.running[e.Test] = t
+		s.tests = append(s.tests, t)
+	}
+	switch e.Action {
+	case "output":
+		t.Output += e.Output
+	case "pass", "fail", "skip":
+		t.Action, t.Elapsed = e.Action, e.Elapsed
+		delete(s.running, e.Test)
+		result := *t
+		return &result
+	}
+	return nil
+}
+
+func isFailure(t *Result) bool {
+	return t.Action == "fail"
+}
+
+// Counts returns the number of tests, of those failed and of those skipped.
+// A package that failed without a failing test, say to build, counts as one
+// failed test.
+func (r *Report) Counts() (tests, failed, skipped int) {
+	for _, pkg := range r.order {
+		s := r.suites[pkg]
+		if s.result.Action == "fail" && !slices.ContainsFunc(s.tests, isFailure) {
+			tests++
+			failed++
+		}
+		for _, t := range s.tests {
+			tests++
+			switch t.Action {
+			case "fail":
+				failed++
+			case "skip":
+				skipped++
+			}
+		}
+	}
+	return tests, failed, skipped
+}
+
+type junitSuites struct {
+	XMLName  xml.Name     `xml:"testsuites"`
+	Tests    int          `xml:"tests,attr"`
+	Failures int          `xml:"failures,attr"`
+	Errors   int          `xml:"errors,attr"`
+	Skipped  int          `xml:"skipped,attr"`
+	Time     string       `xml:"time,attr"`
+	Suites   []junitSuite `xml:"testsuite"`
+}
+
+type junitSuite struct {
+	Name      string      `xml:"name,attr"`
+	Tests     int         `xml:"tests,attr"`
+	Failures  int         `xml:"failures,attr"`
+	Errors    int         `xml:"errors,attr"`
+	Skipped   int         `xml:"skipped,attr"`
+	Time      string      `xml:"time,attr"`
+	Timestamp string      `xml:"timestamp,attr,omitempty"`
+	Cases     []junitCase `xml:"testcase"`
+	SystemOut string      `xml:"system-out,omitempty"`
+}
+
+type junitCase struct {
+	Classname string        `xml:"classname,attr"`
+	Name      string        `xml:"name,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitMessage `xml:"failure,omitempty"`
+	Error     *junitMessage `xml:"error,omitempty"`
+	Skipped   *junitMessage `xml:"skipped,omitempty"`
+}
+
+type junitMessage struct {
+	Message string `xml:"message,attr"`
+	Body    string `xml:",chardata"`
+}
+
+func seconds(s float64) string {
+	return fmt.Sprintf("%.3f", s)
+}
+
+// WriteJUnit writes the report as JUnit XML: a testsuite per package and a
+// testcase per test and subtest. A package that failed to build is reported
+// as an error, and one that failed outside its tests, say in TestMain or on a
+// timeout, as a failed testcase of its own, both carrying its output.
+// Packages without test files are left out.
+func (r *Report) WriteJUnit(w io.Writer) error {
+	var doc junitSuites
+	var total float64
+	for _, pkg := range r.order {
+		s := r.suites[pkg]
+		if s.result.Action == "skip" && len(s.tests) == 0 {
+			continue
+		}
+		js := junitSuite{Name: pkg, Time: seconds(s.result.Elapsed)}
+		if !s.started.IsZero() {
+			js.Timestamp = s.started.UTC().Format(time.RFC3339)
+		}
+		for _, t := range s.tests {
+			c := junitCase{Classname: pkg, Name: t.Test, Time: seconds(t.Elapsed)}
+			switch t.Action {
+			case "fail":
+				c.Failure = &junitMessage{Message: "Failed", Body: t.Output}
+				js.Failures++
+			case "skip":
+				c.Skipped = &junitMessage{Message: "Skipped", Body: t.Output}
+				js.Skipped++
+			}
+			js.Cases = append(js.Cases, c)
+		}
+		if s.result.Action == "fail" && js.Failures == 0 {
+			if s.result.FailedBuild {
+				js.Cases = append(js.Cases, junitCase{Classname: pkg, Name: "[build failed]", Time: seconds(0),
+					Error: &junitMessage{Message: "Build failed", Body: s.result.Output}})
+				js.Errors++
+			} else {
+				js.Cases = append(js.Cases, junitCase{Classname: pkg, Name: "[package failed]", Time: seconds(s.result.Elapsed),
+					Failure: &junitMessage{Message: "Failed", Body: s.result.Output}})
+				js.Failures++
+			}
+		} else {
+			js.SystemOut = s.result.Output
+		}
+		js.Tests = len(js.Cases)
+		doc.Tests += js.Tests
+		doc.Failures += js.Failures
+		doc.Errors += js.Errors
+		doc.Skipped += js.Skipped
+		total += s.result.Elapsed
+		doc.Suites = append(doc.Suites, js)
+	}
+	doc.Time = seconds(total)
+	if _, err := io.WriteString(w, xml.Header); err != nil {
+		return err
+	}
+	enc := xml.NewEncoder(w)
+	enc.Indent("", "  ")
+	if err := enc.Encode(doc); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, "\n")
+	return err
+}
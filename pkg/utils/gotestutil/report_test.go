@@ -0,0 +1,165 @@
+package gotestutil
+
+import (
+	"bufio"
+	"encoding/json"
+	"encoding/xml"
+	"math"
+	"strings"
+	"testing"
+)
+
+// events is go test -json of a package with a passing test, a failing
+// subtest and a skipped test, one that does not build, and one without test
+// files.
+const events = `{"Time":"2026-01-02T03:04:05Z","Action":"start","Package":"x/a"}
+{"Action":"run","Package":"x/a","Test":"TestF"}
+{"Action":"output","Package":"x/a","Test":"TestF","Output":"=== RUN   TestF\n"}
+{"Action":"output","Package":"x/a","Test":"TestF","Output":"--- PASS: TestF (0.00s)\n"}
+{"Action":"pass","Package":"x/a","Test":"TestF","Elapsed":0.01}
+{"Action":"run","Package":"x/a","Test":"TestG"}
+{"Action":"output","Package":"x/a","Test":"TestG","Output":"=== RUN   TestG\n"}
+{"Action":"run","Package":"x/a","Test":"TestG/sub"}
+{"Action":"output","Package":"x/a","Test":"TestG/sub","Output":"=== RUN   TestG/sub\n"}
+{"Action":"output","Package":"x/a","Test":"TestG/sub","Output":"    a_test.go:4: hello\n"}
+{"Action":"output","Package":"x/a","Test":"TestG/sub","Output":"--- FAIL: TestG/sub (0.00s)\n"}
+{"Action":"fail","Package":"x/a","Test":"TestG/sub","Elapsed":0}
+{"Action":"output","Package":"x/a","Test":"TestG","Output":"--- FAIL: TestG (0.00s)\n"}
+{"Action":"fail","Package":"x/a","Test":"TestG","Elapsed":0}
+{"Action":"run","Package":"x/a","Test":"TestS"}
+{"Action":"output","Package":"x/a","Test":"TestS","Output":"    a_test.go:5: nah\n"}
+{"Action":"skip","Package":"x/a","Test":"TestS","Elapsed":0}
+{"Action":"output","Package":"x/a","Output":"FAIL\n"}
+{"Action":"output","Package":"x/a","Output":"FAIL\tx/a\t0.005s\n"}
+{"Action":"fail","Package":"x/a","Elapsed":0.006}
+{"ImportPath":"x/b [x/b.test]","Action":"build-output","Output":"# x/b [x/b.test]\n"}
+{"ImportPath":"x/b [x/b.test]","Action":"build-output","Output":"b/b.go:2:13: undefined: undefined\n"}
+{"ImportPath":"x/b [x/b.test]","Action":"build-fail"}
+{"Action":"start","Package":"x/b"}
+{"Action":"output","Package":"x/b","Output":"FAIL\tx/b [build failed]\n"}
+{"Action":"fail","Package":"x/b","Elapsed":0,"FailedBuild":"x/b [x/b.test]"}
+{"Action":"start","Package":"x/c"}
+{"Action":"output","Package":"x/c","Output":"?   \tx/c\t[no test files]\n"}
+{"Action":"skip","Package":"x/c","Elapsed":0}
+`
+
+func readEvents(t *testing.T, r *Report) []*Result {
+	t.Helper()
+	var finished []*Result
+	scanner := bufio.NewScanner(strings.NewReader(events))
+	for scanner.Scan() {
+		var e Event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatal(err)
+		}
+		if result := r.Add(e); result != nil {
+			finished = append(finished, result)
+		}
+	}
+	return finished
+}
+
+func TestReportCollectsResults(t *testing.T) {
+	r := NewReport()
+	finished := readEvents(t, r)
+	var names []string
+	for _, result := range finished {
+		names = append(names, result.Package+" "+result.Test+" "+result.Action)
+	}
+	want := "x/a TestF pass,x/a TestG/sub fail,x/a TestG fail,x/a TestS skip,x/a  fail,x/b  fail,x/c  skip"
+	if got := strings.Join(names, ","); got != want {
+		t.Errorf("finished %s, want %s", got, want)
+	}
+	if sub := finished[1]; !strings.Contains(sub.Output, "a_test.go:4: hello") {
+		t.Errorf("subtest output %q", sub.Output)
+	}
+	if b := finished[5]; !b.FailedBuild || !strings.Contains(b.Output, "undefined: undefined") {
+		t.Errorf("build failure %+v", b)
+	}
+	if tests, failed, skipped := r.Counts(); tests != 5 || failed != 3 || skipped != 1 {
+		t.Errorf("counts %d tests, %d failed, %d skipped", tests, failed, skipped)
+	}
+}
+
+func TestWriteJUnit(t *testing.T) {
+	r := NewReport()
+	readEvents(t, r)
+	var b strings.Builder
+	if err := r.WriteJUnit(&b); err != nil {
+		t.Fatal(err)
+	}
+	var doc junitSuites
+	if err := xml.Unmarshal([]byte(b.String()), &doc); err != nil {
+		t.Fatalf("%s\n%s", err, b.String())
+	}
+	if doc.Tests != 5 || doc.Failures != 2 || doc.Errors != 1 || doc.Skipped != 1 {
+		t.Errorf("totals %+v", doc)
+	}
+	if len(doc.Suites) != 2 {
+		t.Fatalf("%d suites, want x/a and x/b without x/c", len(doc.Suites))
+	}
+	a, b2 := doc.Suites[0], doc.Suites[1]
+	if a.Name != "x/a" || a.Timestamp != "2026-01-02T03:04:05Z" || a.Time != "0.006" || len(a.Cases) != 4 {
+		t.Errorf("suite x/a %+v", a)
+	}
+	if c := a.Cases[2]; c.Name != "TestG/sub" || c.Classname != "x/a" || c.Failure == nil || !strings.Contains(c.Failure.Body, "hello") {
+		t.Errorf("failed case %+v", c)
+	}
+	if c := a.Cases[3]; c.Skipped == nil {
+		t.Errorf("skipped case %+v", c)
+	}
+	if len(b2.Cases) != 1 || b2.Cases[0].Error == nil || !strings.Contains(b2.Cases[0].Error.Body, "undefined: undefined") {
+		t.Errorf("suite x/b %+v", b2)
+	}
+}
+
+func TestCoverageMerges(t *testing.T) {
+	tests := []struct {
+		mode    string
+		a, b    string
+		want    string
+		percent float64
+	}{
+		{
+			mode: "set",
+			a:    "mode: set\nx/a.go:1.1,2.2 2 1\nx/a.go:3.1,4.2 1 0\n",
+			b:    "mode: set\nx/a.go:3.1,4.2 1 0\nx/b.go:1.1,2.2 3 0\n",
+			want: "mode: set\nx/a.go:1.1,2.2 2 1\nx/a.go:3.1,4.2 1 0\nx/b.go:1.1,2.2 3 0\n",
+			// 2 of 6 statements
+			percent: 100.0 / 3,
+		},
+		{
+			mode:    "count",
+			a:       "mode: count\nx/a.go:1.1,2.2 2 3\nx/a.go:3.1,4.2 2 0\n",
+			b:       "mode: count\nx/a.go:1.1,2.2 2 1\nx/a.go:3.1,4.2 2 4\n",
+			want:    "mode: count\nx/a.go:1.1,2.2 2 4\nx/a.go:3.1,4.2 2 4\n",
+			percent: 100,
+		},
+	}
+	for _, tt := range tests {
+		c := NewCoverage()
+		for _, profile := range []string{tt.a, tt.b} {
+			if err := c.Add(strings.NewReader(profile)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		var b strings.Builder
+		if err := c.Write(&b); err != nil {
+			t.Fatal(err)
+		}
+		if b.String() != tt.want {
+			t.Errorf("%s: merged\n%s\nwant\n%s", tt.mode, b.String(), tt.want)
+		}
+		if got := c.Percent(); math.Abs(got-tt.percent) > 1e-9 {
+			t.Errorf("%s: %.2f%% covered, want %.2f%%", tt.mode, got, tt.percent)
+		}
+	}
+
+	c := NewCoverage()
+	if err := c.Add(strings.NewReader("mode: set\n")); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.Add(strings.NewReader("mode: atomic\n")); err == nil {
+		t.Error("merged profiles of different modes")
+	}
+}
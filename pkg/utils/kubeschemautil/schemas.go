@@ -0,0 +1,204 @@
+// Package kubeschemautil validates Kubernetes manifests offline, against the
+// schemas of the built-in kinds bundled in schemas.yaml, as a cluster of a
+// given minor version serves them.
+package kubeschemautil
+
+import (
+	_ "embed"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/xhanio/gopro/pkg/utils/schemautil"
+)
+
+//go:embed schemas.yaml
+var bundled []byte
+
+// MinVersion and LatestVersion bound the cluster versions the bundled
+// schemas describe.
+const (
+	MinVersion    = "1.24"
+	LatestVersion = "1.34"
+)
+
+type bundle struct {
+	Kinds       []kind         `yaml:"kinds"`
+	Definitions map[string]any `yaml:"definitions"`
+}
+
+type kind struct {
+	APIVersion string `yaml:"apiVersion"`
+	Kind       string `yaml:"kind"`
+	Definition string `yaml:"definition"`
+	Since      string `yaml:"since"`
+	Removed    string `yaml:"removed"`
+}
+
+type typeMeta struct {
+	apiVersion, kind string
+}
+
+// Schemas are the bundled schemas as a cluster version serves them.
+type Schemas struct {
+	version string
+	minor   int
+	kinds   map[typeMeta]kind
+	root    *schemautil.Schema
+}
+
+// Load returns the schemas for a cluster version such as 1.30 or v1.30.2,
+// between MinVersion and LatestVersion.
+func Load(version string) (*Schemas, error) {
+	minor, err := parseMinor(version)
+	if err != nil {
+		return nil, err
+	}
+	lowest, _ := parseMinor(MinVersion)
+	latest, _ := parseMinor(LatestVersion)
+	if minor < lowest || minor > latest {
+		return nil, fmt.Errorf("kubernetes version %s: the bundled schemas cover %s to %s", version, MinVersion, LatestVersion)
+	}
+	var b bundle
+	if err := yaml.Unmarshal(bundled, &b); err != nil {
+		return nil, err
+	}
+	for _, def := range b.Definitions {
+		if err := prepare(def, minor); err != nil {
+			return nil, err
+		}
+	}
+	root, err := schemautil.Compile(map[string]any{"definitions": b.Definitions})
+	if err != nil {
+		return nil, err
+	}
+	s := &Schemas{
+		version: fmt.Sprintf("1.%d", minor),
+		minor:   minor,
+		kinds:   make(map[typeMeta]kind, len(b.Kinds)),
+		root:    root,
+	}
+	for _, k := range b.Kinds {
+		s.kinds[typeMeta{k.APIVersion, k.Kind}] = k
+	}
+	return s, nil
+}
+
+// Version returns the cluster minor version the schemas are for, as 1.30.
+func (s *Schemas) Version() string {
+	return s.version
+}
+
+// Validate checks a manifest against the schema of its apiVersion and kind.
+// known is false for a kind no schema is bundled for, such as a custom
+// resource.
+func (s *Schemas) Validate(doc map[string]any) (errs []schemautil.Error, known bool) {
+	apiVersion, _ := doc["apiVersion"].(string)
+	kindName, _ := doc["kind"].(string)
+	if apiVersion == "" {
+		errs = append(errs, schemautil.Error{Path: "apiVersion", Msg: "is required"})
+	}
+	if kindName == "" {
+		errs = append(errs, schemautil.Error{Path: "kind", Msg: "is required"})
+	}
+	if errs != nil {
+		return errs, true
+	}
+	k, ok := s.kinds[typeMeta{apiVersion, kindName}]
+	if !ok {
+		return nil, false
+	}
+	if since, err := parseMinor(k.Since); err == nil && s.minor < since {
+		return []schemautil.Error{{Path: "apiVersion", Msg: fmt.Sprintf("%s %s is served from Kubernetes %s, not %s", apiVersion, kindName, k.Since, s.version)}}, true
+	}
+	if removed, err := parseMinor(k.Removed); err == nil && s.minor >= removed {
+		msg := fmt.Sprintf("%s %s is no longer served since Kubernetes %s", apiVersion, kindName, k.Removed)
+		if served := s.served(kindName); served != "" {
+			msg += ", use " + served
+		}
+		return []schemautil.Error{{Path: "apiVersion", Msg: msg}}, true
+	}
+	def, ok := s.root.Definition(k.Definition)
+	if !ok {
+		return nil, false
+	}
+	return def.Validate(doc), true
+}
+
+// served returns the apiVersion a kind is served as on the cluster version.
+func (s *Schemas) served(kindName string) string {
+	for tm, k := range s.kinds {
+		if tm.kind != kindName || k.Definition == "" {
+			continue
+		}
+		if removed, err := parseMinor(k.Removed); err == nil && s.minor >= removed {
+			continue
+		}
+		if since, err := parseMinor(k.Since); err == nil && s.minor < since {
+			continue
+		}
+		return tm.apiVersion
+	}
+	return ""
+}
+
+// prepare drops the fields a schema gained after the cluster minor version
+// and closes the objects that list their properties, so an unknown field is
+// an error as the API server's strict field validation makes it.
+func prepare(v any, minor int) error {
+	schema, ok := v.(map[string]any)
+	if !ok {
+		return nil
+	}
+	if props, ok := schema["properties"].(map[string]any); ok {
+		for name, prop := range props {
+			since, ok := prop.(map[string]any)["x-gopro-since"].(string)
+			if ok {
+				added, err := parseMinor(since)
+				if err != nil {
+					return fmt.Errorf("%s: %w", name, err)
+				}
+				if added > minor {
+					delete(props, name)
+					continue
+				}
+			}
+			if err := prepare(prop, minor); err != nil {
+				return err
+			}
+		}
+		if _, ok := schema["additionalProperties"]; !ok {
+			schema["additionalProperties"] = false
+		}
+	}
+	if err := prepare(schema["additionalProperties"], minor); err != nil {
+		return err
+	}
+	if err := prepare(schema["items"], minor); err != nil {
+		return err
+	}
+	for _, key := range []string{"allOf", "anyOf", "oneOf"} {
+		subs, _ := schema[key].([]any)
+		for _, sub := range subs {
+			if err := prepare(sub, minor); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// parseMinor returns the minor of a Kubernetes 1.x version.
+func parseMinor(version string) (int, error) {
+	parts := strings.Split(strings.TrimPrefix(version, "v"), ".")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] != "1" {
+		return 0, fmt.Errorf("kubernetes version %q: want 1.MINOR", version)
+	}
+	minor, err := strconv.Atoi(parts[1])
+	if err != nil || minor < 0 {
+		return 0, fmt.Errorf("kubernetes version %q: want 1.MINOR", version)
+	}
+	return minor, nil
+}
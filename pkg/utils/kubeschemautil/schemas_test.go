@@ -0,0 +1,136 @@
+package kubeschemautil
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/xhanio/gopro/pkg/utils/schemautil"
+)
+
+const deployment = `apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: api
+  labels: {app.kubernetes.io/name: api}
+  creationTimestamp: null
+spec:
+  replicas: 2
+  selector:
+    matchLabels: {app: api}
+  strategy:
+    rollingUpdate: {maxSurge: 25%, maxUnavailable: 0}
+  template:
+    metadata:
+      labels: {app: api}
+    spec:
+      containers:
+        - name: api
+          image: registry.test/api:1.0.0
+          ports:
+            - containerPort: 8080
+          env:
+            - name: MODE
+              valueFrom:
+                configMapKeyRef: {name: api, key: mode}
+          resources:
+            limits: {cpu: 1, memory: 128Mi}
+          readinessProbe:
+            httpGet: {path: /healthz, port: http}
+      volumes:
+        - name: config
+          configMap: {name: api}
+`
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		version string
+		doc     string
+		want    []string
+		unknown bool
+	}{
+		{name: "valid deployment", version: LatestVersion, doc: deployment},
+		{
+			name:    "mistyped fields",
+			version: LatestVersion,
+			doc: strings.NewReplacer(
+				"replicas: 2", "replica: 2",
+				"containerPort: 8080", `containerPort: "8080"`,
+				"image: registry.test/api:1.0.0", "imgae: registry.test/api:1.0.0",
+			).Replace(deployment),
+			want: []string{
+				"spec.replica: is not a known field",
+				"spec.template.spec.containers[0].imgae: is not a known field",
+				"spec.template.spec.containers[0].ports[0].containerPort: got string, want integer",
+			},
+		},
+		{
+			name:    "missing required",
+			version: LatestVersion,
+			doc:     "apiVersion: v1\nkind: Service\nspec:\n  ports: [{targetPort: http}]\n  type: Internal\n",
+			want: []string{
+				"spec.ports[0].port: is required",
+				`spec.type: got "Internal", want one of "ClusterIP", "NodePort", "LoadBalancer", "ExternalName"`,
+			},
+		},
+		{
+			name:    "field added after the cluster version",
+			version: "1.27",
+			doc:     "apiVersion: v1\nkind: Pod\nspec:\n  containers: [{name: init, restartPolicy: Always}]\n",
+			want:    []string{"spec.containers[0].restartPolicy: is not a known field"},
+		},
+		{
+			name:    "field on a newer cluster",
+			version: "v1.28.3",
+			doc:     "apiVersion: v1\nkind: Pod\nspec:\n  containers: [{name: init, restartPolicy: Always}]\n",
+		},
+		{
+			name:    "removed api",
+			version: "1.25",
+			doc:     "apiVersion: batch/v1beta1\nkind: CronJob\n",
+			want:    []string{"apiVersion: batch/v1beta1 CronJob is no longer served since Kubernetes 1.25, use batch/v1"},
+		},
+		{
+			name:    "api still served",
+			version: "1.24",
+			doc:     "apiVersion: batch/v1beta1\nkind: CronJob\nspec:\n  schedule: '@daily'\n  jobTemplate: {spec: {template: {spec: {containers: [{name: job}]}}}}\n",
+		},
+		{name: "no kind", version: LatestVersion, doc: "apiVersion: v1\n", want: []string{"kind: is required"}},
+		{name: "custom resource", version: LatestVersion, doc: "apiVersion: example.com/v1\nkind: Widget\n", unknown: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := Load(tt.version)
+			if err != nil {
+				t.Fatal(err)
+			}
+			docs, err := schemautil.Documents([]byte(tt.doc))
+			if err != nil {
+				t.Fatal(err)
+			}
+			errs, known := s.Validate(docs[0].(map[string]any))
+			if known == tt.unknown {
+				t.Fatalf("known = %v", known)
+			}
+			var got []string
+			for _, e := range errs {
+				got = append(got, e.Error())
+			}
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
+
+func TestLoadVersions(t *testing.T) {
+	for _, version := range []string{"1.23", "1.99", "2.0", "latest", ""} {
+		if _, err := Load(version); err == nil {
+			t.Errorf("loaded %q", version)
+		}
+	}
+	s, err := Load("v" + MinVersion + ".1")
+	if err != nil || s.Version() != MinVersion {
+		t.Errorf("Load = %v, %v", s, err)
+	}
+}
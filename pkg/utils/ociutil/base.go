@@ -0,0 +1,167 @@
+package ociutil
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Base is a base image read from an OCI image layout.
+type Base struct {
+	dir   string
+	index Index
+	// temp is set when dir was extracted from a tarball, and removed on Close.
+	temp bool
+}
+
+// OpenBase opens an OCI image layout directory, or a tarball of one as
+// written by `skopeo copy ... oci-archive:` or by Write.
+func OpenBase(path string) (*Base, error) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	b := &Base{dir: path}
+	if !fi.IsDir() {
+		dir, err := os.MkdirTemp("", "gopro-oci-")
+		if err != nil {
+			return nil, err
+		}
+		b.dir, b.temp = dir, true
+		if err := extract(path, dir); err != nil {
+			b.Close()
+			return nil, fmt.Errorf("failed to read base image %s: %w", path, err)
+		}
+	}
+	if err := readJSON(filepath.Join(b.dir, "index.json"), &b.index); err != nil {
+		b.Close()
+		return nil, fmt.Errorf("%s is not an OCI image layout: %w", path, err)
+	}
+	return b, nil
+}
+
+func (b *Base) Close() error {
+	if b.temp {
+		return os.RemoveAll(b.dir)
+	}
+	return nil
+}
+
+// blobPath returns where the blob with the given digest is stored.
+func (b *Base) blobPath(digest string) (string, error) {
+	algorithm, hex, ok := strings.Cut(digest, ":")
+	if !ok || algorithm == "" || hex == "" || strings.ContainsAny(hex, `/\.`) {
+		return "", fmt.Errorf("malformed digest %q", digest)
+	}
+	return filepath.Join(b.dir, "blobs", algorithm, hex), nil
+}
+
+func (b *Base) readBlob(d Descriptor, v any) error {
+	path, err := b.blobPath(d.Digest)
+	if err != nil {
+		return err
+	}
+	return readJSON(path, v)
+}
+
+// Image resolves the manifest and config of the base for a platform. Nested
+// indexes are followed; a layout holding a single image with no platform is
+// taken as it is, and its config is checked instead.
+func (b *Base) Image(platform Platform) (Manifest, ImageConfig, error) {
+	manifest, err := b.find(b.index, platform)
+	if err != nil {
+		return Manifest{}, ImageConfig{}, err
+	}
+	var config ImageConfig
+	if err := b.readBlob(manifest.Config, &config); err != nil {
+		return Manifest{}, ImageConfig{}, err
+	}
+	declared := Platform{OS: config.OS, Architecture: config.Architecture, Variant: config.Variant}
+	if !platform.matches(declared) {
+		return Manifest{}, ImageConfig{}, fmt.Errorf("base image is %s, not %s", declared, platform)
+	}
+	return manifest, config, nil
+}
+
+func (b *Base) find(index Index, platform Platform) (Manifest, error) {
+	for _, d := range index.Manifests {
+		if d.Platform != nil && !platform.matches(*d.Platform) {
+			continue
+		}
+		switch d.MediaType {
+		case MediaTypeImageIndex, mediaTypeDockerManifestList:
+			var nested Index
+			if err := b.readBlob(d, &nested); err != nil {
+				return Manifest{}, err
+			}
+			if m, err := b.find(nested, platform); err == nil {
+				return m, nil
+			}
+		case MediaTypeImageManifest, mediaTypeDockerManifest:
+			var m Manifest
+			if err := b.readBlob(d, &m); err != nil {
+				return Manifest{}, err
+			}
+			return m, nil
+		}
+	}
+	return Manifest{}, fmt.Errorf("base image has no manifest for %s", platform)
+}
+
+func readJSON(path string, v any) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// extract unpacks a layout tarball into dir. Only regular files and
+// directories are expected; anything escaping dir is refused.
+func extract(tarball, dir string) error {
+	f, err := os.Open(tarball)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	r := tar.NewReader(f)
+	for {
+		h, err := r.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		name := filepath.Clean(filepath.FromSlash(h.Name))
+		if filepath.IsAbs(name) || name == ".." || strings.HasPrefix(name, ".."+string(filepath.Separator)) {
+			return fmt.Errorf("entry %s escapes the layout", h.Name)
+		}
+		target := filepath.Join(dir, name)
+		switch h.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			out, err := os.Create(target)
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(out, r)
+			if cerr := out.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package ociutil
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"path"
+	"slices"
+	"strings"
+	"time"
+)
+
+// File is one file of the layer added on top of the base.
+type File struct {
+	// Path is the absolute path of the file in the image.
+	Path string
+	Mode int64
+	Data []byte
+}
+
+// newLayer packs files into a gzip-compressed tar layer, returning the blob,
+// its descriptor and the digest of the uncompressed tar the config refers to.
+// Parent directories are added, and every entry is owned by root and stamped
+// with modTime, so the same files always make the same layer.
+func newLayer(files []File, modTime time.Time) ([]byte, Descriptor, string, error) {
+	byPath := make(map[string]File, len(files))
+	dirs := make(map[string]bool)
+	for _, f := range files {
+		name := strings.TrimPrefix(path.Clean("/"+f.Path), "/")
+		if name == "" {
+			return nil, Descriptor{}, "", fmt.Errorf("invalid file path %q", f.Path)
+		}
+		byPath[name] = f
+		for dir := path.Dir(name); dir != "."; dir = path.Dir(dir) {
+			dirs[dir] = true
+		}
+	}
+	var names []string
+	for name := range byPath {
+		names = append(names, name)
+	}
+	for dir := range dirs {
+		if _, ok := byPath[dir]; ok {
+			return nil, Descriptor{}, "", fmt.Errorf("%s is both a file and a directory", dir)
+		}
+		names = append(names, dir)
+	}
+	slices.Sort(names)
+
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	diffHash := sha256.New()
+	tw := tar.NewWriter(io.MultiWriter(gz, diffHash))
+	for _, name := range names {
+		h := &tar.Header{
+			Name:    name,
+			ModTime: modTime,
+			Format:  tar.FormatPAX,
+		}
+		if dirs[name] {
+			h.Typeflag, h.Name, h.Mode = tar.TypeDir, name+"/", 0755
+			if err := tw.WriteHeader(h); err != nil {
+				return nil, Descriptor{}, "", err
+			}
+			continue
+		}
+		f := byPath[name]
+		h.Typeflag, h.Mode, h.Size = tar.TypeReg, f.Mode, int64(len(f.Data))
+		if err := tw.WriteHeader(h); err != nil {
+			return nil, Descriptor{}, "", err
+		}
+		if _, err := tw.Write(f.Data); err != nil {
+			return nil, Descriptor{}, "", err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return nil, Descriptor{}, "", err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, Descriptor{}, "", err
+	}
+	blob := compressed.Bytes()
+	desc := Descriptor{
+		MediaType: MediaTypeImageLayer,
+		Digest:    digestOf(blob),
+		Size:      int64(len(blob)),
+	}
+	return blob, desc, fmt.Sprintf("sha256:%x", diffHash.Sum(nil)), nil
+}
+
+func digestOf(b []byte) string {
+	return fmt.Sprintf("sha256:%x", sha256.Sum256(b))
+}
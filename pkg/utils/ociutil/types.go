@@ -0,0 +1,127 @@
+// Package ociutil assembles OCI images without a container engine: it reads
+// a base image from an OCI image layout, adds one layer of files on top, and
+// writes the result as an OCI image layout directory or tarball.
+//
+// Only what gopro needs is covered. Layers are always gzip-compressed tar
+// files, and a base is only ever read from a local layout, never pulled.
+package ociutil
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	MediaTypeImageIndex    = "application/vnd.oci.image.index.v1+json"
+	MediaTypeImageManifest = "application/vnd.oci.image.manifest.v1+json"
+	MediaTypeImageConfig   = "application/vnd.oci.image.config.v1+json"
+	MediaTypeImageLayer    = "application/vnd.oci.image.layer.v1.tar+gzip"
+
+	// A layout written by docker tooling may still carry the docker
+	// equivalents, which are read as if they were the OCI ones.
+	mediaTypeDockerManifestList = "application/vnd.docker.distribution.manifest.list.v2+json"
+	mediaTypeDockerManifest     = "application/vnd.docker.distribution.manifest.v2+json"
+
+	AnnotationRefName = "org.opencontainers.image.ref.name"
+	// AnnotationImageName carries the full image reference, which containerd
+	// and nerdctl name an imported image by.
+	AnnotationImageName = "io.containerd.image.name"
+
+	layoutVersion = "1.0.0"
+)
+
+type Descriptor struct {
+	MediaType   string            `json:"mediaType"`
+	Digest      string            `json:"digest"`
+	Size        int64             `json:"size"`
+	Annotations map[string]string `json:"annotations,omitempty"`
+	Platform    *Platform         `json:"platform,omitempty"`
+}
+
+type Index struct {
+	SchemaVersion int               `json:"schemaVersion"`
+	MediaType     string            `json:"mediaType,omitempty"`
+	Manifests     []Descriptor      `json:"manifests"`
+	Annotations   map[string]string `json:"annotations,omitempty"`
+}
+
+type Manifest struct {
+	SchemaVersion int               `json:"schemaVersion"`
+	MediaType     string            `json:"mediaType,omitempty"`
+	Config        Descriptor        `json:"config"`
+	Layers        []Descriptor      `json:"layers"`
+	Annotations   map[string]string `json:"annotations,omitempty"`
+}
+
+// ImageConfig is the OCI image configuration. The fields the image spec
+// defines are carried over from the base; anything else a base carries, such
+// as docker's container_config, is dropped.
+type ImageConfig struct {
+	Created      *time.Time     `json:"created,omitempty"`
+	Author       string         `json:"author,omitempty"`
+	Architecture string         `json:"architecture"`
+	OS           string         `json:"os"`
+	OSVersion    string         `json:"os.version,omitempty"`
+	Variant      string         `json:"variant,omitempty"`
+	Config       ContainerSpec  `json:"config,omitempty"`
+	RootFS       RootFS         `json:"rootfs"`
+	History      []HistoryEntry `json:"history,omitempty"`
+}
+
+type ContainerSpec struct {
+	User         string              `json:"User,omitempty"`
+	ExposedPorts map[string]struct{} `json:"ExposedPorts,omitempty"`
+	Env          []string            `json:"Env,omitempty"`
+	Entrypoint   []string            `json:"Entrypoint,omitempty"`
+	Cmd          []string            `json:"Cmd,omitempty"`
+	Volumes      map[string]struct{} `json:"Volumes,omitempty"`
+	WorkingDir   string              `json:"WorkingDir,omitempty"`
+	Labels       map[string]string   `json:"Labels,omitempty"`
+	StopSignal   string              `json:"StopSignal,omitempty"`
+}
+
+type RootFS struct {
+	Type    string   `json:"type"`
+	DiffIDs []string `json:"diff_ids"`
+}
+
+type HistoryEntry struct {
+	Created    *time.Time `json:"created,omitempty"`
+	CreatedBy  string     `json:"created_by,omitempty"`
+	Comment    string     `json:"comment,omitempty"`
+	EmptyLayer bool       `json:"empty_layer,omitempty"`
+}
+
+type Platform struct {
+	Architecture string `json:"architecture"`
+	OS           string `json:"os"`
+	Variant      string `json:"variant,omitempty"`
+}
+
+// ParsePlatform parses an os/arch[/variant] platform name.
+func ParsePlatform(name string) (Platform, error) {
+	parts := strings.Split(name, "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return Platform{}, fmt.Errorf("unknown platform %s", name)
+	}
+	p := Platform{OS: parts[0], Architecture: parts[1]}
+	if len(parts) == 3 {
+		p.Variant = parts[2]
+	}
+	return p, nil
+}
+
+func (p Platform) String() string {
+	if p.Variant == "" {
+		return p.OS + "/" + p.Architecture
+	}
+	return p.OS + "/" + p.Architecture + "/" + p.Variant
+}
+
+// matches reports whether a platform an image declares satisfies p. An
+// image without a variant suits any variant asked for.
+func (p Platform) matches(other Platform) bool {
+	return p.OS == other.OS && p.Architecture == other.Architecture &&
+		(other.Variant == "" || p.Variant == other.Variant)
+}
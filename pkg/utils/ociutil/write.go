@@ -0,0 +1,330 @@
+package ociutil
+
+import (
+	"archive/tar"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Image is one platform of the image Write assembles.
+type Image struct {
+	Platform Platform
+	// Files make up the one layer added on top of the base.
+	Files []File
+	// Entrypoint replaces the base's, and clears its Cmd, as a Dockerfile
+	// ENTRYPOINT does. Unset, both are kept.
+	Entrypoint []string
+	// Labels are merged over the base's.
+	Labels map[string]string
+	// Created stamps the config, the history entry and every file.
+	Created time.Time
+	// CreatedBy describes the added layer in the image history.
+	CreatedBy string
+}
+
+// Write assembles images on base, or on an empty image when base is nil, and
+// writes them to output: a tarball when it ends in .tar, a layout directory
+// otherwise. With several images the layout holds an image index of them, a
+// multi-platform image. ref is the full image reference, recorded in the
+// layout's index so the image loads under its name.
+//
+// The descriptor returned is the one of the image index or, for a single
+// platform, of the manifest.
+func Write(output, ref string, base *Base, images []Image) (Descriptor, error) {
+	if len(images) == 0 {
+		return Descriptor{}, fmt.Errorf("no image to write")
+	}
+	w, err := newLayoutWriter(output)
+	if err != nil {
+		return Descriptor{}, err
+	}
+	top, err := assemble(w, base, images)
+	if err != nil {
+		w.abort()
+		return Descriptor{}, err
+	}
+	if ref != "" {
+		top.Annotations = map[string]string{
+			AnnotationRefName:   refTag(ref),
+			AnnotationImageName: ref,
+		}
+	}
+	index, err := json.Marshal(Index{SchemaVersion: 2, MediaType: MediaTypeImageIndex, Manifests: []Descriptor{top}})
+	if err != nil {
+		w.abort()
+		return Descriptor{}, err
+	}
+	if err := w.writeFile("index.json", index); err != nil {
+		w.abort()
+		return Descriptor{}, err
+	}
+	if err := w.commit(); err != nil {
+		return Descriptor{}, err
+	}
+	top.Annotations = nil
+	return top, nil
+}
+
+func assemble(w *layoutWriter, base *Base, images []Image) (Descriptor, error) {
+	var manifests []Descriptor
+	for _, image := range images {
+		desc, err := assembleOne(w, base, image)
+		if err != nil {
+			return Descriptor{}, fmt.Errorf("%s: %w", image.Platform, err)
+		}
+		manifests = append(manifests, desc)
+	}
+	if len(manifests) == 1 {
+		return manifests[0], nil
+	}
+	return w.writeJSON(MediaTypeImageIndex, Index{SchemaVersion: 2, MediaType: MediaTypeImageIndex, Manifests: manifests})
+}
+
+func assembleOne(w *layoutWriter, base *Base, image Image) (Descriptor, error) {
+	manifest := Manifest{SchemaVersion: 2, MediaType: MediaTypeImageManifest}
+	config := ImageConfig{
+		Architecture: image.Platform.Architecture,
+		OS:           image.Platform.OS,
+		Variant:      image.Platform.Variant,
+		RootFS:       RootFS{Type: "layers"},
+	}
+	if base != nil {
+		m, c, err := base.Image(image.Platform)
+		if err != nil {
+			return Descriptor{}, err
+		}
+		for _, layer := range m.Layers {
+			path, err := base.blobPath(layer.Digest)
+			if err != nil {
+				return Descriptor{}, err
+			}
+			if err := w.copyBlob(layer.Digest, path); err != nil {
+				return Descriptor{}, err
+			}
+		}
+		manifest.Layers, config = m.Layers, c
+	}
+
+	blob, layer, diffID, err := newLayer(image.Files, image.Created)
+	if err != nil {
+		return Descriptor{}, err
+	}
+	if err := w.writeBlob(layer.Digest, blob); err != nil {
+		return Descriptor{}, err
+	}
+	manifest.Layers = append(manifest.Layers, layer)
+
+	created := image.Created
+	config.Created = &created
+	config.RootFS.DiffIDs = append(config.RootFS.DiffIDs, diffID)
+	config.History = append(config.History, HistoryEntry{Created: &created, CreatedBy: image.CreatedBy})
+	if image.Entrypoint != nil {
+		config.Config.Entrypoint = image.Entrypoint
+		config.Config.Cmd = nil
+	}
+	if len(image.Labels) > 0 {
+		labels := maps.Clone(config.Config.Labels)
+		if labels == nil {
+			labels = make(map[string]string)
+		}
+		maps.Copy(labels, image.Labels)
+		config.Config.Labels = labels
+	}
+	manifest.Config, err = w.writeJSON(MediaTypeImageConfig, config)
+	if err != nil {
+		return Descriptor{}, err
+	}
+	desc, err := w.writeJSON(MediaTypeImageManifest, manifest)
+	if err != nil {
+		return Descriptor{}, err
+	}
+	platform := image.Platform
+	desc.Platform = &platform
+	return desc, nil
+}
+
+// refTag returns the tag of an image reference, which is what the OCI ref
+// name annotation holds.
+func refTag(ref string) string {
+	if i := strings.LastIndex(ref, ":"); i > strings.LastIndex(ref, "/") {
+		return ref[i+1:]
+	}
+	return "latest"
+}
+
+// layoutWriter writes a layout to a temporary directory beside the output and
+// moves it into place on commit, so a failed build never leaves half an
+// image where the last good one was.
+type layoutWriter struct {
+	output string
+	dir    string
+	blobs  map[string]bool
+}
+
+func newLayoutWriter(output string) (*layoutWriter, error) {
+	if fi, err := os.Stat(output); err == nil && fi.IsDir() {
+		// Only ever replace a layout, never some other directory that
+		// happens to be configured as the output.
+		if _, err := os.Stat(filepath.Join(output, "oci-layout")); err != nil {
+			return nil, fmt.Errorf("%s exists and is not an OCI image layout", output)
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(output), 0755); err != nil {
+		return nil, err
+	}
+	dir, err := os.MkdirTemp(filepath.Dir(output), ".oci-")
+	if err != nil {
+		return nil, err
+	}
+	w := &layoutWriter{output: output, dir: dir, blobs: make(map[string]bool)}
+	if err := w.writeFile("oci-layout", []byte(`{"imageLayoutVersion":"`+layoutVersion+`"}`)); err != nil {
+		w.abort()
+		return nil, err
+	}
+	return w, nil
+}
+
+func (w *layoutWriter) writeFile(name string, data []byte) error {
+	path := filepath.Join(w.dir, filepath.FromSlash(name))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+func blobName(digest string) string {
+	algorithm, hex, _ := strings.Cut(digest, ":")
+	return "blobs/" + algorithm + "/" + hex
+}
+
+func (w *layoutWriter) writeBlob(digest string, data []byte) error {
+	if w.blobs[digest] {
+		return nil
+	}
+	w.blobs[digest] = true
+	return w.writeFile(blobName(digest), data)
+}
+
+func (w *layoutWriter) writeJSON(mediaType string, v any) (Descriptor, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return Descriptor{}, err
+	}
+	desc := Descriptor{MediaType: mediaType, Digest: digestOf(b), Size: int64(len(b))}
+	return desc, w.writeBlob(desc.Digest, b)
+}
+
+// copyBlob copies a base blob as it is; its digest is already known.
+func (w *layoutWriter) copyBlob(digest, src string) error {
+	if w.blobs[digest] {
+		return nil
+	}
+	w.blobs[digest] = true
+	dst := filepath.Join(w.dir, filepath.FromSlash(blobName(digest)))
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(out, in)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (w *layoutWriter) abort() {
+	os.RemoveAll(w.dir)
+}
+
+// commit moves the layout into place, packing it into a tarball first when
+// the output asks for one.
+func (w *layoutWriter) commit() error {
+	defer w.abort()
+	if !strings.HasSuffix(w.output, ".tar") {
+		if err := os.RemoveAll(w.output); err != nil {
+			return err
+		}
+		return os.Rename(w.dir, w.output)
+	}
+	tmp := w.dir + ".tar"
+	if err := pack(w.dir, tmp); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, w.output)
+}
+
+// pack writes the layout in dir as a tarball, blobs before index.json as
+// streaming readers expect.
+func pack(dir, tarball string) error {
+	f, err := os.Create(tarball)
+	if err != nil {
+		return err
+	}
+	tw := tar.NewWriter(f)
+	add := func(name string) error {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		fi, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		h, err := tar.FileInfoHeader(fi, "")
+		if err != nil {
+			return err
+		}
+		h.Name, h.ModTime, h.Uid, h.Gid, h.Uname, h.Gname = name, time.Unix(0, 0), 0, 0, "", ""
+		if fi.IsDir() {
+			h.Name += "/"
+		}
+		if err := tw.WriteHeader(h); err != nil {
+			return err
+		}
+		if fi.IsDir() {
+			return nil
+		}
+		in, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		_, err = io.Copy(tw, in)
+		return err
+	}
+	err = add("oci-layout")
+	if err == nil {
+		err = filepath.WalkDir(filepath.Join(dir, "blobs"), func(path string, _ os.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			rel, err := filepath.Rel(dir, path)
+			if err != nil {
+				return err
+			}
+			return add(filepath.ToSlash(rel))
+		})
+	}
+	if err == nil {
+		err = add("index.json")
+	}
+	if cerr := tw.Close(); err == nil {
+		err = cerr
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
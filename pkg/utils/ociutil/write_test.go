@@ -0,0 +1,191 @@
+package ociutil
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+var created = time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+func platform(t *testing.T, name string) Platform {
+	t.Helper()
+	p, err := ParsePlatform(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+// layerFiles lists the entries of a layer blob of a layout.
+func layerFiles(t *testing.T, b *Base, layer Descriptor) []string {
+	t.Helper()
+	path, err := b.blobPath(layer.Digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(blob))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	r := tar.NewReader(gz)
+	for {
+		h, err := r.Next()
+		if err == io.EOF {
+			return names
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, h.Name)
+	}
+}
+
+func TestWriteScratchThenBuildOnIt(t *testing.T) {
+	dir := t.TempDir()
+	basePath := filepath.Join(dir, "base")
+	amd64, arm64 := platform(t, "linux/amd64"), platform(t, "linux/arm64")
+
+	// a two-platform base from nothing
+	var images []Image
+	for _, p := range []Platform{amd64, arm64} {
+		images = append(images, Image{
+			Platform:   p,
+			Files:      []File{{Path: "/etc/os-release", Mode: 0644, Data: []byte(p.String())}},
+			Entrypoint: []string{"/bin/sh"},
+			Labels:     map[string]string{"base": "yes"},
+			Created:    created,
+		})
+	}
+	if _, err := Write(basePath, "reg.io/base:v1", nil, images); err != nil {
+		t.Fatal(err)
+	}
+
+	base, err := OpenBase(basePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer base.Close()
+	if got := base.index.Manifests[0].Annotations[AnnotationRefName]; got != "v1" {
+		t.Errorf("ref name = %q, want v1", got)
+	}
+
+	// one platform of it, written as a tarball
+	out := filepath.Join(dir, "api.tar")
+	top, err := Write(out, "reg.io/api:v2", base, []Image{{
+		Platform:   arm64,
+		Files:      []File{{Path: "/usr/local/bin/api", Mode: 0755, Data: []byte("binary")}},
+		Entrypoint: []string{"/usr/local/bin/api"},
+		Labels:     map[string]string{"app": "api"},
+		Created:    created,
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if top.MediaType != MediaTypeImageManifest || top.Platform == nil || *top.Platform != arm64 {
+		t.Errorf("top descriptor = %+v, want the arm64 manifest", top)
+	}
+
+	result, err := OpenBase(out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer result.Close()
+	manifest, config, err := result.Image(arm64)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Layers) != 2 || len(config.RootFS.DiffIDs) != 2 {
+		t.Fatalf("got %d layers and %d diff ids, want the base's and one more", len(manifest.Layers), len(config.RootFS.DiffIDs))
+	}
+	if got := layerFiles(t, result, manifest.Layers[0]); !slices.Equal(got, []string{"etc/", "etc/os-release"}) {
+		t.Errorf("base layer = %q", got)
+	}
+	if got := layerFiles(t, result, manifest.Layers[1]); !slices.Equal(got, []string{"usr/", "usr/local/", "usr/local/bin/", "usr/local/bin/api"}) {
+		t.Errorf("added layer = %q", got)
+	}
+	if config.Config.Labels["base"] != "yes" || config.Config.Labels["app"] != "api" {
+		t.Errorf("labels = %v, want the base's and the image's", config.Config.Labels)
+	}
+	if !slices.Equal(config.Config.Entrypoint, []string{"/usr/local/bin/api"}) {
+		t.Errorf("entrypoint = %q", config.Config.Entrypoint)
+	}
+	if _, _, err := result.Image(amd64); err == nil {
+		t.Error("an arm64 image resolved for amd64")
+	}
+}
+
+func TestBaseWithoutPlatformIsRefused(t *testing.T) {
+	dir := t.TempDir()
+	basePath := filepath.Join(dir, "base")
+	if _, err := Write(basePath, "", nil, []Image{{Platform: platform(t, "linux/amd64"), Created: created}}); err != nil {
+		t.Fatal(err)
+	}
+	base, err := OpenBase(basePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer base.Close()
+	_, err = Write(filepath.Join(dir, "out"), "", base, []Image{{Platform: platform(t, "linux/arm64"), Created: created}})
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+// The same files make the same layer, so rebuilding an unchanged image
+// reproduces its digest.
+func TestLayerIsReproducible(t *testing.T) {
+	files := []File{
+		{Path: "/b/two", Mode: 0644, Data: []byte("2")},
+		{Path: "/a/one", Mode: 0755, Data: []byte("1")},
+	}
+	_, first, diff1, err := newLayer(files, created)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Reverse(files)
+	_, second, diff2, err := newLayer(files, created)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Digest != second.Digest || diff1 != diff2 {
+		t.Errorf("layer digests differ: %s %s", first.Digest, second.Digest)
+	}
+}
+
+func TestWriteRefusesToReplaceOtherDirectories(t *testing.T) {
+	dir := t.TempDir()
+	_, err := Write(dir, "", nil, []Image{{Platform: platform(t, "linux/amd64"), Created: created}})
+	if err == nil {
+		t.Fatal("expected an error")
+	}
+}
+
+func TestParsePlatform(t *testing.T) {
+	for name, ok := range map[string]bool{
+		"linux/amd64":    true,
+		"linux/arm/v7":   true,
+		"linux":          false,
+		"linux/":         false,
+		"linux/arm/v7/x": false,
+	} {
+		p, err := ParsePlatform(name)
+		if (err == nil) != ok {
+			t.Errorf("ParsePlatform(%q) error = %v", name, err)
+		}
+		if ok && p.String() != name {
+			t.Errorf("ParsePlatform(%q).String() = %q", name, p.String())
+		}
+	}
+}
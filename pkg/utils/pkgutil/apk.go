@@ -0,0 +1,111 @@
+package pkgutil
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+)
+
+// apkIdentity returns the architecture and full version an apk is named by.
+func apkIdentity(p Package) (arch, version string, err error) {
+	arch, err = archName(p.Arch, apkIndex)
+	if err != nil {
+		return "", "", err
+	}
+	version, err = apkVersion(p.Version)
+	if err != nil {
+		return "", "", err
+	}
+	return arch, version + "-r" + p.Release, nil
+}
+
+func apkFileName(p Package) (string, error) {
+	arch, version, err := apkIdentity(p)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s-%s.%s.apk", p.Name, version, arch), nil
+}
+
+// writeAPK writes the package as an unsigned apk: its control tarball and its
+// data tarball, each gzip-compressed. apk add takes it with
+// --allow-untrusted, or once abuild-sign has signed it.
+func writeAPK(w io.Writer, p Package) error {
+	arch, version, err := apkIdentity(p)
+	if err != nil {
+		return err
+	}
+	files, err := p.files()
+	if err != nil {
+		return err
+	}
+	depends, err := parseDependencies(p.Depends)
+	if err != nil {
+		return err
+	}
+
+	// every file carries the checksum apk verifies it by
+	var entries []tarEntry
+	for _, dir := range dirs(files) {
+		entries = append(entries, tarEntry{name: strings.TrimPrefix(dir, "/") + "/", mode: fs.ModeDir | 0755})
+	}
+	for _, f := range files {
+		entries = append(entries, tarEntry{
+			name: strings.TrimPrefix(f.Path, "/"),
+			mode: f.Mode,
+			data: f.Data,
+			pax:  map[string]string{"APK-TOOLS.checksum.SHA1": fmt.Sprintf("%x", sha1.Sum(f.Data))},
+		})
+	}
+	data, err := tarGz(entries, p.ModTime, true)
+	if err != nil {
+		return err
+	}
+
+	var pkginfo strings.Builder
+	field := func(key, val string) {
+		if val != "" {
+			fmt.Fprintf(&pkginfo, "%s = %s\n", key, val)
+		}
+	}
+	pkginfo.WriteString("# Generated by gopro\n")
+	field("pkgname", p.Name)
+	field("pkgver", version)
+	field("pkgdesc", p.summary())
+	field("url", p.Homepage)
+	field("builddate", fmt.Sprint(p.ModTime.Unix()))
+	field("packager", p.Maintainer)
+	field("size", fmt.Sprint(installedSize(files)))
+	field("arch", arch)
+	field("origin", p.Name)
+	field("license", p.License)
+	for _, d := range depends {
+		field("depend", d.name+d.op+d.version)
+	}
+	field("datahash", fmt.Sprintf("%x", sha256.Sum256(data)))
+	control := []tarEntry{{name: ".PKGINFO", mode: 0644, data: []byte(pkginfo.String())}}
+	for _, s := range []struct{ name, body string }{
+		{".pre-install", p.Scripts.PreInstall},
+		{".post-install", p.Scripts.PostInstall},
+		{".pre-deinstall", p.Scripts.PreRemove},
+		{".post-deinstall", p.Scripts.PostRemove},
+	} {
+		if s.body != "" {
+			control = append(control, tarEntry{name: s.name, mode: 0755, data: []byte(s.body)})
+		}
+	}
+	// the control tarball is cut before its end, so the two read as one
+	head, err := tarGz(control, p.ModTime, false)
+	if err != nil {
+		return err
+	}
+
+	if _, err := w.Write(head); err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
@@ -0,0 +1,238 @@
+package pkgutil
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/md5"
+	"fmt"
+	"io"
+	"io/fs"
+	"strings"
+	"time"
+)
+
+// debIdentity returns the architecture and full version a deb is named by.
+func debIdentity(p Package) (arch, version string, err error) {
+	arch, err = archName(p.Arch, debIndex)
+	if err != nil {
+		return "", "", err
+	}
+	version, err = packageVersion(p.Version)
+	if err != nil {
+		return "", "", err
+	}
+	return arch, version + "-" + p.Release, nil
+}
+
+func debFileName(p Package) (string, error) {
+	arch, version, err := debIdentity(p)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s_%s_%s.deb", p.Name, version, arch), nil
+}
+
+// writeDeb writes the package as a deb: an ar archive of its format version,
+// its control tarball and its data tarball.
+func writeDeb(w io.Writer, p Package) error {
+	arch, version, err := debIdentity(p)
+	if err != nil {
+		return err
+	}
+	files, err := p.files()
+	if err != nil {
+		return err
+	}
+	depends, err := parseDependencies(p.Depends)
+	if err != nil {
+		return err
+	}
+	control, err := debControl(p, arch, version, files, depends)
+	if err != nil {
+		return err
+	}
+	data, err := debData(files, p.ModTime)
+	if err != nil {
+		return err
+	}
+	return writeAr(w, p.ModTime, []arMember{
+		{name: "debian-binary", data: []byte("2.0\n")},
+		{name: "control.tar.gz", data: control},
+		{name: "data.tar.gz", data: data},
+	})
+}
+
+// debControl returns the control.tar.gz of a deb: its control file, the
+// checksums of its files, its config files and its maintainer scripts.
+func debControl(p Package, arch, version string, files []File, depends []dependency) ([]byte, error) {
+	var control strings.Builder
+	field := func(key, val string) {
+		if val != "" {
+			fmt.Fprintf(&control, "%s: %s\n", key, val)
+		}
+	}
+	field("Package", p.Name)
+	field("Version", version)
+	field("Architecture", arch)
+	maintainer := p.Maintainer
+	if maintainer == "" {
+		maintainer = "unknown"
+	}
+	field("Maintainer", maintainer)
+	field("Installed-Size", fmt.Sprint((installedSize(files)+1023)/1024))
+	var names []string
+	for _, d := range depends {
+		name := d.name
+		if d.op != "" {
+			op := d.op
+			switch op {
+			case "<":
+				op = "<<"
+			case ">":
+				op = ">>"
+			}
+			name = fmt.Sprintf("%s (%s %s)", d.name, op, d.version)
+		}
+		names = append(names, name)
+	}
+	field("Depends", strings.Join(names, ", "))
+	field("Section", "misc")
+	field("Priority", "optional")
+	field("Homepage", p.Homepage)
+	control.WriteString("Description: " + p.summary() + "\n")
+	if _, body, ok := strings.Cut(strings.TrimSpace(p.Description), "\n"); ok {
+		// continuation lines are indented, and an empty one is a lone dot
+		for _, line := range strings.Split(body, "\n") {
+			if strings.TrimSpace(line) == "" {
+				line = "."
+			}
+			control.WriteString(" " + line + "\n")
+		}
+	}
+
+	var md5sums, conffiles strings.Builder
+	for _, f := range files {
+		fmt.Fprintf(&md5sums, "%x  %s\n", md5.Sum(f.Data), strings.TrimPrefix(f.Path, "/"))
+		if f.Config {
+			conffiles.WriteString(f.Path + "\n")
+		}
+	}
+	entries := []tarEntry{
+		{name: "./", mode: fs.ModeDir | 0755},
+		{name: "./control", mode: 0644, data: []byte(control.String())},
+		{name: "./md5sums", mode: 0644, data: []byte(md5sums.String())},
+	}
+	if conffiles.Len() > 0 {
+		entries = append(entries, tarEntry{name: "./conffiles", mode: 0644, data: []byte(conffiles.String())})
+	}
+	for _, s := range []struct{ name, body string }{
+		{"preinst", p.Scripts.PreInstall},
+		{"postinst", p.Scripts.PostInstall},
+		{"prerm", p.Scripts.PreRemove},
+		{"postrm", p.Scripts.PostRemove},
+	} {
+		if s.body != "" {
+			entries = append(entries, tarEntry{name: "./" + s.name, mode: 0755, data: []byte(s.body)})
+		}
+	}
+	return tarGz(entries, p.ModTime, true)
+}
+
+// debData returns the data.tar.gz of a deb, holding the files and the
+// directories above them.
+func debData(files []File, modTime time.Time) ([]byte, error) {
+	entries := []tarEntry{{name: "./", mode: fs.ModeDir | 0755}}
+	for _, dir := range dirs(files) {
+		entries = append(entries, tarEntry{name: "." + dir + "/", mode: fs.ModeDir | 0755})
+	}
+	for _, f := range files {
+		entries = append(entries, tarEntry{name: "." + f.Path, mode: f.Mode, data: f.Data})
+	}
+	return tarGz(entries, modTime, true)
+}
+
+// tarEntry is one entry of a tarball.
+type tarEntry struct {
+	name string
+	mode fs.FileMode
+	data []byte
+	pax  map[string]string
+}
+
+// tarGz returns the entries as a gzip-compressed tarball, ending it unless
+// end is false: apk concatenates its control tarball with the data one.
+func tarGz(entries []tarEntry, modTime time.Time, end bool) ([]byte, error) {
+	var b bytes.Buffer
+	gz, err := gzip.NewWriterLevel(&b, gzip.BestCompression)
+	if err != nil {
+		return nil, err
+	}
+	tw := tar.NewWriter(gz)
+	for _, e := range entries {
+		h := &tar.Header{
+			Name:     e.name,
+			Mode:     int64(e.mode.Perm()),
+			Size:     int64(len(e.data)),
+			ModTime:  modTime,
+			Uname:    "root",
+			Gname:    "root",
+			Typeflag: tar.TypeReg,
+		}
+		if e.mode.IsDir() {
+			h.Typeflag = tar.TypeDir
+			h.Size = 0
+		}
+		if e.pax != nil {
+			h.PAXRecords = e.pax
+			h.Format = tar.FormatPAX
+		}
+		if err := tw.WriteHeader(h); err != nil {
+			return nil, err
+		}
+		if _, err := tw.Write(e.data); err != nil {
+			return nil, err
+		}
+	}
+	if end {
+		err = tw.Close()
+	} else {
+		err = tw.Flush()
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// arMember is one member of an ar archive.
+type arMember struct {
+	name string
+	data []byte
+}
+
+// writeAr writes members as the common ar format a deb is.
+func writeAr(w io.Writer, modTime time.Time, members []arMember) error {
+	if _, err := io.WriteString(w, "!<arch>\n"); err != nil {
+		return err
+	}
+	for _, m := range members {
+		header := fmt.Sprintf("%-16s%-12d%-6d%-6d%-8o%-10d`\n", m.name, modTime.Unix(), 0, 0, 0100644, len(m.data))
+		if _, err := io.WriteString(w, header); err != nil {
+			return err
+		}
+		if _, err := w.Write(m.data); err != nil {
+			return err
+		}
+		// members start on an even offset
+		if len(m.data)%2 == 1 {
+			if _, err := io.WriteString(w, "\n"); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,269 @@
+// Package pkgutil writes Linux packages, deb, rpm and apk, without the
+// distribution tools that usually build them. Every entry is owned by root and
+// stamped with the package's build time, so the same package always makes the
+// same file.
+package pkgutil
+
+import (
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Package is a package to write, for one architecture.
+type Package struct {
+	Name string
+	// Version is the upstream version, such as v1.2.3 or 1.2.3-rc.1; each
+	// format turns it into a version it orders correctly.
+	Version string
+	// Release is the package revision of the version.
+	Release string
+	// Arch is the GOARCH the package is for.
+	Arch        string
+	Maintainer  string
+	Description string
+	Homepage    string
+	License     string
+	// Depends are the packages it needs installed, each a name optionally
+	// followed by a constraint, such as "libc6 (>= 2.31)" or "glibc >= 2.31".
+	Depends []string
+	Files   []File
+	Scripts Scripts
+	ModTime time.Time
+}
+
+// File is one file installed by a package.
+type File struct {
+	// Path is the absolute, slash-separated path the file is installed at.
+	Path string
+	Data []byte
+	Mode fs.FileMode
+	// Config marks a config file, which an upgrade leaves alone once edited.
+	Config bool
+}
+
+// Scripts are the maintainer scripts of a package, run by /bin/sh.
+type Scripts struct {
+	PreInstall  string
+	PostInstall string
+	PreRemove   string
+	PostRemove  string
+}
+
+// files returns the files of the package with clean paths, sorted by path.
+func (p Package) files() ([]File, error) {
+	files := make([]File, 0, len(p.Files))
+	for _, f := range p.Files {
+		name := path.Clean("/" + f.Path)
+		if name == "/" {
+			return nil, fmt.Errorf("invalid package path %q", f.Path)
+		}
+		f.Path = name
+		if f.Mode == 0 {
+			f.Mode = 0644
+		}
+		files = append(files, f)
+	}
+	slices.SortFunc(files, func(a, b File) int {
+		return strings.Compare(a.Path, b.Path)
+	})
+	for i := 1; i < len(files); i++ {
+		if files[i].Path == files[i-1].Path {
+			return nil, fmt.Errorf("%s is packaged twice", files[i].Path)
+		}
+	}
+	return files, nil
+}
+
+// dirs returns every directory above the files, parents first.
+func dirs(files []File) []string {
+	seen := make(map[string]bool)
+	var result []string
+	for _, f := range files {
+		var parents []string
+		for dir := path.Dir(f.Path); dir != "/" && !seen[dir]; dir = path.Dir(dir) {
+			seen[dir] = true
+			parents = append(parents, dir)
+		}
+		slices.Reverse(parents)
+		result = append(result, parents...)
+	}
+	return result
+}
+
+// installedSize returns the size of the files in bytes.
+func installedSize(files []File) int64 {
+	var size int64
+	for _, f := range files {
+		size += int64(len(f.Data))
+	}
+	return size
+}
+
+// summary returns the first line of the description, or the package name.
+func (p Package) summary() string {
+	line, _, _ := strings.Cut(strings.TrimSpace(p.Description), "\n")
+	if line == "" {
+		return p.Name
+	}
+	return line
+}
+
+var (
+	versionCore   = regexp.MustCompile(`^[0-9]+(\.[0-9]+)*`)
+	preRelease    = regexp.MustCompile(`^(alpha|beta|pre|rc)\.?([0-9]*)`)
+	postRelease   = regexp.MustCompile(`^([0-9]+)`)
+	versionUnsafe = regexp.MustCompile(`[^A-Za-z0-9.+~]`)
+)
+
+// splitVersion splits a version, without its leading v, into its numeric core
+// and what follows it.
+func splitVersion(version string) (core, rest string, err error) {
+	version = strings.TrimPrefix(version, "v")
+	core = versionCore.FindString(version)
+	if core == "" {
+		return "", "", fmt.Errorf("version %q does not start with a number", version)
+	}
+	return core, strings.TrimLeft(version[len(core):], "-+"), nil
+}
+
+// packageVersion returns the version as deb and rpm order it: a pre-release
+// such as 1.2.3-rc.1 becomes 1.2.3~rc.1, sorting before 1.2.3, and anything
+// else following the core, such as the commits of a git describe, comes after
+// a +.
+func packageVersion(version string) (string, error) {
+	core, rest, err := splitVersion(version)
+	if err != nil || rest == "" {
+		return core, err
+	}
+	sep := "+"
+	if preRelease.MatchString(rest) {
+		sep = "~"
+	}
+	return core + sep + versionUnsafe.ReplaceAllString(rest, "."), nil
+}
+
+// apkVersion returns the version as apk accepts it: the core, then _rc1 and
+// the like for a pre-release or _pN for the commits after a tag.
+func apkVersion(version string) (string, error) {
+	core, rest, err := splitVersion(version)
+	if err != nil {
+		return "", err
+	}
+	if m := preRelease.FindStringSubmatch(rest); m != nil {
+		return core + "_" + m[1] + m[2], nil
+	}
+	if m := postRelease.FindStringSubmatch(rest); m != nil {
+		return core + "_p" + m[1], nil
+	}
+	return core, nil
+}
+
+// dependency is a package a package depends on, with an optional constraint.
+type dependency struct {
+	name, op, version string
+}
+
+var dependencyPattern = regexp.MustCompile(`^([^\s()<>=]+)\s*\(?\s*(<<|>>|<=|>=|=|<|>)?\s*([^\s()]*)\s*\)?$`)
+
+func parseDependency(s string) (dependency, error) {
+	m := dependencyPattern.FindStringSubmatch(strings.TrimSpace(s))
+	if m == nil || (m[2] == "") != (m[3] == "") {
+		return dependency{}, fmt.Errorf("invalid dependency %q", s)
+	}
+	op := m[2]
+	switch op {
+	case "<<":
+		op = "<"
+	case ">>":
+		op = ">"
+	}
+	return dependency{name: m[1], op: op, version: m[3]}, nil
+}
+
+func parseDependencies(depends []string) ([]dependency, error) {
+	result := make([]dependency, 0, len(depends))
+	for _, s := range depends {
+		d, err := parseDependency(s)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, d)
+	}
+	return result, nil
+}
+
+// archNames maps a GOARCH to the architecture each format names it by.
+var archNames = map[string][3]string{
+	//         deb        rpm        apk
+	"amd64":   {"amd64", "x86_64", "x86_64"},
+	"386":     {"i386", "i686", "x86"},
+	"arm64":   {"arm64", "aarch64", "aarch64"},
+	"arm":     {"armhf", "armv7hl", "armv7"},
+	"ppc64le": {"ppc64el", "ppc64le", "ppc64le"},
+	"s390x":   {"s390x", "s390x", "s390x"},
+	"riscv64": {"riscv64", "riscv64", "riscv64"},
+	"loong64": {"loong64", "loongarch64", "loongarch64"},
+}
+
+const (
+	debIndex = iota
+	rpmIndex
+	apkIndex
+)
+
+func archName(goarch string, format int) (string, error) {
+	names, ok := archNames[goarch]
+	if !ok {
+		return "", fmt.Errorf("architecture %s is not packaged", goarch)
+	}
+	return names[format], nil
+}
+
+// formats are the package formats written, by name.
+var formats = map[string]struct {
+	fileName func(Package) (string, error)
+	write    func(io.Writer, Package) error
+}{
+	"deb": {debFileName, writeDeb},
+	"rpm": {rpmFileName, writeRPM},
+	"apk": {apkFileName, writeAPK},
+}
+
+// FileName returns the name of the package file in a format, as the
+// distribution's own tools name it.
+func FileName(format string, p Package) (string, error) {
+	f, ok := formats[format]
+	if !ok {
+		return "", fmt.Errorf("unknown package format %q", format)
+	}
+	return f.fileName(p)
+}
+
+// Write writes the package in a format at output, removing what was written
+// when anything fails.
+func Write(output, format string, p Package) (err error) {
+	f, ok := formats[format]
+	if !ok {
+		return fmt.Errorf("unknown package format %q", format)
+	}
+	out, err := os.Create(output)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(output)
+		}
+	}()
+	return f.write(out, p)
+}
@@ -0,0 +1,342 @@
+package pkgutil
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testPackage() Package {
+	return Package{
+		Name:        "api",
+		Version:     "v1.2.3-rc.1",
+		Release:     "2",
+		Arch:        "arm64",
+		Maintainer:  "Ops <ops@example.com>",
+		Description: "The API server\n\nServes the API.",
+		License:     "MIT",
+		Depends:     []string{"ca-certificates", "libc6 (>= 2.31)"},
+		Files: []File{
+			{Path: "/usr/bin/api", Data: []byte("\x7fELF"), Mode: 0755},
+			{Path: "/etc/api/config.yaml", Data: []byte("port: 80\n"), Config: true},
+			{Path: "/usr/lib/systemd/system/api.service", Data: []byte("[Unit]\n")},
+		},
+		Scripts: Scripts{PostInstall: "systemctl daemon-reload\n"},
+		ModTime: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+}
+
+func TestVersions(t *testing.T) {
+	tests := []struct {
+		version string
+		pkg     string
+		apk     string
+	}{
+		{version: "v1.2.3", pkg: "1.2.3", apk: "1.2.3"},
+		{version: "1.2.3-rc.1", pkg: "1.2.3~rc.1", apk: "1.2.3_rc1"},
+		{version: "v2.0.0-beta", pkg: "2.0.0~beta", apk: "2.0.0_beta"},
+		{version: "v1.2.3-4-gabcdef", pkg: "1.2.3+4.gabcdef", apk: "1.2.3_p4"},
+		{version: "v1.2.3+build.7", pkg: "1.2.3+build.7", apk: "1.2.3"},
+		{version: "dev"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.version, func(t *testing.T) {
+			pkg, err := packageVersion(tt.version)
+			if tt.pkg == "" {
+				if err == nil {
+					t.Errorf("packageVersion(%q) = %q, want an error", tt.version, pkg)
+				}
+				return
+			}
+			if err != nil || pkg != tt.pkg {
+				t.Errorf("packageVersion(%q) = %q, %v, want %q", tt.version, pkg, err, tt.pkg)
+			}
+			apk, err := apkVersion(tt.version)
+			if err != nil || apk != tt.apk {
+				t.Errorf("apkVersion(%q) = %q, %v, want %q", tt.version, apk, err, tt.apk)
+			}
+		})
+	}
+}
+
+func TestParseDependency(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    dependency
+		wantErr bool
+	}{
+		{in: "curl", want: dependency{name: "curl"}},
+		{in: "libc6 (>= 2.31)", want: dependency{name: "libc6", op: ">=", version: "2.31"}},
+		{in: "glibc >= 2.31", want: dependency{name: "glibc", op: ">=", version: "2.31"}},
+		{in: "foo (<< 2)", want: dependency{name: "foo", op: "<", version: "2"}},
+		{in: "foo >=", wantErr: true},
+		{in: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		got, err := parseDependency(tt.in)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("parseDependency(%q) = %+v, %v, want %+v", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+// writePackage writes the test package in a format into a temporary dir.
+func writePackage(t *testing.T, format string) string {
+	t.Helper()
+	name, err := FileName(format, testPackage())
+	if err != nil {
+		t.Fatal(err)
+	}
+	output := filepath.Join(t.TempDir(), name)
+	if err := Write(output, format, testPackage()); err != nil {
+		t.Fatal(err)
+	}
+	return output
+}
+
+// readTar reads the entries of a tarball, or of several concatenated ones
+// when it is cut before its end, by name.
+func readTar(t *testing.T, r io.Reader) (map[string]string, []*tar.Header) {
+	t.Helper()
+	entries := make(map[string]string)
+	var headers []*tar.Header
+	tr := tar.NewReader(r)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return entries, headers
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := io.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries[h.Name] = string(b)
+		headers = append(headers, h)
+	}
+}
+
+func readTarGz(t *testing.T, b []byte) map[string]string {
+	t.Helper()
+	gz, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		t.Fatal(err)
+	}
+	entries, _ := readTar(t, gz)
+	return entries
+}
+
+func TestWriteDeb(t *testing.T) {
+	output := writePackage(t, "deb")
+	if filepath.Base(output) != "api_1.2.3~rc.1-2_arm64.deb" {
+		t.Errorf("wrote %s", output)
+	}
+	b, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(b, []byte("!<arch>\n")) {
+		t.Fatal("not an ar archive")
+	}
+	members := make(map[string][]byte)
+	var names []string
+	for rest := b[8:]; len(rest) > 0; {
+		name := strings.TrimSpace(string(rest[:16]))
+		var size int
+		fmt.Sscan(string(rest[48:58]), &size)
+		members[name] = rest[60 : 60+size]
+		names = append(names, name)
+		rest = rest[60+size+size%2:]
+	}
+	if !slices.Equal(names, []string{"debian-binary", "control.tar.gz", "data.tar.gz"}) {
+		t.Fatalf("members %q", names)
+	}
+
+	control := readTarGz(t, members["control.tar.gz"])
+	for _, want := range []string{
+		"Package: api\n",
+		"Version: 1.2.3~rc.1-2\n",
+		"Architecture: arm64\n",
+		"Depends: ca-certificates, libc6 (>= 2.31)\n",
+		"Description: The API server\n .\n Serves the API.\n",
+	} {
+		if !strings.Contains(control["./control"], want) {
+			t.Errorf("control lacks %q:\n%s", want, control["./control"])
+		}
+	}
+	if control["./conffiles"] != "/etc/api/config.yaml\n" {
+		t.Errorf("conffiles = %q", control["./conffiles"])
+	}
+	if control["./postinst"] != "systemctl daemon-reload\n" {
+		t.Errorf("postinst = %q", control["./postinst"])
+	}
+	data := readTarGz(t, members["data.tar.gz"])
+	for _, name := range []string{"./etc/", "./etc/api/", "./usr/bin/", "./usr/lib/systemd/system/"} {
+		if _, ok := data[name]; !ok {
+			t.Errorf("data lacks directory %s", name)
+		}
+	}
+	if data["./usr/bin/api"] != "\x7fELF" {
+		t.Errorf("binary = %q", data["./usr/bin/api"])
+	}
+}
+
+func TestWriteAPK(t *testing.T) {
+	output := writePackage(t, "apk")
+	if filepath.Base(output) != "api-1.2.3_rc1-r2.aarch64.apk" {
+		t.Errorf("wrote %s", output)
+	}
+	b, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the control stream comes first, and the data stream is what is left
+	r := bytes.NewReader(b)
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	gz.Multistream(false)
+	control, _ := readTar(t, gz)
+	if _, err := io.Copy(io.Discard, gz); err != nil {
+		t.Fatal(err)
+	}
+	data := b[len(b)-r.Len():]
+
+	pkginfo := control[".PKGINFO"]
+	for _, want := range []string{
+		"pkgver = 1.2.3_rc1-r2\n",
+		"arch = aarch64\n",
+		"depend = libc6>=2.31\n",
+		fmt.Sprintf("datahash = %x\n", sha256.Sum256(data)),
+	} {
+		if !strings.Contains(pkginfo, want) {
+			t.Errorf(".PKGINFO lacks %q:\n%s", want, pkginfo)
+		}
+	}
+	if control[".post-install"] != "systemctl daemon-reload\n" {
+		t.Errorf(".post-install = %q", control[".post-install"])
+	}
+
+	gz, err = gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files, headers := readTar(t, gz)
+	if files["usr/bin/api"] != "\x7fELF" {
+		t.Errorf("binary = %q", files["usr/bin/api"])
+	}
+	for _, h := range headers {
+		if h.Typeflag == tar.TypeReg && h.PAXRecords["APK-TOOLS.checksum.SHA1"] == "" {
+			t.Errorf("%s carries no checksum", h.Name)
+		}
+	}
+}
+
+func TestWriteRPM(t *testing.T) {
+	output := writePackage(t, "rpm")
+	if filepath.Base(output) != "api-1.2.3~rc.1-2.aarch64.rpm" {
+		t.Errorf("wrote %s", output)
+	}
+	b, err := os.ReadFile(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(b, []byte{0xed, 0xab, 0xee, 0xdb}) {
+		t.Fatal("no lead magic")
+	}
+	sig, n := rpmTags(t, b[96:], rpmTagHeaderSignatures)
+	n += (8 - n%8) % 8
+	rest := b[96+n:]
+	header, n := rpmTags(t, rest, rpmTagHeaderImmutable)
+	payload := rest[n:]
+
+	if got := fmt.Sprintf("%x", sha256.Sum256(rest[:n])); cString(sig[rpmSigTagSHA256]) != got {
+		t.Errorf("header digest %s, want %s", cString(sig[rpmSigTagSHA256]), got)
+	}
+	if got := binary.BigEndian.Uint32(sig[rpmSigTagSize]); int(got) != len(rest) {
+		t.Errorf("size %d, want %d", got, len(rest))
+	}
+	for tag, want := range map[uint32]string{
+		rpmTagName:      "api",
+		rpmTagVersion:   "1.2.3~rc.1",
+		rpmTagRelease:   "2",
+		rpmTagArch:      "aarch64",
+		rpmTagPostIn:    "systemctl daemon-reload\n",
+		rpmTagSourceRPM: "api-1.2.3~rc.1-2.src.rpm",
+	} {
+		if got := cString(header[tag]); got != want {
+			t.Errorf("tag %d = %q, want %q", tag, got, want)
+		}
+	}
+	baseNames := strings.Split(string(header[rpmTagBaseNames]), "\x00")[:3]
+	if !slices.Equal(baseNames, []string{"config.yaml", "api", "api.service"}) {
+		t.Errorf("base names %q", baseNames)
+	}
+	if flags := binary.BigEndian.Uint32(header[rpmTagFileFlags]); flags != rpmFileConfig|rpmFileNoReplace {
+		t.Errorf("config file flags %d", flags)
+	}
+
+	gz, err := gzip.NewReader(bytes.NewReader(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cpio, err := io.ReadAll(gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"./etc/api/config.yaml\x00", "./usr/bin/api\x00", "TRAILER!!!\x00"} {
+		if !bytes.Contains(cpio, []byte(name)) {
+			t.Errorf("payload lacks %q", name)
+		}
+	}
+	if got := binary.BigEndian.Uint32(sig[rpmSigTagPayloadSize]); int(got) != len(cpio) {
+		t.Errorf("payload size %d, want %d", got, len(cpio))
+	}
+}
+
+// rpmTags reads the entries of the rpm header at the start of b, checking
+// its region, and returns them by tag with the header's length.
+func rpmTags(t *testing.T, b []byte, region uint32) (map[uint32][]byte, int) {
+	t.Helper()
+	if !bytes.HasPrefix(b, []byte{0x8e, 0xad, 0xe8, 0x01}) {
+		t.Fatal("no header magic")
+	}
+	il, dl := int(binary.BigEndian.Uint32(b[8:])), int(binary.BigEndian.Uint32(b[12:]))
+	index, store := b[16:16+il*16], b[16+il*16:16+il*16+dl]
+	tags := make(map[uint32][]byte)
+	var offsets []int
+	for i := 0; i < il; i++ {
+		e := index[i*16:]
+		tag, offset := binary.BigEndian.Uint32(e), int(binary.BigEndian.Uint32(e[8:]))
+		tags[tag] = store[offset:]
+		offsets = append(offsets, offset)
+	}
+	if binary.BigEndian.Uint32(index) != region || offsets[0] != dl-16 {
+		t.Fatalf("region entry %x at %d", index[:16], offsets[0])
+	}
+	if int32(binary.BigEndian.Uint32(store[dl-8:])) != int32(-il*16) {
+		t.Errorf("region trailer %x", store[dl-16:])
+	}
+	if !slices.IsSorted(offsets[1:]) {
+		t.Errorf("data out of order: %v", offsets)
+	}
+	return tags, 16 + il*16 + dl
+}
+
+func cString(b []byte) string {
+	s, _, _ := strings.Cut(string(b), "\x00")
+	return s
+}
@@ -0,0 +1,444 @@
+package pkgutil
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"path"
+	"slices"
+	"strings"
+)
+
+// rpm header tags, as rpmtag.h numbers them
+const (
+	rpmTagHeaderSignatures  = 62
+	rpmTagHeaderImmutable   = 63
+	rpmTagHeaderI18NTable   = 100
+	rpmSigTagSHA1           = 269
+	rpmSigTagSHA256         = 273
+	rpmSigTagSize           = 1000
+	rpmSigTagMD5            = 1004
+	rpmSigTagPayloadSize    = 1007
+	rpmTagName              = 1000
+	rpmTagVersion           = 1001
+	rpmTagRelease           = 1002
+	rpmTagSummary           = 1004
+	rpmTagDescription       = 1005
+	rpmTagBuildTime         = 1006
+	rpmTagBuildHost         = 1007
+	rpmTagSize              = 1009
+	rpmTagLicense           = 1014
+	rpmTagPackager          = 1015
+	rpmTagGroup             = 1016
+	rpmTagURL               = 1020
+	rpmTagOS                = 1021
+	rpmTagArch              = 1022
+	rpmTagPreIn             = 1023
+	rpmTagPostIn            = 1024
+	rpmTagPreUn             = 1025
+	rpmTagPostUn            = 1026
+	rpmTagFileSizes         = 1028
+	rpmTagFileModes         = 1030
+	rpmTagFileRdevs         = 1033
+	rpmTagFileMtimes        = 1034
+	rpmTagFileDigests       = 1035
+	rpmTagFileLinkTos       = 1036
+	rpmTagFileFlags         = 1037
+	rpmTagFileUserName      = 1039
+	rpmTagFileGroupName     = 1040
+	rpmTagSourceRPM         = 1044
+	rpmTagProvideName       = 1047
+	rpmTagRequireFlags      = 1048
+	rpmTagRequireName       = 1049
+	rpmTagRequireVersion    = 1050
+	rpmTagRPMVersion        = 1064
+	rpmTagPreInProg         = 1085
+	rpmTagPostInProg        = 1086
+	rpmTagPreUnProg         = 1087
+	rpmTagPostUnProg        = 1088
+	rpmTagFileDevices       = 1095
+	rpmTagFileInodes        = 1096
+	rpmTagFileLangs         = 1097
+	rpmTagProvideFlags      = 1112
+	rpmTagProvideVersion    = 1113
+	rpmTagDirIndexes        = 1116
+	rpmTagBaseNames         = 1117
+	rpmTagDirNames          = 1118
+	rpmTagPayloadFormat     = 1124
+	rpmTagPayloadCompressor = 1125
+	rpmTagPayloadFlags      = 1126
+	rpmTagFileDigestAlgo    = 5011
+	rpmTagPayloadDigest     = 5092
+	rpmTagPayloadDigestAlgo = 5093
+)
+
+// rpm header data types
+const (
+	rpmBin         = 7
+	rpmInt16       = 3
+	rpmInt32       = 4
+	rpmString      = 6
+	rpmStringArray = 8
+	rpmI18NString  = 9
+)
+
+const (
+	rpmSenseLess    = 1 << 1
+	rpmSenseGreater = 1 << 2
+	rpmSenseEqual   = 1 << 3
+	rpmSenseRPMLib  = 1 << 24
+
+	rpmFileConfig    = 1 << 0
+	rpmFileNoReplace = 1 << 4
+
+	rpmDigestSHA256 = 8
+)
+
+// rpmIdentity returns the architecture and version an rpm is named by.
+func rpmIdentity(p Package) (arch, version string, err error) {
+	arch, err = archName(p.Arch, rpmIndex)
+	if err != nil {
+		return "", "", err
+	}
+	version, err = packageVersion(p.Version)
+	return arch, version, err
+}
+
+func rpmFileName(p Package) (string, error) {
+	arch, version, err := rpmIdentity(p)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s-%s-%s.%s.rpm", p.Name, version, p.Release, arch), nil
+}
+
+// writeRPM writes the package as an rpm: its lead, the signature header
+// holding the digests of what follows, the header describing the package,
+// and its files as a gzip-compressed cpio archive.
+func writeRPM(w io.Writer, p Package) error {
+	arch, version, err := rpmIdentity(p)
+	if err != nil {
+		return err
+	}
+	files, err := p.files()
+	if err != nil {
+		return err
+	}
+	depends, err := parseDependencies(p.Depends)
+	if err != nil {
+		return err
+	}
+
+	cpio := rpmPayload(files, p)
+	var payload bytes.Buffer
+	gz, err := gzip.NewWriterLevel(&payload, gzip.BestCompression)
+	if err != nil {
+		return err
+	}
+	if _, err := gz.Write(cpio); err != nil {
+		return err
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+
+	h := rpmHeader(p, arch, version, files, depends, payload.Bytes())
+	header := h.bytes(rpmTagHeaderImmutable)
+	headerSHA1, headerSHA256 := sha1.Sum(header), sha256.Sum256(header)
+	digest := md5.New()
+	digest.Write(header)
+	digest.Write(payload.Bytes())
+	var sig rpmHeaderBuilder
+	sig.string(rpmSigTagSHA1, fmt.Sprintf("%x", headerSHA1))
+	sig.string(rpmSigTagSHA256, fmt.Sprintf("%x", headerSHA256))
+	sig.int32(rpmSigTagSize, uint32(len(header)+payload.Len()))
+	sig.bin(rpmSigTagMD5, digest.Sum(nil))
+	sig.int32(rpmSigTagPayloadSize, uint32(len(cpio)))
+	signature := sig.bytes(rpmTagHeaderSignatures)
+	// the header following the signature starts on an 8 byte boundary
+	if pad := len(signature) % 8; pad != 0 {
+		signature = append(signature, make([]byte, 8-pad)...)
+	}
+
+	for _, b := range [][]byte{rpmLead(fmt.Sprintf("%s-%s-%s", p.Name, version, p.Release)), signature, header, payload.Bytes()} {
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rpmLead returns the lead an rpm starts with, which rpm only checks the
+// magic of these days.
+func rpmLead(name string) []byte {
+	lead := make([]byte, 96)
+	copy(lead, []byte{0xed, 0xab, 0xee, 0xdb, 3, 0})
+	// a binary package, built for linux, with a header-style signature
+	binary.BigEndian.PutUint16(lead[6:], 0)
+	binary.BigEndian.PutUint16(lead[8:], 1)
+	copy(lead[10:75], name)
+	binary.BigEndian.PutUint16(lead[76:], 1)
+	binary.BigEndian.PutUint16(lead[78:], 5)
+	return lead
+}
+
+// rpmHeader returns the header describing the package and its files.
+func rpmHeader(p Package, arch, version string, files []File, depends []dependency, payload []byte) *rpmHeaderBuilder {
+	var h rpmHeaderBuilder
+	h.stringArray(rpmTagHeaderI18NTable, []string{"C"})
+	h.string(rpmTagName, p.Name)
+	h.string(rpmTagVersion, version)
+	h.string(rpmTagRelease, p.Release)
+	h.i18nString(rpmTagSummary, p.summary())
+	description := strings.TrimSpace(p.Description)
+	if description == "" {
+		description = p.summary()
+	}
+	h.i18nString(rpmTagDescription, description)
+	h.int32(rpmTagBuildTime, uint32(p.ModTime.Unix()))
+	h.string(rpmTagBuildHost, "localhost")
+	h.int32(rpmTagSize, uint32(installedSize(files)))
+	license := p.License
+	if license == "" {
+		license = "Unknown"
+	}
+	h.string(rpmTagLicense, license)
+	if p.Maintainer != "" {
+		h.string(rpmTagPackager, p.Maintainer)
+	}
+	h.i18nString(rpmTagGroup, "Unspecified")
+	if p.Homepage != "" {
+		h.string(rpmTagURL, p.Homepage)
+	}
+	h.string(rpmTagOS, "linux")
+	h.string(rpmTagArch, arch)
+	scripts := []struct {
+		tag, progTag int
+		body         string
+	}{
+		{rpmTagPreIn, rpmTagPreInProg, p.Scripts.PreInstall},
+		{rpmTagPostIn, rpmTagPostInProg, p.Scripts.PostInstall},
+		{rpmTagPreUn, rpmTagPreUnProg, p.Scripts.PreRemove},
+		{rpmTagPostUn, rpmTagPostUnProg, p.Scripts.PostRemove},
+	}
+	for _, s := range scripts {
+		if s.body != "" {
+			h.string(s.tag, s.body)
+			h.string(s.progTag, "/bin/sh")
+		}
+	}
+
+	if len(files) > 0 {
+		var (
+			sizes, mtimes, flags, devices, inodes, dirIndexes   []uint32
+			modes, rdevs                                        []uint16
+			digests, linkTos, users, langs, baseNames, dirNames []string
+		)
+		for i, f := range files {
+			dir, base := path.Split(f.Path)
+			index := slices.Index(dirNames, dir)
+			if index < 0 {
+				index = len(dirNames)
+				dirNames = append(dirNames, dir)
+			}
+			var flag uint32
+			if f.Config {
+				flag = rpmFileConfig | rpmFileNoReplace
+			}
+			sizes = append(sizes, uint32(len(f.Data)))
+			modes = append(modes, uint16(0o100000|f.Mode.Perm()))
+			rdevs = append(rdevs, 0)
+			mtimes = append(mtimes, uint32(p.ModTime.Unix()))
+			digests = append(digests, fmt.Sprintf("%x", sha256.Sum256(f.Data)))
+			linkTos = append(linkTos, "")
+			flags = append(flags, flag)
+			users = append(users, "root")
+			devices = append(devices, 1)
+			inodes = append(inodes, uint32(i+1))
+			langs = append(langs, "")
+			dirIndexes = append(dirIndexes, uint32(index))
+			baseNames = append(baseNames, base)
+		}
+		h.int32(rpmTagFileSizes, sizes...)
+		h.int16(rpmTagFileModes, modes...)
+		h.int16(rpmTagFileRdevs, rdevs...)
+		h.int32(rpmTagFileMtimes, mtimes...)
+		h.stringArray(rpmTagFileDigests, digests)
+		h.stringArray(rpmTagFileLinkTos, linkTos)
+		h.int32(rpmTagFileFlags, flags...)
+		h.stringArray(rpmTagFileUserName, users)
+		h.stringArray(rpmTagFileGroupName, users)
+		h.int32(rpmTagFileDevices, devices...)
+		h.int32(rpmTagFileInodes, inodes...)
+		h.stringArray(rpmTagFileLangs, langs)
+		h.int32(rpmTagDirIndexes, dirIndexes...)
+		h.stringArray(rpmTagBaseNames, baseNames)
+		h.stringArray(rpmTagDirNames, dirNames)
+		h.int32(rpmTagFileDigestAlgo, rpmDigestSHA256)
+	}
+	// without a source rpm, rpm takes the package for a source package
+	h.string(rpmTagSourceRPM, fmt.Sprintf("%s-%s-%s.src.rpm", p.Name, version, p.Release))
+	h.stringArray(rpmTagProvideName, []string{p.Name})
+	h.int32(rpmTagProvideFlags, rpmSenseEqual)
+	h.stringArray(rpmTagProvideVersion, []string{version + "-" + p.Release})
+
+	var (
+		requireNames, requireVersions []string
+		requireFlags                  []uint32
+	)
+	for _, d := range depends {
+		var flag uint32
+		if strings.Contains(d.op, "<") {
+			flag |= rpmSenseLess
+		}
+		if strings.Contains(d.op, ">") {
+			flag |= rpmSenseGreater
+		}
+		if strings.Contains(d.op, "=") {
+			flag |= rpmSenseEqual
+		}
+		requireNames = append(requireNames, d.name)
+		requireVersions = append(requireVersions, d.version)
+		requireFlags = append(requireFlags, flag)
+	}
+	// the features of rpm the package is written with
+	for _, lib := range []struct{ name, version string }{
+		{"rpmlib(CompressedFileNames)", "3.0.4-1"},
+		{"rpmlib(FileDigests)", "4.6.0-1"},
+		{"rpmlib(PayloadFilesHavePrefix)", "4.0-1"},
+	} {
+		requireNames = append(requireNames, lib.name)
+		requireVersions = append(requireVersions, lib.version)
+		requireFlags = append(requireFlags, rpmSenseRPMLib|rpmSenseLess|rpmSenseEqual)
+	}
+	h.int32(rpmTagRequireFlags, requireFlags...)
+	h.stringArray(rpmTagRequireName, requireNames)
+	h.stringArray(rpmTagRequireVersion, requireVersions)
+	h.string(rpmTagRPMVersion, "4.16.0")
+	h.string(rpmTagPayloadFormat, "cpio")
+	h.string(rpmTagPayloadCompressor, "gzip")
+	h.string(rpmTagPayloadFlags, "9")
+	h.stringArray(rpmTagPayloadDigest, []string{fmt.Sprintf("%x", sha256.Sum256(payload))})
+	h.int32(rpmTagPayloadDigestAlgo, rpmDigestSHA256)
+	return &h
+}
+
+// rpmPayload returns the files as the newc cpio archive an rpm carries.
+func rpmPayload(files []File, p Package) []byte {
+	var b bytes.Buffer
+	entry := func(name string, inode, mode, nlink uint32, data []byte) {
+		fmt.Fprintf(&b, "070701%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x%08x",
+			inode, mode, 0, 0, nlink, uint32(p.ModTime.Unix()), len(data), 0, 1, 0, 0, len(name)+1, 0)
+		b.WriteString(name + "\x00")
+		pad4(&b)
+		b.Write(data)
+		pad4(&b)
+	}
+	for i, f := range files {
+		entry("."+f.Path, uint32(i+1), uint32(0o100000|f.Mode.Perm()), 1, f.Data)
+	}
+	entry("TRAILER!!!", 0, 0, 1, nil)
+	return b.Bytes()
+}
+
+func pad4(b *bytes.Buffer) {
+	if n := b.Len() % 4; n != 0 {
+		b.Write(make([]byte, 4-n))
+	}
+}
+
+// rpmHeaderBuilder collects the entries of an rpm header.
+type rpmHeaderBuilder struct {
+	entries []rpmEntry
+}
+
+type rpmEntry struct {
+	tag, typ int
+	count    int
+	data     []byte
+}
+
+func (h *rpmHeaderBuilder) add(tag, typ, count int, data []byte) {
+	h.entries = append(h.entries, rpmEntry{tag: tag, typ: typ, count: count, data: data})
+}
+
+func (h *rpmHeaderBuilder) string(tag int, s string) {
+	h.add(tag, rpmString, 1, []byte(s+"\x00"))
+}
+
+func (h *rpmHeaderBuilder) i18nString(tag int, s string) {
+	h.add(tag, rpmI18NString, 1, []byte(s+"\x00"))
+}
+
+func (h *rpmHeaderBuilder) stringArray(tag int, ss []string) {
+	var b []byte
+	for _, s := range ss {
+		b = append(b, s...)
+		b = append(b, 0)
+	}
+	h.add(tag, rpmStringArray, len(ss), b)
+}
+
+func (h *rpmHeaderBuilder) bin(tag int, b []byte) {
+	h.add(tag, rpmBin, len(b), b)
+}
+
+func (h *rpmHeaderBuilder) int16(tag int, vals ...uint16) {
+	b := make([]byte, 2*len(vals))
+	for i, v := range vals {
+		binary.BigEndian.PutUint16(b[2*i:], v)
+	}
+	h.add(tag, rpmInt16, len(vals), b)
+}
+
+func (h *rpmHeaderBuilder) int32(tag int, vals ...uint32) {
+	b := make([]byte, 4*len(vals))
+	for i, v := range vals {
+		binary.BigEndian.PutUint32(b[4*i:], v)
+	}
+	h.add(tag, rpmInt32, len(vals), b)
+}
+
+// bytes returns the header, its entries sorted by tag behind the region
+// entry that marks them all immutable. The data of each entry follows the
+// one before it, aligned to its type, and the region trailer closes the store.
+func (h *rpmHeaderBuilder) bytes(region int) []byte {
+	entries := slices.Clone(h.entries)
+	slices.SortStableFunc(entries, func(a, b rpmEntry) int { return a.tag - b.tag })
+
+	var (
+		index []byte
+		store []byte
+	)
+	entryInfo := func(tag, typ, offset, count int) []byte {
+		b := make([]byte, 16)
+		binary.BigEndian.PutUint32(b, uint32(tag))
+		binary.BigEndian.PutUint32(b[4:], uint32(typ))
+		binary.BigEndian.PutUint32(b[8:], uint32(int32(offset)))
+		binary.BigEndian.PutUint32(b[12:], uint32(count))
+		return b
+	}
+	for _, e := range entries {
+		align := map[int]int{rpmInt16: 2, rpmInt32: 4}[e.typ]
+		for align > 0 && len(store)%align != 0 {
+			store = append(store, 0)
+		}
+		index = append(index, entryInfo(e.tag, e.typ, len(store), e.count)...)
+		store = append(store, e.data...)
+	}
+	n := len(entries) + 1
+	trailer := entryInfo(region, rpmBin, -n*16, 16)
+	index = append(entryInfo(region, rpmBin, len(store), 16), index...)
+	store = append(store, trailer...)
+
+	b := []byte{0x8e, 0xad, 0xe8, 0x01, 0, 0, 0, 0}
+	b = binary.BigEndian.AppendUint32(b, uint32(n))
+	b = binary.BigEndian.AppendUint32(b, uint32(len(store)))
+	b = append(b, index...)
+	return append(b, store...)
+}
@@ -0,0 +1,93 @@
+package sbomutil
+
+import "time"
+
+type cdxBOM struct {
+	BOMFormat    string          `json:"bomFormat"`
+	SpecVersion  string          `json:"specVersion"`
+	SerialNumber string          `json:"serialNumber"`
+	Version      int             `json:"version"`
+	Metadata     cdxMetadata     `json:"metadata"`
+	Components   []cdxComponent  `json:"components"`
+	Dependencies []cdxDependency `json:"dependencies"`
+}
+
+type cdxMetadata struct {
+	Timestamp string       `json:"timestamp"`
+	Tools     cdxTools     `json:"tools"`
+	Component cdxComponent `json:"component"`
+}
+
+type cdxTools struct {
+	Components []cdxComponent `json:"components"`
+}
+
+type cdxComponent struct {
+	Type    string    `json:"type"`
+	BOMRef  string    `json:"bom-ref,omitempty"`
+	Name    string    `json:"name"`
+	Version string    `json:"version,omitempty"`
+	PURL    string    `json:"purl,omitempty"`
+	Hashes  []cdxHash `json:"hashes,omitempty"`
+}
+
+type cdxHash struct {
+	Alg     string `json:"alg"`
+	Content string `json:"content"`
+}
+
+type cdxDependency struct {
+	Ref       string   `json:"ref"`
+	DependsOn []string `json:"dependsOn,omitempty"`
+}
+
+func cdxComponentOf(c Component) cdxComponent {
+	component := cdxComponent{
+		Type:    c.Kind,
+		BOMRef:  c.Ref(),
+		Name:    c.Name,
+		Version: c.Version,
+		PURL:    c.PURL,
+	}
+	if c.SHA256 != "" {
+		component.Hashes = []cdxHash{{Alg: "SHA-256", Content: c.SHA256}}
+	}
+	return component
+}
+
+// cycloneDX returns the document as CycloneDX 1.5: the subject as the
+// metadata component, the rest as components, and the dependency graph.
+func cycloneDX(d Document) any {
+	bom := cdxBOM{
+		BOMFormat:    "CycloneDX",
+		SpecVersion:  "1.5",
+		SerialNumber: "urn:uuid:" + d.id(),
+		Version:      1,
+		Metadata: cdxMetadata{
+			Timestamp: d.Created.UTC().Format(time.RFC3339),
+			Tools:     cdxTools{Components: []cdxComponent{{Type: KindApplication, Name: "gopro"}}},
+			Component: cdxComponentOf(d.Subject),
+		},
+		Components:   []cdxComponent{},
+		Dependencies: []cdxDependency{},
+	}
+	refs := make(map[string]bool)
+	components := d.all()
+	for _, c := range components {
+		refs[c.Ref()] = true
+	}
+	for i, c := range components {
+		if i > 0 {
+			bom.Components = append(bom.Components, cdxComponentOf(c))
+		}
+		dep := cdxDependency{Ref: c.Ref()}
+		for _, ref := range c.DependsOn {
+			// a dependency must refer to a component of the document
+			if refs[ref] {
+				dep.DependsOn = append(dep.DependsOn, ref)
+			}
+		}
+		bom.Dependencies = append(bom.Dependencies, dep)
+	}
+	return bom
+}
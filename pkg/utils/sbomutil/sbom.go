@@ -0,0 +1,162 @@
+// Package sbomutil writes software bills of materials, SPDX 2.3 and
+// CycloneDX 1.5 JSON documents, for Go binaries and the images carrying them.
+// A document only depends on what it describes, so the same artifact always
+// makes the same document.
+package sbomutil
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"runtime/debug"
+	"strings"
+	"time"
+)
+
+// Component kinds, named as CycloneDX names component types.
+const (
+	KindApplication = "application"
+	KindLibrary     = "library"
+	KindContainer   = "container"
+)
+
+// Component is one component of a document.
+type Component struct {
+	Kind    string
+	Name    string
+	Version string
+	// PURL is the package URL identifying the component, and what other
+	// components refer to it by.
+	PURL string
+	// SHA256 is the hex digest of the component's file or image, when known.
+	SHA256 string
+	// DependsOn lists the PURLs, or names when there is none, of the
+	// components this one is made of.
+	DependsOn []string
+}
+
+// Ref returns what other components refer to the component by.
+func (c Component) Ref() string {
+	if c.PURL != "" {
+		return c.PURL
+	}
+	return c.Name
+}
+
+// Document is a bill of materials of one artifact.
+type Document struct {
+	// Subject is the artifact described.
+	Subject Component
+	// Components are what the subject is made of, directly or not.
+	Components []Component
+	Created    time.Time
+}
+
+// all returns the subject followed by the components, each once.
+func (d Document) all() []Component {
+	seen := make(map[string]bool)
+	var result []Component
+	for _, c := range append([]Component{d.Subject}, d.Components...) {
+		if !seen[c.Ref()] {
+			seen[c.Ref()] = true
+			result = append(result, c)
+		}
+	}
+	return result
+}
+
+// id returns a UUID derived from what the document describes.
+func (d Document) id() string {
+	h := sha256.New()
+	fmt.Fprintf(h, "%s\x00%s\x00%s\x00%s", d.Subject.Ref(), d.Subject.Version, d.Subject.SHA256, d.Created.UTC().Format(time.RFC3339))
+	for _, c := range d.Components {
+		fmt.Fprintf(h, "\x00%s", c.Ref())
+	}
+	b := h.Sum(nil)[:16]
+	// a name-based UUID, version 5 and variant RFC 4122
+	b[6] = b[6]&0x0f | 0x50
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// GoPURL returns the package URL of a Go module at a version, with the
+// qualifiers given as key=value pairs.
+func GoPURL(path, version string, qualifiers ...string) string {
+	purl := "pkg:golang/" + path
+	if version != "" {
+		purl += "@" + strings.ReplaceAll(version, "+", "%2B")
+	}
+	if len(qualifiers) > 0 {
+		purl += "?" + strings.Join(qualifiers, "&")
+	}
+	return purl
+}
+
+// FromBuildInfo returns the module a Go binary was built from, and the
+// modules and standard library it depends on, from its embedded build info.
+// The main module depends on all of them.
+func FromBuildInfo(bi *debug.BuildInfo) (Component, []Component) {
+	var qualifiers []string
+	for _, s := range bi.Settings {
+		if s.Key == "GOOS" || s.Key == "GOARCH" {
+			qualifiers = append(qualifiers, strings.ToLower(s.Key)+"="+s.Value)
+		}
+	}
+	version := bi.Main.Version
+	if version == "(devel)" {
+		version = ""
+	}
+	main := Component{
+		Kind:    KindApplication,
+		Name:    bi.Main.Path,
+		Version: version,
+		PURL:    GoPURL(bi.Main.Path, version, qualifiers...),
+	}
+	var deps []Component
+	if goVersion := strings.TrimPrefix(bi.GoVersion, "go"); goVersion != "" {
+		deps = append(deps, Component{Kind: KindLibrary, Name: "stdlib", Version: bi.GoVersion, PURL: GoPURL("stdlib", goVersion)})
+	}
+	for _, dep := range bi.Deps {
+		if dep.Replace != nil {
+			dep = dep.Replace
+		}
+		deps = append(deps, Component{Kind: KindLibrary, Name: dep.Path, Version: dep.Version, PURL: GoPURL(dep.Path, dep.Version)})
+	}
+	for _, dep := range deps {
+		main.DependsOn = append(main.DependsOn, dep.Ref())
+	}
+	return main, deps
+}
+
+// formats are the document formats written, by name.
+var formats = map[string]struct {
+	ext    string
+	encode func(Document) any
+}{
+	"spdx":      {".spdx.json", spdx},
+	"cyclonedx": {".cdx.json", cycloneDX},
+}
+
+// FileName returns the name of the document in a format of the artifact
+// named base.
+func FileName(base, format string) (string, error) {
+	f, ok := formats[format]
+	if !ok {
+		return "", fmt.Errorf("unknown sbom format %q", format)
+	}
+	return base + f.ext, nil
+}
+
+// Write writes the document in a format at output.
+func Write(output, format string, d Document) error {
+	f, ok := formats[format]
+	if !ok {
+		return fmt.Errorf("unknown sbom format %q", format)
+	}
+	b, err := json.MarshalIndent(f.encode(d), "", "  ")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(output, append(b, '\n'), 0644)
+}
@@ -0,0 +1,123 @@
+package sbomutil
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"slices"
+	"testing"
+	"time"
+)
+
+func testDocument() Document {
+	main, deps := FromBuildInfo(&debug.BuildInfo{
+		GoVersion: "go1.22.1",
+		Path:      "example.com/app/cmd/api",
+		Main:      debug.Module{Path: "example.com/app", Version: "(devel)"},
+		Deps: []*debug.Module{
+			{Path: "github.com/spf13/cobra", Version: "v1.8.0"},
+			{Path: "example.com/fork", Version: "v0.1.0", Replace: &debug.Module{Path: "example.com/fork", Version: "v0.2.0+incompatible"}},
+		},
+		Settings: []debug.BuildSetting{{Key: "GOOS", Value: "linux"}, {Key: "GOARCH", Value: "arm64"}},
+	})
+	main.Name, main.Version, main.SHA256 = "api", "v1.2.3", "abc123"
+	return Document{Subject: main, Components: deps, Created: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)}
+}
+
+func TestFromBuildInfo(t *testing.T) {
+	d := testDocument()
+	if d.Subject.PURL != "pkg:golang/example.com/app?goos=linux&goarch=arm64" {
+		t.Errorf("main purl = %s", d.Subject.PURL)
+	}
+	var purls []string
+	for _, c := range d.Components {
+		purls = append(purls, c.PURL)
+	}
+	want := []string{
+		"pkg:golang/stdlib@1.22.1",
+		"pkg:golang/github.com/spf13/cobra@v1.8.0",
+		"pkg:golang/example.com/fork@v0.2.0%2Bincompatible",
+	}
+	if !slices.Equal(purls, want) {
+		t.Errorf("components %q, want %q", purls, want)
+	}
+	if !slices.Equal(d.Subject.DependsOn, want) {
+		t.Errorf("main depends on %q", d.Subject.DependsOn)
+	}
+}
+
+func writeDocument(t *testing.T, format string, d Document) map[string]any {
+	t.Helper()
+	name, err := FileName(filepath.Join(t.TempDir(), "api"), format)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := Write(name, format, d); err != nil {
+		t.Fatal(err)
+	}
+	b, err := os.ReadFile(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var doc map[string]any
+	if err := json.Unmarshal(b, &doc); err != nil {
+		t.Fatal(err)
+	}
+	// the same artifact makes the same document
+	again := filepath.Join(filepath.Dir(name), "again.json")
+	if err := Write(again, format, d); err != nil {
+		t.Fatal(err)
+	}
+	if b2, _ := os.ReadFile(again); !bytes.Equal(b, b2) {
+		t.Error("documents of the same artifact differ")
+	}
+	return doc
+}
+
+func TestWriteSPDX(t *testing.T) {
+	doc := writeDocument(t, "spdx", testDocument())
+	if doc["spdxVersion"] != "SPDX-2.3" || doc["name"] != "api" {
+		t.Errorf("document %v", doc)
+	}
+	packages := doc["packages"].([]any)
+	if len(packages) != 4 {
+		t.Fatalf("%d packages, want 4", len(packages))
+	}
+	subject := packages[0].(map[string]any)
+	if subject["versionInfo"] != "v1.2.3" || subject["checksums"].([]any)[0].(map[string]any)["checksumValue"] != "abc123" {
+		t.Errorf("subject %v", subject)
+	}
+	var types []string
+	for _, r := range doc["relationships"].([]any) {
+		types = append(types, r.(map[string]any)["relationshipType"].(string))
+	}
+	if !slices.Equal(types, []string{"DESCRIBES", "DEPENDS_ON", "DEPENDS_ON", "DEPENDS_ON"}) {
+		t.Errorf("relationships %q", types)
+	}
+}
+
+func TestWriteCycloneDX(t *testing.T) {
+	doc := writeDocument(t, "cyclonedx", testDocument())
+	if doc["bomFormat"] != "CycloneDX" || doc["specVersion"] != "1.5" {
+		t.Errorf("document %v", doc)
+	}
+	subject := doc["metadata"].(map[string]any)["component"].(map[string]any)
+	if subject["name"] != "api" || subject["bom-ref"] != "pkg:golang/example.com/app?goos=linux&goarch=arm64" {
+		t.Errorf("subject %v", subject)
+	}
+	if n := len(doc["components"].([]any)); n != 3 {
+		t.Errorf("%d components, want 3", n)
+	}
+	deps := doc["dependencies"].([]any)
+	if len(deps) != 4 || len(deps[0].(map[string]any)["dependsOn"].([]any)) != 3 {
+		t.Errorf("dependencies %v", deps)
+	}
+}
+
+func TestWriteRejectsUnknownFormat(t *testing.T) {
+	if err := Write(filepath.Join(t.TempDir(), "api"), "swid", testDocument()); err == nil {
+		t.Error("expected an error")
+	}
+}
@@ -0,0 +1,109 @@
+package sbomutil
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+type spdxDocument struct {
+	SPDXVersion       string             `json:"spdxVersion"`
+	DataLicense       string             `json:"dataLicense"`
+	SPDXID            string             `json:"SPDXID"`
+	Name              string             `json:"name"`
+	DocumentNamespace string             `json:"documentNamespace"`
+	CreationInfo      spdxCreationInfo   `json:"creationInfo"`
+	Packages          []spdxPackage      `json:"packages"`
+	Relationships     []spdxRelationship `json:"relationships"`
+}
+
+type spdxCreationInfo struct {
+	Created  string   `json:"created"`
+	Creators []string `json:"creators"`
+}
+
+type spdxPackage struct {
+	Name                  string            `json:"name"`
+	SPDXID                string            `json:"SPDXID"`
+	VersionInfo           string            `json:"versionInfo,omitempty"`
+	DownloadLocation      string            `json:"downloadLocation"`
+	FilesAnalyzed         bool              `json:"filesAnalyzed"`
+	PrimaryPackagePurpose string            `json:"primaryPackagePurpose,omitempty"`
+	Checksums             []spdxChecksum    `json:"checksums,omitempty"`
+	ExternalRefs          []spdxExternalRef `json:"externalRefs,omitempty"`
+}
+
+type spdxChecksum struct {
+	Algorithm     string `json:"algorithm"`
+	ChecksumValue string `json:"checksumValue"`
+}
+
+type spdxExternalRef struct {
+	ReferenceCategory string `json:"referenceCategory"`
+	ReferenceType     string `json:"referenceType"`
+	ReferenceLocator  string `json:"referenceLocator"`
+}
+
+type spdxRelationship struct {
+	SPDXElementID      string `json:"spdxElementId"`
+	RelationshipType   string `json:"relationshipType"`
+	RelatedSPDXElement string `json:"relatedSpdxElement"`
+}
+
+var spdxIDUnsafe = regexp.MustCompile(`[^A-Za-z0-9.-]+`)
+
+// spdx returns the document as SPDX 2.3: a package per component, the subject
+// described by the document, and a DEPENDS_ON relationship per dependency.
+func spdx(d Document) any {
+	doc := spdxDocument{
+		SPDXVersion:       "SPDX-2.3",
+		DataLicense:       "CC0-1.0",
+		SPDXID:            "SPDXRef-DOCUMENT",
+		Name:              d.Subject.Name,
+		DocumentNamespace: "https://spdx.org/spdxdocs/" + spdxIDUnsafe.ReplaceAllString(d.Subject.Name, "-") + "-" + d.id(),
+		CreationInfo: spdxCreationInfo{
+			Created:  d.Created.UTC().Format(time.RFC3339),
+			Creators: []string{"Tool: gopro"},
+		},
+		Packages:      []spdxPackage{},
+		Relationships: []spdxRelationship{},
+	}
+	ids := make(map[string]string)
+	components := d.all()
+	for i, c := range components {
+		id := fmt.Sprintf("SPDXRef-Package-%d-%s", i, strings.Trim(spdxIDUnsafe.ReplaceAllString(c.Name, "-"), "-"))
+		ids[c.Ref()] = id
+		p := spdxPackage{
+			Name:                  c.Name,
+			SPDXID:                id,
+			VersionInfo:           c.Version,
+			DownloadLocation:      "NOASSERTION",
+			PrimaryPackagePurpose: strings.ToUpper(c.Kind),
+		}
+		if c.SHA256 != "" {
+			p.Checksums = []spdxChecksum{{Algorithm: "SHA256", ChecksumValue: c.SHA256}}
+		}
+		if c.PURL != "" {
+			p.ExternalRefs = []spdxExternalRef{{ReferenceCategory: "PACKAGE-MANAGER", ReferenceType: "purl", ReferenceLocator: c.PURL}}
+		}
+		doc.Packages = append(doc.Packages, p)
+	}
+	doc.Relationships = append(doc.Relationships, spdxRelationship{
+		SPDXElementID:      doc.SPDXID,
+		RelationshipType:   "DESCRIBES",
+		RelatedSPDXElement: ids[d.Subject.Ref()],
+	})
+	for _, c := range components {
+		for _, dep := range c.DependsOn {
+			if related, ok := ids[dep]; ok {
+				doc.Relationships = append(doc.Relationships, spdxRelationship{
+					SPDXElementID:      ids[c.Ref()],
+					RelationshipType:   "DEPENDS_ON",
+					RelatedSPDXElement: related,
+				})
+			}
+		}
+	}
+	return doc
+}
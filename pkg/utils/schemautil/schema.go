@@ -0,0 +1,404 @@
+// Package schemautil validates YAML and JSON documents against JSON Schema,
+// with github.com/santhosh-tekuri/jsonschema, every keyword of draft 2020-12
+// included. $refs are resolved within the schema itself, and errors are
+// reported at the path of the value within the document, such as
+// spec.containers[0].image, in short messages of their own.
+package schemautil
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+	"net/url"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/santhosh-tekuri/jsonschema/v6"
+	"github.com/santhosh-tekuri/jsonschema/v6/kind"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+	"gopkg.in/yaml.v3"
+)
+
+// location is where a schema compiles from: a name for the document only, as
+// refs to other documents are not loaded.
+const location = "urn:schema"
+
+var printer = message.NewPrinter(language.English)
+
+// Schema is a compiled JSON Schema.
+type Schema struct {
+	schema      *jsonschema.Schema
+	definitions map[string]*Schema
+}
+
+// Error is a value that does not match its schema, at the path of the value
+// within the document, such as spec.containers[0].image.
+type Error struct {
+	Path string
+	Msg  string
+}
+
+func (e Error) Error() string {
+	if e.Path == "" {
+		return e.Msg
+	}
+	return e.Path + ": " + e.Msg
+}
+
+// localOnly refuses to load the documents a $ref outside the schema points
+// to.
+type localOnly struct{}
+
+func (localOnly) Load(url string) (any, error) {
+	return nil, fmt.Errorf("only refs within the schema are supported")
+}
+
+// Compile compiles a schema decoded from YAML or JSON, as draft 2020-12
+// unless its $schema says otherwise. $refs are resolved within the schema
+// itself, as JSON pointers such as #/definitions/Name.
+func Compile(doc any) (*Schema, error) {
+	c := jsonschema.NewCompiler()
+	c.DefaultDraft(jsonschema.Draft2020)
+	c.UseLoader(localOnly{})
+	if err := c.AddResource(location, doc); err != nil {
+		return nil, err
+	}
+	root, err := c.Compile(location)
+	if err != nil {
+		return nil, err
+	}
+	s := &Schema{schema: root}
+	for _, key := range []string{"definitions", "$defs"} {
+		defs, ok := object(doc)[key].(map[string]any)
+		if !ok {
+			continue
+		}
+		for name := range defs {
+			def, err := c.Compile(location + "#/" + key + "/" + url.PathEscape(escapePointer(name)))
+			if err != nil {
+				return nil, err
+			}
+			if s.definitions == nil {
+				s.definitions = make(map[string]*Schema)
+			}
+			s.definitions[name] = &Schema{schema: def}
+		}
+	}
+	return s, nil
+}
+
+// Parse compiles the schema in b, JSON or YAML.
+func Parse(b []byte) (*Schema, error) {
+	docs, err := Documents(b)
+	if err != nil {
+		return nil, err
+	}
+	if len(docs) != 1 {
+		return nil, fmt.Errorf("a schema is one document, not %d", len(docs))
+	}
+	return Compile(docs[0])
+}
+
+// Definition returns the schema of definitions or $defs of that name.
+func (s *Schema) Definition(name string) (*Schema, bool) {
+	def, ok := s.definitions[name]
+	return def, ok
+}
+
+// Documents decodes the YAML or JSON documents of b as Validate takes them.
+// An empty document is nil.
+func Documents(b []byte) ([]any, error) {
+	decoder := yaml.NewDecoder(bytes.NewReader(b))
+	var docs []any
+	for {
+		var doc any
+		err := decoder.Decode(&doc)
+		if errors.Is(err, io.EOF) {
+			return docs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		docs = append(docs, normalize(doc))
+	}
+}
+
+// normalize turns the maps of non-string keys YAML allows into the
+// map[string]any of JSON objects.
+func normalize(v any) any {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, e := range v {
+			v[k] = normalize(e)
+		}
+		return v
+	case map[any]any:
+		m := make(map[string]any, len(v))
+		for k, e := range v {
+			m[fmt.Sprint(k)] = normalize(e)
+		}
+		return m
+	case []any:
+		for i, e := range v {
+			v[i] = normalize(e)
+		}
+		return v
+	case time.Time:
+		// a YAML timestamp is a string to JSON
+		return v.Format(time.RFC3339Nano)
+	}
+	return v
+}
+
+// Validate returns where v does not match the schema, sorted by path.
+func (s *Schema) Validate(v any) []Error {
+	err := s.schema.Validate(v)
+	if err == nil {
+		return nil
+	}
+	var verr *jsonschema.ValidationError
+	if !errors.As(err, &verr) {
+		return []Error{{Msg: err.Error()}}
+	}
+	errs := flatten(verr, v)
+	sort.SliceStable(errs, func(i, j int) bool { return errs[i].Path < errs[j].Path })
+	return errs
+}
+
+// flatten turns the tree of a validation error into the errors of its
+// leaves, the keywords that failed.
+func flatten(e *jsonschema.ValidationError, doc any) []Error {
+	path, v := locate(doc, e.InstanceLocation)
+	switch k := e.ErrorKind.(type) {
+	case *kind.AnyOf:
+		return alternatives(e, doc, path, v)
+	case *kind.OneOf:
+		if len(k.Subschemas) > 1 {
+			return []Error{{Path: path, Msg: fmt.Sprintf("matches %d of the alternatives, want exactly one", len(k.Subschemas))}}
+		}
+		return alternatives(e, doc, path, v)
+	case *kind.Contains:
+		// the items each failing the schema would bury the point
+		return []Error{{Path: path, Msg: "has no item matching contains"}}
+	case *kind.PropertyNames:
+		return []Error{{Path: field(path, k.Property), Msg: "is not a valid field name"}}
+	}
+	if len(e.Causes) == 0 {
+		return leaf(e.ErrorKind, path, v)
+	}
+	var errs []Error
+	for _, cause := range e.Causes {
+		errs = append(errs, flatten(cause, doc)...)
+	}
+	return errs
+}
+
+// alternatives reports a value matching none of the schemas of anyOf or
+// oneOf. When every alternative only disagrees on the type, as for a value
+// that is a string or an integer, the mismatch is reported as one type error.
+func alternatives(e *jsonschema.ValidationError, doc any, path string, v any) []Error {
+	var types []string
+	for _, cause := range e.Causes {
+		want, ok := typeMismatch(cause, e.InstanceLocation)
+		if !ok {
+			types = nil
+			break
+		}
+		types = append(types, want...)
+	}
+	if types != nil {
+		return []Error{{Path: path, Msg: fmt.Sprintf("got %s, want %s", typeOf(v), strings.Join(types, " or "))}}
+	}
+	errs := []Error{{Path: path, Msg: fmt.Sprintf("matches none of the %d alternatives", len(e.Causes))}}
+	if len(e.Causes) > 0 {
+		errs = append(errs, flatten(e.Causes[0], doc)...)
+	}
+	return errs
+}
+
+// typeMismatch reports the types an alternative wanted when its only error
+// is that the value at loc has another type.
+func typeMismatch(e *jsonschema.ValidationError, loc []string) ([]string, bool) {
+	for len(e.Causes) == 1 {
+		e = e.Causes[0]
+	}
+	k, ok := e.ErrorKind.(*kind.Type)
+	if !ok || len(e.Causes) != 0 || !slices.Equal(e.InstanceLocation, loc) {
+		return nil, false
+	}
+	return k.Want, true
+}
+
+// leaf words the error of a keyword that failed on v, at path.
+func leaf(k jsonschema.ErrorKind, path string, v any) []Error {
+	msg := func(format string, args ...any) []Error {
+		return []Error{{Path: path, Msg: fmt.Sprintf(format, args...)}}
+	}
+	switch k := k.(type) {
+	case *kind.Required:
+		var errs []Error
+		for _, name := range k.Missing {
+			errs = append(errs, Error{Path: field(path, name), Msg: "is required"})
+		}
+		return errs
+	case *kind.AdditionalProperties:
+		var errs []Error
+		for _, name := range k.Properties {
+			errs = append(errs, Error{Path: field(path, name), Msg: "is not a known field"})
+		}
+		return errs
+	case *kind.DependentRequired:
+		var errs []Error
+		for _, name := range k.Missing {
+			errs = append(errs, Error{Path: field(path, name), Msg: fmt.Sprintf("is required with %s", k.Prop)})
+		}
+		return errs
+	case *kind.Not:
+		return msg("matches the schema of not")
+	case *kind.UniqueItems:
+		return msg("has items %d and %d equal, want unique items", k.Duplicates[0], k.Duplicates[1])
+	case *kind.MultipleOf:
+		return msg("%s is not a multiple of %s", rat(k.Got), rat(k.Want))
+	case *kind.FalseSchema:
+		return msg("is not allowed")
+	case *kind.Type:
+		return msg("got %s, want %s", typeOf(v), strings.Join(k.Want, " or "))
+	case *kind.Enum:
+		var allowed []string
+		for _, e := range k.Want {
+			allowed = append(allowed, format(e))
+		}
+		return msg("got %s, want one of %s", format(v), strings.Join(allowed, ", "))
+	case *kind.Const:
+		return msg("got %s, want %s", format(v), format(k.Want))
+	case *kind.MinItems:
+		return msg("has %d items, want at least %d", k.Got, k.Want)
+	case *kind.MaxItems:
+		return msg("has %d items, want at most %d", k.Got, k.Want)
+	case *kind.MinLength:
+		return msg("is %d characters long, want at least %d", k.Got, k.Want)
+	case *kind.MaxLength:
+		return msg("is %d characters long, want at most %d", k.Got, k.Want)
+	case *kind.Pattern:
+		return msg("%q does not match %s", k.Got, k.Want)
+	case *kind.Minimum:
+		return msg("%s is less than the minimum %s", rat(k.Got), rat(k.Want))
+	case *kind.Maximum:
+		return msg("%s is greater than the maximum %s", rat(k.Got), rat(k.Want))
+	case *kind.ExclusiveMinimum:
+		return msg("%s is not greater than %s", rat(k.Got), rat(k.Want))
+	case *kind.ExclusiveMaximum:
+		return msg("%s is not less than %s", rat(k.Got), rat(k.Want))
+	}
+	return msg("%s", k.LocalizedString(printer))
+}
+
+// locate returns the path of the value at the JSON pointer tokens of loc
+// within doc, and the value.
+func locate(doc any, loc []string) (string, any) {
+	path, v := "", doc
+	for _, token := range loc {
+		switch node := v.(type) {
+		case []any:
+			path += "[" + token + "]"
+			if i, err := strconv.Atoi(token); err == nil && i >= 0 && i < len(node) {
+				v = node[i]
+			} else {
+				v = nil
+			}
+		default:
+			path = field(path, token)
+			v = object(v)[token]
+		}
+	}
+	return path, v
+}
+
+// typeOf names the JSON type of a decoded value.
+func typeOf(v any) string {
+	switch v := v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	default:
+		if n, ok := number(v); ok {
+			if n == math.Trunc(n) {
+				return "integer"
+			}
+			return "number"
+		}
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+func number(v any) (float64, bool) {
+	switch n := v.(type) {
+	case int:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case float64:
+		return n, true
+	}
+	return 0, false
+}
+
+func rat(r *big.Rat) string {
+	if r == nil {
+		return "null"
+	}
+	f, _ := r.Float64()
+	return format(f)
+}
+
+func format(v any) string {
+	switch v := v.(type) {
+	case string:
+		return strconv.Quote(v)
+	case nil:
+		return "null"
+	}
+	if n, ok := number(v); ok {
+		return strconv.FormatFloat(n, 'f', -1, 64)
+	}
+	return fmt.Sprint(v)
+}
+
+// identifier is a key a path can show as .key; others are shown as ["key"].
+var identifier = regexp.MustCompile(`^[A-Za-z_$][A-Za-z0-9_$-]*$`)
+
+func field(path, key string) string {
+	if !identifier.MatchString(key) {
+		return fmt.Sprintf("%s[%q]", path, key)
+	}
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func object(v any) map[string]any {
+	m, _ := v.(map[string]any)
+	return m
+}
+
+func escapePointer(s string) string {
+	return strings.NewReplacer("~", "~0", "/", "~1").Replace(s)
+}
@@ -0,0 +1,129 @@
+package schemautil
+
+import (
+	"strings"
+	"testing"
+)
+
+const testSchema = `
+type: object
+required: [name, port]
+additionalProperties: false
+properties:
+  name: {type: string, minLength: 1, pattern: "^[a-z]+$"}
+  port: {$ref: "#/definitions/port"}
+  mode: {enum: [debug, release]}
+  target: {oneOf: [{type: string}, {type: integer}]}
+  tags:
+    type: array
+    maxItems: 2
+    items: {type: string}
+  labels:
+    type: object
+    additionalProperties: {type: string}
+  child: {$ref: "#"}
+definitions:
+  port: {type: integer, minimum: 1, maximum: 65535}
+`
+
+func TestValidate(t *testing.T) {
+	s, err := Parse([]byte(testSchema))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		doc  string
+		want []string
+	}{
+		{name: "valid", doc: "name: api\nport: 8080\nmode: debug\ntarget: 80\ntags: [a]\nlabels: {app.kubernetes.io/name: api}\n"},
+		{name: "valid json", doc: `{"name": "api", "port": 1, "target": "http"}`},
+		{name: "missing and unknown", doc: "nmae: api\n", want: []string{
+			`name: is required`,
+			`nmae: is not a known field`,
+			`port: is required`,
+		}},
+		{name: "types", doc: "name: api\nport: \"8080\"\ntarget: true\nlabels: {a.b: 1}\n", want: []string{
+			`labels["a.b"]: got integer, want string`,
+			`port: got string, want integer`,
+			`target: got boolean, want string or integer`,
+		}},
+		{name: "bounds", doc: "name: API\nport: 70000\nmode: trace\ntags: [a, b, 3]\n", want: []string{
+			`mode: got "trace", want one of "debug", "release"`,
+			`name: "API" does not match ^[a-z]+$`,
+			`port: 70000 is greater than the maximum 65535`,
+			`tags: has 3 items, want at most 2`,
+			`tags[2]: got integer, want string`,
+		}},
+		{name: "recursive", doc: "name: api\nport: 1\nchild: {name: web, port: 0.5}\n", want: []string{
+			`child.port: got number, want integer`,
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			docs, err := Documents([]byte(tt.doc))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, e := range s.Validate(docs[0]) {
+				got = append(got, e.Error())
+			}
+			if strings.Join(got, "\n") != strings.Join(tt.want, "\n") {
+				t.Errorf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	for _, schema := range []string{
+		`{"$ref": "#/definitions/missing"}`,
+		`{"$ref": "other.json#/definitions/x"}`,
+		`{"pattern": "("}`,
+		`{"properties": {"x": 1}}`,
+	} {
+		if _, err := Parse([]byte(schema)); err == nil {
+			t.Errorf("%s compiled", schema)
+		}
+	}
+}
+
+func TestValidateEveryKeyword(t *testing.T) {
+	s, err := Parse([]byte(`
+type: object
+properties:
+  replicas: {type: integer, multipleOf: 2}
+  tags: {type: array, uniqueItems: true, contains: {const: app}}
+  mode: {not: {const: debug}}
+  tls:
+    type: object
+    if: {properties: {enabled: {const: true}}}
+    then: {required: [cert]}
+dependentRequired: {user: [password]}
+propertyNames: {pattern: "^[a-z]+$"}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	docs, err := Documents([]byte("replicas: 3\ntags: [web, web]\nmode: debug\ntls: {enabled: true}\nuser: admin\nTag: x\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		`Tag: is not a valid field name`,
+		`mode: matches the schema of not`,
+		`password: is required with user`,
+		`replicas: 3 is not a multiple of 2`,
+		`tags: has items 0 and 1 equal, want unique items`,
+		`tags: has no item matching contains`,
+		`tls.cert: is required`,
+	}
+	var got []string
+	for _, e := range s.Validate(docs[0]) {
+		got = append(got, e.Error())
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("got\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
@@ -0,0 +1,193 @@
+package signutil
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/scrypt"
+)
+
+const (
+	untrustedComment = "untrusted comment: "
+	trustedComment   = "trusted comment: "
+	minisignExt      = ".minisig"
+)
+
+// Signature algorithms of minisign: Ed signs the file itself, ED its
+// BLAKE2b-512 hash, which is what minisign writes since 0.10.
+const (
+	minisignAlg       = "Ed"
+	minisignHashedAlg = "ED"
+)
+
+// minisignKey is a minisign key pair. The public half alone verifies.
+type minisignKey struct {
+	id   [8]byte
+	priv ed25519.PrivateKey
+	pub  ed25519.PublicKey
+}
+
+// minisignPayload returns the base64 line following the untrusted comment of
+// a minisign key file, decoded.
+func minisignPayload(s string) ([]byte, error) {
+	var line string
+	for _, l := range strings.Split(strings.TrimSpace(s), "\n") {
+		l = strings.TrimSpace(l)
+		if l != "" && !strings.HasPrefix(l, untrustedComment) {
+			line = l
+			break
+		}
+	}
+	return base64.StdEncoding.DecodeString(line)
+}
+
+// parseMinisignPrivateKey parses a minisign secret key file:
+//
+//	sig_alg[2] kdf_alg[2] chk_alg[2] salt[32] opslimit[8] memlimit[8]
+//	key_id[8] secret_key[64] checksum[32]
+//
+// The last three are XORed with an scrypt stream of the password, unless
+// kdf_alg is zero, as minisign -G -W writes it.
+func parseMinisignPrivateKey(b, password []byte) (*minisignKey, error) {
+	raw, err := minisignPayload(string(b))
+	if err != nil || len(raw) != 158 {
+		return nil, fmt.Errorf("not a minisign secret key")
+	}
+	if string(raw[0:2]) != minisignAlg || string(raw[4:6]) != "B2" {
+		return nil, fmt.Errorf("unsupported minisign key algorithms %q/%q", raw[0:2], raw[4:6])
+	}
+	sk := bytes.Clone(raw[54:])
+	switch string(raw[2:4]) {
+	case "Sc":
+		if len(password) == 0 {
+			return nil, fmt.Errorf("the key is encrypted and no password is given")
+		}
+		n, r, p := scryptParams(binary.LittleEndian.Uint64(raw[38:46]), binary.LittleEndian.Uint64(raw[46:54]))
+		stream, err := scrypt.Key(password, raw[6:38], n, r, p, len(sk))
+		if err != nil {
+			return nil, err
+		}
+		subtle.XORBytes(sk, sk, stream)
+	case "\x00\x00":
+	default:
+		return nil, fmt.Errorf("unsupported minisign key derivation %q", raw[2:4])
+	}
+	k := &minisignKey{priv: ed25519.PrivateKey(sk[8:72])}
+	copy(k.id[:], sk[0:8])
+	sum := blake2b.Sum256(append(append([]byte(minisignAlg), sk[0:8]...), sk[8:72]...))
+	if subtle.ConstantTimeCompare(sum[:], sk[72:104]) != 1 {
+		return nil, fmt.Errorf("wrong password for the minisign key")
+	}
+	k.pub = k.priv.Public().(ed25519.PublicKey)
+	return k, nil
+}
+
+// scryptParams returns the scrypt N, r and p libsodium derives from the
+// opslimit and memlimit of crypto_pwhash_scryptsalsa208sha256.
+func scryptParams(opslimit, memlimit uint64) (int, int, int) {
+	const r = 8
+	opslimit = max(opslimit, 32768)
+	var maxN uint64
+	if opslimit < memlimit/32 {
+		maxN = opslimit / (r * 4)
+	} else {
+		maxN = memlimit / (r * 128)
+	}
+	logN := 1
+	for ; logN < 63; logN++ {
+		if uint64(1)<<logN > maxN/2 {
+			break
+		}
+	}
+	if opslimit < memlimit/32 {
+		return 1 << logN, r, 1
+	}
+	maxrp := min((opslimit/4)/(uint64(1)<<logN), 0x3fffffff)
+	return 1 << logN, r, max(int(maxrp/r), 1)
+}
+
+// parseMinisignPublicKey parses a minisign public key: sig_alg[2] key_id[8]
+// public_key[32].
+func parseMinisignPublicKey(s string) (*minisignKey, error) {
+	raw, err := minisignPayload(s)
+	if err != nil || len(raw) != 42 || string(raw[0:2]) != minisignAlg {
+		return nil, fmt.Errorf("not a minisign or PEM public key")
+	}
+	k := &minisignKey{pub: ed25519.PublicKey(raw[10:])}
+	copy(k.id[:], raw[2:10])
+	return k, nil
+}
+
+func (k *minisignKey) Ext() string {
+	return minisignExt
+}
+
+// ID returns the key ID as minisign prints it.
+func (k *minisignKey) ID() string {
+	return fmt.Sprintf("%016X", binary.LittleEndian.Uint64(k.id[:]))
+}
+
+// Sign signs the hash of data, and the signature with a trusted comment
+// naming the file and when it was signed, as minisign -S does.
+func (k *minisignKey) Sign(name string, data []byte, created time.Time) ([]byte, error) {
+	if k.priv == nil {
+		return nil, fmt.Errorf("minisign key %s has no private key", k.ID())
+	}
+	if strings.ContainsAny(name, "\r\n") {
+		return nil, fmt.Errorf("file name %q does not fit a trusted comment", name)
+	}
+	hash := blake2b.Sum512(data)
+	sig := append(append([]byte(minisignHashedAlg), k.id[:]...), ed25519.Sign(k.priv, hash[:])...)
+	comment := fmt.Sprintf("timestamp:%d\tfile:%s\thashed", created.Unix(), name)
+	global := ed25519.Sign(k.priv, append(bytes.Clone(sig[10:]), comment...))
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "%ssignature from gopro secret key\n", untrustedComment)
+	fmt.Fprintf(&b, "%s\n", base64.StdEncoding.EncodeToString(sig))
+	fmt.Fprintf(&b, "%s%s\n", trustedComment, comment)
+	fmt.Fprintf(&b, "%s\n", base64.StdEncoding.EncodeToString(global))
+	return b.Bytes(), nil
+}
+
+// Verify checks a minisign signature file: the signature of data, by this
+// key, and the signature of its trusted comment.
+func (k *minisignKey) Verify(data, sig []byte) error {
+	lines := strings.Split(strings.TrimSpace(strings.ReplaceAll(string(sig), "\r\n", "\n")), "\n")
+	if len(lines) != 4 || !strings.HasPrefix(lines[0], untrustedComment) || !strings.HasPrefix(lines[2], trustedComment) {
+		return fmt.Errorf("not a minisign signature")
+	}
+	raw, err := base64.StdEncoding.DecodeString(lines[1])
+	if err != nil || len(raw) != 74 {
+		return fmt.Errorf("not a minisign signature")
+	}
+	global, err := base64.StdEncoding.DecodeString(lines[3])
+	if err != nil || len(global) != ed25519.SignatureSize {
+		return fmt.Errorf("not a minisign signature")
+	}
+	if !bytes.Equal(raw[2:10], k.id[:]) {
+		return fmt.Errorf("signed by key %016X, not %s", binary.LittleEndian.Uint64(raw[2:10]), k.ID())
+	}
+	message := data
+	switch string(raw[0:2]) {
+	case minisignHashedAlg:
+		hash := blake2b.Sum512(data)
+		message = hash[:]
+	case minisignAlg:
+	default:
+		return fmt.Errorf("unsupported minisign signature algorithm %q", raw[0:2])
+	}
+	if !ed25519.Verify(k.pub, message, raw[10:]) {
+		return fmt.Errorf("signature does not match the file")
+	}
+	comment := strings.TrimPrefix(lines[2], trustedComment)
+	if !ed25519.Verify(k.pub, append(bytes.Clone(raw[10:]), comment...), global) {
+		return fmt.Errorf("trusted comment is not signed by the key")
+	}
+	return nil
+}
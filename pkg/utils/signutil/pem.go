@@ -0,0 +1,159 @@
+package signutil
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"time"
+
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/scrypt"
+)
+
+const pemExt = ".sig"
+
+// pemKey is an ed25519 or ECDSA key pair. Its signatures are the base64 of
+// the signature alone, as cosign sign-blob writes them: ed25519 over the file
+// itself, ECDSA over its SHA-256 hash. The public half alone verifies.
+type pemKey struct {
+	priv any
+	pub  any
+}
+
+// sigstoreKey is the JSON a cosign generate-key-pair key is encrypted in.
+type sigstoreKey struct {
+	KDF struct {
+		Name   string `json:"name"`
+		Params struct {
+			N int `json:"N"`
+			R int `json:"r"`
+			P int `json:"p"`
+		} `json:"params"`
+		Salt []byte `json:"salt"`
+	} `json:"kdf"`
+	Cipher struct {
+		Name  string `json:"name"`
+		Nonce []byte `json:"nonce"`
+	} `json:"cipher"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// parsePEMPrivateKey parses a PKCS#8 private key, or a cosign one, which is
+// PKCS#8 sealed with a key scrypt derives from the password.
+func parsePEMPrivateKey(block *pem.Block, password []byte) (*pemKey, error) {
+	der := block.Bytes
+	switch block.Type {
+	case "PRIVATE KEY":
+	case "ENCRYPTED SIGSTORE PRIVATE KEY", "ENCRYPTED COSIGN PRIVATE KEY":
+		var sealed sigstoreKey
+		if err := json.Unmarshal(block.Bytes, &sealed); err != nil {
+			return nil, fmt.Errorf("not a cosign private key: %s", err)
+		}
+		if sealed.KDF.Name != "scrypt" || sealed.Cipher.Name != "nacl/secretbox" || len(sealed.Cipher.Nonce) != 24 {
+			return nil, fmt.Errorf("unsupported cosign key encryption %s/%s", sealed.KDF.Name, sealed.Cipher.Name)
+		}
+		p := sealed.KDF.Params
+		secret, err := scrypt.Key(password, sealed.KDF.Salt, p.N, p.R, p.P, 32)
+		if err != nil {
+			return nil, err
+		}
+		var key [32]byte
+		var nonce [24]byte
+		copy(key[:], secret)
+		copy(nonce[:], sealed.Cipher.Nonce)
+		opened, ok := secretbox.Open(nil, sealed.Ciphertext, &nonce, &key)
+		if !ok {
+			return nil, fmt.Errorf("wrong password for the cosign key")
+		}
+		der = opened
+	default:
+		return nil, fmt.Errorf("unsupported PEM block %q, want a PKCS#8 PRIVATE KEY", block.Type)
+	}
+	priv, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, err
+	}
+	switch key := priv.(type) {
+	case ed25519.PrivateKey:
+		return &pemKey{priv: key, pub: key.Public()}, nil
+	case *ecdsa.PrivateKey:
+		return &pemKey{priv: key, pub: &key.PublicKey}, nil
+	}
+	return nil, fmt.Errorf("unsupported private key %T, want ed25519 or ECDSA", priv)
+}
+
+// parsePEMPublicKey parses a PKIX public key.
+func parsePEMPublicKey(block *pem.Block) (*pemKey, error) {
+	if block.Type != "PUBLIC KEY" {
+		return nil, fmt.Errorf("unsupported PEM block %q, want a PUBLIC KEY", block.Type)
+	}
+	pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	switch pub.(type) {
+	case ed25519.PublicKey, *ecdsa.PublicKey:
+		return &pemKey{pub: pub}, nil
+	}
+	return nil, fmt.Errorf("unsupported public key %T, want ed25519 or ECDSA", pub)
+}
+
+func (k *pemKey) Ext() string {
+	return pemExt
+}
+
+// ID returns the start of the SHA-256 fingerprint of the public key.
+func (k *pemKey) ID() string {
+	der, err := x509.MarshalPKIXPublicKey(k.pub)
+	if err != nil {
+		return "unknown"
+	}
+	sum := sha256.Sum256(der)
+	return fmt.Sprintf("%X", sum[:8])
+}
+
+func (k *pemKey) Sign(name string, data []byte, created time.Time) ([]byte, error) {
+	var (
+		sig []byte
+		err error
+	)
+	switch key := k.priv.(type) {
+	case ed25519.PrivateKey:
+		sig = ed25519.Sign(key, data)
+	case *ecdsa.PrivateKey:
+		hash := sha256.Sum256(data)
+		sig, err = ecdsa.SignASN1(rand.Reader, key, hash[:])
+	default:
+		err = fmt.Errorf("key %s has no private key", k.ID())
+	}
+	if err != nil {
+		return nil, err
+	}
+	return []byte(base64.StdEncoding.EncodeToString(sig)), nil
+}
+
+func (k *pemKey) Verify(data, sig []byte) error {
+	raw, err := base64.StdEncoding.DecodeString(string(bytes.TrimSpace(sig)))
+	if err != nil {
+		return fmt.Errorf("not a base64 signature")
+	}
+	var ok bool
+	switch key := k.pub.(type) {
+	case ed25519.PublicKey:
+		ok = ed25519.Verify(key, data, raw)
+	case *ecdsa.PublicKey:
+		hash := sha256.Sum256(data)
+		ok = ecdsa.VerifyASN1(key, hash[:], raw)
+	}
+	if !ok {
+		return fmt.Errorf("signature does not match the file")
+	}
+	return nil
+}
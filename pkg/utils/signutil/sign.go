@@ -0,0 +1,58 @@
+// Package signutil signs files and verifies their signatures. Keys are
+// either minisign keys, whose signatures minisign -V checks, or PEM keys as
+// openssl and cosign write them, whose signatures cosign verify-blob checks.
+package signutil
+
+import (
+	"bytes"
+	"encoding/pem"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Signer signs files with a private key.
+type Signer interface {
+	// Sign returns the signature file of data, the content of the file
+	// named name, signed at created.
+	Sign(name string, data []byte, created time.Time) ([]byte, error)
+	// Ext is what the signature file of a file is named by: the file's name
+	// plus Ext.
+	Ext() string
+	// ID identifies the key pair, as the Verifier of its public key does.
+	ID() string
+}
+
+// Verifier checks signatures against a public key.
+type Verifier interface {
+	// Verify checks sig, a signature file, against data.
+	Verify(data, sig []byte) error
+	// Ext is what the signature file of a file is named by: the file's name
+	// plus Ext.
+	Ext() string
+	// ID identifies the key pair, as the Signer of its private key does.
+	ID() string
+}
+
+// LoadPrivateKey parses a private key file: a minisign secret key, a PKCS#8
+// PEM ed25519 or ECDSA key, or a key cosign generate-key-pair encrypted.
+// password unlocks an encrypted key, and is ignored otherwise.
+func LoadPrivateKey(b, password []byte) (Signer, error) {
+	if block, _ := pem.Decode(b); block != nil {
+		return parsePEMPrivateKey(block, password)
+	}
+	if bytes.HasPrefix(b, []byte(untrustedComment)) {
+		return parseMinisignPrivateKey(b, password)
+	}
+	return nil, fmt.Errorf("not a minisign or PEM private key")
+}
+
+// ParsePublicKey parses a public key: a PEM public key, or a minisign public
+// key, as its file or as the base64 line of it.
+func ParsePublicKey(s string) (Verifier, error) {
+	s = strings.TrimSpace(s)
+	if block, _ := pem.Decode([]byte(s)); block != nil {
+		return parsePEMPublicKey(block)
+	}
+	return parseMinisignPublicKey(s)
+}
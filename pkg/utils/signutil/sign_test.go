@@ -0,0 +1,204 @@
+package signutil
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"encoding/pem"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/nacl/secretbox"
+	"golang.org/x/crypto/scrypt"
+)
+
+var signedAt = time.Date(2025, 1, 2, 3, 4, 5, 0, time.UTC)
+
+// minisignKeys returns a minisign secret key file, encrypted with password
+// unless it is empty, and the public key of it, as minisign -G writes them.
+func minisignKeys(t *testing.T, password string) ([]byte, string) {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+	sum := blake2b.Sum256(append(append([]byte("Ed"), id...), priv...))
+	sk := append(append(append([]byte{}, id...), priv...), sum[:]...)
+	header := append([]byte("Ed"), "\x00\x00B2"...)
+	salt := make([]byte, 32)
+	limits := make([]byte, 16)
+	if password != "" {
+		header = []byte("EdScB2")
+		// cheap limits: N=1024, r=8, p=1
+		binary.LittleEndian.PutUint64(limits[0:8], 32768)
+		binary.LittleEndian.PutUint64(limits[8:16], 1<<24)
+		stream, err := scrypt.Key([]byte(password), salt, 1024, 8, 1, len(sk))
+		if err != nil {
+			t.Fatal(err)
+		}
+		subtle.XORBytes(sk, sk, stream)
+	}
+	raw := append(append(append(header, salt...), limits...), sk...)
+	secret := "untrusted comment: minisign encrypted secret key\n" + base64.StdEncoding.EncodeToString(raw) + "\n"
+	public := base64.StdEncoding.EncodeToString(append(append([]byte("Ed"), id...), pub...))
+	return []byte(secret), "untrusted comment: minisign public key 0807060504030201\n" + public + "\n"
+}
+
+func pemPublicKey(t *testing.T, pub any) string {
+	t.Helper()
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+}
+
+func pkcs8(t *testing.T, priv any) []byte {
+	t.Helper()
+	der, err := x509.MarshalPKCS8PrivateKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+// cosignKey seals a PKCS#8 key the way cosign generate-key-pair does.
+func cosignKey(t *testing.T, der []byte, password string) []byte {
+	t.Helper()
+	var sealed sigstoreKey
+	sealed.KDF.Name, sealed.Cipher.Name = "scrypt", "nacl/secretbox"
+	sealed.KDF.Params.N, sealed.KDF.Params.R, sealed.KDF.Params.P = 1024, 8, 1
+	sealed.KDF.Salt = bytes.Repeat([]byte{7}, 32)
+	sealed.Cipher.Nonce = bytes.Repeat([]byte{9}, 24)
+	secret, err := scrypt.Key([]byte(password), sealed.KDF.Salt, 1024, 8, 1, 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var key [32]byte
+	var nonce [24]byte
+	copy(key[:], secret)
+	copy(nonce[:], sealed.Cipher.Nonce)
+	sealed.Ciphertext = secretbox.Seal(nil, der, &nonce, &key)
+	b, err := json.Marshal(sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "ENCRYPTED SIGSTORE PRIVATE KEY", Bytes: b})
+}
+
+func TestSignAndVerify(t *testing.T) {
+	minisignPlain, minisignPublic := minisignKeys(t, "")
+	minisignSealed, minisignSealedPublic := minisignKeys(t, "s3cret")
+	edPub, edPriv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecPriv, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		private  []byte
+		password string
+		public   string
+		ext      string
+	}{
+		{"minisign", minisignPlain, "", minisignPublic, ".minisig"},
+		{"encrypted minisign", minisignSealed, "s3cret", strings.Split(minisignSealedPublic, "\n")[1], ".minisig"},
+		{"ed25519 pem", pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: pkcs8(t, edPriv)}), "", pemPublicKey(t, edPub), ".sig"},
+		{"cosign", cosignKey(t, pkcs8(t, ecPriv), "s3cret"), "s3cret", pemPublicKey(t, &ecPriv.PublicKey), ".sig"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			signer, err := LoadPrivateKey(tt.private, []byte(tt.password))
+			if err != nil {
+				t.Fatal(err)
+			}
+			verifier, err := ParsePublicKey(tt.public)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if signer.Ext() != tt.ext || verifier.Ext() != tt.ext {
+				t.Errorf("ext %s/%s, want %s", signer.Ext(), verifier.Ext(), tt.ext)
+			}
+			if signer.ID() != verifier.ID() {
+				t.Errorf("signer %s, verifier %s", signer.ID(), verifier.ID())
+			}
+			data := []byte("the binary")
+			sig, err := signer.Sign("api", data, signedAt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := verifier.Verify(data, sig); err != nil {
+				t.Errorf("verify: %s", err)
+			}
+			if err := verifier.Verify([]byte("another binary"), sig); err == nil {
+				t.Error("verified the signature of another file")
+			}
+			if tt.password != "" {
+				if _, err := LoadPrivateKey(tt.private, []byte("wrong")); err == nil {
+					t.Error("loaded the key with a wrong password")
+				}
+			}
+		})
+	}
+}
+
+func TestMinisignSignature(t *testing.T) {
+	secret, public := minisignKeys(t, "")
+	signer, err := LoadPrivateKey(secret, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signer.ID() != "0807060504030201" {
+		t.Errorf("id = %s", signer.ID())
+	}
+	sig, err := signer.Sign("api_linux_amd64", []byte("the binary"), signedAt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(string(sig), "\n")
+	if lines[2] != "trusted comment: timestamp:1735787045\tfile:api_linux_amd64\thashed" {
+		t.Errorf("trusted comment %q", lines[2])
+	}
+	// ed25519 is deterministic, so is a signature of the same file
+	if again, _ := signer.Sign("api_linux_amd64", []byte("the binary"), signedAt); !bytes.Equal(sig, again) {
+		t.Error("signatures of the same file differ")
+	}
+
+	verifier, err := ParsePublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := strings.Replace(string(sig), "api_linux_amd64", "api_linux_arm64", 1)
+	if err := verifier.Verify([]byte("the binary"), []byte(tampered)); err == nil || !strings.Contains(err.Error(), "trusted comment") {
+		t.Errorf("tampered trusted comment: %v", err)
+	}
+	_, other := minisignKeys(t, "")
+	otherVerifier, err := ParsePublicKey(other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := otherVerifier.Verify([]byte("the binary"), sig); err == nil {
+		t.Error("verified against another key")
+	}
+}
+
+func TestParsePublicKeyRejectsGarbage(t *testing.T) {
+	for _, s := range []string{"", "RWQ", "-----BEGIN CERTIFICATE-----\nMIIB\n-----END CERTIFICATE-----\n"} {
+		if _, err := ParsePublicKey(s); err == nil {
+			t.Errorf("%q parsed", s)
+		}
+	}
+}
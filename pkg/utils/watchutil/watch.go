@@ -0,0 +1,195 @@
+// Package watchutil watches files for changes by polling them. Polling needs
+// nothing from the platform and sees changes on network and container
+// mounts alike, at the cost of noticing them an interval late.
+package watchutil
+
+import (
+	"context"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Target is what is watched: a file, or a directory and the files directly
+// in it, or every file under it when Recursive.
+type Target struct {
+	Path      string
+	Recursive bool
+	// Exclude are the paths under Path left unwatched, such as the output a
+	// step writes next to its sources.
+	Exclude []string
+}
+
+// Contains reports whether path, as Scan names it, is watched by t.
+func (t Target) Contains(path string) bool {
+	rel, ok := relative(t.Path, path)
+	if !ok || t.excludes(path) {
+		return false
+	}
+	return t.Recursive || !strings.ContainsRune(rel, filepath.Separator)
+}
+
+func (t Target) excludes(path string) bool {
+	return slices.ContainsFunc(t.Exclude, func(exclude string) bool {
+		_, ok := relative(exclude, path)
+		return ok
+	})
+}
+
+// covers reports whether t scans what o does, whatever either excludes.
+func (t Target) covers(o Target) bool {
+	return t.Path == o.Path && t.Recursive == o.Recursive
+}
+
+// relative returns path relative to dir, if it is dir or under it. Paths
+// absolute on one side only are compared absolute.
+func relative(dir, path string) (string, bool) {
+	if filepath.IsAbs(dir) != filepath.IsAbs(path) {
+		var err error
+		if dir, err = filepath.Abs(dir); err != nil {
+			return "", false
+		}
+		if path, err = filepath.Abs(path); err != nil {
+			return "", false
+		}
+	}
+	rel, err := filepath.Rel(dir, path)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", false
+	}
+	return rel, true
+}
+
+type fileState struct {
+	size    int64
+	modTime time.Time
+	mode    fs.FileMode
+}
+
+// Snapshot is the state of every file watched, by path.
+type Snapshot map[string]fileState
+
+// Scan takes a snapshot of the targets. A target that does not exist holds
+// no files, and one that cannot be read is skipped, so it shows as removed.
+func Scan(targets []Target) Snapshot {
+	s := make(Snapshot)
+	add := func(path string, fi fs.FileInfo) {
+		s[filepath.Clean(path)] = fileState{size: fi.Size(), modTime: fi.ModTime(), mode: fi.Mode()}
+	}
+	for _, t := range targets {
+		fi, err := os.Stat(t.Path)
+		if err != nil {
+			continue
+		}
+		if !fi.IsDir() {
+			if !t.excludes(t.Path) {
+				add(t.Path, fi)
+			}
+			continue
+		}
+		filepath.WalkDir(t.Path, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return nil
+			}
+			if t.excludes(path) {
+				if d.IsDir() {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if d.IsDir() {
+				if path != t.Path && !t.Recursive {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if fi, err := d.Info(); err == nil {
+				add(path, fi)
+			}
+			return nil
+		})
+	}
+	return s
+}
+
+// Changes returns the paths added, removed or modified in next, sorted.
+func (s Snapshot) Changes(next Snapshot) []string {
+	var changed []string
+	for path, state := range next {
+		if old, ok := s[path]; !ok || old.size != state.size || !old.modTime.Equal(state.modTime) || old.mode != state.mode {
+			changed = append(changed, path)
+		}
+	}
+	for path := range s {
+		if _, ok := next[path]; !ok {
+			changed = append(changed, path)
+		}
+	}
+	slices.Sort(changed)
+	return changed
+}
+
+// Retarget carries a snapshot of from over to the targets to. The files
+// both watch keep their state in s, so a change s has not seen yet is still
+// reported; only the targets new in to are scanned, and the files to
+// excludes are dropped.
+func (s Snapshot) Retarget(from, to []Target) Snapshot {
+	watched := func(targets []Target, path string) bool {
+		return slices.ContainsFunc(targets, func(t Target) bool { return t.Contains(path) })
+	}
+	next := make(Snapshot)
+	for path, state := range s {
+		if watched(to, path) {
+			next[path] = state
+		}
+	}
+	var added []Target
+	for _, t := range to {
+		if !slices.ContainsFunc(from, t.covers) {
+			added = append(added, t)
+		}
+	}
+	for path, state := range Scan(added) {
+		if !watched(from, path) {
+			next[path] = state
+		}
+	}
+	return next
+}
+
+// Wait polls the targets every interval until they change from since, then
+// until they stay unchanged for quiet, so that a burst of writes, such as a
+// save or a checkout, is acted on once. It returns every path changed and the
+// snapshot they settled in, or the context's error once it is done.
+func Wait(ctx context.Context, targets []Target, since Snapshot, interval, quiet time.Duration) ([]string, Snapshot, error) {
+	changed := make(map[string]bool)
+	var lastChange time.Time
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil, since, ctx.Err()
+		case <-ticker.C:
+		}
+		next := Scan(targets)
+		if paths := since.Changes(next); len(paths) > 0 {
+			for _, path := range paths {
+				changed[path] = true
+			}
+			since, lastChange = next, time.Now()
+			continue
+		}
+		if len(changed) > 0 && time.Since(lastChange) >= quiet {
+			paths := make([]string, 0, len(changed))
+			for path := range changed {
+				paths = append(paths, path)
+			}
+			slices.Sort(paths)
+			return paths, since, nil
+		}
+	}
+}
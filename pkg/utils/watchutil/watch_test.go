@@ -0,0 +1,129 @@
+package watchutil
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func write(t *testing.T, path, body string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(body), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestTargetContains(t *testing.T) {
+	tests := []struct {
+		target Target
+		path   string
+		want   bool
+	}{
+		{Target{Path: "cmd/api"}, "cmd/api/main.go", true},
+		{Target{Path: "cmd/api"}, "cmd/api/sub/sub.go", false},
+		{Target{Path: "cmd/api", Recursive: true}, "cmd/api/sub/sub.go", true},
+		{Target{Path: "cmd/api", Recursive: true}, "cmd/apiserver/main.go", false},
+		{Target{Path: ".", Recursive: true}, "env/default/config.yaml", true},
+		{Target{Path: "go.mod"}, "go.mod", true},
+		{Target{Path: "env/default", Recursive: true}, "env/prod/config.yaml", false},
+		{Target{Path: "env", Recursive: true, Exclude: []string{"env/out"}}, "env/out/app.yaml", false},
+		{Target{Path: "env", Recursive: true, Exclude: []string{"env/out"}}, "env/output/app.yaml", true},
+	}
+	for _, tt := range tests {
+		if got := tt.target.Contains(tt.path); got != tt.want {
+			t.Errorf("%+v contains %s = %v, want %v", tt.target, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestScanChanges(t *testing.T) {
+	dir := t.TempDir()
+	write(t, filepath.Join(dir, "pkg", "a.go"), "a")
+	write(t, filepath.Join(dir, "pkg", "b.go"), "b")
+	write(t, filepath.Join(dir, "pkg", "sub", "c.go"), "c")
+	write(t, filepath.Join(dir, "config", "nested", "app.yaml"), "port: 80")
+	targets := []Target{
+		{Path: filepath.Join(dir, "pkg")},
+		{Path: filepath.Join(dir, "config"), Recursive: true},
+		{Path: filepath.Join(dir, "missing"), Recursive: true},
+	}
+	before := Scan(targets)
+	if len(before) != 3 {
+		t.Fatalf("scanned %d files, want 3 (not the sub package)", len(before))
+	}
+
+	write(t, filepath.Join(dir, "pkg", "a.go"), "a changed")
+	write(t, filepath.Join(dir, "pkg", "new.go"), "new")
+	write(t, filepath.Join(dir, "pkg", "sub", "c.go"), "c changed")
+	if err := os.Remove(filepath.Join(dir, "config", "nested", "app.yaml")); err != nil {
+		t.Fatal(err)
+	}
+	got := before.Changes(Scan(targets))
+	want := []string{
+		filepath.Join(dir, "config", "nested", "app.yaml"),
+		filepath.Join(dir, "pkg", "a.go"),
+		filepath.Join(dir, "pkg", "new.go"),
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("changes %q, want %q", got, want)
+	}
+}
+
+func TestWaitSettlesBurstsOfChanges(t *testing.T) {
+	dir := t.TempDir()
+	targets := []Target{{Path: dir, Recursive: true}}
+	since := Scan(targets)
+	go func() {
+		for i, name := range []string{"a", "b", "c"} {
+			time.Sleep(time.Duration(i) * 5 * time.Millisecond)
+			write(t, filepath.Join(dir, name), name)
+		}
+	}()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	changed, settled, err := Wait(ctx, targets, since, 2*time.Millisecond, 50*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{filepath.Join(dir, "a"), filepath.Join(dir, "b"), filepath.Join(dir, "c")}
+	if !slices.Equal(changed, want) {
+		t.Errorf("changed %q, want %q in one go", changed, want)
+	}
+	if len(settled) != 3 {
+		t.Errorf("settled on %d files", len(settled))
+	}
+
+	ctx, cancel = context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, _, err := Wait(ctx, targets, settled, 2*time.Millisecond, time.Millisecond); err != context.DeadlineExceeded {
+		t.Errorf("err = %v, want the deadline without changes", err)
+	}
+}
+
+func TestRetarget(t *testing.T) {
+	dir := t.TempDir()
+	write(t, filepath.Join(dir, "pkg", "a.go"), "a")
+	write(t, filepath.Join(dir, "env", "template.app.yaml"), "port: 80")
+	write(t, filepath.Join(dir, "lib", "lib.go"), "lib")
+	from := []Target{{Path: filepath.Join(dir, "pkg")}, {Path: filepath.Join(dir, "env")}}
+	since := Scan(from)
+
+	// written while the steps ran: a change to watch, and an output
+	write(t, filepath.Join(dir, "pkg", "a.go"), "a changed")
+	write(t, filepath.Join(dir, "env", "app.yaml"), "port: 80")
+	to := []Target{
+		{Path: filepath.Join(dir, "env"), Exclude: []string{filepath.Join(dir, "env", "app.yaml")}},
+		{Path: filepath.Join(dir, "pkg")},
+		{Path: filepath.Join(dir, "lib")},
+	}
+	got := since.Retarget(from, to).Changes(Scan(to))
+	if want := []string{filepath.Join(dir, "pkg", "a.go")}; !slices.Equal(got, want) {
+		t.Errorf("changes %q, want %q", got, want)
+	}
+}